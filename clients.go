@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/theaniketnegi/goredis/store"
+)
+
+var errClientDisconnected = errors.New("client disconnected")
+
+type client struct {
+	id int64
+
+	mu      sync.Mutex
+	unblock context.CancelCauseFunc
+
+	// Transaction state, only touched by the connection's own goroutine.
+	inMulti   bool
+	aborted   bool
+	queued    []request
+	executing bool
+	inCommand bool
+}
+
+type clientRegistry struct {
+	mu      sync.Mutex
+	nextID  atomic.Int64
+	clients map[int64]*client
+}
+
+var clients = &clientRegistry{clients: make(map[int64]*client)}
+
+func (r *clientRegistry) register() *client {
+	c := &client{id: r.nextID.Add(1)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c.id] = c
+	return c
+}
+
+func (r *clientRegistry) unregister(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, c.id)
+}
+
+// unblock cancels the blocking command the client is currently waiting in,
+// reporting false when the client does not exist or is not blocked.
+func (r *clientRegistry) unblock(id int64, cause error) bool {
+	r.mu.Lock()
+	c, ok := r.clients[id]
+	r.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unblock == nil {
+		return false
+	}
+	c.unblock(cause)
+	c.unblock = nil
+	return true
+}
+
+// unblockCause maps a CLIENT UNBLOCK reason to the cancellation cause the
+// store understands, returning nil for an unknown reason.
+func unblockCause(reason string) error {
+	switch strings.ToUpper(reason) {
+	case "TIMEOUT":
+		return store.ErrUnblockedTimeout
+	case "ERROR":
+		return store.ErrUnblockedError
+	}
+	return nil
+}
+
+// beginBlocking derives the context a blocking command waits on. The returned
+// func must be called once the command returns.
+func (c *client) beginBlocking(ctx context.Context) (context.Context, func()) {
+	blockCtx, cancel := context.WithCancelCause(ctx)
+
+	c.mu.Lock()
+	c.unblock = cancel
+	c.mu.Unlock()
+
+	return blockCtx, func() {
+		c.mu.Lock()
+		c.unblock = nil
+		c.mu.Unlock()
+		cancel(nil)
+	}
+}
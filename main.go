@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -18,23 +20,59 @@ import (
 var dir = flag.String("dir", "/tmp/redis-data", "Directory to store godb file")
 var dbFilename = flag.String("dbfilename", "dump.godb", "godb file")
 
+type request struct {
+	command string
+	args    []string
+}
+
+// readRequests keeps reading from the connection while a command is being
+// executed, so a client that disconnects in the middle of a blocking command
+// cancels ctx right away instead of leaving a waiter behind.
+func readRequests(conn net.Conn, cancel context.CancelCauseFunc) <-chan request {
+	requests := make(chan request, 128)
+
+	go func() {
+		defer close(requests)
+		defer cancel(errClientDisconnected)
+
+		reader := bufio.NewReader(conn)
+		for {
+			command, args, err := parser.ParseRESP(reader)
+			if err != nil {
+				if err == io.EOF {
+					fmt.Println("Connection closed.")
+					return
+				}
+				log.Println(err)
+				return
+			}
+			requests <- request{command: command, args: args}
+		}
+	}()
+	return requests
+}
+
 func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *store.Persistence) {
 	defer conn.Close()
 
-	reader := bufio.NewReader(conn)
+	client := clients.register()
+	defer clients.unregister(client)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
+	requests := readRequests(conn, cancel)
 	for {
-		command, args, err := parser.ParseRESP(reader)
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("Connection closed.")
-				return
-			}
-			log.Fatal(err)
+		request, ok := client.next(store, requests)
+		if !ok {
 			return
 		}
+		command, args := request.command, request.args
 
 		fmt.Printf("COMMAND: %q, ARGS: %v\n", command, args)
+		if client.queue(conn, command, args) {
+			continue
+		}
 		switch command {
 		case "PING":
 			if len(args) == 1 {
@@ -69,14 +107,15 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				conn.Write([]byte("$-1\r\n"))
 				continue
 			}
-			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val.Value), val.Value))
+			value := val.String()
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(value), value))
 		case "SET":
 			if len(args) < 2 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'set' command\r\n"))
 				continue
 			}
 
-			var expiry *time.Time = nil
+			var expiresAt int64 = 0
 
 			expiryCmd := ""
 			var nx bool = false
@@ -110,8 +149,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 						goto errHandler
 					}
 
-					expire := time.Now().Add(time.Duration(seconds) * time.Second)
-					expiry = &expire
+					expiresAt = time.Now().Add(time.Duration(seconds) * time.Second).UnixMilli()
 
 					expiryCmd = cmd
 					i += 2
@@ -136,8 +174,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 						goto errHandler
 					}
 
-					expire := time.Now().Add(time.Duration(milliseconds) * time.Millisecond)
-					expiry = &expire
+					expiresAt = time.Now().Add(time.Duration(milliseconds) * time.Millisecond).UnixMilli()
 					expiryCmd = cmd
 					i += 2
 				case "KEEPTTL":
@@ -180,7 +217,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				continue
 			}
 
-			returnedVal, err := store.StringSet(args[0], args[1], expiry, nx, xx, ttl, get)
+			returnedVal, err := store.StringSet(args[0], args[1], expiresAt, nx, xx, ttl, get)
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
@@ -220,33 +257,111 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				continue
 			}
 
-			if storeVal.Expiry == nil {
+			if !storeVal.HasExpiry() {
 				conn.Write([]byte(":-1\r\n"))
 				continue
 			}
 
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", int64(time.Until(*storeVal.Expiry).Seconds())))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", int64(time.Until(time.UnixMilli(storeVal.ExpiresAt)).Seconds())))
 		case "CONFIG":
 			if len(args) == 0 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'config' command\r\n"))
 				continue
 			}
-			if len(args) == 1 && args[0] == "GET" {
+			subcommand := strings.ToUpper(args[0])
+			if len(args) == 1 && subcommand == "GET" {
 				conn.Write([]byte("-ERR wrong number of arguments for 'config|get' command\r\n"))
 				continue
 			}
 
-			if args[0] == "GET" {
+			if subcommand == "GET" {
 				if args[1] == "dir" {
 					conn.Write(fmt.Appendf(nil, "*2\r\n$3\r\ndir\r\n$%d\r\n%s\r\n", len(*dir), *dir))
 				} else if args[1] == "dbfilename" {
 					conn.Write(fmt.Appendf(nil, "*2\r\n$10\r\ndbfilename\r\n$%d\r\n%s\r\n", len(*dbFilename), *dbFilename))
+				} else if value, ok := store.ConfigGet(args[1]); ok {
+					name := strings.ToLower(args[1])
+					conn.Write(fmt.Appendf(nil, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(name), name, len(value), value))
 				} else {
 					conn.Write([]byte("*0\r\n"))
 				}
+			} else if subcommand == "SET" {
+				if len(args) < 3 || len(args)%2 == 0 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'config|set' command\r\n"))
+					continue
+				}
+				if err := store.ConfigSet(args[1:]); err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write([]byte("+OK\r\n"))
 			} else {
 				conn.Write([]byte("*0\r\n"))
 			}
+		case "CLIENT":
+			if len(args) == 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'client' command\r\n"))
+				continue
+			}
+
+			switch strings.ToUpper(args[0]) {
+			case "ID":
+				if len(args) != 1 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'client|id' command\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", client.id))
+			case "UNBLOCK":
+				if len(args) != 2 && len(args) != 3 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'client|unblock' command\r\n"))
+					continue
+				}
+				id, err := strconv.ParseInt(args[1], 10, 64)
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+
+				reason := "TIMEOUT"
+				if len(args) == 3 {
+					reason = args[2]
+				}
+				cause := unblockCause(reason)
+				if cause == nil {
+					conn.Write([]byte("-ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR\r\n"))
+					continue
+				}
+
+				if clients.unblock(id, cause) {
+					conn.Write([]byte(":1\r\n"))
+				} else {
+					conn.Write([]byte(":0\r\n"))
+				}
+			default:
+				conn.Write([]byte("-ERR unknown subcommand '" + args[0] + "'. Try CLIENT HELP.\r\n"))
+			}
+		case "OBJECT":
+			if len(args) == 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'object' command\r\n"))
+				continue
+			}
+
+			subcommand := strings.ToUpper(args[0])
+			if subcommand != "ENCODING" {
+				conn.Write([]byte("-ERR unknown subcommand '" + args[0] + "'. Try OBJECT HELP.\r\n"))
+				continue
+			}
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'object|encoding' command\r\n"))
+				continue
+			}
+
+			encoding, ok := store.ObjectEncoding(args[1])
+			if !ok {
+				conn.Write([]byte("$-1\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(encoding), encoding))
 		case "KEYS":
 			if len(args) != 1 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'keys' command\r\n"))
@@ -320,7 +435,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 			}
 			val, err := store.Increment(args[0], int64(by))
 			if err != nil {
-				conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 			conn.Write(fmt.Appendf(nil, ":%d\r\n", val))
@@ -331,7 +446,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 			}
 			val, err := store.Increment(args[0], -1)
 			if err != nil {
-				conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 			conn.Write(fmt.Appendf(nil, ":%d\r\n", val))
@@ -347,7 +462,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 			}
 			val, err := store.Increment(args[0], -int64(by))
 			if err != nil {
-				conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
+				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 			conn.Write(fmt.Appendf(nil, ":%d\r\n", val))
@@ -357,28 +472,13 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				continue
 			}
 
-			storeVal, ok, err := store.StringGet(args[0])
-			if err != nil {
-				conn.Write([]byte(err.Error() + "\r\n"))
-				continue
-			}
-			if !ok {
-				_, err := store.StringSet(args[0], args[1], nil, false, false, false, false)
-				if err != nil {
-					conn.Write([]byte(err.Error() + "\r\n"))
-					continue
-				}
-				conn.Write(fmt.Appendf(nil, ":%d\r\n", len(args[1])))
-				continue
-			}
-
-			_, err = store.StringSet(args[0], storeVal.Value+args[1], nil, false, false, false, false)
+			length, err := store.Append(args[0], args[1])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", len(storeVal.Value+args[1])))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
 		case "MSET":
 			if len(args) < 2 || len(args)%2 != 0 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'mset' command\r\n"))
@@ -386,7 +486,7 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 			}
 
 			for i := 0; i < len(args); i += 2 {
-				_, err := store.StringSet(args[i], args[i+1], nil, false, false, false, false)
+				_, err := store.StringSet(args[i], args[i+1], 0, false, false, false, false)
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
 					goto func_exit_mset
@@ -412,7 +512,8 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 					resp.WriteString("$-1\r\n")
 					continue
 				}
-				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(storeVal.Value), storeVal.Value))
+				value := storeVal.String()
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(value), value))
 			}
 			conn.Write([]byte(resp.String()))
 		func_exit_mget:
@@ -423,26 +524,52 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				continue
 			}
 
-			err := store.LPush(args[0], args[1:])
+			length, err := store.LPush(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "LPUSHX":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lpushx' command\r\n"))
+				continue
+			}
+
+			length, err := store.LPushX(args[0], args[1:])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", len(args)-1))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
 		case "RPUSH":
 			if len(args) < 2 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'rpush' command\r\n"))
 				continue
 			}
 
-			err := store.RPush(args[0], args[1:])
+			length, err := store.RPush(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "RPUSHX":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'rpushx' command\r\n"))
+				continue
+			}
+
+			length, err := store.RPushX(args[0], args[1:])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", len(args)-1))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
 		case "LPOP":
 			if len(args) < 1 || len(args) > 2 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'lpop' command\r\n"))
@@ -513,13 +640,15 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				duration = time.Duration(timeout * float64(time.Second))
 			}
 
-			key, value, err := store.BLPop(keys, duration, false)
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			key, value, ok, err := store.BLPop(blockCtx, keys, duration, false)
+			unblocked()
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			if value == "" {
+			if !ok {
 				conn.Write([]byte("_\r\n"))
 				continue
 			}
@@ -544,13 +673,15 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				duration = time.Duration(timeout * float64(time.Second))
 			}
 
-			key, value, err := store.BLPop(keys, duration, true)
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			key, value, ok, err := store.BLPop(blockCtx, keys, duration, true)
+			unblocked()
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			if value == "" {
+			if !ok {
 				conn.Write([]byte("_\r\n"))
 				continue
 			}
@@ -752,12 +883,14 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 
 			if uppercaseSrcFlag == "LEFT" {
 				if uppercaseDestFlag == "LEFT" {
-					val, err := store.BLMove(sourceKey, destinationKey, true, true, duration)
+					blockCtx, unblocked := client.beginBlocking(ctx)
+					val, ok, err := store.BLMove(blockCtx, sourceKey, destinationKey, true, true, duration)
+					unblocked()
 					if err != nil {
 						conn.Write([]byte(err.Error() + "\r\n"))
 						continue
 					}
-					if val == "" {
+					if !ok {
 						conn.Write([]byte("_\r\n"))
 						continue
 					}
@@ -765,13 +898,15 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 					conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
 					continue
 				}
-				val, err := store.BLMove(sourceKey, destinationKey, true, false, duration)
+				blockCtx, unblocked := client.beginBlocking(ctx)
+				val, ok, err := store.BLMove(blockCtx, sourceKey, destinationKey, true, false, duration)
+				unblocked()
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
 					continue
 				}
 
-				if val == "" {
+				if !ok {
 					conn.Write([]byte("_\r\n"))
 					continue
 				}
@@ -779,12 +914,14 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				continue
 			}
 			if uppercaseDestFlag == "LEFT" {
-				val, err := store.BLMove(sourceKey, destinationKey, false, true, duration)
+				blockCtx, unblocked := client.beginBlocking(ctx)
+				val, ok, err := store.BLMove(blockCtx, sourceKey, destinationKey, false, true, duration)
+				unblocked()
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
 					continue
 				}
-				if val == "" {
+				if !ok {
 					conn.Write([]byte("_\r\n"))
 					continue
 				}
@@ -792,204 +929,562 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
 				continue
 			}
-			val, err := store.BLMove(sourceKey, destinationKey, false, false, duration)
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			val, ok, err := store.BLMove(blockCtx, sourceKey, destinationKey, false, false, duration)
+			unblocked()
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			if val == "" {
+			if !ok {
 				conn.Write([]byte("_\r\n"))
 				continue
 			}
 
 			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
-		case "SADD":
-			if len(args) < 2 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'sadd' command\r\n"))
+		case "LINDEX":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lindex' command\r\n"))
+				continue
+			}
+			index, err := strconv.Atoi(args[1])
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
 				continue
 			}
 
-			addedValues := 0
-			for _, val := range args[1:] {
-				returnedVal, err := store.SAdd(args[0], val)
-				if err != nil {
-					conn.Write([]byte(err.Error() + "\r\n"))
-					goto func_exit_sadd
-				}
-				addedValues += returnedVal
+			val, ok, err := store.LIndex(args[0], index)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
 			}
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", addedValues))
-		func_exit_sadd:
-			continue
-		case "SREM":
-			if len(args) < 2 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'srem' command\r\n"))
+			if !ok {
+				conn.Write([]byte("_\r\n"))
 				continue
 			}
-			removedValues := 0
-			for _, val := range args[1:] {
-				returnedVal, err := store.SRem(args[0], val)
-				if err != nil {
-					conn.Write([]byte(err.Error() + "\r\n"))
-					goto func_exit_srem
-				}
-				removedValues += returnedVal
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
+		case "LSET":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lset' command\r\n"))
+				continue
 			}
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", removedValues))
-		func_exit_srem:
-			continue
-		case "SISMEMBER":
-			if len(args) != 2 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'sismember' command\r\n"))
+			index, err := strconv.Atoi(args[1])
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
 				continue
 			}
 
-			returnedVal, err := store.SIsMember(args[0], args[1])
+			err = store.LSet(args[0], index, args[2])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", returnedVal))
-		case "SINTER":
-			if len(args) < 1 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'sinter' command\r\n"))
+			conn.Write([]byte("+OK\r\n"))
+		case "LINSERT":
+			if len(args) != 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'linsert' command\r\n"))
 				continue
 			}
 
-			intersectedValues, err := store.SInter(args)
+			position := strings.ToUpper(args[1])
+			if position != "BEFORE" && position != "AFTER" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			length, err := store.LInsert(args[0], position == "BEFORE", args[2], args[3])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			var resp strings.Builder
-			resp.WriteString(fmt.Sprintf("~%d\r\n", len(intersectedValues)))
-			for _, val := range intersectedValues {
-				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "LREM":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lrem' command\r\n"))
+				continue
 			}
-			conn.Write([]byte(resp.String()))
-		case "SCARD":
-			if len(args) != 1 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'scard' command\r\n"))
+			count, err := strconv.Atoi(args[1])
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
 				continue
 			}
-			cardinality, err := store.SCard(args[0])
+
+			removed, err := store.LRem(args[0], count, args[2])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
-		case "SMEMBERS":
-			if len(args) != 1 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'smembers' command\r\n"))
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", removed))
+		case "LPOS":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lpos' command\r\n"))
 				continue
 			}
-			members, err := store.SMembers(args[0])
+
+			rank, count, maxLen := 1, -1, 0
+			var errMsg string
+			for i := 2; i < len(args) && errMsg == ""; i += 2 {
+				option := strings.ToUpper(args[i])
+				if i+1 >= len(args) || (option != "RANK" && option != "COUNT" && option != "MAXLEN") {
+					errMsg = "-ERR syntax error"
+					break
+				}
+				value, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					errMsg = "-ERR value is not an integer or out of range"
+					break
+				}
+
+				switch option {
+				case "RANK":
+					if value == 0 || value == math.MinInt {
+						errMsg = "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"
+					}
+					rank = value
+				case "COUNT":
+					if value < 0 {
+						errMsg = "-ERR COUNT can't be negative"
+					}
+					count = value
+				case "MAXLEN":
+					if value < 0 {
+						errMsg = "-ERR MAXLEN can't be negative"
+					}
+					maxLen = value
+				}
+			}
+			if errMsg != "" {
+				conn.Write([]byte(errMsg + "\r\n"))
+				continue
+			}
+
+			limit := count
+			if count == -1 {
+				limit = 1
+			}
+			positions, err := store.LPos(args[0], args[1], rank, limit, maxLen)
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
+
+			if count == -1 {
+				if len(positions) == 0 {
+					conn.Write([]byte("_\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", positions[0]))
+				continue
+			}
+
 			var resp strings.Builder
-			resp.WriteString(fmt.Sprintf("~%d\r\n", len(members)))
-			for _, val := range members {
-				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(positions)))
+			for _, position := range positions {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", position))
 			}
 			conn.Write([]byte(resp.String()))
-		case "SUNION":
-			if len(args) < 1 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'sunion' command\r\n"))
+		case "LMPOP":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'lmpop' command\r\n"))
 				continue
 			}
-			unionedValues, err := store.SUnion(args)
+
+			keys, left, count, err := parseMPopArgs(args, "LEFT", "RIGHT")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			key, values, err := store.LMPop(keys, left, count)
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
+			if len(values) == 0 {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+
 			var resp strings.Builder
-			resp.WriteString(fmt.Sprintf("~%d\r\n", len(unionedValues)))
-			for _, val := range unionedValues {
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(key), key, len(values)))
+			for _, val := range values {
 				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
 			}
 			conn.Write([]byte(resp.String()))
-		case "SMOVE":
-			if len(args) != 3 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'smove' command\r\n"))
+		case "BLMPOP":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'blmpop' command\r\n"))
 				continue
 			}
-			srcKey := args[0]
-			destKey := args[1]
-			member := args[2]
-
-			returnedVal, err := store.SMove(srcKey, destKey, member)
 
+			duration, err := parseTimeout(args[0])
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			conn.Write(fmt.Appendf(nil, ":%d\r\n", returnedVal))
-		case "SPOP":
-			if len(args) < 1 || len(args) > 2 {
-				conn.Write([]byte("-ERR wrong number of arguments for 'spop' command\r\n"))
+			keys, left, count, err := parseMPopArgs(args[1:], "LEFT", "RIGHT")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
 
-			if len(args) == 1 {
-				key := args[0]
-				val, err := store.SPop(key)
-
-				if err != nil {
-					conn.Write([]byte(err.Error() + "\r\n"))
-					continue
-				}
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			key, values, ok, err := store.BLMPop(blockCtx, keys, left, count, duration)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
 
-				if val == "" {
-					conn.Write([]byte("_\r\n"))
-					continue
-				}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(key), key, len(values)))
+			for _, val := range values {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "RPOPLPUSH":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'rpoplpush' command\r\n"))
+				continue
+			}
 
-				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
+			val, err := store.LMove(args[0], args[1], false, true)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
+		case "BRPOPLPUSH":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'brpoplpush' command\r\n"))
+				continue
+			}
+
+			duration, err := parseTimeout(args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			val, ok, err := store.BLMove(blockCtx, args[0], args[1], false, true, duration)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(val), val))
+		case "SADD":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sadd' command\r\n"))
+				continue
+			}
+
+			addedValues := 0
+			for _, val := range args[1:] {
+				returnedVal, err := store.SAdd(args[0], val)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					goto func_exit_sadd
+				}
+				addedValues += returnedVal
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", addedValues))
+		func_exit_sadd:
+			continue
+		case "SREM":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'srem' command\r\n"))
+				continue
+			}
+			removedValues := 0
+			for _, val := range args[1:] {
+				returnedVal, err := store.SRem(args[0], val)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					goto func_exit_srem
+				}
+				removedValues += returnedVal
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", removedValues))
+		func_exit_srem:
+			continue
+		case "SISMEMBER":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sismember' command\r\n"))
+				continue
+			}
+
+			returnedVal, err := store.SIsMember(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", returnedVal))
+		case "SMISMEMBER":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'smismember' command\r\n"))
+				continue
+			}
+
+			memberships, err := store.SMIsMember(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(memberships)))
+			for _, val := range memberships {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "SINTER":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sinter' command\r\n"))
+				continue
+			}
+
+			intersectedValues, err := store.SInter(args)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("~%d\r\n", len(intersectedValues)))
+			for _, val := range intersectedValues {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "SCARD":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'scard' command\r\n"))
+				continue
+			}
+			cardinality, err := store.SCard(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "SMEMBERS":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'smembers' command\r\n"))
+				continue
+			}
+			members, err := store.SMembers(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("~%d\r\n", len(members)))
+			for _, val := range members {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "SUNION":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sunion' command\r\n"))
+				continue
+			}
+			unionedValues, err := store.SUnion(args)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("~%d\r\n", len(unionedValues)))
+			for _, val := range unionedValues {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "SDIFF":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sdiff' command\r\n"))
+				continue
+			}
+			diffValues, err := store.SDiff(args)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("~%d\r\n", len(diffValues)))
+			for _, val := range diffValues {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+			if len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			var cardinality int
+			var err error
+			switch command {
+			case "SINTERSTORE":
+				cardinality, err = store.SInterStore(args[0], args[1:])
+			case "SUNIONSTORE":
+				cardinality, err = store.SUnionStore(args[0], args[1:])
+			default:
+				cardinality, err = store.SDiffStore(args[0], args[1:])
+			}
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "SINTERCARD":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'sintercard' command\r\n"))
+				continue
+			}
+
+			numKeys, err := strconv.Atoi(args[0])
+			if err != nil || numKeys <= 0 {
+				conn.Write([]byte("-ERR numkeys should be greater than 0\r\n"))
+				continue
+			}
+			if numKeys > len(args)-1 {
+				conn.Write([]byte("-ERR Number of keys can't be greater than number of args\r\n"))
+				continue
+			}
+
+			keys := args[1 : numKeys+1]
+			limit := 0
+			options := args[numKeys+1:]
+			if len(options) == 2 && strings.ToUpper(options[0]) == "LIMIT" {
+				limit, err = strconv.Atoi(options[1])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				if limit < 0 {
+					conn.Write([]byte("-ERR LIMIT can't be negative\r\n"))
+					continue
+				}
+			} else if len(options) != 0 {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			cardinality, err := store.SInterCard(keys, limit)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "SMOVE":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'smove' command\r\n"))
+				continue
+			}
+			srcKey := args[0]
+			destKey := args[1]
+			member := args[2]
+
+			returnedVal, err := store.SMove(srcKey, destKey, member)
+
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", returnedVal))
+		case "SPOP":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'spop' command\r\n"))
+				continue
+			}
+
+			if len(args) == 1 {
+				values, err := store.SPop(args[0], 1)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				if len(values) == 0 {
+					conn.Write([]byte("_\r\n"))
+					continue
+				}
+
+				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(values[0]), values[0]))
 			} else {
 				count, err := strconv.Atoi(args[1])
 				if err != nil || count <= 0 {
 					conn.Write([]byte("-ERR value is out of range, must be positive\r\n"))
 					continue
 				}
-				key := args[0]
-				size, err := store.SCard(key)
 
+				values, err := store.SPop(args[0], count)
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
 					continue
 				}
 
-				if size == 0 {
+				if len(values) == 0 {
 					conn.Write([]byte("_\r\n"))
 					continue
 				}
 
-				count = min(count, size)
-				var values []string
 				var resp strings.Builder
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+				for _, val := range values {
+					resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+				}
+				conn.Write([]byte(resp.String()))
+			}
+		case "SRANDMEMBER":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'srandmember' command\r\n"))
+				continue
+			}
 
-				for range count {
-					val, err := store.SPop(key)
-					if err != nil {
-						conn.Write([]byte(err.Error() + "\r\n"))
-						goto func_exit_spop
-					}
-					if val == "" {
-						conn.Write([]byte("_\r\n"))
-						continue
-					}
-					values = append(values, val)
+			if len(args) == 1 {
+				values, err := store.SRandMember(args[0], 1)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				if len(values) == 0 {
+					conn.Write([]byte("_\r\n"))
+					continue
+				}
+
+				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(values[0]), values[0]))
+			} else {
+				count, err := strconv.Atoi(args[1])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				if count < -math.MaxInt32 {
+					conn.Write([]byte("-ERR value is out of range\r\n"))
+					continue
+				}
+
+				values, err := store.SRandMember(args[0], count)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
 				}
 
+				var resp strings.Builder
 				resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
 				for _, val := range values {
 					resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
 				}
 				conn.Write([]byte(resp.String()))
-			func_exit_spop:
-				continue
 			}
 		case "HSET":
 			if len(args) < 3 || len(args)%2 == 0 {
@@ -1003,11 +1498,29 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				returnedVal, err := store.HSet(key, args[i], args[i+1])
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
-					continue
+					goto func_exit_hset
 				}
 				newFields += returnedVal
 			}
 			conn.Write(fmt.Appendf(nil, ":%d\r\n", newFields))
+		func_exit_hset:
+			continue
+		case "HSETNX":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hsetnx' command\r\n"))
+				continue
+			}
+
+			set, err := store.HSetNX(args[0], args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if set {
+				conn.Write([]byte(":1\r\n"))
+			} else {
+				conn.Write([]byte(":0\r\n"))
+			}
 		case "HGET":
 			if len(args) != 2 {
 				conn.Write([]byte("-ERR wrong number of arguments for 'hget' command\r\n"))
@@ -1016,12 +1529,12 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 
 			key := args[0]
 			field := args[1]
-			val, err := store.HGet(key, field)
+			val, ok, err := store.HGet(key, field)
 			if err != nil {
 				conn.Write([]byte(err.Error() + "\r\n"))
 				continue
 			}
-			if val == "" {
+			if !ok {
 				conn.Write([]byte("$-1\r\n"))
 				continue
 			}
@@ -1031,24 +1544,2475 @@ func connectionHandler(conn net.Conn, store *store.InMemoryStore, persistence *s
 				conn.Write([]byte("-ERR wrong number of arguments for 'hmget' command\r\n"))
 				continue
 			}
-			key := args[0]
+
+			values, exists, err := store.HMGet(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+			for i, val := range values {
+				if !exists[i] {
+					resp.WriteString("$-1\r\n")
+					continue
+				}
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HDEL":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hdel' command\r\n"))
+				continue
+			}
+
+			deleted, err := store.HDel(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", deleted))
+		case "HGETALL":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hgetall' command\r\n"))
+				continue
+			}
+
+			fields, values, err := store.HGetAll(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("%%%d\r\n", len(fields)))
+			for i, field := range fields {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(field), field))
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(values[i]), values[i]))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HKEYS", "HVALS":
+			if len(args) != 1 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			fields, values, err := store.HGetAll(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if command == "HVALS" {
+				fields = values
+			}
 			var resp strings.Builder
-			resp.WriteString(fmt.Sprintf("*%d\r\n", len(args)-1))
-			for _, field := range args[1:] {
-				val, err := store.HGet(key, field)
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(fields)))
+			for _, val := range fields {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HLEN":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hlen' command\r\n"))
+				continue
+			}
+
+			length, err := store.HLen(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "HEXISTS":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hexists' command\r\n"))
+				continue
+			}
+
+			exists, err := store.HExists(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if exists {
+				conn.Write([]byte(":1\r\n"))
+			} else {
+				conn.Write([]byte(":0\r\n"))
+			}
+		case "HSTRLEN":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hstrlen' command\r\n"))
+				continue
+			}
+
+			length, err := store.HStrLen(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "HRANDFIELD":
+			if len(args) < 1 || len(args) > 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hrandfield' command\r\n"))
+				continue
+			}
+
+			if len(args) == 1 {
+				fields, _, err := store.HRandField(args[0], 1)
 				if err != nil {
 					conn.Write([]byte(err.Error() + "\r\n"))
-					goto func_exit_hmget
+					continue
+				}
+				if len(fields) == 0 {
+					conn.Write([]byte("$-1\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(fields[0]), fields[0]))
+				continue
+			}
+
+			count, err := strconv.Atoi(args[1])
+			if err != nil {
+				conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+				continue
+			}
+			withValues := len(args) == 3
+			if withValues && strings.ToUpper(args[2]) != "WITHVALUES" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+			if count < -math.MaxInt32 {
+				conn.Write([]byte("-ERR value is out of range\r\n"))
+				continue
+			}
+
+			fields, values, err := store.HRandField(args[0], count)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(fields)))
+			for i, field := range fields {
+				if withValues {
+					resp.WriteString("*2\r\n")
+				}
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(field), field))
+				if withValues {
+					resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(values[i]), values[i]))
+				}
+			}
+			conn.Write([]byte(resp.String()))
+		case "HEXPIRE", "HPEXPIRE", "HEXPIREAT", "HPEXPIREAT":
+			if len(args) < 5 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			unit := map[string]string{"HEXPIRE": "EX", "HPEXPIRE": "PX", "HEXPIREAT": "EXAT", "HPEXPIREAT": "PXAT"}[command]
+			expiresAt, err := parseExpireAt(unit, args[1], 0, strings.ToLower(command))
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			condition := ""
+			rest := args[2:]
+			switch strings.ToUpper(rest[0]) {
+			case "NX", "XX", "GT", "LT":
+				condition = strings.ToUpper(rest[0])
+				rest = rest[1:]
+			}
+
+			fields, err := parseFieldsArg(rest, 1)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			results, err := store.HExpire(args[0], expiresAt, condition, fields)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(results)))
+			for _, val := range results {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HTTL", "HPTTL":
+			if len(args) < 4 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			fields, err := parseFieldsArg(args[1:], 1)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			results, err := store.HPTTL(args[0], fields)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(results)))
+			for _, val := range results {
+				if command == "HTTL" && val > 0 {
+					val /= 1000
+				}
+				resp.WriteString(fmt.Sprintf(":%d\r\n", val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HPERSIST":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hpersist' command\r\n"))
+				continue
+			}
+
+			fields, err := parseFieldsArg(args[1:], 1)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			results, err := store.HPersist(args[0], fields)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(results)))
+			for _, val := range results {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", val))
+			}
+			conn.Write([]byte(resp.String()))
+		case "HGETEX", "HGETDEL":
+			if len(args) < 4 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			var expiresAt int64
+			persist := false
+			rest := args[1:]
+			if command == "HGETEX" {
+				switch option := strings.ToUpper(rest[0]); option {
+				case "EX", "PX", "EXAT", "PXAT":
+					var err error
+					expiresAt, err = parseExpireAt(option, rest[1], 1, "hgetex")
+					if err != nil {
+						conn.Write([]byte(err.Error() + "\r\n"))
+						continue
+					}
+					rest = rest[2:]
+				case "PERSIST":
+					persist = true
+					rest = rest[1:]
 				}
-				if val == "" {
+			}
+
+			fields, err := parseFieldsArg(rest, 1)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var values []string
+			var exists []bool
+			if command == "HGETEX" {
+				values, exists, err = store.HGetEx(args[0], fields, expiresAt, persist)
+			} else {
+				values, exists, err = store.HGetDel(args[0], fields)
+			}
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+			for i, val := range values {
+				if !exists[i] {
 					resp.WriteString("$-1\r\n")
 					continue
 				}
 				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
 			}
 			conn.Write([]byte(resp.String()))
-		func_exit_hmget:
+		case "HSETEX":
+			if len(args) < 5 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'hsetex' command\r\n"))
+				continue
+			}
+
+			var expiresAt int64
+			condition := ""
+			keepTTL := false
+			rest := args[1:]
+			for len(rest) > 0 && strings.ToUpper(rest[0]) != "FIELDS" {
+				switch option := strings.ToUpper(rest[0]); option {
+				case "FNX", "FXX":
+					if condition != "" {
+						conn.Write([]byte("-ERR Only one of FXX or FNX arguments can be specified\r\n"))
+						goto func_exit_hsetex
+					}
+					condition = option
+					rest = rest[1:]
+				case "EX", "PX", "EXAT", "PXAT", "KEEPTTL":
+					if expiresAt != 0 || keepTTL {
+						conn.Write([]byte("-ERR Only one of EX, PX, EXAT, PXAT or KEEPTTL arguments can be specified\r\n"))
+						goto func_exit_hsetex
+					}
+					if option == "KEEPTTL" {
+						keepTTL = true
+						rest = rest[1:]
+						continue
+					}
+					if len(rest) < 2 {
+						conn.Write([]byte("-ERR syntax error\r\n"))
+						goto func_exit_hsetex
+					}
+					var err error
+					expiresAt, err = parseExpireAt(option, rest[1], 1, "hsetex")
+					if err != nil {
+						conn.Write([]byte(err.Error() + "\r\n"))
+						goto func_exit_hsetex
+					}
+					rest = rest[2:]
+				default:
+					conn.Write([]byte("-ERR syntax error\r\n"))
+					goto func_exit_hsetex
+				}
+			}
+
+			{
+				fieldValues, err := parseFieldsArg(rest, 2)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				var fields, values []string
+				for i := 0; i < len(fieldValues); i += 2 {
+					fields = append(fields, fieldValues[i])
+					values = append(values, fieldValues[i+1])
+				}
+
+				set, err := store.HSetEx(args[0], fields, values, condition, expiresAt, keepTTL)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				if set {
+					conn.Write([]byte(":1\r\n"))
+				} else {
+					conn.Write([]byte(":0\r\n"))
+				}
+			}
+		func_exit_hsetex:
 			continue
+		case "ZADD":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zadd' command\r\n"))
+				continue
+			}
+
+			options, members, err := parseZAddArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			changed, score, applied, err := store.ZAdd(args[0], members, options)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !options.Incr {
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", changed))
+			} else if applied {
+				conn.Write(fmt.Appendf(nil, ",%s\r\n", formatScore(score)))
+			} else {
+				conn.Write([]byte("_\r\n"))
+			}
+		case "ZINCRBY":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zincrby' command\r\n"))
+				continue
+			}
+
+			increment, err := parseScore(args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			score, err := store.ZIncrBy(args[0], increment, args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ",%s\r\n", formatScore(score)))
+		case "ZSCORE":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zscore' command\r\n"))
+				continue
+			}
+
+			score, ok, err := store.ZScore(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ",%s\r\n", formatScore(score)))
+		case "ZMSCORE":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zmscore' command\r\n"))
+				continue
+			}
+
+			scores, exists, err := store.ZMScore(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(scores)))
+			for i, score := range scores {
+				if !exists[i] {
+					resp.WriteString("_\r\n")
+					continue
+				}
+				resp.WriteString(fmt.Sprintf(",%s\r\n", formatScore(score)))
+			}
+			conn.Write([]byte(resp.String()))
+		case "ZREM":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zrem' command\r\n"))
+				continue
+			}
+
+			removed, err := store.ZRem(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", removed))
+		case "ZCARD":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zcard' command\r\n"))
+				continue
+			}
+
+			cardinality, err := store.ZCard(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "ZCOUNT":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zcount' command\r\n"))
+				continue
+			}
+
+			scoreRange, err := parseScoreRange(args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			count, err := store.ZCount(args[0], scoreRange)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", count))
+		case "ZLEXCOUNT":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zlexcount' command\r\n"))
+				continue
+			}
+
+			lexRange, err := parseLexRange(args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			count, err := store.ZLexCount(args[0], lexRange)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", count))
+		case "ZRANK", "ZREVRANK":
+			if len(args) != 2 && len(args) != 3 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+			withScore := len(args) == 3
+			if withScore && strings.ToUpper(args[2]) != "WITHSCORE" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			rank, score, ok, err := store.ZRank(args[0], args[1], command == "ZREVRANK")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			if withScore {
+				conn.Write(fmt.Appendf(nil, "*2\r\n:%d\r\n,%s\r\n", rank, formatScore(score)))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", rank))
+		case "ZRANGE":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zrange' command\r\n"))
+				continue
+			}
+
+			spec, withScores, err := parseZRangeArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			members, err := store.ZRange(args[0], spec)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeScoredMembers(&resp, members, withScores)
+			conn.Write([]byte(resp.String()))
+		case "ZUNION", "ZINTER", "ZDIFF":
+			if len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			keys, weights, aggregate, withScores, err := parseZSetOperationArgs(args, strings.ToLower(command), command != "ZDIFF", true)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			members, err := zsetOperation(store, command, keys, weights, aggregate)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeScoredMembers(&resp, members, withScores)
+			conn.Write([]byte(resp.String()))
+		case "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE":
+			if len(args) < 3 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			keys, weights, aggregate, _, err := parseZSetOperationArgs(args[1:], strings.ToLower(command), command != "ZDIFFSTORE", false)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var cardinality int
+			switch command {
+			case "ZUNIONSTORE":
+				cardinality, err = store.ZUnionStore(args[0], keys, weights, aggregate)
+			case "ZINTERSTORE":
+				cardinality, err = store.ZInterStore(args[0], keys, weights, aggregate)
+			default:
+				cardinality, err = store.ZDiffStore(args[0], keys)
+			}
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "ZINTERCARD":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zintercard' command\r\n"))
+				continue
+			}
+
+			numKeys, err := strconv.Atoi(args[0])
+			if err != nil || numKeys <= 0 {
+				conn.Write([]byte("-ERR numkeys should be greater than 0\r\n"))
+				continue
+			}
+			if numKeys > len(args)-1 {
+				conn.Write([]byte("-ERR Number of keys can't be greater than number of args\r\n"))
+				continue
+			}
+
+			keys := args[1 : numKeys+1]
+			limit := 0
+			options := args[numKeys+1:]
+			if len(options) == 2 && strings.ToUpper(options[0]) == "LIMIT" {
+				limit, err = strconv.Atoi(options[1])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				if limit < 0 {
+					conn.Write([]byte("-ERR LIMIT can't be negative\r\n"))
+					continue
+				}
+			} else if len(options) != 0 {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			cardinality, err := store.ZInterCard(keys, limit)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "ZRANGESTORE":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zrangestore' command\r\n"))
+				continue
+			}
+
+			spec, withScores, err := parseZRangeArgs(args[2:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if withScores {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+			cardinality, err := store.ZRangeStore(args[0], args[1], spec)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX":
+			if len(args) != 3 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			rangeArgs := args[1:]
+			switch command {
+			case "ZREMRANGEBYSCORE":
+				rangeArgs = append(rangeArgs, "BYSCORE")
+			case "ZREMRANGEBYLEX":
+				rangeArgs = append(rangeArgs, "BYLEX")
+			}
+			spec, _, err := parseZRangeArgs(rangeArgs)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			removed, err := store.ZRemRange(args[0], spec)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", removed))
+		case "ZPOPMIN", "ZPOPMAX":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			count := 1
+			if len(args) == 2 {
+				n, err := strconv.Atoi(args[1])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				count = n
+				if count < 0 {
+					conn.Write([]byte("-ERR value is out of range, must be positive\r\n"))
+					continue
+				}
+			}
+
+			members, err := store.ZPop(args[0], command == "ZPOPMAX", count)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			if len(args) == 1 {
+				if len(members) == 0 {
+					conn.Write([]byte("*0\r\n"))
+					continue
+				}
+				member := members[0]
+				resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n,%s\r\n", len(member.Member), member.Member, formatScore(member.Score)))
+			} else {
+				writeScoredMembers(&resp, members, true)
+			}
+			conn.Write([]byte(resp.String()))
+		case "ZMPOP":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'zmpop' command\r\n"))
+				continue
+			}
+
+			keys, lowest, count, err := parseMPopArgs(args, "MIN", "MAX")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			key, members, err := store.ZMPop(keys, !lowest, count)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if len(members) == 0 {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(key), key))
+			writeScoredMembers(&resp, members, true)
+			conn.Write([]byte(resp.String()))
+		case "BZPOPMIN", "BZPOPMAX":
+			if len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			duration, err := parseTimeout(args[len(args)-1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			key, members, ok, err := store.BZMPop(blockCtx, args[:len(args)-1], command == "BZPOPMAX", 1, duration)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+
+			member := members[0]
+			conn.Write(fmt.Appendf(nil, "*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n,%s\r\n", len(key), key, len(member.Member), member.Member, formatScore(member.Score)))
+		case "BZMPOP":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'bzmpop' command\r\n"))
+				continue
+			}
+
+			duration, err := parseTimeout(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			keys, lowest, count, err := parseMPopArgs(args[1:], "MIN", "MAX")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			key, members, ok, err := store.BZMPop(blockCtx, keys, !lowest, count, duration)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n", len(key), key))
+			writeScoredMembers(&resp, members, true)
+			conn.Write([]byte(resp.String()))
+		case "GEOADD":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geoadd' command\r\n"))
+				continue
+			}
+
+			options, members, err := parseGeoAddArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			changed, _, _, err := store.ZAdd(args[0], members, options)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", changed))
+		case "GEOPOS":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geopos' command\r\n"))
+				continue
+			}
+
+			coords, exists, err := store.GeoPos(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(coords)))
+			for i, coord := range coords {
+				if !exists[i] {
+					resp.WriteString("_\r\n")
+					continue
+				}
+				writeGeoCoord(&resp, coord)
+			}
+			conn.Write([]byte(resp.String()))
+		case "GEODIST":
+			if len(args) != 3 && len(args) != 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geodist' command\r\n"))
+				continue
+			}
+
+			unit := 1.0
+			if len(args) == 4 {
+				meters, err := parseGeoUnit(args[3])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				unit = meters
+			}
+
+			distance, ok, err := store.GeoDist(args[0], args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			formatted := formatGeoDistance(distance / unit)
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(formatted), formatted))
+		case "GEOHASH":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geohash' command\r\n"))
+				continue
+			}
+
+			hashes, exists, err := store.GeoHash(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(hashes)))
+			for i, hash := range hashes {
+				if !exists[i] {
+					resp.WriteString("_\r\n")
+					continue
+				}
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(hash), hash))
+			}
+			conn.Write([]byte(resp.String()))
+		case "GEOSEARCH":
+			if len(args) < 5 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geosearch' command\r\n"))
+				continue
+			}
+
+			query, options, _, err := parseGeoSearchArgs(args[1:], command, false)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			results, err := store.GeoSearch(args[0], query)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			writeGeoResults(&resp, results, options)
+			conn.Write([]byte(resp.String()))
+		case "GEOSEARCHSTORE":
+			if len(args) < 6 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'geosearchstore' command\r\n"))
+				continue
+			}
+
+			query, _, storeDist, err := parseGeoSearchArgs(args[2:], command, true)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			cardinality, err := store.GeoSearchStore(args[0], args[1], query, storeDist)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "XADD":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xadd' command\r\n"))
+				continue
+			}
+
+			options, fields, err := parseXAddArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			id, ok, err := store.XAdd(args[0], fields, options)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			formatted := id.String()
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(formatted), formatted))
+		case "XLEN":
+			if len(args) != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xlen' command\r\n"))
+				continue
+			}
+
+			length, err := store.XLen(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", length))
+		case "XRANGE", "XREVRANGE":
+			if len(args) != 3 && len(args) != 5 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			reverse := command == "XREVRANGE"
+			startArg, endArg := args[1], args[2]
+			if reverse {
+				startArg, endArg = endArg, startArg
+			}
+			start, err := parseStreamRangeBound(startArg, true)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			end, err := parseStreamRangeBound(endArg, false)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			count := -1
+			if len(args) == 5 {
+				if strings.ToUpper(args[3]) != "COUNT" {
+					conn.Write([]byte("-ERR syntax error\r\n"))
+					continue
+				}
+				n, err := strconv.Atoi(args[4])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				count = max(n, 0)
+			}
+
+			entries, err := store.XRange(args[0], start, end, reverse, count)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			writeStreamEntries(&resp, entries)
+			conn.Write([]byte(resp.String()))
+		case "XDEL":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xdel' command\r\n"))
+				continue
+			}
+
+			ids, err := parseStreamIDs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			deleted, err := store.XDel(args[0], ids)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", deleted))
+		case "XTRIM":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xtrim' command\r\n"))
+				continue
+			}
+
+			trim, err := parseXTrimArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			removed, err := store.XTrim(args[0], trim)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", removed))
+		case "XSETID":
+			if len(args) != 2 && len(args) != 4 && len(args) != 6 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xsetid' command\r\n"))
+				continue
+			}
+
+			lastID, entriesAdded, maxDeletedID, err := parseXSetIDArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			if err := store.XSetID(args[0], lastID, entriesAdded, maxDeletedID); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "XINFO":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xinfo' command\r\n"))
+				continue
+			}
+
+			switch subcommand := strings.ToUpper(args[0]); subcommand {
+			case "STREAM":
+				if len(args) != 2 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|stream' command\r\n"))
+					continue
+				}
+
+				info, err := store.XInfoStream(args[1])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				var resp strings.Builder
+				writeStreamInfo(&resp, info)
+				conn.Write([]byte(resp.String()))
+			case "GROUPS":
+				if len(args) != 2 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|groups' command\r\n"))
+					continue
+				}
+
+				groups, err := store.XInfoGroups(args[1])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				var resp strings.Builder
+				writeStreamGroupInfos(&resp, groups)
+				conn.Write([]byte(resp.String()))
+			case "CONSUMERS":
+				if len(args) != 3 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xinfo|consumers' command\r\n"))
+					continue
+				}
+
+				consumers, err := store.XInfoConsumers(args[1], args[2])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				var resp strings.Builder
+				writeStreamConsumerInfos(&resp, consumers)
+				conn.Write([]byte(resp.String()))
+			default:
+				conn.Write(fmt.Appendf(nil, "-ERR unknown subcommand '%s'. Try XINFO HELP.\r\n", args[0]))
+			}
+		case "XREAD":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xread' command\r\n"))
+				continue
+			}
+
+			reads, options, err := parseXReadArgs(args, command)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			results, err := store.XRead(blockCtx, reads, options.count, options.block, options.timeout)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			writeStreamReadResults(&resp, results)
+			conn.Write([]byte(resp.String()))
+		case "XGROUP":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xgroup' command\r\n"))
+				continue
+			}
+
+			switch subcommand := strings.ToUpper(args[0]); subcommand {
+			case "CREATE", "SETID":
+				if len(args) < 4 {
+					conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for 'xgroup|%s' command\r\n", strings.ToLower(subcommand)))
+					continue
+				}
+
+				id, last, mkStream, entriesRead, err := parseXGroupIDArgs(args[3:], subcommand == "CREATE")
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+
+				if subcommand == "CREATE" {
+					err = store.XGroupCreate(args[1], args[2], id, last, mkStream, entriesRead)
+				} else {
+					err = store.XGroupSetID(args[1], args[2], id, last, entriesRead)
+				}
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write([]byte("+OK\r\n"))
+			case "DESTROY":
+				if len(args) != 3 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xgroup|destroy' command\r\n"))
+					continue
+				}
+
+				destroyed, err := store.XGroupDestroy(args[1], args[2])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				if destroyed {
+					conn.Write([]byte(":1\r\n"))
+				} else {
+					conn.Write([]byte(":0\r\n"))
+				}
+			case "CREATECONSUMER":
+				if len(args) != 4 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xgroup|createconsumer' command\r\n"))
+					continue
+				}
+
+				created, err := store.XGroupCreateConsumer(args[1], args[2], args[3])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				if created {
+					conn.Write([]byte(":1\r\n"))
+				} else {
+					conn.Write([]byte(":0\r\n"))
+				}
+			case "DELCONSUMER":
+				if len(args) != 4 {
+					conn.Write([]byte("-ERR wrong number of arguments for 'xgroup|delconsumer' command\r\n"))
+					continue
+				}
+
+				pending, err := store.XGroupDelConsumer(args[1], args[2], args[3])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", pending))
+			default:
+				conn.Write(fmt.Appendf(nil, "-ERR unknown subcommand '%s'. Try XGROUP HELP.\r\n", args[0]))
+			}
+		case "XREADGROUP":
+			if len(args) < 6 || strings.ToUpper(args[0]) != "GROUP" {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xreadgroup' command\r\n"))
+				continue
+			}
+
+			reads, options, err := parseXReadArgs(args[3:], command)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			blockCtx, unblocked := client.beginBlocking(ctx)
+			results, err := store.XReadGroup(blockCtx, args[1], args[2], reads, options.count, options.noAck, options.block, options.timeout)
+			unblocked()
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			writeStreamReadResults(&resp, results)
+			conn.Write([]byte(resp.String()))
+		case "XACK":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xack' command\r\n"))
+				continue
+			}
+
+			ids, err := parseStreamIDs(args[2:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			acked, err := store.XAck(args[0], args[1], ids)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", acked))
+		case "XPENDING":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xpending' command\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			if len(args) == 2 {
+				summary, err := store.XPendingSummary(args[0], args[1])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				writePendingSummary(&resp, summary)
+			} else {
+				query, err := parseXPendingArgs(args[2:])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				entries, err := store.XPending(args[0], args[1], query)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				writePendingEntries(&resp, entries)
+			}
+			conn.Write([]byte(resp.String()))
+		case "XCLAIM":
+			if len(args) < 5 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xclaim' command\r\n"))
+				continue
+			}
+
+			minIdle, ids, options, err := parseXClaimArgs(args[3:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			entries, err := store.XClaim(args[0], args[1], args[2], minIdle, ids, options)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			writeClaimedEntries(&resp, entries, options.JustID)
+			conn.Write([]byte(resp.String()))
+		case "XAUTOCLAIM":
+			if len(args) < 5 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'xautoclaim' command\r\n"))
+				continue
+			}
+
+			minIdle, start, count, justID, err := parseXAutoClaimArgs(args[3:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			next, entries, deleted, err := store.XAutoClaim(args[0], args[1], args[2], minIdle, start, count, justID)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+
+			var resp strings.Builder
+			nextID := next.String()
+			resp.WriteString(fmt.Sprintf("*3\r\n$%d\r\n%s\r\n", len(nextID), nextID))
+			writeClaimedEntries(&resp, entries, justID)
+			writeStreamIDs(&resp, deleted)
+			conn.Write([]byte(resp.String()))
+		case "JSON.SET":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.set' command\r\n"))
+				continue
+			}
+
+			nx, xx, err := parseJSONSetArgs(args[3:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			ok, err := store.JSONSet(args[0], args[1], args[2], nx, xx)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "JSON.GET":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.get' command\r\n"))
+				continue
+			}
+
+			format, paths := parseJSONGetArgs(args[1:])
+			value, ok, err := store.JSONGet(args[0], paths, format)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(value), value))
+		case "JSON.MGET":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.mget' command\r\n"))
+				continue
+			}
+
+			values, exists, err := store.JSONMGet(args[:len(args)-1], args[len(args)-1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeBulkStrings(&resp, values, exists)
+			conn.Write([]byte(resp.String()))
+		case "JSON.DEL", "JSON.FORGET":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			path := "$"
+			if len(args) == 2 {
+				path = args[1]
+			}
+			deleted, err := store.JSONDel(args[0], path)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", deleted))
+		case "JSON.TYPE":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.type' command\r\n"))
+				continue
+			}
+
+			path := "."
+			if len(args) == 2 {
+				path = args[1]
+			}
+			types, ok, err := store.JSONType(args[0], path)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok || !isJSONPath(path) && len(types) == 0 {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			if !isJSONPath(path) {
+				conn.Write(fmt.Appendf(nil, "+%s\r\n", types[0]))
+				continue
+			}
+			var resp strings.Builder
+			writeBulkStrings(&resp, types, nil)
+			conn.Write([]byte(resp.String()))
+		case "JSON.NUMINCRBY":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.numincrby' command\r\n"))
+				continue
+			}
+
+			value, err := store.JSONNumIncrBy(args[0], args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(value), value))
+		case "JSON.STRAPPEND":
+			if len(args) < 2 || len(args) > 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.strappend' command\r\n"))
+				continue
+			}
+
+			path := "."
+			if len(args) == 3 {
+				path = args[1]
+			}
+			lengths, exists, err := store.JSONStrAppend(args[0], path, args[len(args)-1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeJSONIntegers(&resp, path, lengths, exists)
+			conn.Write([]byte(resp.String()))
+		case "JSON.ARRAPPEND", "JSON.ARRINSERT":
+			values := 2
+			if command == "JSON.ARRINSERT" {
+				values = 3
+			}
+			if len(args) < values+1 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			var index *int
+			if command == "JSON.ARRINSERT" {
+				n, err := strconv.Atoi(args[2])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				index = &n
+			}
+			lengths, exists, err := store.JSONArrInsert(args[0], args[1], index, args[values:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeJSONIntegers(&resp, args[1], lengths, exists)
+			conn.Write([]byte(resp.String()))
+		case "JSON.ARRPOP":
+			if len(args) < 1 || len(args) > 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.arrpop' command\r\n"))
+				continue
+			}
+
+			path, index := ".", -1
+			if len(args) >= 2 {
+				path = args[1]
+			}
+			if len(args) == 3 {
+				n, err := strconv.Atoi(args[2])
+				if err != nil {
+					conn.Write([]byte("-ERR value is not an integer or out of range\r\n"))
+					continue
+				}
+				index = n
+			}
+			popped, exists, err := store.JSONArrPop(args[0], path, index)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !isJSONPath(path) {
+				if !exists[0] {
+					conn.Write([]byte("_\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(popped[0]), popped[0]))
+				continue
+			}
+			var resp strings.Builder
+			writeBulkStrings(&resp, popped, exists)
+			conn.Write([]byte(resp.String()))
+		case "JSON.OBJKEYS":
+			if len(args) < 1 || len(args) > 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'json.objkeys' command\r\n"))
+				continue
+			}
+
+			path := "."
+			if len(args) == 2 {
+				path = args[1]
+			}
+			keys, exists, err := store.JSONObjKeys(args[0], path)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if exists == nil {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			if !isJSONPath(path) {
+				var resp strings.Builder
+				writeBulkStrings(&resp, keys[0], nil)
+				conn.Write([]byte(resp.String()))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(keys)))
+			for i := range keys {
+				if !exists[i] {
+					resp.WriteString("_\r\n")
+					continue
+				}
+				writeBulkStrings(&resp, keys[i], nil)
+			}
+			conn.Write([]byte(resp.String()))
+		case "BF.RESERVE":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'bf.reserve' command\r\n"))
+				continue
+			}
+
+			errorRate, capacity, expansion, nonScaling, err := parseBFReserveArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.BFReserve(args[0], errorRate, capacity, expansion, nonScaling); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "BF.ADD", "BF.MADD":
+			if command == "BF.ADD" && len(args) != 2 || len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			added, errs, err := store.BFAdd(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if command == "BF.ADD" && errs[0] != nil {
+				conn.Write([]byte(errs[0].Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			if command == "BF.MADD" {
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(added)))
+			}
+			for i := range added {
+				if errs[i] != nil {
+					resp.WriteString(errs[i].Error() + "\r\n")
+					continue
+				}
+				resp.WriteString(fmt.Sprintf(":%d\r\n", boolToInt(added[i])))
+			}
+			conn.Write([]byte(resp.String()))
+		case "BF.EXISTS", "BF.MEXISTS":
+			if command == "BF.EXISTS" && len(args) != 2 || len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			exists, err := store.BFExists(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			if command == "BF.MEXISTS" {
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(exists)))
+			}
+			for _, ok := range exists {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", boolToInt(ok)))
+			}
+			conn.Write([]byte(resp.String()))
+		case "BF.INFO":
+			if len(args) != 1 && len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'bf.info' command\r\n"))
+				continue
+			}
+
+			info, err := store.BFInfo(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if len(args) == 1 {
+				var resp strings.Builder
+				writeBloomInfo(&resp, info)
+				conn.Write([]byte(resp.String()))
+				continue
+			}
+			switch strings.ToUpper(args[1]) {
+			case "CAPACITY":
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", info.Capacity))
+			case "SIZE":
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", info.Size))
+			case "FILTERS":
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", info.Filters))
+			case "ITEMS":
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", info.Items))
+			case "EXPANSION":
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", info.Expansion))
+			default:
+				conn.Write([]byte("-ERR Invalid information value\r\n"))
+			}
+		case "CF.RESERVE":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cf.reserve' command\r\n"))
+				continue
+			}
+
+			capacity, bucketSize, maxIterations, expansion, err := parseCFReserveArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.CFReserve(args[0], capacity, bucketSize, maxIterations, expansion); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "CF.ADD", "CF.ADDNX":
+			if len(args) != 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			added, err := store.CFAdd(args[0], args[1], command == "CF.ADDNX")
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", boolToInt(added)))
+		case "CF.DEL":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cf.del' command\r\n"))
+				continue
+			}
+
+			deleted, err := store.CFDel(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", boolToInt(deleted)))
+		case "CF.EXISTS", "CF.COUNT":
+			if len(args) != 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			count, err := store.CFCount(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if command == "CF.EXISTS" {
+				count = boolToInt(count > 0)
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", count))
+		case "TOPK.RESERVE":
+			if len(args) != 2 && len(args) != 5 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'topk.reserve' command\r\n"))
+				continue
+			}
+
+			k, width, depth, decay, err := parseTopKReserveArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.TopKReserve(args[0], k, width, depth, decay); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TOPK.ADD", "TOPK.INCRBY":
+			if len(args) < 2 || command == "TOPK.INCRBY" && len(args)%2 != 1 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			items, increments := args[1:], make([]uint32, len(args)-1)
+			for i := range increments {
+				increments[i] = 1
+			}
+			if command == "TOPK.INCRBY" {
+				parsedItems, parsedIncrements, err := parseTopKIncrByArgs(args[1:])
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				items, increments = parsedItems, parsedIncrements
+			}
+			expelled, exists, err := store.TopKIncrBy(args[0], items, increments)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeBulkStrings(&resp, expelled, exists)
+			conn.Write([]byte(resp.String()))
+		case "TOPK.QUERY":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'topk.query' command\r\n"))
+				continue
+			}
+
+			contained, err := store.TopKQuery(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(contained)))
+			for _, ok := range contained {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", boolToInt(ok)))
+			}
+			conn.Write([]byte(resp.String()))
+		case "TOPK.LIST":
+			if len(args) != 1 && len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'topk.list' command\r\n"))
+				continue
+			}
+			withCount := len(args) == 2
+			if withCount && strings.ToUpper(args[1]) != "WITHCOUNT" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			items, err := store.TopKList(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			if withCount {
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(items)*2))
+			} else {
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(items)))
+			}
+			for _, item := range items {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(item.Item), item.Item))
+				if withCount {
+					resp.WriteString(fmt.Sprintf(":%d\r\n", item.Count))
+				}
+			}
+			conn.Write([]byte(resp.String()))
+		case "CMS.INITBYDIM":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cms.initbydim' command\r\n"))
+				continue
+			}
+
+			width, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil || width < 1 {
+				conn.Write([]byte("-ERR CMS: invalid width\r\n"))
+				continue
+			}
+			depth, err := strconv.ParseUint(args[2], 10, 64)
+			if err != nil || depth < 1 {
+				conn.Write([]byte("-ERR CMS: invalid depth\r\n"))
+				continue
+			}
+			if err := store.CMSInitByDim(args[0], width, depth); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "CMS.INITBYPROB":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cms.initbyprob' command\r\n"))
+				continue
+			}
+
+			errorRate, err := strconv.ParseFloat(args[1], 64)
+			if err != nil || errorRate <= 0 || errorRate >= 1 {
+				conn.Write([]byte("-ERR CMS: invalid overestimation value\r\n"))
+				continue
+			}
+			probability, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || probability <= 0 || probability >= 1 {
+				conn.Write([]byte("-ERR CMS: invalid prob value\r\n"))
+				continue
+			}
+			if err := store.CMSInitByProb(args[0], errorRate, probability); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "CMS.INCRBY":
+			if len(args) < 3 || len(args)%2 != 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cms.incrby' command\r\n"))
+				continue
+			}
+
+			items, increments, err := parseCMSIncrByArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			estimates, err := store.CMSIncrBy(args[0], items, increments)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(estimates)))
+			for _, estimate := range estimates {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", estimate))
+			}
+			conn.Write([]byte(resp.String()))
+		case "CMS.QUERY":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cms.query' command\r\n"))
+				continue
+			}
+
+			estimates, err := store.CMSQuery(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(estimates)))
+			for _, estimate := range estimates {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", estimate))
+			}
+			conn.Write([]byte(resp.String()))
+		case "CMS.MERGE":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'cms.merge' command\r\n"))
+				continue
+			}
+
+			sources, weights, err := parseCMSMergeArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.CMSMerge(args[0], sources, weights); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TDIGEST.CREATE":
+			if len(args) != 1 && len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'tdigest.create' command\r\n"))
+				continue
+			}
+
+			compression, err := parseTDigestCreateArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.TDigestCreate(args[0], compression); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TDIGEST.ADD":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'tdigest.add' command\r\n"))
+				continue
+			}
+
+			values, err := parseTDigestValues(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.TDigestAdd(args[0], values); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TDIGEST.MERGE":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'tdigest.merge' command\r\n"))
+				continue
+			}
+
+			sources, compression, override, err := parseTDigestMergeArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.TDigestMerge(args[0], sources, compression, override); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TDIGEST.QUANTILE", "TDIGEST.CDF":
+			if len(args) < 2 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			values, err := parseTDigestValues(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var results []float64
+			if command == "TDIGEST.QUANTILE" {
+				if !validQuantiles(values) {
+					conn.Write([]byte("-ERR T-Digest: quantile should be in [0,1]\r\n"))
+					continue
+				}
+				results, err = store.TDigestQuantile(args[0], values)
+			} else {
+				results, err = store.TDigestCDF(args[0], values)
+			}
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeDoubles(&resp, results)
+			conn.Write([]byte(resp.String()))
+		case "TDIGEST.RANK":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'tdigest.rank' command\r\n"))
+				continue
+			}
+
+			values, err := parseTDigestValues(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			ranks, err := store.TDigestRank(args[0], values)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(ranks)))
+			for _, rank := range ranks {
+				resp.WriteString(fmt.Sprintf(":%d\r\n", rank))
+			}
+			conn.Write([]byte(resp.String()))
+		case "TDIGEST.MIN", "TDIGEST.MAX":
+			if len(args) != 1 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			lowest, highest, err := store.TDigestMinMax(args[0])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if command == "TDIGEST.MAX" {
+				lowest = highest
+			}
+			conn.Write(fmt.Appendf(nil, ",%s\r\n", formatScore(lowest)))
+		case "TDIGEST.TRIMMED_MEAN":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'tdigest.trimmed_mean' command\r\n"))
+				continue
+			}
+
+			low, err := strconv.ParseFloat(args[1], 64)
+			if err != nil || low < 0 || low > 1 {
+				conn.Write([]byte("-ERR T-Digest: low_cut_percentile and high_cut_percentile should be in [0,1]\r\n"))
+				continue
+			}
+			high, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || high < 0 || high > 1 {
+				conn.Write([]byte("-ERR T-Digest: low_cut_percentile and high_cut_percentile should be in [0,1]\r\n"))
+				continue
+			}
+			if low >= high {
+				conn.Write([]byte("-ERR T-Digest: low_cut_percentile should be lower than high_cut_percentile\r\n"))
+				continue
+			}
+			mean, err := store.TDigestTrimmedMean(args[0], low, high)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ",%s\r\n", formatScore(mean)))
+		case "TS.CREATE":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.create' command\r\n"))
+				continue
+			}
+
+			options, err := parseTSOptions(args[1:], command)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.TSCreate(args[0], options.series); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TS.ADD":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.add' command\r\n"))
+				continue
+			}
+
+			timestamp, err := parseTSTimestamp(args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			value, err := parseTSValue(args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			options, err := parseTSOptions(args[3:], command)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			added, err := store.TSAdd(args[0], timestamp, value, options.series, options.onDuplicate)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", added))
+		case "TS.MADD":
+			if len(args) < 3 || len(args)%3 != 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.madd' command\r\n"))
+				continue
+			}
+
+			keys, samples, err := parseTSMAddArgs(args)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			errs := store.TSMAdd(keys, samples)
+			var resp strings.Builder
+			resp.WriteString(fmt.Sprintf("*%d\r\n", len(keys)))
+			for i, sample := range samples {
+				if errs[i] != nil {
+					resp.WriteString(errs[i].Error() + "\r\n")
+					continue
+				}
+				resp.WriteString(fmt.Sprintf(":%d\r\n", sample.Timestamp))
+			}
+			conn.Write([]byte(resp.String()))
+		case "TS.INCRBY":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.incrby' command\r\n"))
+				continue
+			}
+
+			value, err := parseTSValue(args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			options, err := parseTSOptions(args[2:], command)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			added, err := store.TSIncrBy(args[0], value, options.timestamp, options.series)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", added))
+		case "TS.RANGE", "TS.REVRANGE":
+			if len(args) < 3 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			query, _, _, err := parseTSRangeArgs(args[1:], false)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			query.Reverse = command == "TS.REVRANGE"
+			samples, err := store.TSRange(args[0], query)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeTSSamples(&resp, samples)
+			conn.Write([]byte(resp.String()))
+		case "TS.MRANGE":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.mrange' command\r\n"))
+				continue
+			}
+
+			query, filters, withLabels, err := parseTSRangeArgs(args, true)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeTSRangeResults(&resp, store.TSMRange(filters, query), withLabels)
+			conn.Write([]byte(resp.String()))
+		case "TS.CREATERULE":
+			if len(args) != 5 && len(args) != 6 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.createrule' command\r\n"))
+				continue
+			}
+			if strings.ToUpper(args[2]) != "AGGREGATION" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			aggregation, err := parseTSAggregation(args[3], args[4])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if len(args) == 6 {
+				align, err := strconv.ParseInt(args[5], 10, 64)
+				if err != nil {
+					conn.Write([]byte("-ERR TSDB: invalid align timestamp\r\n"))
+					continue
+				}
+				aggregation.Align = align
+			}
+			if err := store.TSCreateRule(args[0], args[1], aggregation); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "TS.DELETERULE":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ts.deleterule' command\r\n"))
+				continue
+			}
+
+			if err := store.TSDeleteRule(args[0], args[1]); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "VADD":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'vadd' command\r\n"))
+				continue
+			}
+
+			vector, element, options, err := parseVAddArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			added, err := store.VAdd(args[0], element, vector, options)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", boolToInt(added)))
+		case "VREM":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'vrem' command\r\n"))
+				continue
+			}
+
+			removed, err := store.VRem(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", boolToInt(removed)))
+		case "VSIM":
+			if len(args) < 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'vsim' command\r\n"))
+				continue
+			}
+
+			query, options, err := parseVSimArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			matches, err := store.VSim(args[0], query)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeVectorMatches(&resp, matches, options)
+			conn.Write([]byte(resp.String()))
+		case "VCARD", "VDIM":
+			if len(args) != 1 {
+				conn.Write(fmt.Appendf(nil, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(command)))
+				continue
+			}
+
+			var n int
+			var err error
+			if command == "VCARD" {
+				n, err = store.VCard(args[0])
+			} else {
+				n, err = store.VDim(args[0])
+			}
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", n))
+		case "VGETATTR":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'vgetattr' command\r\n"))
+				continue
+			}
+
+			attributes, ok, err := store.VGetAttr(args[0], args[1])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if !ok {
+				conn.Write([]byte("_\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(attributes), attributes))
+		case "VSETATTR":
+			if len(args) != 3 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'vsetattr' command\r\n"))
+				continue
+			}
+
+			updated, err := store.VSetAttr(args[0], args[1], args[2])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", boolToInt(updated)))
+		case "FT.CREATE":
+			if len(args) < 4 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ft.create' command\r\n"))
+				continue
+			}
+
+			schema, err := parseFTCreateArgs(args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			if err := store.FTCreate(args[0], schema); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "FT.DROPINDEX":
+			if len(args) != 1 && len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ft.dropindex' command\r\n"))
+				continue
+			}
+			if len(args) == 2 && strings.ToUpper(args[1]) != "DD" {
+				conn.Write([]byte("-ERR syntax error\r\n"))
+				continue
+			}
+
+			if err := store.FTDropIndex(args[0], len(args) == 2); err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "FT._LIST":
+			var resp strings.Builder
+			writeBulkStrings(&resp, store.FTList(), nil)
+			conn.Write([]byte(resp.String()))
+		case "FT.SEARCH":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ft.search' command\r\n"))
+				continue
+			}
+
+			query, err := parseFTSearchArgs(args[2:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			query.Query = args[1]
+			total, results, err := store.FTSearch(args[0], query)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeSearchReply(&resp, total, results, true, query.NoContent)
+			conn.Write([]byte(resp.String()))
+		case "FT.AGGREGATE":
+			if len(args) < 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'ft.aggregate' command\r\n"))
+				continue
+			}
+
+			steps, err := parseFTAggregateArgs(args[2:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			results, err := store.FTAggregate(args[0], args[1], steps)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			var resp strings.Builder
+			writeSearchReply(&resp, len(results), results, false, false)
+			conn.Write([]byte(resp.String()))
+		case "MULTI":
+			if len(args) != 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'multi' command\r\n"))
+				continue
+			}
+			if client.inMulti {
+				conn.Write([]byte("-ERR MULTI calls can not be nested\r\n"))
+				continue
+			}
+			client.inMulti = true
+			conn.Write([]byte("+OK\r\n"))
+		case "EXEC":
+			if len(args) != 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'exec' command\r\n"))
+				continue
+			}
+			if !client.inMulti {
+				conn.Write([]byte("-ERR EXEC without MULTI\r\n"))
+				continue
+			}
+			if client.aborted {
+				client.discard()
+				conn.Write([]byte("-EXECABORT Transaction discarded because of previous errors.\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, "*%d\r\n", client.exec(store)))
+		case "DISCARD":
+			if len(args) != 0 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'discard' command\r\n"))
+				continue
+			}
+			if !client.inMulti {
+				conn.Write([]byte("-ERR DISCARD without MULTI\r\n"))
+				continue
+			}
+			client.discard()
+			conn.Write([]byte("+OK\r\n"))
+		case "PFADD":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'pfadd' command\r\n"))
+				continue
+			}
+
+			updated, err := store.PFAdd(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", updated))
+		case "PFCOUNT":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'pfcount' command\r\n"))
+				continue
+			}
+
+			cardinality, err := store.PFCount(args)
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write(fmt.Appendf(nil, ":%d\r\n", cardinality))
+		case "PFMERGE":
+			if len(args) < 1 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'pfmerge' command\r\n"))
+				continue
+			}
+
+			err := store.PFMerge(args[0], args[1:])
+			if err != nil {
+				conn.Write([]byte(err.Error() + "\r\n"))
+				continue
+			}
+			conn.Write([]byte("+OK\r\n"))
+		case "PFDEBUG":
+			if len(args) != 2 {
+				conn.Write([]byte("-ERR wrong number of arguments for 'pfdebug' command\r\n"))
+				continue
+			}
+
+			key := args[1]
+			switch strings.ToUpper(args[0]) {
+			case "GETREG":
+				registers, err := store.PFDebugGetReg(key)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				var resp strings.Builder
+				resp.WriteString(fmt.Sprintf("*%d\r\n", len(registers)))
+				for _, register := range registers {
+					resp.WriteString(fmt.Sprintf(":%d\r\n", register))
+				}
+				conn.Write([]byte(resp.String()))
+			case "DECODE":
+				decoded, err := store.PFDebugDecode(key)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, "$%d\r\n%s\r\n", len(decoded), decoded))
+			case "ENCODING":
+				encoding, err := store.PFDebugEncoding(key)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write([]byte("+" + encoding + "\r\n"))
+			case "TODENSE":
+				converted, err := store.PFDebugToDense(key)
+				if err != nil {
+					conn.Write([]byte(err.Error() + "\r\n"))
+					continue
+				}
+				conn.Write(fmt.Appendf(nil, ":%d\r\n", converted))
+			default:
+				conn.Write([]byte("-ERR Unknown PFDEBUG subcommand '" + args[0] + "'\r\n"))
+			}
 		default:
 			conn.Write([]byte("-ERR unknown command '" + strings.ToLower(command) + "', with args beginning with: " + strings.Join(args, " ") + "\r\n"))
 		}
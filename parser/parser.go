@@ -3,6 +3,7 @@ package parser
 import (
 	"bufio"
 	"errors"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -40,13 +41,12 @@ func ParseRESP(reader *bufio.Reader) (string, []string, error) {
 			}
 
 			word := make([]byte, stringLength+2)
-			_, err = reader.Read(word)
+			_, err = io.ReadFull(reader, word)
 			if err != nil {
 				return "", nil, errors.New("invalid string length")
 			}
 
-			word = []byte(strings.TrimSpace(string(word)))
-			args = append(args, string(word))
+			args = append(args, string(word[:stringLength]))
 		}
 
 		if len(args) == 0 {
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Causes used with context.WithCancelCause to unblock a client through
+// CLIENT UNBLOCK. ErrUnblockedTimeout makes the blocking call behave as if its
+// timeout expired; ErrUnblockedError is returned to the client as is.
+var ErrUnblockedTimeout = errors.New("-UNBLOCKED client unblocked via CLIENT UNBLOCK TIMEOUT")
+var ErrUnblockedError = errors.New("-UNBLOCKED client unblocked via CLIENT UNBLOCK")
+
+// blockedClient is a client waiting on one or more keys. serve is invoked with
+// s.mu held every time one of those keys may have become satisfiable, and
+// reports whether the client got what it was waiting for. A client that has
+// been served is removed from the queues of all its keys at once.
+type blockedClient struct {
+	ctx    context.Context
+	keys   []string
+	serve  func(key string) bool
+	done   chan struct{}
+	served bool
+}
+
+// signalKeyAsReady must be called with s.mu held by every write that may
+// satisfy a client blocked on key.
+func (s *InMemoryStore) signalKeyAsReady(key string) {
+	if len(s.blockedClients[key]) == 0 {
+		return
+	}
+	if _, ok := s.readyKeySet[key]; ok {
+		return
+	}
+	s.readyKeySet[key] = struct{}{}
+	s.readyKeys = append(s.readyKeys, key)
+}
+
+// serveBlockedClients must be called with s.mu held at the end of every write.
+// Clients are served in the order they blocked; serving one may signal further
+// keys (e.g. the destination of a BLMOVE), which are handled in the same pass.
+// During a transaction the ready keys are kept until EndTransaction.
+func (s *InMemoryStore) serveBlockedClients() {
+	if s.inTransaction {
+		return
+	}
+	for len(s.readyKeys) > 0 {
+		key := s.readyKeys[0]
+		s.readyKeys = s.readyKeys[1:]
+		delete(s.readyKeySet, key)
+
+		for _, client := range slices.Clone(s.blockedClients[key]) {
+			if client.served {
+				continue
+			}
+			if client.ctx.Err() != nil {
+				s.removeBlockedClient(client)
+				continue
+			}
+			if client.serve(key) {
+				s.unblockClient(client)
+			}
+		}
+	}
+	s.readyKeys = nil
+}
+
+func (s *InMemoryStore) unblockClient(client *blockedClient) {
+	client.served = true
+	s.removeBlockedClient(client)
+	close(client.done)
+}
+
+func (s *InMemoryStore) removeBlockedClient(client *blockedClient) {
+	for _, key := range client.keys {
+		s.blockedClients[key] = slices.DeleteFunc(s.blockedClients[key], func(c *blockedClient) bool {
+			return c == client
+		})
+		if len(s.blockedClients[key]) == 0 {
+			delete(s.blockedClients, key)
+		}
+	}
+}
+
+// blockOn must be called with s.mu held, after the caller has already failed
+// to serve itself immediately, and returns with s.mu released. It reports
+// whether serve succeeded before the timeout; a zero timeout waits forever.
+//
+// Cancelling ctx (client disconnect or CLIENT UNBLOCK) removes the client
+// under s.mu, so no later write can hand it anything. If serve already ran
+// but the cancellation won the race to wake the client, restore is called
+// with s.mu held to put back what serve consumed.
+//
+// The caller's share of commandMu is released while waiting so transactions
+// can run. Inside a transaction nothing could ever wake the client, so it
+// behaves as if the timeout expired right away.
+func (s *InMemoryStore) blockOn(ctx context.Context, keys []string, timeout time.Duration, serve func(key string) bool, restore func()) (bool, error) {
+	if s.inTransaction {
+		s.mu.Unlock()
+		return false, nil
+	}
+
+	client := &blockedClient{
+		ctx:   ctx,
+		keys:  slices.Clone(keys),
+		serve: serve,
+		done:  make(chan struct{}),
+	}
+	for _, key := range client.keys {
+		s.blockedClients[key] = append(s.blockedClients[key], client)
+	}
+	s.mu.Unlock()
+	s.commandMu.RUnlock()
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	select {
+	case <-client.done:
+		s.commandMu.RLock()
+		return true, nil
+	case <-timer:
+		s.commandMu.RLock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if client.served {
+			return true, nil
+		}
+		s.removeBlockedClient(client)
+		return false, nil
+	case <-ctx.Done():
+		s.commandMu.RLock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if client.served {
+			if restore != nil {
+				restore()
+				s.serveBlockedClients()
+			}
+		} else {
+			s.removeBlockedClient(client)
+		}
+
+		cause := context.Cause(ctx)
+		if errors.Is(cause, ErrUnblockedTimeout) {
+			return false, nil
+		}
+		return false, cause
+	}
+}
@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Blocking commands must run between BeginCommand and EndCommand like they
+// do for a connection, since blockOn releases the command lock while waiting.
+
+type popResult struct {
+	key    string
+	values []string
+	ok     bool
+	err    error
+}
+
+func startBLMPop(s *InMemoryStore, ctx context.Context, keys []string, left bool, count int, timeout time.Duration) <-chan popResult {
+	result := make(chan popResult, 1)
+	go func() {
+		s.BeginCommand()
+		defer s.EndCommand()
+		key, values, ok, err := s.BLMPop(ctx, keys, left, count, timeout)
+		result <- popResult{key, values, ok, err}
+	}()
+	return result
+}
+
+// waitBlocked waits until n clients are blocked on key.
+func waitBlocked(t *testing.T, s *InMemoryStore, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		blocked := len(s.blockedClients[key])
+		s.mu.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%d clients never blocked on %q", n, key)
+}
+
+func receive(t *testing.T, result <-chan popResult) popResult {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked client was never served")
+	}
+	return popResult{}
+}
+
+func TestBlockedClientsServedInOrder(t *testing.T) {
+	s := NewInMemoryStore()
+	var results []<-chan popResult
+	for i := range 3 {
+		results = append(results, startBLMPop(s, context.Background(), []string{"list"}, true, 1, 0))
+		waitBlocked(t, s, "list", i+1)
+	}
+
+	s.RPush("list", []string{"a", "b", "c", "d"})
+	for i, want := range []string{"a", "b", "c"} {
+		if r := receive(t, results[i]); r.key != "list" || !slices.Equal(r.values, []string{want}) {
+			t.Errorf("client %d got %+v, want %s", i, r, want)
+		}
+	}
+	if got := listContents(t, s, "list"); !slices.Equal(got, []string{"d"}) {
+		t.Errorf("list = %v after serving, want [d]", got)
+	}
+}
+
+func TestOnePushServesOneClient(t *testing.T) {
+	s := NewInMemoryStore()
+	first := startBLMPop(s, context.Background(), []string{"list"}, true, 1, 0)
+	waitBlocked(t, s, "list", 1)
+	second := startBLMPop(s, context.Background(), []string{"list"}, true, 1, 0)
+	waitBlocked(t, s, "list", 2)
+
+	s.RPush("list", []string{"a"})
+	if r := receive(t, first); !slices.Equal(r.values, []string{"a"}) {
+		t.Errorf("first client got %+v", r)
+	}
+	waitBlocked(t, s, "list", 1)
+
+	s.LPush("list", []string{"b"})
+	if r := receive(t, second); !slices.Equal(r.values, []string{"b"}) {
+		t.Errorf("second client got %+v", r)
+	}
+	if _, ok := s.KeyType["list"]; ok {
+		t.Error("served list was not deleted once empty")
+	}
+}
+
+func TestBlockedOnSeveralKeys(t *testing.T) {
+	s := NewInMemoryStore()
+	result := startBLMPop(s, context.Background(), []string{"first", "second"}, false, 2, 0)
+	waitBlocked(t, s, "second", 1)
+
+	s.RPush("second", []string{"a", "b", "c"})
+	if r := receive(t, result); r.key != "second" || !slices.Equal(r.values, []string{"c", "b"}) {
+		t.Errorf("client got %+v, want [c b] from second", r)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.blockedClients) != 0 {
+		t.Errorf("served client is still blocked on %v", s.blockedClients)
+	}
+}
+
+func TestBLMoveServesChain(t *testing.T) {
+	s := NewInMemoryStore()
+	moved := make(chan string, 1)
+	go func() {
+		s.BeginCommand()
+		defer s.EndCommand()
+		value, _, _ := s.BLMove(context.Background(), "source", "destination", true, false, 0)
+		moved <- value
+	}()
+	waitBlocked(t, s, "source", 1)
+	popped := startBLMPop(s, context.Background(), []string{"destination"}, true, 1, 0)
+	waitBlocked(t, s, "destination", 1)
+
+	s.RPush("source", []string{"a"})
+	select {
+	case value := <-moved:
+		if value != "a" {
+			t.Errorf("BLMove moved %q", value)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BLMove was never served")
+	}
+	if r := receive(t, popped); r.key != "destination" || !slices.Equal(r.values, []string{"a"}) {
+		t.Errorf("client blocked on the destination got %+v", r)
+	}
+}
+
+func TestBLMPopTimeout(t *testing.T) {
+	s := NewInMemoryStore()
+	r := receive(t, startBLMPop(s, context.Background(), []string{"list"}, true, 1, 10*time.Millisecond))
+	if r.ok || r.err != nil {
+		t.Errorf("timed out BLMPop = %+v", r)
+	}
+	waitBlocked(t, s, "list", 0)
+}
+
+func TestLMPop(t *testing.T) {
+	s := NewInMemoryStore()
+	s.RPush("second", []string{"a", "b", "c"})
+
+	key, values, err := s.LMPop([]string{"first", "second"}, true, 2)
+	if err != nil || key != "second" || !slices.Equal(values, []string{"a", "b"}) {
+		t.Errorf("LMPop = %q, %v, %v", key, values, err)
+	}
+	key, values, _ = s.LMPop([]string{"first", "second"}, false, 10)
+	if key != "second" || !slices.Equal(values, []string{"c"}) {
+		t.Errorf("LMPop = %q, %v, want [c]", key, values)
+	}
+	if key, values, _ := s.LMPop([]string{"first", "second"}, true, 1); key != "" || values != nil {
+		t.Errorf("LMPop on empty lists = %q, %v", key, values)
+	}
+
+	s.RPush("list", []string{"a"})
+	s.StringSet("string", "value", 0, false, false, false, false)
+	if _, _, err := s.LMPop([]string{"list", "string"}, true, 1); err == nil {
+		t.Error("LMPop with a string among the keys did not fail")
+	}
+	if got := listContents(t, s, "list"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("failed LMPop popped from the list: %v", got)
+	}
+}
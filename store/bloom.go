@@ -0,0 +1,297 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+)
+
+// A scalable Bloom filter is a stack of plain Bloom filters. Items go into
+// the newest layer until it holds its capacity, after which a layer with
+// expansion times the capacity and half the error rate is added. The first
+// layer gets half the filter's error rate, so the rates of all the layers sum
+// to less than it and so does the compound false positive rate.
+const (
+	bloomDefaultErrorRate = 0.01
+	bloomDefaultCapacity  = 100
+	BloomDefaultExpansion = 2
+	bloomTighteningRatio  = 0.5
+	bloomMaxBits          = 1 << 36
+)
+
+var errBloomFull = errors.New("-ERR non scaling filter is full")
+
+type bloomLayer struct {
+	bits      []uint64
+	numBits   uint64
+	numHashes uint64
+	capacity  uint64
+	count     uint64
+}
+
+func newBloomLayer(capacity uint64, errorRate float64) (*bloomLayer, error) {
+	numBits := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if numBits > bloomMaxBits {
+		return nil, errors.New("-ERR Insufficient memory to create filter")
+	}
+	layer := &bloomLayer{
+		numBits:   max(uint64(numBits), 64),
+		numHashes: uint64(max(math.Ceil(-math.Log2(errorRate)), 1)),
+		capacity:  capacity,
+	}
+	layer.bits = make([]uint64, (layer.numBits+63)/64)
+	return layer, nil
+}
+
+// bloomHashes returns the two hashes the bit positions of an item are
+// derived from, as in Kirsch and Mitzenmacher's double hashing.
+func bloomHashes(item string) (uint64, uint64) {
+	return murmurHash64A([]byte(item), 0xc6a4a7935bd1e995), murmurHash64A([]byte(item), 0x5bd1e995)
+}
+
+func (layer *bloomLayer) has(h1 uint64, h2 uint64) bool {
+	for i := range layer.numHashes {
+		bit := (h1 + i*h2) % layer.numBits
+		if layer.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (layer *bloomLayer) add(h1 uint64, h2 uint64) {
+	for i := range layer.numHashes {
+		bit := (h1 + i*h2) % layer.numBits
+		layer.bits[bit/64] |= 1 << (bit % 64)
+	}
+	layer.count++
+}
+
+type BloomFilter struct {
+	layers     []*bloomLayer
+	errorRate  float64
+	expansion  uint64
+	nonScaling bool
+}
+
+func NewBloomFilter(errorRate float64, capacity uint64, expansion uint64, nonScaling bool) (*BloomFilter, error) {
+	bf := &BloomFilter{
+		errorRate:  errorRate,
+		expansion:  expansion,
+		nonScaling: nonScaling,
+	}
+	layer, err := newBloomLayer(capacity, bf.layerErrorRate(0))
+	if err != nil {
+		return nil, err
+	}
+	bf.layers = []*bloomLayer{layer}
+	return bf, nil
+}
+
+// layerErrorRate returns the error rate of layer i. A non-scaling filter only
+// ever has one layer, which gets the whole error rate.
+func (bf *BloomFilter) layerErrorRate(i int) float64 {
+	if bf.nonScaling {
+		return bf.errorRate
+	}
+	return bf.errorRate * (1 - bloomTighteningRatio) * math.Pow(bloomTighteningRatio, float64(i))
+}
+
+func (bf *BloomFilter) Encoding() string {
+	return "raw"
+}
+
+func (bf *BloomFilter) Exists(item string) bool {
+	h1, h2 := bloomHashes(item)
+	for _, layer := range bf.layers {
+		if layer.has(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds item and reports whether it was new, that is whether no layer
+// already seemed to hold it.
+func (bf *BloomFilter) Add(item string) (bool, error) {
+	h1, h2 := bloomHashes(item)
+	for _, layer := range bf.layers {
+		if layer.has(h1, h2) {
+			return false, nil
+		}
+	}
+
+	last := bf.layers[len(bf.layers)-1]
+	if last.count >= last.capacity {
+		if bf.nonScaling {
+			return false, errBloomFull
+		}
+		layer, err := newBloomLayer(last.capacity*bf.expansion, bf.layerErrorRate(len(bf.layers)))
+		if err != nil {
+			return false, err
+		}
+		bf.layers = append(bf.layers, layer)
+		last = layer
+	}
+	last.add(h1, h2)
+	return true, nil
+}
+
+// BloomInfo describes a Bloom filter for BF.INFO. Size is in bytes.
+type BloomInfo struct {
+	Capacity  uint64
+	Size      uint64
+	Filters   int
+	Items     uint64
+	Expansion uint64
+}
+
+func (bf *BloomFilter) Info() BloomInfo {
+	info := BloomInfo{Filters: len(bf.layers), Expansion: bf.expansion}
+	for _, layer := range bf.layers {
+		info.Capacity += layer.capacity
+		info.Size += uint64(len(layer.bits)) * 8
+		info.Items += layer.count
+	}
+	return info
+}
+
+type bloomLayerSnapshot struct {
+	Bits      []uint64
+	NumBits   uint64
+	NumHashes uint64
+	Capacity  uint64
+	Count     uint64
+}
+
+type bloomSnapshot struct {
+	Layers     []bloomLayerSnapshot
+	ErrorRate  float64
+	Expansion  uint64
+	NonScaling bool
+}
+
+func (bf *BloomFilter) GobEncode() ([]byte, error) {
+	snapshot := bloomSnapshot{ErrorRate: bf.errorRate, Expansion: bf.expansion, NonScaling: bf.nonScaling}
+	for _, layer := range bf.layers {
+		snapshot.Layers = append(snapshot.Layers, bloomLayerSnapshot{
+			Bits:      layer.bits,
+			NumBits:   layer.numBits,
+			NumHashes: layer.numHashes,
+			Capacity:  layer.capacity,
+			Count:     layer.count,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (bf *BloomFilter) GobDecode(data []byte) error {
+	var snapshot bloomSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	bf.errorRate, bf.expansion, bf.nonScaling = snapshot.ErrorRate, snapshot.Expansion, snapshot.NonScaling
+	bf.layers = nil
+	for _, layer := range snapshot.Layers {
+		bf.layers = append(bf.layers, &bloomLayer{
+			bits:      layer.Bits,
+			numBits:   layer.NumBits,
+			numHashes: layer.NumHashes,
+			capacity:  layer.Capacity,
+			count:     layer.Count,
+		})
+	}
+	return nil
+}
+
+func (s *InMemoryStore) bloomLocked(key string) (*BloomFilter, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != BloomType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.BloomKV[key], nil
+}
+
+func (s *InMemoryStore) BFReserve(key string, errorRate float64, capacity uint64, expansion uint64, nonScaling bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR item exists")
+	}
+	bf, err := NewBloomFilter(errorRate, capacity, expansion, nonScaling)
+	if err != nil {
+		return err
+	}
+	s.KeyType[key] = BloomType
+	s.BloomKV[key] = bf
+	return nil
+}
+
+// BFAdd adds items to the filter at key, creating it with the default error
+// rate and capacity if needed, and reports which of them were new. An item
+// that doesn't fit a full non-scaling filter gets an error of its own
+// instead.
+func (s *InMemoryStore) BFAdd(key string, items []string) ([]bool, []error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bf, err := s.bloomLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bf == nil {
+		bf, err = NewBloomFilter(bloomDefaultErrorRate, bloomDefaultCapacity, BloomDefaultExpansion, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.KeyType[key] = BloomType
+		s.BloomKV[key] = bf
+	}
+
+	added := make([]bool, len(items))
+	errs := make([]error, len(items))
+	for i, item := range items {
+		added[i], errs[i] = bf.Add(item)
+	}
+	return added, errs, nil
+}
+
+func (s *InMemoryStore) BFExists(key string, items []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bf, err := s.bloomLocked(key)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make([]bool, len(items))
+	if bf != nil {
+		for i, item := range items {
+			exists[i] = bf.Exists(item)
+		}
+	}
+	return exists, nil
+}
+
+func (s *InMemoryStore) BFInfo(key string) (BloomInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bf, err := s.bloomLocked(key)
+	if err != nil {
+		return BloomInfo{}, err
+	}
+	if bf == nil {
+		return BloomInfo{}, errors.New("-ERR not found")
+	}
+	return bf.Info(), nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// TestBloomCompoundErrorRate fills a scaling filter to four layers and
+// checks that neither its false positive rate nor that of a full non-scaling
+// filter goes over the error rate they were created with.
+func TestBloomCompoundErrorRate(t *testing.T) {
+	const errorRate = 0.01
+	tests := []struct {
+		name       string
+		nonScaling bool
+		items      int
+		layers     int
+	}{
+		{"scaling", false, 15000, 4},
+		{"non-scaling", true, 1000, 1},
+	}
+	for _, test := range tests {
+		bf, err := NewBloomFilter(errorRate, 1000, 2, test.nonScaling)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range test.items {
+			if _, err := bf.Add("in" + strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(bf.layers) != test.layers {
+			t.Fatalf("%s: %d layers, want %d", test.name, len(bf.layers), test.layers)
+		}
+		for i := range test.items {
+			if !bf.Exists("in" + strconv.Itoa(i)) {
+				t.Fatalf("%s: added item %d not found", test.name, i)
+			}
+		}
+
+		const probes = 200000
+		falsePositives := 0
+		for i := range probes {
+			if bf.Exists("out" + strconv.Itoa(i)) {
+				falsePositives++
+			}
+		}
+		if rate := float64(falsePositives) / probes; rate > errorRate {
+			t.Errorf("%s: false positive rate %v, want at most %v", test.name, rate, errorRate)
+		}
+	}
+}
+
+func TestBloomNonScalingFull(t *testing.T) {
+	bf, _ := NewBloomFilter(0.01, 10, 2, true)
+	added := 0
+	var err error
+	for i := 0; err == nil; i++ {
+		var ok bool
+		if ok, err = bf.Add(strconv.Itoa(i)); ok {
+			added++
+		}
+	}
+	if err != errBloomFull || added != 10 || len(bf.layers) != 1 {
+		t.Errorf("full non-scaling filter took %d items over %d layers, then %v", added, len(bf.layers), err)
+	}
+}
+
+func TestBloomCommands(t *testing.T) {
+	s := NewInMemoryStore()
+	added, errs, err := s.BFAdd("bf", []string{"a", "b", "a"})
+	if err != nil || !slices.Equal(added, []bool{true, true, false}) || slices.ContainsFunc(errs, func(err error) bool { return err != nil }) {
+		t.Errorf("BFAdd = %v, %v, %v", added, errs, err)
+	}
+	if exists, _ := s.BFExists("bf", []string{"a", "b", "c"}); !slices.Equal(exists, []bool{true, true, false}) {
+		t.Errorf("BFExists = %v", exists)
+	}
+	if exists, err := s.BFExists("missing", []string{"a"}); err != nil || exists[0] {
+		t.Errorf("BFExists on a missing key = %v, %v", exists, err)
+	}
+	info, _ := s.BFInfo("bf")
+	if info.Capacity != bloomDefaultCapacity || info.Items != 2 || info.Filters != 1 || info.Expansion != BloomDefaultExpansion {
+		t.Errorf("BFInfo = %+v", info)
+	}
+	if _, err := s.BFInfo("missing"); err == nil {
+		t.Error("BFInfo of a missing key did not fail")
+	}
+
+	if err := s.BFReserve("bf", 0.01, 100, 2, false); err == nil {
+		t.Error("BFReserve of an existing key did not fail")
+	}
+	s.BFReserve("small", 0.01, 2, 2, true)
+	_, errs, _ = s.BFAdd("small", []string{"a", "b", "c"})
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Errorf("BFAdd past a non-scaling capacity = %v", errs)
+	}
+
+	s.RPush("list", []string{"a"})
+	if _, _, err := s.BFAdd("list", []string{"a"}); err == nil {
+		t.Error("BFAdd on a list did not fail")
+	}
+}
+
+func TestBloomSnapshot(t *testing.T) {
+	bf, _ := NewBloomFilter(0.01, 100, 2, false)
+	for i := range 500 {
+		bf.Add(strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(bf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded BloomFilter
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Info() != bf.Info() {
+		t.Errorf("decoded info %+v, want %+v", decoded.Info(), bf.Info())
+	}
+	for i := range 1000 {
+		if item := strconv.Itoa(i); decoded.Exists(item) != bf.Exists(item) {
+			t.Fatalf("decoded filter disagrees on %s", item)
+		}
+	}
+
+	// Layers added after decoding keep tightening the error rate.
+	for i := 500; i < 2000; i++ {
+		decoded.Add(strconv.Itoa(i))
+	}
+	if last := decoded.layers[len(decoded.layers)-1]; last.numHashes <= bf.layers[len(bf.layers)-1].numHashes {
+		t.Errorf("layer added after decoding has %d hashes", last.numHashes)
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+)
+
+// CountMinSketch estimates item counts with depth rows of width counters.
+// An item increments one counter per row and its estimate is the smallest
+// of them, which overestimates only by the collisions in the luckiest row.
+type CountMinSketch struct {
+	width    uint64
+	depth    uint64
+	counters []int64
+	count    int64
+}
+
+var errCMSNoKey = errors.New("-ERR CMS: key does not exist")
+
+func NewCountMinSketch(width uint64, depth uint64) *CountMinSketch {
+	return &CountMinSketch{width: width, depth: depth, counters: make([]int64, width*depth)}
+}
+
+// cmsDimensions returns the width and depth of a sketch whose estimates
+// exceed the true count by more than errorRate of the total with at most
+// the given probability.
+func cmsDimensions(errorRate float64, probability float64) (uint64, uint64) {
+	width := math.Ceil(2 / errorRate)
+	depth := math.Ceil(math.Log10(probability) / math.Log10(0.5))
+	return uint64(width), uint64(max(depth, 1))
+}
+
+func (cms *CountMinSketch) Encoding() string {
+	return "raw"
+}
+
+func (cms *CountMinSketch) counter(row uint64, item string) *int64 {
+	column := murmurHash64A([]byte(item), row) % cms.width
+	return &cms.counters[row*cms.width+column]
+}
+
+// IncrBy adds increment to the counters of item and returns its new
+// estimate.
+func (cms *CountMinSketch) IncrBy(item string, increment int64) int64 {
+	estimate := int64(math.MaxInt64)
+	for row := range cms.depth {
+		counter := cms.counter(row, item)
+		*counter += increment
+		estimate = min(estimate, *counter)
+	}
+	cms.count += increment
+	return estimate
+}
+
+func (cms *CountMinSketch) Query(item string) int64 {
+	estimate := int64(math.MaxInt64)
+	for row := range cms.depth {
+		estimate = min(estimate, *cms.counter(row, item))
+	}
+	return estimate
+}
+
+type cmsSnapshot struct {
+	Width    uint64
+	Depth    uint64
+	Counters []int64
+	Count    int64
+}
+
+func (cms *CountMinSketch) GobEncode() ([]byte, error) {
+	snapshot := cmsSnapshot{Width: cms.width, Depth: cms.depth, Counters: cms.counters, Count: cms.count}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cms *CountMinSketch) GobDecode(data []byte) error {
+	var snapshot cmsSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	cms.width, cms.depth, cms.counters, cms.count = snapshot.Width, snapshot.Depth, snapshot.Counters, snapshot.Count
+	return nil
+}
+
+func (s *InMemoryStore) cmsLocked(key string) (*CountMinSketch, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != CMSType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.CMSKV[key], nil
+}
+
+func (s *InMemoryStore) CMSInitByDim(key string, width uint64, depth uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR CMS: key already exists")
+	}
+	s.KeyType[key] = CMSType
+	s.CMSKV[key] = NewCountMinSketch(width, depth)
+	return nil
+}
+
+func (s *InMemoryStore) CMSInitByProb(key string, errorRate float64, probability float64) error {
+	width, depth := cmsDimensions(errorRate, probability)
+	return s.CMSInitByDim(key, width, depth)
+}
+
+func (s *InMemoryStore) CMSIncrBy(key string, items []string, increments []int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cms, err := s.cmsLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if cms == nil {
+		return nil, errCMSNoKey
+	}
+
+	estimates := make([]int64, len(items))
+	for i, item := range items {
+		estimates[i] = cms.IncrBy(item, increments[i])
+	}
+	return estimates, nil
+}
+
+func (s *InMemoryStore) CMSQuery(key string, items []string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cms, err := s.cmsLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if cms == nil {
+		return nil, errCMSNoKey
+	}
+
+	estimates := make([]int64, len(items))
+	for i, item := range items {
+		estimates[i] = cms.Query(item)
+	}
+	return estimates, nil
+}
+
+// CMSMerge overwrites the sketch at destination with the sum of the source
+// sketches, each multiplied by its weight. All of them must have the same
+// dimensions.
+func (s *InMemoryStore) CMSMerge(destination string, sources []string, weights []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dest, err := s.cmsLocked(destination)
+	if err != nil {
+		return err
+	}
+	if dest == nil {
+		return errCMSNoKey
+	}
+
+	sketches := make([]*CountMinSketch, len(sources))
+	for i, source := range sources {
+		cms, err := s.cmsLocked(source)
+		if err != nil {
+			return err
+		}
+		if cms == nil {
+			return errCMSNoKey
+		}
+		if cms.width != dest.width || cms.depth != dest.depth {
+			return errors.New("-ERR CMS: width/depth is not equal")
+		}
+		sketches[i] = cms
+	}
+
+	counters := make([]int64, len(dest.counters))
+	count := int64(0)
+	for i, cms := range sketches {
+		for j, counter := range cms.counters {
+			counters[j] += counter * weights[i]
+		}
+		count += cms.count * weights[i]
+	}
+	dest.counters, dest.count = counters, count
+	return nil
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestCMSDimensions(t *testing.T) {
+	tests := []struct {
+		errorRate, probability float64
+		width, depth           uint64
+	}{
+		{0.01, 0.01, 200, 7},
+		{0.001, 0.002, 2000, 9},
+		{0.5, 0.9, 4, 1},
+	}
+	for _, test := range tests {
+		if width, depth := cmsDimensions(test.errorRate, test.probability); width != test.width || depth != test.depth {
+			t.Errorf("cmsDimensions(%v, %v) = %d, %d, want %d, %d", test.errorRate, test.probability, width, depth, test.width, test.depth)
+		}
+	}
+}
+
+// TestCMSErrorBound checks that estimates never undercount and exceed the
+// true count by more than errorRate of the total for no more than about the
+// given probability of items.
+func TestCMSErrorBound(t *testing.T) {
+	const errorRate, probability = 0.01, 0.01
+	cms := NewCountMinSketch(cmsDimensions(errorRate, probability))
+	rng := rand.New(rand.NewSource(1))
+
+	counts := make(map[string]int64)
+	var total int64
+	for range 50000 {
+		item := strconv.Itoa(int(rng.ExpFloat64() * 2000))
+		increment := rng.Int63n(5) + 1
+		counts[item] += increment
+		total += increment
+		cms.IncrBy(item, increment)
+	}
+	if cms.count != total {
+		t.Errorf("sketch counted %d, want %d", cms.count, total)
+	}
+
+	over := 0
+	for item, count := range counts {
+		estimate := cms.Query(item)
+		if estimate < count {
+			t.Fatalf("estimate of %s is %d, below its count %d", item, estimate, count)
+		}
+		if float64(estimate-count) > errorRate*float64(total) {
+			over++
+		}
+	}
+	if float64(over) > 2*probability*float64(len(counts)) {
+		t.Errorf("%d of %d estimates are off by more than the error rate", over, len(counts))
+	}
+}
+
+func TestCMSCommands(t *testing.T) {
+	s := NewInMemoryStore()
+	s.CMSInitByDim("a", 100, 5)
+	s.CMSInitByDim("b", 100, 5)
+	s.CMSInitByDim("dest", 100, 5)
+	s.CMSInitByProb("other", 0.1, 0.1)
+
+	if estimates, err := s.CMSIncrBy("a", []string{"x", "y", "x"}, []int64{2, 3, 4}); err != nil || !slices.Equal(estimates, []int64{2, 3, 6}) {
+		t.Errorf("CMSIncrBy = %v, %v", estimates, err)
+	}
+	s.CMSIncrBy("b", []string{"x", "z"}, []int64{1, 10})
+	if estimates, _ := s.CMSQuery("a", []string{"x", "y", "z"}); !slices.Equal(estimates, []int64{6, 3, 0}) {
+		t.Errorf("CMSQuery = %v", estimates)
+	}
+
+	if err := s.CMSMerge("dest", []string{"a", "b"}, []int64{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if estimates, _ := s.CMSQuery("dest", []string{"x", "y", "z"}); !slices.Equal(estimates, []int64{8, 3, 20}) {
+		t.Errorf("CMSQuery after CMSMerge = %v", estimates)
+	}
+	if count := s.CMSKV["dest"].count; count != 9+2*11 {
+		t.Errorf("merged count = %d", count)
+	}
+	// Merging into a source overwrites it with the sum.
+	s.CMSMerge("a", []string{"a", "a"}, []int64{1, 1})
+	if estimates, _ := s.CMSQuery("a", []string{"x"}); estimates[0] != 12 {
+		t.Errorf("CMSMerge into a source = %v", estimates)
+	}
+
+	if err := s.CMSMerge("dest", []string{"a", "other"}, []int64{1, 1}); err == nil {
+		t.Error("CMSMerge of different dimensions did not fail")
+	}
+	if err := s.CMSMerge("dest", []string{"missing"}, []int64{1}); err == nil {
+		t.Error("CMSMerge from a missing key did not fail")
+	}
+	if estimates, _ := s.CMSQuery("dest", []string{"x"}); estimates[0] != 8 {
+		t.Errorf("failed CMSMerge changed the destination: %v", estimates)
+	}
+	if err := s.CMSInitByDim("a", 10, 1); err == nil {
+		t.Error("CMSInitByDim of an existing key did not fail")
+	}
+	if _, err := s.CMSIncrBy("missing", []string{"x"}, []int64{1}); err == nil {
+		t.Error("CMSIncrBy on a missing key did not fail")
+	}
+	if _, err := s.CMSQuery("missing", []string{"x"}); err == nil {
+		t.Error("CMSQuery on a missing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.CMSQuery("list", []string{"x"}); err == nil {
+		t.Error("CMSQuery on a list did not fail")
+	}
+}
+
+func TestCMSSnapshot(t *testing.T) {
+	cms := NewCountMinSketch(50, 4)
+	for i := range 1000 {
+		cms.IncrBy(strconv.Itoa(i%100), int64(i%7))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cms); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CountMinSketch
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.width != 50 || decoded.depth != 4 || decoded.count != cms.count || !slices.Equal(decoded.counters, cms.counters) {
+		t.Errorf("decoded %dx%d sketch counting %d", decoded.width, decoded.depth, decoded.count)
+	}
+}
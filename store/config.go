@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// encodingConfig holds the thresholds past which small sets and hashes are
+// converted from their compact encodings to hash tables, and the number of
+// entries in each chunk of a stream.
+type encodingConfig struct {
+	setMaxIntsetEntries    int
+	hashMaxListpackEntries int
+	hashMaxListpackValue   int
+	streamNodeMaxEntries   int
+}
+
+var defaultEncodingConfig = encodingConfig{
+	setMaxIntsetEntries:    512,
+	hashMaxListpackEntries: 128,
+	hashMaxListpackValue:   64,
+	streamNodeMaxEntries:   100,
+}
+
+func (c *encodingConfig) parameter(name string) (*int, bool) {
+	switch strings.ToLower(name) {
+	case "set-max-intset-entries":
+		return &c.setMaxIntsetEntries, true
+	case "hash-max-listpack-entries", "hash-max-ziplist-entries":
+		return &c.hashMaxListpackEntries, true
+	case "hash-max-listpack-value", "hash-max-ziplist-value":
+		return &c.hashMaxListpackValue, true
+	case "stream-node-max-entries":
+		return &c.streamNodeMaxEntries, true
+	}
+	return nil, false
+}
+
+// ConfigGet returns the value of a store configuration parameter.
+func (s *InMemoryStore) ConfigGet(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, ok := s.config.parameter(name)
+	if !ok {
+		return "", false
+	}
+	return strconv.Itoa(*value), true
+}
+
+// ConfigSet changes the store configuration parameters given as name value
+// pairs. Either all of them are changed or, when one is unknown, invalid or
+// given twice, none are. Values that are already stored keep their encoding
+// until they are next written.
+func (s *InMemoryStore) ConfigSet(pairs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config := s.config
+	set := make(map[*int]bool)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		parameter, ok := config.parameter(name)
+		if !ok {
+			return fmt.Errorf("-ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+		}
+		if set[parameter] {
+			return fmt.Errorf("-ERR CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", name)
+		}
+		set[parameter] = true
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("-ERR CONFIG SET failed (possibly related to argument '%s') - argument couldn't be parsed into an integer", name)
+		}
+		*parameter = n
+	}
+	s.config = config
+	return nil
+}
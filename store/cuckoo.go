@@ -0,0 +1,334 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math/bits"
+	"math/rand/v2"
+)
+
+// A cuckoo filter stores an 8-bit fingerprint of every item in one of two
+// buckets, the second derived from the first and the fingerprint alone, so
+// an entry can be moved to its other bucket without knowing the item. Unlike
+// a Bloom filter, items can be deleted. When an insertion keeps evicting
+// entries for more than the maximum number of iterations, a new filter with
+// expansion times the capacity is added, as RedisBloom does.
+const (
+	cuckooDefaultCapacity      = 1024
+	CuckooDefaultBucketSize    = 2
+	CuckooDefaultMaxIterations = 20
+	CuckooDefaultExpansion     = 1
+	cuckooMaxBuckets           = 1 << 32
+)
+
+var errCuckooFull = errors.New("-ERR Filter is full")
+
+type cuckooLayer struct {
+	buckets    []uint8
+	numBuckets uint64
+	count      uint64
+}
+
+func newCuckooLayer(capacity uint64, bucketSize uint64) (*cuckooLayer, error) {
+	numBuckets := max((capacity+bucketSize-1)/bucketSize, 1)
+	numBuckets = 1 << bits.Len64(numBuckets-1)
+	if numBuckets > cuckooMaxBuckets {
+		return nil, errors.New("-ERR Insufficient memory to create filter")
+	}
+	return &cuckooLayer{buckets: make([]uint8, numBuckets*bucketSize), numBuckets: numBuckets}, nil
+}
+
+// cuckooFingerprint returns the hash an item's buckets are derived from and
+// its fingerprint, which is never zero as zero marks an empty slot.
+func cuckooFingerprint(item string) (uint64, uint8) {
+	hash := murmurHash64A([]byte(item), 0)
+	return hash, uint8(hash>>56)%255 + 1
+}
+
+func (layer *cuckooLayer) index(hash uint64) uint64 {
+	return hash & (layer.numBuckets - 1)
+}
+
+// altIndex returns the other bucket of a fingerprint stored in bucket i.
+// Since the number of buckets is a power of two the mapping is its own
+// inverse.
+func (layer *cuckooLayer) altIndex(i uint64, fp uint8) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) & (layer.numBuckets - 1)
+}
+
+func (cf *CuckooFilter) bucket(layer *cuckooLayer, i uint64) []uint8 {
+	return layer.buckets[i*cf.bucketSize : (i+1)*cf.bucketSize]
+}
+
+type CuckooFilter struct {
+	layers        []*cuckooLayer
+	bucketSize    uint64
+	maxIterations int
+	expansion     uint64
+}
+
+func NewCuckooFilter(capacity uint64, bucketSize uint64, maxIterations int, expansion uint64) (*CuckooFilter, error) {
+	layer, err := newCuckooLayer(capacity, bucketSize)
+	if err != nil {
+		return nil, err
+	}
+	return &CuckooFilter{
+		layers:        []*cuckooLayer{layer},
+		bucketSize:    bucketSize,
+		maxIterations: maxIterations,
+		expansion:     expansion,
+	}, nil
+}
+
+func (cf *CuckooFilter) Encoding() string {
+	return "raw"
+}
+
+// Count returns how many times the item's fingerprint is stored, which is at
+// least the number of times the item was added and not deleted.
+func (cf *CuckooFilter) Count(item string) int {
+	hash, fp := cuckooFingerprint(item)
+	count := 0
+	for _, layer := range cf.layers {
+		i1 := layer.index(hash)
+		i2 := layer.altIndex(i1, fp)
+		for _, i := range []uint64{i1, i2} {
+			for _, slot := range cf.bucket(layer, i) {
+				if slot == fp {
+					count++
+				}
+			}
+			if i1 == i2 {
+				break
+			}
+		}
+	}
+	return count
+}
+
+func (cf *CuckooFilter) Exists(item string) bool {
+	return cf.Count(item) > 0
+}
+
+// insert stores fp in one of its buckets in layer, evicting entries to their
+// other bucket when both are full. The evictions are undone if no free slot
+// turns up within the maximum number of iterations.
+func (cf *CuckooFilter) insert(layer *cuckooLayer, hash uint64, fp uint8) bool {
+	i1 := layer.index(hash)
+	i2 := layer.altIndex(i1, fp)
+	for _, i := range []uint64{i1, i2} {
+		bucket := cf.bucket(layer, i)
+		for slot := range bucket {
+			if bucket[slot] == 0 {
+				bucket[slot] = fp
+				layer.count++
+				return true
+			}
+		}
+	}
+
+	type eviction struct {
+		bucket uint64
+		slot   uint64
+		fp     uint8
+	}
+	var evictions []eviction
+
+	i := i1
+	if rand.IntN(2) == 1 {
+		i = i2
+	}
+	for range cf.maxIterations {
+		slot := rand.Uint64N(cf.bucketSize)
+		bucket := cf.bucket(layer, i)
+		evictions = append(evictions, eviction{i, slot, bucket[slot]})
+		fp, bucket[slot] = bucket[slot], fp
+
+		i = layer.altIndex(i, fp)
+		bucket = cf.bucket(layer, i)
+		for slot := range bucket {
+			if bucket[slot] == 0 {
+				bucket[slot] = fp
+				layer.count++
+				return true
+			}
+		}
+	}
+
+	for j := len(evictions) - 1; j >= 0; j-- {
+		e := evictions[j]
+		cf.bucket(layer, e.bucket)[e.slot] = e.fp
+	}
+	return false
+}
+
+// Add adds item, growing the filter when the newest layer has no room.
+func (cf *CuckooFilter) Add(item string) error {
+	hash, fp := cuckooFingerprint(item)
+	last := cf.layers[len(cf.layers)-1]
+	if cf.insert(last, hash, fp) {
+		return nil
+	}
+	if cf.expansion == 0 {
+		return errCuckooFull
+	}
+
+	capacity := last.numBuckets * cf.bucketSize * cf.expansion
+	layer, err := newCuckooLayer(capacity, cf.bucketSize)
+	if err != nil {
+		return err
+	}
+	cf.layers = append(cf.layers, layer)
+	if !cf.insert(layer, hash, fp) {
+		return errCuckooFull
+	}
+	return nil
+}
+
+// Delete removes one occurrence of the item's fingerprint, newest layers
+// first, and reports whether there was one.
+func (cf *CuckooFilter) Delete(item string) bool {
+	hash, fp := cuckooFingerprint(item)
+	for j := len(cf.layers) - 1; j >= 0; j-- {
+		layer := cf.layers[j]
+		i1 := layer.index(hash)
+		for _, i := range []uint64{i1, layer.altIndex(i1, fp)} {
+			bucket := cf.bucket(layer, i)
+			for slot := range bucket {
+				if bucket[slot] == fp {
+					bucket[slot] = 0
+					layer.count--
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+type cuckooLayerSnapshot struct {
+	Buckets    []uint8
+	NumBuckets uint64
+	Count      uint64
+}
+
+type cuckooSnapshot struct {
+	Layers        []cuckooLayerSnapshot
+	BucketSize    uint64
+	MaxIterations int
+	Expansion     uint64
+}
+
+func (cf *CuckooFilter) GobEncode() ([]byte, error) {
+	snapshot := cuckooSnapshot{BucketSize: cf.bucketSize, MaxIterations: cf.maxIterations, Expansion: cf.expansion}
+	for _, layer := range cf.layers {
+		snapshot.Layers = append(snapshot.Layers, cuckooLayerSnapshot{
+			Buckets:    layer.buckets,
+			NumBuckets: layer.numBuckets,
+			Count:      layer.count,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cf *CuckooFilter) GobDecode(data []byte) error {
+	var snapshot cuckooSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	cf.bucketSize, cf.maxIterations, cf.expansion = snapshot.BucketSize, snapshot.MaxIterations, snapshot.Expansion
+	cf.layers = nil
+	for _, layer := range snapshot.Layers {
+		cf.layers = append(cf.layers, &cuckooLayer{
+			buckets:    layer.Buckets,
+			numBuckets: layer.NumBuckets,
+			count:      layer.Count,
+		})
+	}
+	return nil
+}
+
+func (s *InMemoryStore) cuckooLocked(key string) (*CuckooFilter, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != CuckooType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.CuckooKV[key], nil
+}
+
+func (s *InMemoryStore) CFReserve(key string, capacity uint64, bucketSize uint64, maxIterations int, expansion uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR item exists")
+	}
+	cf, err := NewCuckooFilter(capacity, bucketSize, maxIterations, expansion)
+	if err != nil {
+		return err
+	}
+	s.KeyType[key] = CuckooType
+	s.CuckooKV[key] = cf
+	return nil
+}
+
+// CFAdd adds item to the filter at key, creating it with the default
+// capacity if needed. With nx the item is only added if it doesn't seem to
+// be there already, and the result reports whether it was added.
+func (s *InMemoryStore) CFAdd(key string, item string, nx bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cf, err := s.cuckooLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if cf == nil {
+		cf, err = NewCuckooFilter(cuckooDefaultCapacity, CuckooDefaultBucketSize, CuckooDefaultMaxIterations, CuckooDefaultExpansion)
+		if err != nil {
+			return false, err
+		}
+		s.KeyType[key] = CuckooType
+		s.CuckooKV[key] = cf
+	}
+
+	if nx && cf.Exists(item) {
+		return false, nil
+	}
+	if err := cf.Add(item); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *InMemoryStore) CFDel(key string, item string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cf, err := s.cuckooLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if cf == nil {
+		return false, errors.New("-ERR Not found")
+	}
+	return cf.Delete(item), nil
+}
+
+func (s *InMemoryStore) CFCount(key string, item string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cf, err := s.cuckooLocked(key)
+	if err != nil || cf == nil {
+		return 0, err
+	}
+	return cf.Count(item), nil
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"strconv"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	cf, _ := NewCuckooFilter(1000, CuckooDefaultBucketSize, CuckooDefaultMaxIterations, CuckooDefaultExpansion)
+	for i := range 3000 {
+		if err := cf.Add(strconv.Itoa(i)); err != nil {
+			t.Fatalf("Add(%d) = %v", i, err)
+		}
+	}
+	if len(cf.layers) < 2 {
+		t.Errorf("filter holding three times its capacity has %d layers", len(cf.layers))
+	}
+	for i := range 3000 {
+		if !cf.Exists(strconv.Itoa(i)) {
+			t.Fatalf("added item %d not found", i)
+		}
+	}
+
+	// Deleting every other item keeps the rest.
+	for i := 0; i < 3000; i += 2 {
+		if !cf.Delete(strconv.Itoa(i)) {
+			t.Fatalf("Delete(%d) found nothing", i)
+		}
+	}
+	for i := 1; i < 3000; i += 2 {
+		if !cf.Exists(strconv.Itoa(i)) {
+			t.Fatalf("item %d lost after deleting others", i)
+		}
+	}
+	var count uint64
+	for _, layer := range cf.layers {
+		count += layer.count
+	}
+	if count != 1500 {
+		t.Errorf("filter counts %d items, want 1500", count)
+	}
+
+	falsePositives := 0
+	for i := range 100000 {
+		if cf.Exists("out" + strconv.Itoa(i)) {
+			falsePositives++
+		}
+	}
+	// Each lookup compares against 2 buckets of 2 slots per layer, and a
+	// fingerprint matches with probability 1/255.
+	if rate, bound := float64(falsePositives)/100000, float64(4*len(cf.layers))/255; rate > bound {
+		t.Errorf("false positive rate %v, want at most %v", rate, bound)
+	}
+}
+
+func TestCuckooCount(t *testing.T) {
+	cf, _ := NewCuckooFilter(100, CuckooDefaultBucketSize, CuckooDefaultMaxIterations, CuckooDefaultExpansion)
+	for range 3 {
+		cf.Add("dup")
+	}
+	if n := cf.Count("dup"); n != 3 {
+		t.Errorf("Count after adding 3 times = %d", n)
+	}
+	cf.Delete("dup")
+	if n := cf.Count("dup"); n != 2 {
+		t.Errorf("Count after a delete = %d", n)
+	}
+	if cf.Delete("missing") {
+		t.Error("Delete of a missing item succeeded")
+	}
+}
+
+// TestCuckooFailedInsertUndone fills a non-expanding filter until an insert
+// fails and checks the evictions it made were undone.
+func TestCuckooFailedInsertUndone(t *testing.T) {
+	cf, _ := NewCuckooFilter(64, CuckooDefaultBucketSize, CuckooDefaultMaxIterations, 0)
+	var added []string
+	for i := 0; ; i++ {
+		item := strconv.Itoa(i)
+		before := bytes.Clone(cf.layers[0].buckets)
+		if err := cf.Add(item); err != nil {
+			if err != errCuckooFull {
+				t.Fatalf("Add = %v", err)
+			}
+			if !bytes.Equal(before, cf.layers[0].buckets) {
+				t.Error("failed insert changed the buckets")
+			}
+			break
+		}
+		added = append(added, item)
+	}
+	if len(cf.layers) != 1 || len(added) < 32 {
+		t.Errorf("non-expanding filter of 64 took %d items over %d layers", len(added), len(cf.layers))
+	}
+	for _, item := range added {
+		if !cf.Exists(item) {
+			t.Fatalf("item %s lost when the filter filled", item)
+		}
+	}
+}
+
+func TestCuckooCommands(t *testing.T) {
+	s := NewInMemoryStore()
+	if ok, err := s.CFAdd("cf", "a", false); !ok || err != nil {
+		t.Errorf("CFAdd = %v, %v", ok, err)
+	}
+	if ok, _ := s.CFAdd("cf", "a", true); ok {
+		t.Error("CFADDNX added an existing item")
+	}
+	s.CFAdd("cf", "a", false)
+	if n, _ := s.CFCount("cf", "a"); n != 2 {
+		t.Errorf("CFCount = %d", n)
+	}
+	if n, err := s.CFCount("missing", "a"); n != 0 || err != nil {
+		t.Errorf("CFCount on a missing key = %d, %v", n, err)
+	}
+	if ok, _ := s.CFDel("cf", "a"); !ok {
+		t.Error("CFDel found nothing")
+	}
+	if _, err := s.CFDel("missing", "a"); err == nil {
+		t.Error("CFDel on a missing key did not fail")
+	}
+	if err := s.CFReserve("cf", 100, 2, 20, 1); err == nil {
+		t.Error("CFReserve of an existing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.CFAdd("list", "a", false); err == nil {
+		t.Error("CFAdd on a list did not fail")
+	}
+}
+
+func TestCuckooSnapshot(t *testing.T) {
+	cf, _ := NewCuckooFilter(100, 4, 50, 2)
+	for i := range 500 {
+		cf.Add(strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CuckooFilter
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.layers) != len(cf.layers) || decoded.bucketSize != 4 || decoded.maxIterations != 50 || decoded.expansion != 2 {
+		t.Errorf("decoded %d layers, bucket size %d, %d iterations, expansion %d", len(decoded.layers), decoded.bucketSize, decoded.maxIterations, decoded.expansion)
+	}
+	for i := range 1000 {
+		if item := strconv.Itoa(i); decoded.Count(item) != cf.Count(item) {
+			t.Fatalf("decoded filter disagrees on %s", item)
+		}
+	}
+}
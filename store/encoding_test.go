@@ -0,0 +1,226 @@
+package store
+
+import (
+	"maps"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetEncodingConversion(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		want    string
+	}{
+		{"integers", []string{"1", "-5", "300"}, "intset"},
+		{"non integer", []string{"1", "a"}, "hashtable"},
+		{"non canonical integer", []string{"1", "007"}, "hashtable"},
+		{"past the limit", []string{"1", "2", "3", "4", "5"}, "hashtable"},
+		{"duplicates at the limit", []string{"1", "2", "3", "4", "4", "1"}, "intset"},
+	}
+	for _, test := range tests {
+		set := NewSet()
+		for _, member := range test.members {
+			set.Add(member, 4)
+		}
+		if got := set.Encoding(); got != test.want {
+			t.Errorf("%s: encoding = %s, want %s", test.name, got, test.want)
+		}
+		if got, want := sorted(set.Members()), slices.Compact(sorted(test.members)); !slices.Equal(got, want) {
+			t.Errorf("%s: members = %v, want %v", test.name, got, want)
+		}
+	}
+
+	set := NewSet()
+	set.Add("1", 4)
+	set.Add("a", 4)
+	set.Remove("a")
+	if set.Encoding() != "hashtable" {
+		t.Error("set went back to an intset after removing its only non integer")
+	}
+}
+
+func TestIntsetContainsOnlyCanonicalIntegers(t *testing.T) {
+	set := NewSet()
+	set.Add("10", 512)
+	for _, member := range []string{"010", "+10", "10.0", " 10"} {
+		if set.Contains(member) {
+			t.Errorf("intset containing 10 reports %q as a member", member)
+		}
+	}
+	if !set.Contains("10") {
+		t.Error("intset does not contain 10")
+	}
+}
+
+func TestHashEncodingConversion(t *testing.T) {
+	long := strings.Repeat("v", 9)
+	tests := []struct {
+		name   string
+		fields [][2]string
+		want   string
+	}{
+		{"small", [][2]string{{"a", "1"}, {"b", "2"}}, "listpack"},
+		{"too many fields", [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"d", "4"}, {"e", "5"}}, "hashtable"},
+		{"updates at the limit", [][2]string{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"d", "4"}, {"d", "5"}}, "listpack"},
+		{"long value", [][2]string{{"a", long}}, "hashtable"},
+		{"long field", [][2]string{{long, "1"}}, "hashtable"},
+		{"long update", [][2]string{{"a", "1"}, {"a", long}}, "hashtable"},
+	}
+	for _, test := range tests {
+		hash := NewHash()
+		want := make(map[string]string)
+		for _, pair := range test.fields {
+			hash.Set(pair[0], pair[1], 4, 8)
+			want[pair[0]] = pair[1]
+		}
+		if got := hash.Encoding(); got != test.want {
+			t.Errorf("%s: encoding = %s, want %s", test.name, got, test.want)
+		}
+		checkHash(t, test.name, hash, want)
+	}
+}
+
+func checkHash(t *testing.T, name string, hash *Hash, want map[string]string) {
+	t.Helper()
+	if hash.Len() != len(want) {
+		t.Fatalf("%s: Len() = %d, want %d", name, hash.Len(), len(want))
+	}
+	got := make(map[string]string)
+	hash.Each(func(field string, value string) bool {
+		got[field] = value
+		return true
+	})
+	if !maps.Equal(got, want) {
+		t.Fatalf("%s: hash holds %v, want %v", name, got, want)
+	}
+	for field, value := range want {
+		if v, ok := hash.Get(field); !ok || v != value {
+			t.Fatalf("%s: Get(%q) = %q, %v, want %q", name, field, v, ok, value)
+		}
+	}
+}
+
+func TestHashAgainstMap(t *testing.T) {
+	for seed := range int64(50) {
+		rng := rand.New(rand.NewSource(seed))
+		hash := NewHash()
+		want := make(map[string]string)
+		name := "seed " + strconv.FormatInt(seed, 10)
+
+		for range 300 {
+			field := "f" + strconv.Itoa(rng.Intn(20))
+			switch rng.Intn(3) {
+			case 0, 1:
+				value := strings.Repeat("x", rng.Intn(12))
+				added := hash.Set(field, value, 16, 10)
+				_, existed := want[field]
+				if added == existed {
+					t.Fatalf("%s: Set(%q) reported added = %v", name, field, added)
+				}
+				want[field] = value
+			case 2:
+				_, existed := want[field]
+				if hash.Delete(field) != existed {
+					t.Fatalf("%s: Delete(%q) disagrees with the reference", name, field)
+				}
+				delete(want, field)
+			}
+			checkHash(t, name, hash, want)
+		}
+
+		data, err := hash.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewHash()
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Encoding() != hash.Encoding() {
+			t.Errorf("%s: decoded as %s, saved as %s", name, decoded.Encoding(), hash.Encoding())
+		}
+		checkHash(t, name+" decoded", decoded, want)
+	}
+}
+
+func TestSetSnapshotKeepsEncoding(t *testing.T) {
+	for _, members := range [][]string{{"3", "1", "2"}, {"a", "1", "b"}} {
+		set := NewSet()
+		for _, member := range members {
+			set.Add(member, 512)
+		}
+		data, err := set.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewSet()
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Encoding() != set.Encoding() || !slices.Equal(sorted(decoded.Members()), sorted(members)) {
+			t.Errorf("decoded %s set %v, saved %s set %v", decoded.Encoding(), decoded.Members(), set.Encoding(), members)
+		}
+		if !decoded.Contains(members[0]) {
+			t.Errorf("decoded set lost the index for %q", members[0])
+		}
+	}
+}
+
+func TestConfigSetChangesThresholds(t *testing.T) {
+	s := NewInMemoryStore()
+	if err := s.ConfigSet([]string{"hash-max-listpack-entries", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := s.ConfigGet("hash-max-ziplist-entries"); value != "2" {
+		t.Errorf("hash-max-ziplist-entries = %s, want the listpack alias to follow", value)
+	}
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	if encoding, _ := s.ObjectEncoding("hash"); encoding != "listpack" {
+		t.Errorf("encoding at the limit = %s", encoding)
+	}
+	s.HSet("hash", "c", "3")
+	if encoding, _ := s.ObjectEncoding("hash"); encoding != "hashtable" {
+		t.Errorf("encoding past the limit = %s", encoding)
+	}
+
+	s.ConfigSet([]string{"set-max-intset-entries", "1"})
+	s.SAdd("set", "1")
+	s.SAdd("set", "2")
+	if encoding, _ := s.ObjectEncoding("set"); encoding != "hashtable" {
+		t.Errorf("set encoding past the limit = %s", encoding)
+	}
+
+	if err := s.ConfigSet([]string{"hash-max-listpack-value", "-1"}); err == nil {
+		t.Error("negative threshold was accepted")
+	}
+	if err := s.ConfigSet([]string{"no-such-parameter", "1"}); err == nil {
+		t.Error("unknown parameter was accepted")
+	}
+}
+
+func TestConfigSetAllOrNothing(t *testing.T) {
+	s := NewInMemoryStore()
+	if err := s.ConfigSet([]string{"set-max-intset-entries", "7", "hash-max-listpack-value", "9"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pairs := range [][]string{
+		{"set-max-intset-entries", "1", "hash-max-listpack-value", "-1"},
+		{"set-max-intset-entries", "1", "no-such-parameter", "1"},
+		{"hash-max-listpack-value", "1", "hash-max-ziplist-value", "2"},
+	} {
+		if err := s.ConfigSet(pairs); err == nil {
+			t.Errorf("CONFIG SET %v was accepted", pairs)
+		}
+		for name, want := range map[string]string{"set-max-intset-entries": "7", "hash-max-listpack-value": "9"} {
+			if value, _ := s.ConfigGet(name); value != want {
+				t.Errorf("after the failed CONFIG SET %v, %s = %s, want %s", pairs, name, value, want)
+			}
+		}
+	}
+}
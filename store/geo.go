@@ -0,0 +1,173 @@
+package store
+
+import (
+	"errors"
+	"slices"
+)
+
+// GeoQuery is a GEOSEARCH query. Its center is either FromMember, when
+// UseMember is set, or Center. Radius, Width and Height are in meters, and
+// Unit is the number of meters in the unit results report distances in.
+type GeoQuery struct {
+	FromMember string
+	UseMember  bool
+	Center     GeoCoord
+	ByBox      bool
+	Radius     float64
+	Width      float64
+	Height     float64
+	Unit       float64
+	Sort       int
+	Count      int
+	Any        bool
+}
+
+// GeoResult is a member matched by a GeoQuery, with its distance from the
+// center in the query's unit.
+type GeoResult struct {
+	Member   string
+	Score    float64
+	Coord    GeoCoord
+	Distance float64
+}
+
+// Sort orders of a GeoQuery.
+const (
+	GeoUnsorted = 0
+	GeoAsc      = 1
+	GeoDesc     = -1
+)
+
+func (s *InMemoryStore) GeoPos(key string, members []string) ([]GeoCoord, []bool, error) {
+	scores, exists, err := s.ZMScore(key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coords := make([]GeoCoord, len(members))
+	for i := range members {
+		if exists[i] {
+			coords[i] = geoScoreCoord(scores[i])
+		}
+	}
+	return coords, exists, nil
+}
+
+// GeoDist returns the distance in meters between two members.
+func (s *InMemoryStore) GeoDist(key string, from string, to string) (float64, bool, error) {
+	scores, exists, err := s.ZMScore(key, []string{from, to})
+	if err != nil || !exists[0] || !exists[1] {
+		return 0, false, err
+	}
+	return geoDistance(geoScoreCoord(scores[0]), geoScoreCoord(scores[1])), true, nil
+}
+
+func (s *InMemoryStore) GeoHash(key string, members []string) ([]string, []bool, error) {
+	scores, exists, err := s.ZMScore(key, members)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(members))
+	for i := range members {
+		if exists[i] {
+			hashes[i] = geohashString(geoScoreCoord(scores[i]))
+		}
+	}
+	return hashes, exists, nil
+}
+
+func (s *InMemoryStore) geoSearchLocked(key string, query GeoQuery) ([]GeoResult, error) {
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+
+	shape := geoShape{center: query.Center, box: query.ByBox, radius: query.Radius, width: query.Width, height: query.Height}
+	if query.UseMember {
+		score, ok := zset.Score(query.FromMember)
+		if !ok {
+			return nil, errors.New("-ERR could not decode requested zset member")
+		}
+		shape.center = geoScoreCoord(score)
+	}
+
+	var results []GeoResult
+	scanned := make(map[geoHashBits]struct{})
+search:
+	for _, cell := range shape.cells() {
+		// Cells repeat when the search wraps around a coarse grid.
+		if _, ok := scanned[cell]; ok {
+			continue
+		}
+		scanned[cell] = struct{}{}
+
+		for _, member := range zset.RangeByScore(cell.scoreRange(), false, 0, -1) {
+			coord := geoScoreCoord(member.Score)
+			distance, ok := shape.contains(coord)
+			if !ok {
+				continue
+			}
+			results = append(results, GeoResult{
+				Member:   member.Member,
+				Score:    member.Score,
+				Coord:    coord,
+				Distance: distance / query.Unit,
+			})
+			if query.Any && len(results) == query.Count {
+				break search
+			}
+		}
+	}
+
+	sortOrder := query.Sort
+	if sortOrder == GeoUnsorted && query.Count > 0 && !query.Any {
+		sortOrder = GeoAsc
+	}
+	if sortOrder != GeoUnsorted {
+		slices.SortStableFunc(results, func(a GeoResult, b GeoResult) int {
+			if a.Distance < b.Distance {
+				return -sortOrder
+			}
+			if a.Distance > b.Distance {
+				return sortOrder
+			}
+			return 0
+		})
+	}
+	if query.Count > 0 && len(results) > query.Count {
+		results = results[:query.Count]
+	}
+	return results, nil
+}
+
+func (s *InMemoryStore) GeoSearch(key string, query GeoQuery) ([]GeoResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.geoSearchLocked(key, query)
+}
+
+// GeoSearchStore stores the members matched by query at destination, scored
+// by their distance when storeDist is set and by their position otherwise.
+func (s *InMemoryStore) GeoSearchStore(destination string, key string, query GeoQuery, storeDist bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results, err := s.geoSearchLocked(key, query)
+	if err != nil {
+		return 0, err
+	}
+
+	result := NewSortedSet()
+	for _, match := range results {
+		score := match.Score
+		if storeDist {
+			score = match.Distance
+		}
+		result.Set(match.Member, score)
+	}
+	cardinality := s.storeSortedSetLocked(destination, result)
+	s.serveBlockedClients()
+	return cardinality, nil
+}
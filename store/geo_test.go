@@ -0,0 +1,192 @@
+package store
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func geoAdd(t *testing.T, s *InMemoryStore, key string, member string, longitude float64, latitude float64) {
+	t.Helper()
+	score, err := GeoScore(GeoCoord{Longitude: longitude, Latitude: latitude})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ZAdd(key, []ScoredMember{{Member: member, Score: score}}, ZAddOptions{})
+}
+
+func newSicily(t *testing.T) *InMemoryStore {
+	s := NewInMemoryStore()
+	geoAdd(t, s, "Sicily", "Palermo", 13.361389, 38.115556)
+	geoAdd(t, s, "Sicily", "Catania", 15.087269, 37.502669)
+	geoAdd(t, s, "Sicily", "edge1", 12.758489, 38.788135)
+	geoAdd(t, s, "Sicily", "edge2", 17.241510, 38.788135)
+	return s
+}
+
+func near(a float64, b float64, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+// The expected values are the ones Redis returns for the same commands.
+func TestGeoMatchesRedis(t *testing.T) {
+	s := newSicily(t)
+
+	if distance, ok, _ := s.GeoDist("Sicily", "Palermo", "Catania"); !ok || !near(distance, 166274.1516, 0.001) {
+		t.Errorf("GeoDist = %v, %v", distance, ok)
+	}
+	if _, ok, _ := s.GeoDist("Sicily", "Palermo", "missing"); ok {
+		t.Error("GeoDist to a missing member succeeded")
+	}
+
+	hashes, exists, _ := s.GeoHash("Sicily", []string{"Palermo", "Catania", "missing"})
+	if !slices.Equal(hashes[:2], []string{"sqc8b49rny0", "sqdtr74hyu0"}) || !slices.Equal(exists, []bool{true, true, false}) {
+		t.Errorf("GeoHash = %v, %v", hashes, exists)
+	}
+
+	coords, _, _ := s.GeoPos("Sicily", []string{"Palermo"})
+	if !near(coords[0].Longitude, 13.36138933897018433, 1e-12) || !near(coords[0].Latitude, 38.11555639549629859, 1e-12) {
+		t.Errorf("GeoPos = %+v", coords[0])
+	}
+
+	results, _ := s.GeoSearch("Sicily", GeoQuery{Center: GeoCoord{15, 37}, Radius: 200000, Unit: 1000, Sort: GeoAsc})
+	if len(results) != 2 || results[0].Member != "Catania" || results[1].Member != "Palermo" ||
+		!near(results[0].Distance, 56.4413, 0.0001) || !near(results[1].Distance, 190.4424, 0.0001) {
+		t.Errorf("GEOSEARCH BYRADIUS = %+v", results)
+	}
+
+	results, _ = s.GeoSearch("Sicily", GeoQuery{Center: GeoCoord{15, 37}, ByBox: true, Width: 400000, Height: 400000, Unit: 1000, Sort: GeoAsc})
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Member)
+	}
+	if !slices.Equal(names, []string{"Catania", "Palermo", "edge2", "edge1"}) ||
+		!near(results[2].Distance, 279.7403, 0.0001) || !near(results[3].Distance, 279.7405, 0.0001) {
+		t.Errorf("GEOSEARCH BYBOX = %+v", results)
+	}
+}
+
+func TestGeoSearchOptions(t *testing.T) {
+	s := newSicily(t)
+	base := GeoQuery{FromMember: "Palermo", UseMember: true, Radius: 400000, Unit: 1}
+
+	desc := base
+	desc.Sort = GeoDesc
+	results, _ := s.GeoSearch("Sicily", desc)
+	for i := 1; i < len(results); i++ {
+		if results[i].Distance > results[i-1].Distance {
+			t.Errorf("DESC results out of order: %+v", results)
+		}
+	}
+	if len(results) != 4 || results[len(results)-1].Member != "Palermo" || results[len(results)-1].Distance != 0 {
+		t.Errorf("FROMMEMBER results = %+v", results)
+	}
+
+	// COUNT without ANY returns the closest members.
+	count := base
+	count.Count = 2
+	results, _ = s.GeoSearch("Sicily", count)
+	if len(results) != 2 || results[0].Member != "Palermo" || results[1].Member != "edge1" {
+		t.Errorf("COUNT 2 = %+v", results)
+	}
+
+	count.Any = true
+	if results, _ := s.GeoSearch("Sicily", count); len(results) != 2 {
+		t.Errorf("COUNT 2 ANY returned %d results", len(results))
+	}
+
+	missing := base
+	missing.FromMember = "missing"
+	if _, err := s.GeoSearch("Sicily", missing); err == nil {
+		t.Error("FROMMEMBER with a missing member did not fail")
+	}
+	if results, err := s.GeoSearch("missing", base); results != nil || err != nil {
+		t.Errorf("GeoSearch on a missing key = %v, %v", results, err)
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	s := newSicily(t)
+	query := GeoQuery{Center: GeoCoord{15, 37}, Radius: 200000, Unit: 1000}
+
+	if n, err := s.GeoSearchStore("near", "Sicily", query, false); err != nil || n != 2 {
+		t.Errorf("GeoSearchStore = %d, %v", n, err)
+	}
+	want, _, _ := s.ZScore("Sicily", "Palermo")
+	if score, _, _ := s.ZScore("near", "Palermo"); score != want {
+		t.Errorf("stored score %v, want the geohash %v", score, want)
+	}
+
+	s.GeoSearchStore("near", "Sicily", query, true)
+	if score, _, _ := s.ZScore("near", "Catania"); !near(score, 56.4413, 0.0001) {
+		t.Errorf("STOREDIST score = %v", score)
+	}
+
+	query.Radius = 1
+	if n, _ := s.GeoSearchStore("near", "Sicily", query, false); n != 0 {
+		t.Errorf("GeoSearchStore of nothing = %d", n)
+	}
+	if _, ok := s.KeyType["near"]; ok {
+		t.Error("empty GeoSearchStore left the destination behind")
+	}
+}
+
+func TestGeoScoreRejectsInvalidCoordinates(t *testing.T) {
+	for _, coord := range []GeoCoord{{181, 0}, {-181, 0}, {0, 85.06}, {0, -86}} {
+		if _, err := GeoScore(coord); err == nil {
+			t.Errorf("GeoScore(%+v) did not fail", coord)
+		}
+	}
+}
+
+// TestGeoSearchAgainstScan compares searches with a scan of every member, so
+// the cells a search visits are checked to cover the whole shape, including
+// across the antimeridian and close to the poles.
+func TestGeoSearchAgainstScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	centers := []GeoCoord{{0, 0}, {179.9, 10}, {-179.9, -10}, {13.4, 52.5}, {-70, 80}, {30, -84}}
+
+	for _, center := range centers {
+		s := NewInMemoryStore()
+		var scores []ScoredMember
+		for i := range 2000 {
+			coord := GeoCoord{
+				Longitude: math.Remainder(center.Longitude+rng.NormFloat64()*3, 360),
+				Latitude:  min(max(center.Latitude+rng.NormFloat64()*2, geoLatitudeMin), geoLatitudeMax),
+			}
+			score, err := GeoScore(coord)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scores = append(scores, ScoredMember{Member: strconv.Itoa(i), Score: score})
+		}
+		s.ZAdd("points", scores, ZAddOptions{})
+
+		for _, query := range []GeoQuery{
+			{Center: center, Radius: 1000, Unit: 1},
+			{Center: center, Radius: 150000, Unit: 1},
+			{Center: center, Radius: 400000, Unit: 1},
+			{Center: center, ByBox: true, Width: 300000, Height: 100000, Unit: 1},
+			{Center: center, ByBox: true, Width: 50000, Height: 500000, Unit: 1},
+		} {
+			shape := geoShape{center: query.Center, box: query.ByBox, radius: query.Radius, width: query.Width, height: query.Height}
+			var want []string
+			for _, member := range scores {
+				if _, ok := shape.contains(geoScoreCoord(member.Score)); ok {
+					want = append(want, member.Member)
+				}
+			}
+
+			results, _ := s.GeoSearch("points", query)
+			var got []string
+			for _, result := range results {
+				got = append(got, result.Member)
+			}
+			if !slices.Equal(sorted(got), sorted(want)) {
+				t.Errorf("search around %+v with %+v found %d members, scan found %d", center, query, len(got), len(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package store
+
+import (
+	"fmt"
+	"math"
+)
+
+// Geohashes follow the Redis geo implementation: longitude and latitude are
+// each quantized to 26 bits and interleaved into a 52-bit integer, which is
+// exactly representable as a sorted set score. Nearby points share score
+// prefixes, so a search only has to scan the score ranges of a handful of
+// cells around its center.
+const (
+	geoStepMax        = 26
+	geoLongitudeMin   = -180.0
+	geoLongitudeMax   = 180.0
+	geoLatitudeMin    = -85.05112878
+	geoLatitudeMax    = 85.05112878
+	earthRadius       = 6372797.560856
+	mercatorMax       = 20037726.37
+	geoStandardLatMax = 90.0
+)
+
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoRange struct {
+	min float64
+	max float64
+}
+
+type geoArea struct {
+	hash      geoHashBits
+	longitude geoRange
+	latitude  geoRange
+}
+
+// GeoCoord is a longitude, latitude pair in degrees.
+type GeoCoord struct {
+	Longitude float64
+	Latitude  float64
+}
+
+// interleave64 spreads the bits of x over the even positions and those of y
+// over the odd ones.
+func interleave64(x uint32, y uint32) uint64 {
+	spread := func(v uint64) uint64 {
+		v = (v | v<<16) & 0x0000FFFF0000FFFF
+		v = (v | v<<8) & 0x00FF00FF00FF00FF
+		v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v<<2) & 0x3333333333333333
+		v = (v | v<<1) & 0x5555555555555555
+		return v
+	}
+	return spread(uint64(x)) | spread(uint64(y))<<1
+}
+
+func deinterleave64(interleaved uint64) (uint32, uint32) {
+	squash := func(v uint64) uint32 {
+		v &= 0x5555555555555555
+		v = (v | v>>1) & 0x3333333333333333
+		v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v>>4) & 0x00FF00FF00FF00FF
+		v = (v | v>>8) & 0x0000FFFF0000FFFF
+		v = (v | v>>16) & 0x00000000FFFFFFFF
+		return uint32(v)
+	}
+	return squash(interleaved), squash(interleaved >> 1)
+}
+
+// geohashEncode quantizes a coordinate within the given latitude bounds,
+// keeping latitude in the even bits and longitude in the odd ones.
+func geohashEncode(coord GeoCoord, latitudeRange geoRange, step uint) geoHashBits {
+	latOffset := (coord.Latitude - latitudeRange.min) / (latitudeRange.max - latitudeRange.min)
+	lonOffset := (coord.Longitude - geoLongitudeMin) / (geoLongitudeMax - geoLongitudeMin)
+	latOffset *= float64(uint64(1) << step)
+	lonOffset *= float64(uint64(1) << step)
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(lonOffset)), step: step}
+}
+
+func geohashEncodeWGS84(coord GeoCoord, step uint) geoHashBits {
+	return geohashEncode(coord, geoRange{geoLatitudeMin, geoLatitudeMax}, step)
+}
+
+func geohashDecode(hash geoHashBits) geoArea {
+	latBits, lonBits := deinterleave64(hash.bits)
+	cells := float64(uint64(1) << hash.step)
+	latScale := geoLatitudeMax - geoLatitudeMin
+	lonScale := geoLongitudeMax - geoLongitudeMin
+	return geoArea{
+		hash: hash,
+		latitude: geoRange{
+			min: geoLatitudeMin + float64(latBits)/cells*latScale,
+			max: geoLatitudeMin + float64(latBits+1)/cells*latScale,
+		},
+		longitude: geoRange{
+			min: geoLongitudeMin + float64(lonBits)/cells*lonScale,
+			max: geoLongitudeMin + float64(lonBits+1)/cells*lonScale,
+		},
+	}
+}
+
+// center returns the middle of the area, clamped to the valid coordinates.
+func (area geoArea) center() GeoCoord {
+	return GeoCoord{
+		Longitude: min(max((area.longitude.min+area.longitude.max)/2, geoLongitudeMin), geoLongitudeMax),
+		Latitude:  min(max((area.latitude.min+area.latitude.max)/2, geoLatitudeMin), geoLatitudeMax),
+	}
+}
+
+// GeoScore returns the sorted set score GEOADD stores for coord.
+func GeoScore(coord GeoCoord) (float64, error) {
+	if coord.Longitude < geoLongitudeMin || coord.Longitude > geoLongitudeMax ||
+		coord.Latitude < geoLatitudeMin || coord.Latitude > geoLatitudeMax {
+		return 0, fmt.Errorf("-ERR invalid longitude,latitude pair %f,%f", coord.Longitude, coord.Latitude)
+	}
+	return float64(geohashEncodeWGS84(coord, geoStepMax).bits), nil
+}
+
+func geoScoreCoord(score float64) GeoCoord {
+	return geohashDecode(geoHashBits{bits: uint64(score), step: geoStepMax}).center()
+}
+
+// geohashString returns the standard 11 character base32 geohash, which
+// unlike the scores is computed over the full -90..90 latitude range.
+func geohashString(coord GeoCoord) string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+	hash := geohashEncode(coord, geoRange{-geoStandardLatMax, geoStandardLatMax}, geoStepMax)
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		index := 0
+		if i < 10 {
+			index = int(hash.bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = alphabet[index]
+	}
+	return string(buf)
+}
+
+// moveX shifts the cell d steps east, or west for a negative d, wrapping
+// around at the antimeridian.
+func (hash geoHashBits) moveX(d int) geoHashBits {
+	if d == 0 {
+		return hash
+	}
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+	return hash
+}
+
+// moveY shifts the cell d steps north, or south for a negative d.
+func (hash geoHashBits) moveY(d int) geoHashBits {
+	if d == 0 {
+		return hash
+	}
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+	return hash
+}
+
+func degToRad(deg float64) float64 { return deg * math.Pi / 180 }
+func radToDeg(rad float64) float64 { return rad * 180 / math.Pi }
+
+// geoDistance returns the haversine distance in meters between two points.
+func geoDistance(from GeoCoord, to GeoCoord) float64 {
+	lat1, lat2 := degToRad(from.Latitude), degToRad(to.Latitude)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((degToRad(to.Longitude) - degToRad(from.Longitude)) / 2)
+	a := u*u + math.Cos(lat1)*math.Cos(lat2)*v*v
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// geoEstimateStep returns the precision at which a cell is about as large as
+// the search radius, coarser near the poles where cells shrink.
+func geoEstimateStep(radius float64, latitude float64) uint {
+	if radius == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStepMax))
+}
+
+// geoShape is the area a GEOSEARCH covers: a circle of the given radius, or
+// a box of the given width and height, all in meters.
+type geoShape struct {
+	center GeoCoord
+	box    bool
+	radius float64
+	width  float64
+	height float64
+}
+
+// boundingBox returns the longitude and latitude bounds enclosing the shape.
+func (shape geoShape) boundingBox() (geoRange, geoRange) {
+	halfWidth, halfHeight := shape.radius, shape.radius
+	if shape.box {
+		halfWidth, halfHeight = shape.width/2, shape.height/2
+	}
+
+	latDelta := radToDeg(halfHeight / earthRadius)
+	lonDeltaTop := radToDeg(halfWidth / earthRadius / math.Cos(degToRad(shape.center.Latitude+latDelta)))
+	lonDeltaBottom := radToDeg(halfWidth / earthRadius / math.Cos(degToRad(shape.center.Latitude-latDelta)))
+
+	lonDelta := lonDeltaTop
+	if shape.center.Latitude < 0 {
+		lonDelta = lonDeltaBottom
+	}
+	return geoRange{shape.center.Longitude - lonDelta, shape.center.Longitude + lonDelta},
+		geoRange{shape.center.Latitude - latDelta, shape.center.Latitude + latDelta}
+}
+
+// contains reports whether coord lies within the shape, and its distance in
+// meters from the center.
+func (shape geoShape) contains(coord GeoCoord) (float64, bool) {
+	if !shape.box {
+		distance := geoDistance(shape.center, coord)
+		return distance, distance <= shape.radius
+	}
+
+	// The latitude distance is cheaper to compute, so it is checked first.
+	latDistance := earthRadius * math.Abs(degToRad(coord.Latitude)-degToRad(shape.center.Latitude))
+	if latDistance > shape.height/2 {
+		return 0, false
+	}
+	lonDistance := geoDistance(GeoCoord{shape.center.Longitude, coord.Latitude}, coord)
+	if lonDistance > shape.width/2 {
+		return 0, false
+	}
+	return geoDistance(shape.center, coord), true
+}
+
+// cells returns the center cell of the shape followed by its neighbours,
+// sized so that together they cover the shape. Neighbours the shape cannot
+// reach are left out.
+func (shape geoShape) cells() []geoHashBits {
+	radius := shape.radius
+	if shape.box {
+		radius = math.Hypot(shape.width/2, shape.height/2)
+	}
+	longitude, latitude := shape.boundingBox()
+	step := geoEstimateStep(radius, shape.center.Latitude)
+
+	hash := geohashEncodeWGS84(shape.center, step)
+	area := geohashDecode(hash)
+
+	// The estimate can be too fine when the center sits near a cell edge, in
+	// which case the neighbours may not reach the edge of the bounding box.
+	north := geohashDecode(hash.moveY(1))
+	south := geohashDecode(hash.moveY(-1))
+	east := geohashDecode(hash.moveX(1))
+	west := geohashDecode(hash.moveX(-1))
+	if step > 1 && (north.latitude.max < latitude.max || south.latitude.min > latitude.min ||
+		east.longitude.max < longitude.max || west.longitude.min > longitude.min) {
+		step--
+		hash = geohashEncodeWGS84(shape.center, step)
+		area = geohashDecode(hash)
+	}
+
+	cells := []geoHashBits{hash}
+	for _, move := range [8][2]int{{0, 1}, {0, -1}, {1, 0}, {-1, 0}, {-1, 1}, {1, 1}, {-1, -1}, {1, -1}} {
+		if step >= 2 {
+			if move[1] < 0 && area.latitude.min < latitude.min ||
+				move[1] > 0 && area.latitude.max > latitude.max ||
+				move[0] < 0 && area.longitude.min < longitude.min ||
+				move[0] > 0 && area.longitude.max > longitude.max {
+				continue
+			}
+		}
+		cells = append(cells, hash.moveX(move[0]).moveY(move[1]))
+	}
+	return cells
+}
+
+// scoreRange returns the scores of every point inside the cell.
+func (hash geoHashBits) scoreRange() ScoreRange {
+	shift := 52 - hash.step*2
+	return ScoreRange{
+		Min:          float64(hash.bits << shift),
+		Max:          float64((hash.bits + 1) << shift),
+		MaxExclusive: true,
+	}
+}
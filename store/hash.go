@@ -0,0 +1,267 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Hash starts out as a listpack, its fields and values packed alternately in
+// a single buffer with the same entry layout as a quicklist node. It becomes a
+// hash table once it holds more than hash-max-listpack-entries fields or any
+// field or value longer than hash-max-listpack-value bytes. A hash table keeps
+// fields and values in dense slices alongside an index into them.
+//
+// Fields with a TTL have their expiry, in unix milliseconds, recorded in
+// expires whatever the encoding.
+type Hash struct {
+	listpack []byte
+	count    int
+	fields   []string
+	values   []string
+	index    map[string]int
+	expires  map[string]int64
+}
+
+func NewHash() *Hash {
+	return &Hash{}
+}
+
+func (h *Hash) isListpack() bool {
+	return h.index == nil
+}
+
+func (h *Hash) Len() int {
+	if h.isListpack() {
+		return h.count
+	}
+	return len(h.fields)
+}
+
+func (h *Hash) Encoding() string {
+	if h.isListpack() {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// find returns the offsets of field's entry and of its value entry in the
+// listpack.
+func (h *Hash) find(field string) (int, int, bool) {
+	off := 0
+	for range h.count {
+		data, next := entryData(h.listpack, off)
+		if string(data) == field {
+			return off, next, true
+		}
+		off = skipEntry(h.listpack, next)
+	}
+	return 0, 0, false
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	if h.isListpack() {
+		_, valueOff, ok := h.find(field)
+		if !ok {
+			return "", false
+		}
+		value, _ := decodeEntry(h.listpack, valueOff)
+		return value, true
+	}
+
+	i, ok := h.index[field]
+	if !ok {
+		return "", false
+	}
+	return h.values[i], true
+}
+
+// Set reports whether field is new and clears any TTL it had. A listpack is
+// converted to a hash table first if it would exceed maxEntries fields or
+// maxValue bytes in a field or value.
+func (h *Hash) Set(field string, value string, maxEntries int, maxValue int) bool {
+	delete(h.expires, field)
+	if h.isListpack() {
+		_, valueOff, ok := h.find(field)
+		fits := len(field) <= maxValue && len(value) <= maxValue
+		switch {
+		case ok && fits:
+			end := skipEntry(h.listpack, valueOff)
+			rest := bytes.Clone(h.listpack[end:])
+			h.listpack = append(appendQuickListEntry(h.listpack[:valueOff], value), rest...)
+			return false
+		case !ok && fits && h.count < maxEntries:
+			h.listpack = appendQuickListEntry(h.listpack, field)
+			h.listpack = appendQuickListEntry(h.listpack, value)
+			h.count++
+			return true
+		}
+		h.convertToHashtable()
+	}
+
+	if i, ok := h.index[field]; ok {
+		h.values[i] = value
+		return false
+	}
+	h.index[field] = len(h.fields)
+	h.fields = append(h.fields, field)
+	h.values = append(h.values, value)
+	return true
+}
+
+func (h *Hash) Delete(field string) bool {
+	delete(h.expires, field)
+	if h.isListpack() {
+		fieldOff, valueOff, ok := h.find(field)
+		if !ok {
+			return false
+		}
+		end := skipEntry(h.listpack, valueOff)
+		h.listpack = append(h.listpack[:fieldOff], h.listpack[end:]...)
+		h.count--
+		return true
+	}
+
+	i, ok := h.index[field]
+	if !ok {
+		return false
+	}
+	last := len(h.fields) - 1
+	h.fields[i], h.values[i] = h.fields[last], h.values[last]
+	h.index[h.fields[i]] = i
+	h.fields[last], h.values[last] = "", ""
+	h.fields, h.values = h.fields[:last], h.values[:last]
+	delete(h.index, field)
+	return true
+}
+
+// Expiry returns when field expires, or 0 if it has no TTL.
+func (h *Hash) Expiry(field string) int64 {
+	return h.expires[field]
+}
+
+// SetExpiry sets the TTL of an existing field; an expiresAt of 0 removes it.
+func (h *Hash) SetExpiry(field string, expiresAt int64) {
+	if expiresAt == 0 {
+		delete(h.expires, field)
+		return
+	}
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	h.expires[field] = expiresAt
+}
+
+// expire deletes the fields whose TTL has passed and returns how many there
+// were.
+func (h *Hash) expire() int {
+	expired := 0
+	for field, expiresAt := range h.expires {
+		if hasExpired(expiresAt) {
+			h.Delete(field)
+			expired++
+		}
+	}
+	return expired
+}
+
+func (h *Hash) convertToHashtable() {
+	fields := make([]string, 0, h.count)
+	values := make([]string, 0, h.count)
+	index := make(map[string]int, h.count)
+	h.Each(func(field string, value string) bool {
+		index[field] = len(fields)
+		fields = append(fields, field)
+		values = append(values, value)
+		return true
+	})
+	h.fields, h.values, h.index = fields, values, index
+	h.listpack = nil
+	h.count = 0
+}
+
+// Each calls fn for every field and value until it returns false.
+func (h *Hash) Each(fn func(field string, value string) bool) {
+	if !h.isListpack() {
+		for i, field := range h.fields {
+			if !fn(field, h.values[i]) {
+				return
+			}
+		}
+		return
+	}
+
+	off := 0
+	for range h.count {
+		field, next := decodeEntry(h.listpack, off)
+		value, next := decodeEntry(h.listpack, next)
+		if !fn(field, value) {
+			return
+		}
+		off = next
+	}
+}
+
+// Random returns count fields and their values picked uniformly at random,
+// following the sign convention of Set.Random.
+func (h *Hash) Random(count int) ([]string, []string) {
+	allFields, allValues := h.fields, h.values
+	if h.isListpack() {
+		h.Each(func(field string, value string) bool {
+			allFields = append(allFields, field)
+			allValues = append(allValues, value)
+			return true
+		})
+	}
+
+	indices := randomIndices(h.Len(), count)
+	fields := make([]string, len(indices))
+	values := make([]string, len(indices))
+	for i, index := range indices {
+		fields[i], values[i] = allFields[index], allValues[index]
+	}
+	return fields, values
+}
+
+type hashSnapshot struct {
+	Hashtable bool
+	Listpack  []byte
+	Count     int
+	Fields    []string
+	Values    []string
+	Expires   map[string]int64
+}
+
+func (h *Hash) GobEncode() ([]byte, error) {
+	snapshot := hashSnapshot{
+		Hashtable: !h.isListpack(),
+		Listpack:  h.listpack,
+		Count:     h.count,
+		Fields:    h.fields,
+		Values:    h.values,
+		Expires:   h.expires,
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (h *Hash) GobDecode(data []byte) error {
+	var snapshot hashSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	*h = Hash{listpack: snapshot.Listpack, count: snapshot.Count, expires: snapshot.Expires}
+	if snapshot.Hashtable {
+		h.fields = snapshot.Fields
+		h.values = snapshot.Values
+		h.index = make(map[string]int, len(h.fields))
+		for i, field := range h.fields {
+			h.index[field] = i
+		}
+	}
+	return nil
+}
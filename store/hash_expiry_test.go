@@ -0,0 +1,236 @@
+package store
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// expireFields backdates the TTL of fields so they expire without waiting,
+// leaving them in place for the next access to reclaim.
+func expireFields(s *InMemoryStore, key string, fields ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, field := range fields {
+		s.HashSetKV[key].SetExpiry(field, time.Now().UnixMilli()-1)
+	}
+}
+
+func TestHExpire(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	s.HSet("hash", "c", "3")
+	now := time.Now().UnixMilli()
+
+	tests := []struct {
+		condition string
+		expiresAt int64
+		fields    []string
+		want      []int
+	}{
+		{"", now + 10000, []string{"a", "missing"}, []int{1, -2}},
+		{"NX", now + 20000, []string{"a", "b"}, []int{0, 1}},
+		{"XX", now + 20000, []string{"a", "c"}, []int{1, 0}},
+		{"GT", now + 30000, []string{"a", "c"}, []int{1, 0}},
+		{"GT", now + 5000, []string{"a"}, []int{0}},
+		{"LT", now + 5000, []string{"a", "c"}, []int{1, 1}},
+		{"LT", now + 50000, []string{"a"}, []int{0}},
+		{"", now - 1, []string{"c"}, []int{2}},
+	}
+	for _, test := range tests {
+		got, err := s.HExpire("hash", test.expiresAt, test.condition, test.fields)
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("HExpire %s %v = %v, %v, want %v", test.condition, test.fields, got, err, test.want)
+		}
+	}
+
+	if got := hashContents(t, s, "hash"); !maps.Equal(got, map[string]string{"a": "1", "b": "2"}) {
+		t.Errorf("hash = %v, want c deleted by the past expiry", got)
+	}
+	if got, _ := s.HExpire("missing", now+1000, "", []string{"a"}); !slices.Equal(got, []int{-2}) {
+		t.Errorf("HExpire on a missing key = %v", got)
+	}
+}
+
+func TestHPTTLAndHPersist(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	s.HExpire("hash", time.Now().UnixMilli()+10000, "", []string{"a"})
+
+	ttls, _ := s.HPTTL("hash", []string{"a", "b", "missing"})
+	if ttls[0] <= 9000 || ttls[0] > 10000 || ttls[1] != -1 || ttls[2] != -2 {
+		t.Errorf("HPTTL = %v", ttls)
+	}
+
+	if got, _ := s.HPersist("hash", []string{"a", "b", "missing"}); !slices.Equal(got, []int{1, -1, -2}) {
+		t.Errorf("HPersist = %v", got)
+	}
+	if ttls, _ := s.HPTTL("hash", []string{"a"}); ttls[0] != -1 {
+		t.Errorf("HPTTL after HPersist = %v", ttls)
+	}
+
+	// Setting a field clears its TTL.
+	s.HExpire("hash", time.Now().UnixMilli()+10000, "", []string{"b"})
+	s.HSet("hash", "b", "3")
+	if ttls, _ := s.HPTTL("hash", []string{"b"}); ttls[0] != -1 {
+		t.Errorf("HPTTL after HSet = %v", ttls)
+	}
+}
+
+func TestHGetEx(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	later := time.Now().UnixMilli() + 10000
+
+	values, exists, _ := s.HGetEx("hash", []string{"a", "missing"}, later, false)
+	if !slices.Equal(values, []string{"1", ""}) || !slices.Equal(exists, []bool{true, false}) {
+		t.Errorf("HGetEx = %q, %v", values, exists)
+	}
+	if ttls, _ := s.HPTTL("hash", []string{"a", "b"}); ttls[0] < 0 || ttls[1] != -1 {
+		t.Errorf("HPTTL after HGetEx = %v", ttls)
+	}
+
+	s.HGetEx("hash", []string{"a"}, 0, true)
+	if ttls, _ := s.HPTTL("hash", []string{"a"}); ttls[0] != -1 {
+		t.Errorf("HPTTL after HGetEx PERSIST = %v", ttls)
+	}
+
+	values, _, _ = s.HGetEx("hash", []string{"a", "b"}, time.Now().UnixMilli()-1, false)
+	if !slices.Equal(values, []string{"1", "2"}) {
+		t.Errorf("HGetEx with a past expiry = %q, want the values read first", values)
+	}
+	if _, ok := s.KeyType["hash"]; ok {
+		t.Error("HGetEx expiring every field left the key behind")
+	}
+}
+
+func TestHSetEx(t *testing.T) {
+	s := NewInMemoryStore()
+	later := time.Now().UnixMilli() + 10000
+
+	if ok, _ := s.HSetEx("hash", []string{"a"}, []string{"1"}, "FXX", later, false); ok {
+		t.Error("HSetEx FXX set a missing field")
+	}
+	if _, ok := s.KeyType["hash"]; ok {
+		t.Error("failed HSetEx created the key")
+	}
+	if ok, _ := s.HSetEx("hash", []string{"a", "b"}, []string{"1", "2"}, "FNX", later, false); !ok {
+		t.Error("HSetEx FNX did not set new fields")
+	}
+	if ok, _ := s.HSetEx("hash", []string{"b", "c"}, []string{"3", "4"}, "FNX", 0, false); ok {
+		t.Error("HSetEx FNX set fields when one existed")
+	}
+	if _, ok, _ := s.HGet("hash", "c"); ok {
+		t.Error("failed HSetEx FNX set some of its fields")
+	}
+
+	s.HSetEx("hash", []string{"a"}, []string{"5"}, "", 0, true)
+	if ttls, _ := s.HPTTL("hash", []string{"a"}); ttls[0] < 0 {
+		t.Errorf("HSetEx KEEPTTL dropped the TTL: %v", ttls)
+	}
+	s.HSetEx("hash", []string{"b"}, []string{"6"}, "", 0, false)
+	if ttls, _ := s.HPTTL("hash", []string{"b"}); ttls[0] != -1 {
+		t.Errorf("HSetEx without KEEPTTL kept the TTL: %v", ttls)
+	}
+	if got := hashContents(t, s, "hash"); !maps.Equal(got, map[string]string{"a": "5", "b": "6"}) {
+		t.Errorf("hash = %v", got)
+	}
+}
+
+func TestHGetDel(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "")
+
+	values, exists, _ := s.HGetDel("hash", []string{"a", "missing", "a"})
+	if !slices.Equal(values, []string{"1", "", ""}) || !slices.Equal(exists, []bool{true, false, false}) {
+		t.Errorf("HGetDel = %q, %v", values, exists)
+	}
+	values, exists, _ = s.HGetDel("hash", []string{"b"})
+	if !slices.Equal(values, []string{""}) || !slices.Equal(exists, []bool{true}) {
+		t.Errorf("HGetDel of an empty value = %q, %v", values, exists)
+	}
+	if _, ok := s.KeyType["hash"]; ok {
+		t.Error("HGetDel of the last field left the key behind")
+	}
+}
+
+func TestExpiredFieldsAreInvisible(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	s.HExpire("hash", time.Now().UnixMilli()+10000, "", []string{"a"})
+	expireFields(s, "hash", "a")
+
+	if _, ok, _ := s.HGet("hash", "a"); ok {
+		t.Error("HGet returned an expired field")
+	}
+	if n, _ := s.HLen("hash"); n != 1 {
+		t.Errorf("HLen counts expired fields: %d", n)
+	}
+	if got := hashContents(t, s, "hash"); !maps.Equal(got, map[string]string{"b": "2"}) {
+		t.Errorf("HGetAll = %v", got)
+	}
+	if ttls, _ := s.HPTTL("hash", []string{"a"}); ttls[0] != -2 {
+		t.Errorf("HPTTL of an expired field = %v", ttls)
+	}
+}
+
+func TestKeyGoneWhenLastFieldExpires(t *testing.T) {
+	lookups := map[string]func(s *InMemoryStore) bool{
+		"EXISTS": func(s *InMemoryStore) bool { return s.NumKeyExists([]string{"hash"}, false) == 1 },
+		"DEL":    func(s *InMemoryStore) bool { return s.NumKeyExists([]string{"hash"}, true) == 1 },
+		"KEYS":   func(s *InMemoryStore) bool { return slices.Contains(s.GetKeys(".*"), "hash") },
+		"OBJECT": func(s *InMemoryStore) bool { _, ok := s.ObjectEncoding("hash"); return ok },
+		"HLEN":   func(s *InMemoryStore) bool { n, _ := s.HLen("hash"); return n > 0 },
+	}
+	for name, lookup := range lookups {
+		s := NewInMemoryStore()
+		s.HSet("hash", "a", "1")
+		s.HSet("hash", "b", "2")
+		expireFields(s, "hash", "a", "b")
+
+		if lookup(s) {
+			t.Errorf("%s sees a hash whose fields have all expired", name)
+		}
+		if _, ok := s.KeyType["hash"]; ok {
+			t.Errorf("%s left the expired hash behind", name)
+		}
+		if _, ok := s.HashSetKV["hash"]; ok {
+			t.Errorf("%s left the expired hash value behind", name)
+		}
+	}
+
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+	expireFields(s, "hash", "a")
+	if !slices.Contains(s.GetKeys(".*"), "hash") || s.NumKeyExists([]string{"hash"}, false) != 1 {
+		t.Error("a hash with a live field is reported missing")
+	}
+}
+
+func TestActiveExpiryReclaimsFields(t *testing.T) {
+	s := NewInMemoryStore()
+	s.BackgroundKeyCleanup(5)
+	s.HSet("hash", "a", "1")
+	s.HExpire("hash", time.Now().UnixMilli()+20, "", []string{"a"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		_, ok := s.KeyType["hash"]
+		s.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("active expiry never removed the hash")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func hashContents(t *testing.T, s *InMemoryStore, key string) map[string]string {
+	t.Helper()
+	fields, values, err := s.HGetAll(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != len(values) {
+		t.Fatalf("HGetAll returned %d fields and %d values", len(fields), len(values))
+	}
+	contents := make(map[string]string)
+	for i, field := range fields {
+		contents[field] = values[i]
+	}
+	return contents
+}
+
+func TestHGetReportsPresence(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "empty", "")
+
+	if value, ok, err := s.HGet("hash", "empty"); err != nil || !ok || value != "" {
+		t.Errorf("HGet of an empty value = %q, %v, %v", value, ok, err)
+	}
+	if _, ok, _ := s.HGet("hash", "missing"); ok {
+		t.Error("HGet found a missing field")
+	}
+	if _, ok, _ := s.HGet("missing", "empty"); ok {
+		t.Error("HGet found a field of a missing key")
+	}
+
+	values, exists, _ := s.HMGet("hash", []string{"empty", "missing"})
+	if !slices.Equal(values, []string{"", ""}) || !slices.Equal(exists, []bool{true, false}) {
+		t.Errorf("HMGet = %q, %v", values, exists)
+	}
+	if ok, _ := s.HExists("hash", "empty"); !ok {
+		t.Error("HExists does not see the empty value")
+	}
+}
+
+func TestHSetAndHSetNX(t *testing.T) {
+	s := NewInMemoryStore()
+	if n, _ := s.HSet("hash", "a", "1"); n != 1 {
+		t.Errorf("HSet of a new field = %d", n)
+	}
+	if n, _ := s.HSet("hash", "a", "2"); n != 0 {
+		t.Errorf("HSet of an existing field = %d", n)
+	}
+	if ok, _ := s.HSetNX("hash", "a", "3"); ok {
+		t.Error("HSetNX overwrote an existing field")
+	}
+	if ok, _ := s.HSetNX("hash", "b", "3"); !ok {
+		t.Error("HSetNX did not set a new field")
+	}
+	if ok, _ := s.HSetNX("other", "a", "1"); !ok || s.KeyType["other"] != HashType {
+		t.Error("HSetNX did not create the key")
+	}
+	if got := hashContents(t, s, "hash"); !maps.Equal(got, map[string]string{"a": "2", "b": "3"}) {
+		t.Errorf("hash = %v", got)
+	}
+}
+
+func TestHDel(t *testing.T) {
+	s := NewInMemoryStore()
+	s.HSet("hash", "a", "1")
+	s.HSet("hash", "b", "2")
+
+	if n, _ := s.HDel("hash", []string{"a", "missing", "a"}); n != 1 {
+		t.Errorf("HDel = %d, want 1", n)
+	}
+	if n, _ := s.HLen("hash"); n != 1 {
+		t.Errorf("HLen after HDel = %d", n)
+	}
+	if n, _ := s.HDel("hash", []string{"b"}); n != 1 {
+		t.Errorf("HDel of the last field = %d", n)
+	}
+	if _, ok := s.KeyType["hash"]; ok {
+		t.Error("HDel left an empty hash behind")
+	}
+	if _, ok := s.HashSetKV["hash"]; ok {
+		t.Error("HDel left the hash value behind")
+	}
+	if n, err := s.HDel("hash", []string{"a"}); n != 0 || err != nil {
+		t.Errorf("HDel on a missing key = %d, %v", n, err)
+	}
+}
+
+func TestHashReads(t *testing.T) {
+	// Both encodings go through the same store methods.
+	for _, size := range []int{3, 200} {
+		s := NewInMemoryStore()
+		want := make(map[string]string)
+		for i := range size {
+			field, value := "field"+strconv.Itoa(i), "value"+strconv.Itoa(i*7)
+			s.HSet("hash", field, value)
+			want[field] = value
+		}
+		encoding, _ := s.ObjectEncoding("hash")
+
+		if got := hashContents(t, s, "hash"); !maps.Equal(got, want) {
+			t.Errorf("%s: HGetAll = %v", encoding, got)
+		}
+		if n, _ := s.HLen("hash"); n != size {
+			t.Errorf("%s: HLen = %d, want %d", encoding, n, size)
+		}
+		if n, _ := s.HStrLen("hash", "field1"); n != len("value7") {
+			t.Errorf("%s: HStrLen = %d", encoding, n)
+		}
+		if n, _ := s.HStrLen("hash", "missing"); n != 0 {
+			t.Errorf("%s: HStrLen of a missing field = %d", encoding, n)
+		}
+	}
+
+	s := NewInMemoryStore()
+	if fields, values, err := s.HGetAll("missing"); fields != nil || values != nil || err != nil {
+		t.Errorf("HGetAll on a missing key = %v, %v, %v", fields, values, err)
+	}
+	if n, _ := s.HLen("missing"); n != 0 {
+		t.Errorf("HLen on a missing key = %d", n)
+	}
+}
+
+func TestHRandField(t *testing.T) {
+	s := NewInMemoryStore()
+	want := make(map[string]string)
+	for i := range 5 {
+		field := "f" + strconv.Itoa(i)
+		s.HSet("hash", field, "v"+strconv.Itoa(i))
+		want[field] = "v" + strconv.Itoa(i)
+	}
+
+	for _, count := range []int{1, 3, 5, 10} {
+		fields, values, _ := s.HRandField("hash", count)
+		if len(fields) != min(count, 5) {
+			t.Errorf("HRandField(%d) returned %d fields", count, len(fields))
+		}
+		if distinct := slices.Compact(sorted(fields)); len(distinct) != len(fields) {
+			t.Errorf("HRandField(%d) repeated fields: %v", count, fields)
+		}
+		for i, field := range fields {
+			if want[field] != values[i] {
+				t.Errorf("HRandField(%d) paired %q with %q", count, field, values[i])
+			}
+		}
+	}
+
+	fields, _, _ := s.HRandField("hash", -12)
+	if len(fields) != 12 {
+		t.Errorf("HRandField(-12) returned %d fields", len(fields))
+	}
+	for _, field := range fields {
+		if _, ok := want[field]; !ok {
+			t.Errorf("HRandField(-12) returned %q which is not a field", field)
+		}
+	}
+	if fields, _, _ := s.HRandField("hash", 0); fields != nil {
+		t.Errorf("HRandField(0) = %v", fields)
+	}
+	if fields, _, _ := s.HRandField("missing", 3); fields != nil {
+		t.Errorf("HRandField on a missing key = %v", fields)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	s := NewInMemoryStore()
+	s.RPush("list", []string{"a"})
+
+	calls := map[string]func() error{
+		"HSet":       func() error { _, err := s.HSet("list", "f", "v"); return err },
+		"HSetNX":     func() error { _, err := s.HSetNX("list", "f", "v"); return err },
+		"HGet":       func() error { _, _, err := s.HGet("list", "f"); return err },
+		"HDel":       func() error { _, err := s.HDel("list", []string{"f"}); return err },
+		"HGetAll":    func() error { _, _, err := s.HGetAll("list"); return err },
+		"HLen":       func() error { _, err := s.HLen("list"); return err },
+		"HExists":    func() error { _, err := s.HExists("list", "f"); return err },
+		"HStrLen":    func() error { _, err := s.HStrLen("list", "f"); return err },
+		"HRandField": func() error { _, _, err := s.HRandField("list", 1); return err },
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("%s on a list did not fail", name)
+		}
+	}
+	if got := listContents(t, s, "list"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("list = %v after the failed hash commands", got)
+	}
+}
@@ -0,0 +1,823 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// The layout below is byte-for-byte the one used by Redis (hyperloglog.c), so
+// a value produced here can be restored into Redis and vice versa, and the
+// cardinality estimates are identical.
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// followed by either 16384 6-bit dense registers or the sparse run-length
+// encoding made of ZERO, XZERO and VAL opcodes.
+const (
+	hllP              = 14
+	hllQ              = 64 - hllP
+	hllRegisters      = 1 << hllP
+	hllPMask          = hllRegisters - 1
+	hllBits           = 6
+	hllRegisterMax    = (1 << hllBits) - 1
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllMaxEncoding    = 1
+	hllSparseMaxBytes = 3000
+
+	hllSparseXZeroBit    = 0x40
+	hllSparseValBit      = 0x80
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+
+	hllAlphaInf = 0.721347520444481703680
+)
+
+var errInvalidHLL = errors.New("-WRONGTYPE Key is not a valid HyperLogLog string value.")
+var errCorruptedHLL = errors.New("-INVALIDOBJ Corrupted HLL object detected")
+
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+	data := key
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register an element maps to and the length of the
+// 000..1 pattern that follows the index bits.
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+func newHLL() []byte {
+	hll := make([]byte, hllHeaderSize+2)
+	copy(hll, "HYLL")
+	hll[4] = hllSparse
+	hllSparseXZeroSet(hll[hllHeaderSize:], hllRegisters)
+	return hll
+}
+
+func isValidHLL(hll []byte) bool {
+	if len(hll) < hllHeaderSize || string(hll[:4]) != "HYLL" {
+		return false
+	}
+	if hll[4] > hllMaxEncoding {
+		return false
+	}
+	if hll[4] == hllDense && len(hll) != hllDenseSize {
+		return false
+	}
+	return true
+}
+
+func hllInvalidateCache(hll []byte) {
+	hll[15] |= 1 << 7
+}
+
+func hllCachedCard(hll []byte) (uint64, bool) {
+	if hll[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(hll[8:16]), true
+}
+
+func hllSetCachedCard(hll []byte, card uint64) {
+	binary.LittleEndian.PutUint64(hll[8:16], card)
+}
+
+func hllDenseGet(registers []byte, index int) uint8 {
+	byteIndex := index * hllBits / 8
+	fb := uint(index*hllBits) & 7
+	fb8 := 8 - fb
+	b0 := uint(registers[byteIndex])
+	var b1 uint
+	if byteIndex+1 < len(registers) {
+		b1 = uint(registers[byteIndex+1])
+	}
+	return uint8(((b0 >> fb) | (b1 << fb8)) & hllRegisterMax)
+}
+
+func hllDenseSetRegister(registers []byte, index int, value uint8) {
+	byteIndex := index * hllBits / 8
+	fb := uint(index*hllBits) & 7
+	fb8 := 8 - fb
+	v := uint(value)
+	registers[byteIndex] &^= byte(hllRegisterMax << fb)
+	registers[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(hllRegisterMax >> fb8)
+		registers[byteIndex+1] |= byte(v >> fb8)
+	}
+}
+
+func hllDenseSet(registers []byte, index int, count uint8) bool {
+	if count > hllDenseGet(registers, index) {
+		hllDenseSetRegister(registers, index, count)
+		return true
+	}
+	return false
+}
+
+func hllSparseIsZero(p byte) bool  { return p&0xc0 == 0 }
+func hllSparseIsXZero(p byte) bool { return p&0xc0 == hllSparseXZeroBit }
+func hllSparseIsVal(p byte) bool   { return p&hllSparseValBit != 0 }
+func hllSparseZeroLen(p byte) int  { return int(p&0x3f) + 1 }
+func hllSparseXZeroLen(p []byte) int {
+	return (int(p[0]&0x3f)<<8 | int(p[1])) + 1
+}
+func hllSparseValValue(p byte) uint8 { return (p>>2)&0x1f + 1 }
+func hllSparseValLen(p byte) int     { return int(p&0x3) + 1 }
+
+func hllSparseValSet(p []byte, value uint8, length int) {
+	p[0] = byte(int(value-1)<<2|(length-1)) | hllSparseValBit
+}
+
+func hllSparseZeroSet(p []byte, length int) {
+	p[0] = byte(length - 1)
+}
+
+func hllSparseXZeroSet(p []byte, length int) {
+	l := length - 1
+	p[0] = byte(l>>8) | hllSparseXZeroBit
+	p[1] = byte(l & 0xff)
+}
+
+func hllSparseToDense(hll []byte) ([]byte, error) {
+	if hll[4] == hllDense {
+		return hll, nil
+	}
+
+	dense := make([]byte, hllDenseSize)
+	copy(dense, hll[:hllHeaderSize])
+	dense[4] = hllDense
+	registers := dense[hllHeaderSize:]
+
+	index := 0
+	sparse := hll[hllHeaderSize:]
+	for p := 0; p < len(sparse); {
+		switch {
+		case hllSparseIsZero(sparse[p]):
+			index += hllSparseZeroLen(sparse[p])
+			p++
+		case hllSparseIsXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return nil, errCorruptedHLL
+			}
+			index += hllSparseXZeroLen(sparse[p:])
+			p += 2
+		default:
+			runLen := hllSparseValLen(sparse[p])
+			value := hllSparseValValue(sparse[p])
+			if index+runLen > hllRegisters {
+				return nil, errCorruptedHLL
+			}
+			for range runLen {
+				hllDenseSetRegister(registers, index, value)
+				index++
+			}
+			p++
+		}
+	}
+
+	if index != hllRegisters {
+		return nil, errCorruptedHLL
+	}
+	return dense, nil
+}
+
+// hllSparseSet mirrors the in-place opcode splitting and merging done by
+// Redis, promoting to the dense encoding when a value no longer fits a VAL
+// opcode or the representation grows past hllSparseMaxBytes.
+func hllSparseSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	if count > hllSparseValMaxValue {
+		return hllPromoteAndSet(hll, index, count)
+	}
+
+	sparse := hllHeaderSize
+	end := len(hll)
+	p := sparse
+	first, span := 0, 0
+	prev := -1
+	for p < end {
+		opLen := 1
+		switch {
+		case hllSparseIsZero(hll[p]):
+			span = hllSparseZeroLen(hll[p])
+		case hllSparseIsVal(hll[p]):
+			span = hllSparseValLen(hll[p])
+		default:
+			if p+1 >= end {
+				return hll, false, errCorruptedHLL
+			}
+			span = hllSparseXZeroLen(hll[p:])
+			opLen = 2
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += opLen
+		first += span
+	}
+	if span == 0 || p >= end {
+		return hll, false, errCorruptedHLL
+	}
+
+	next := p + 1
+	if hllSparseIsXZero(hll[p]) {
+		next = p + 2
+	}
+
+	isZero, isXZero, isVal := false, false, false
+	runLen := 0
+	switch {
+	case hllSparseIsZero(hll[p]):
+		isZero = true
+		runLen = hllSparseZeroLen(hll[p])
+	case hllSparseIsXZero(hll[p]):
+		isXZero = true
+		runLen = hllSparseXZeroLen(hll[p:])
+	default:
+		isVal = true
+		runLen = hllSparseValLen(hll[p])
+	}
+
+	updated := false
+	if isVal {
+		oldCount := hllSparseValValue(hll[p])
+		if oldCount >= count {
+			return hll, false, nil
+		}
+		if runLen == 1 {
+			hllSparseValSet(hll[p:], count, 1)
+			updated = true
+		}
+	}
+
+	if isZero && runLen == 1 {
+		hllSparseValSet(hll[p:], count, 1)
+		updated = true
+	}
+
+	if !updated {
+		var seq [5]byte
+		n := 0
+		last := first + span - 1
+
+		if isZero || isXZero {
+			if index != first {
+				length := index - first
+				if length > hllSparseZeroMaxLen {
+					hllSparseXZeroSet(seq[n:], length)
+					n += 2
+				} else {
+					hllSparseZeroSet(seq[n:], length)
+					n++
+				}
+			}
+			hllSparseValSet(seq[n:], count, 1)
+			n++
+			if index != last {
+				length := last - index
+				if length > hllSparseZeroMaxLen {
+					hllSparseXZeroSet(seq[n:], length)
+					n += 2
+				} else {
+					hllSparseZeroSet(seq[n:], length)
+					n++
+				}
+			}
+		} else {
+			curValue := hllSparseValValue(hll[p])
+			if index != first {
+				hllSparseValSet(seq[n:], curValue, index-first)
+				n++
+			}
+			hllSparseValSet(seq[n:], count, 1)
+			n++
+			if index != last {
+				hllSparseValSet(seq[n:], curValue, last-index)
+				n++
+			}
+		}
+
+		oldLen := 1
+		if isXZero {
+			oldLen = 2
+		}
+		delta := n - oldLen
+		if delta > 0 && len(hll)+delta > hllSparseMaxBytes {
+			return hllPromoteAndSet(hll, index, count)
+		}
+
+		replaced := make([]byte, 0, len(hll)+delta)
+		replaced = append(replaced, hll[:p]...)
+		replaced = append(replaced, seq[:n]...)
+		replaced = append(replaced, hll[next:]...)
+		hll = replaced
+		end = len(hll)
+	}
+
+	p = prev
+	if p < 0 {
+		p = sparse
+	}
+	for scanLen := 5; p < end && scanLen > 0; scanLen-- {
+		if hllSparseIsXZero(hll[p]) {
+			p += 2
+			continue
+		} else if hllSparseIsZero(hll[p]) {
+			p++
+			continue
+		}
+		if p+1 < end && hllSparseIsVal(hll[p+1]) {
+			v1 := hllSparseValValue(hll[p])
+			v2 := hllSparseValValue(hll[p+1])
+			if v1 == v2 {
+				length := hllSparseValLen(hll[p]) + hllSparseValLen(hll[p+1])
+				if length <= hllSparseValMaxLen {
+					hllSparseValSet(hll[p+1:], v1, length)
+					hll = append(hll[:p], hll[p+1:]...)
+					end--
+					continue
+				}
+			}
+		}
+		p++
+	}
+
+	hllInvalidateCache(hll)
+	return hll, true, nil
+}
+
+func hllPromoteAndSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	dense, err := hllSparseToDense(hll)
+	if err != nil {
+		return hll, false, err
+	}
+	hllDenseSet(dense[hllHeaderSize:], index, count)
+	return dense, true, nil
+}
+
+func hllAdd(hll []byte, element string) ([]byte, bool, error) {
+	index, count := hllPatLen(element)
+	if hll[4] == hllDense {
+		changed := hllDenseSet(hll[hllHeaderSize:], index, count)
+		return hll, changed, nil
+	}
+	return hllSparseSet(hll, index, count)
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// hllRegisterHistogram fills the register value histogram used by the
+// estimator from any of the dense, sparse or raw (one byte per register)
+// representations.
+func hllRegisterHistogram(hll []byte) ([64]int, error) {
+	var histogram [64]int
+	if hll[4] == hllDense {
+		registers := hll[hllHeaderSize:]
+		for i := range hllRegisters {
+			histogram[hllDenseGet(registers, i)]++
+		}
+		return histogram, nil
+	}
+
+	index := 0
+	sparse := hll[hllHeaderSize:]
+	for p := 0; p < len(sparse); {
+		switch {
+		case hllSparseIsZero(sparse[p]):
+			runLen := hllSparseZeroLen(sparse[p])
+			index += runLen
+			histogram[0] += runLen
+			p++
+		case hllSparseIsXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return histogram, errCorruptedHLL
+			}
+			runLen := hllSparseXZeroLen(sparse[p:])
+			index += runLen
+			histogram[0] += runLen
+			p += 2
+		default:
+			runLen := hllSparseValLen(sparse[p])
+			index += runLen
+			histogram[hllSparseValValue(sparse[p])] += runLen
+			p++
+		}
+	}
+	if index != hllRegisters {
+		return histogram, errCorruptedHLL
+	}
+	return histogram, nil
+}
+
+func hllRawRegisterHistogram(registers []uint8) [64]int {
+	var histogram [64]int
+	for _, register := range registers {
+		histogram[register]++
+	}
+	return histogram
+}
+
+func hllEstimate(histogram [64]int) uint64 {
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// hllMerge folds the registers of hll into max, keeping the larger value of
+// each register.
+func hllMerge(max []uint8, hll []byte) error {
+	if hll[4] == hllDense {
+		registers := hll[hllHeaderSize:]
+		for i := range hllRegisters {
+			if value := hllDenseGet(registers, i); value > max[i] {
+				max[i] = value
+			}
+		}
+		return nil
+	}
+
+	index := 0
+	sparse := hll[hllHeaderSize:]
+	for p := 0; p < len(sparse); {
+		switch {
+		case hllSparseIsZero(sparse[p]):
+			index += hllSparseZeroLen(sparse[p])
+			p++
+		case hllSparseIsXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return errCorruptedHLL
+			}
+			index += hllSparseXZeroLen(sparse[p:])
+			p += 2
+		default:
+			runLen := hllSparseValLen(sparse[p])
+			value := hllSparseValValue(sparse[p])
+			if index+runLen > hllRegisters {
+				return errCorruptedHLL
+			}
+			for range runLen {
+				if value > max[index] {
+					max[index] = value
+				}
+				index++
+			}
+			p++
+		}
+	}
+	if index != hllRegisters {
+		return errCorruptedHLL
+	}
+	return nil
+}
+
+// hllLookup must be called with s.mu held. It returns a private copy of the
+// HLL bytes so callers can modify them before writing back with hllStore.
+func (s *InMemoryStore) hllLookup(key string) ([]byte, bool, error) {
+	keyType, ok := s.KeyType[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if keyType != StringType {
+		return nil, false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	value := s.StringKV[key]
+	if hasExpired(value.ExpiresAt) {
+		delete(s.StringKV, key)
+		delete(s.KeyType, key)
+		return nil, false, nil
+	}
+
+	hll := []byte(value.String())
+	if !isValidHLL(hll) {
+		return nil, false, errInvalidHLL
+	}
+	return hll, true, nil
+}
+
+func (s *InMemoryStore) hllStore(key string, hll []byte) {
+	s.StringKV[key] = StoreValue{Encoding: RawEncoding, Str: string(hll), ExpiresAt: s.StringKV[key].ExpiresAt}
+	s.KeyType[key] = StringType
+}
+
+func (s *InMemoryStore) PFAdd(key string, elements []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, ok, err := s.hllLookup(key)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := false
+	if !ok {
+		hll = newHLL()
+		updated = true
+	}
+
+	for _, element := range elements {
+		var changed bool
+		hll, changed, err = hllAdd(hll, element)
+		if err != nil {
+			return 0, err
+		}
+		if changed {
+			updated = true
+		}
+	}
+
+	if !updated {
+		return 0, nil
+	}
+	hllInvalidateCache(hll)
+	s.hllStore(key, hll)
+	return 1, nil
+}
+
+func (s *InMemoryStore) PFCount(keys []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(keys) > 1 {
+		max := make([]uint8, hllRegisters)
+		for _, key := range keys {
+			hll, ok, err := s.hllLookup(key)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				continue
+			}
+			if err := hllMerge(max, hll); err != nil {
+				return 0, err
+			}
+		}
+		return int64(hllEstimate(hllRawRegisterHistogram(max))), nil
+	}
+
+	hll, ok, err := s.hllLookup(keys[0])
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+
+	if card, ok := hllCachedCard(hll); ok {
+		return int64(card), nil
+	}
+
+	histogram, err := hllRegisterHistogram(hll)
+	if err != nil {
+		return 0, err
+	}
+	card := hllEstimate(histogram)
+	hllSetCachedCard(hll, card)
+	s.hllStore(keys[0], hll)
+	return int64(card), nil
+}
+
+func (s *InMemoryStore) PFMerge(destination string, sources []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	max := make([]uint8, hllRegisters)
+	useDense := false
+	for _, key := range append([]string{destination}, sources...) {
+		hll, ok, err := s.hllLookup(key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if hll[4] == hllDense {
+			useDense = true
+		}
+		if err := hllMerge(max, hll); err != nil {
+			return err
+		}
+	}
+
+	hll, ok, err := s.hllLookup(destination)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		hll = newHLL()
+	}
+	if useDense {
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return err
+		}
+	}
+
+	for i, value := range max {
+		if value == 0 {
+			continue
+		}
+		if hll[4] == hllDense {
+			hllDenseSet(hll[hllHeaderSize:], i, value)
+		} else if hll, _, err = hllSparseSet(hll, i, value); err != nil {
+			return err
+		}
+	}
+
+	hllInvalidateCache(hll)
+	s.hllStore(destination, hll)
+	return nil
+}
+
+func (s *InMemoryStore) pfDebugLookup(key string) ([]byte, error) {
+	hll, ok, err := s.hllLookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("-ERR The specified key does not exist")
+	}
+	return hll, nil
+}
+
+// PFDebugGetReg converts the HLL to the dense encoding, as Redis does, and
+// returns every register.
+func (s *InMemoryStore) PFDebugGetReg(key string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, err := s.pfDebugLookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if hll[4] == hllSparse {
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return nil, err
+		}
+		s.hllStore(key, hll)
+	}
+
+	registers := make([]int, hllRegisters)
+	for i := range registers {
+		registers[i] = int(hllDenseGet(hll[hllHeaderSize:], i))
+	}
+	return registers, nil
+}
+
+func (s *InMemoryStore) PFDebugDecode(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, err := s.pfDebugLookup(key)
+	if err != nil {
+		return "", err
+	}
+	if hll[4] != hllSparse {
+		return "", errors.New("-ERR HLL encoding is not sparse")
+	}
+
+	var decoded strings.Builder
+	sparse := hll[hllHeaderSize:]
+	for p := 0; p < len(sparse); {
+		switch {
+		case hllSparseIsZero(sparse[p]):
+			decoded.WriteString(fmt.Sprintf("z:%d ", hllSparseZeroLen(sparse[p])))
+			p++
+		case hllSparseIsXZero(sparse[p]):
+			if p+1 >= len(sparse) {
+				return "", errCorruptedHLL
+			}
+			decoded.WriteString(fmt.Sprintf("Z:%d ", hllSparseXZeroLen(sparse[p:])))
+			p += 2
+		default:
+			decoded.WriteString(fmt.Sprintf("v:%d,%d ", hllSparseValValue(sparse[p]), hllSparseValLen(sparse[p])))
+			p++
+		}
+	}
+	return strings.TrimSpace(decoded.String()), nil
+}
+
+func (s *InMemoryStore) PFDebugEncoding(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, err := s.pfDebugLookup(key)
+	if err != nil {
+		return "", err
+	}
+	if hll[4] == hllDense {
+		return "dense", nil
+	}
+	return "sparse", nil
+}
+
+func (s *InMemoryStore) PFDebugToDense(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, err := s.pfDebugLookup(key)
+	if err != nil {
+		return 0, err
+	}
+	if hll[4] == hllDense {
+		return 0, nil
+	}
+	if hll, err = hllSparseToDense(hll); err != nil {
+		return 0, err
+	}
+	s.hllStore(key, hll)
+	return 1, nil
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestNewHLLLayout(t *testing.T) {
+	want := "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff"
+	if got := string(newHLL()); got != want {
+		t.Errorf("newHLL() = %q, want %q", got, want)
+	}
+}
+
+// referenceRegisters computes the registers an HLL should hold after adding
+// elements, straight from hllPatLen.
+func referenceRegisters(elements []string) []int {
+	registers := make([]int, hllRegisters)
+	for _, element := range elements {
+		index, count := hllPatLen(element)
+		registers[index] = max(registers[index], int(count))
+	}
+	return registers
+}
+
+func elementRange(prefix string, n int) []string {
+	elements := make([]string, n)
+	for i := range elements {
+		elements[i] = prefix + strconv.Itoa(i)
+	}
+	return elements
+}
+
+func TestPFAddRegisters(t *testing.T) {
+	for _, n := range []int{1, 10, 200, 2000, 20000} {
+		s := NewInMemoryStore()
+		elements := elementRange("element:", n)
+		for i := 0; i < n; i += 7 {
+			if _, err := s.PFAdd("hll", elements[i:min(i+7, n)]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		registers, err := s.PFDebugGetReg("hll")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := referenceRegisters(elements)
+		for i := range want {
+			if registers[i] != want[i] {
+				t.Fatalf("n=%d: register %d = %d, want %d", n, i, registers[i], want[i])
+			}
+		}
+	}
+}
+
+func TestPFAddSparseToDensePromotion(t *testing.T) {
+	s := NewInMemoryStore()
+	var elements []string
+	promoted := false
+	for i := 0; !promoted; i++ {
+		element := "promote:" + strconv.Itoa(i)
+		elements = append(elements, element)
+		if _, err := s.PFAdd("hll", []string{element}); err != nil {
+			t.Fatal(err)
+		}
+		encoding, err := s.PFDebugEncoding("hll")
+		if err != nil {
+			t.Fatal(err)
+		}
+		promoted = encoding == "dense"
+		if !promoted && len(s.StringKV["hll"].Str) > hllSparseMaxBytes {
+			t.Fatalf("sparse HLL grew to %d bytes", len(s.StringKV["hll"].Str))
+		}
+		if i > 100000 {
+			t.Fatal("HLL never switched to the dense encoding")
+		}
+	}
+
+	if len(s.StringKV["hll"].Str) != hllDenseSize {
+		t.Errorf("dense HLL is %d bytes, want %d", len(s.StringKV["hll"].Str), hllDenseSize)
+	}
+	registers, _ := s.PFDebugGetReg("hll")
+	want := referenceRegisters(elements)
+	for i := range want {
+		if registers[i] != want[i] {
+			t.Fatalf("register %d = %d after promotion, want %d", i, registers[i], want[i])
+		}
+	}
+}
+
+func TestPFCountAccuracy(t *testing.T) {
+	s := NewInMemoryStore()
+	added := 0
+	for _, n := range []int{10, 100, 1000, 10000, 100000} {
+		s.PFAdd("hll", elementRange("accuracy:", n)[added:])
+		added = n
+
+		count, err := s.PFCount([]string{"hll"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if relative := math.Abs(float64(count)-float64(n)) / float64(n); relative > 0.03 {
+			t.Errorf("PFCount = %d for %d elements", count, n)
+		}
+	}
+}
+
+func TestPFAddReportsChanges(t *testing.T) {
+	s := NewInMemoryStore()
+	if n, _ := s.PFAdd("hll", nil); n != 1 {
+		t.Errorf("PFAdd without elements on a new key = %d, want 1", n)
+	}
+	if n, _ := s.PFAdd("hll", []string{"a"}); n != 1 {
+		t.Errorf("PFAdd of a new element = %d, want 1", n)
+	}
+	if n, _ := s.PFAdd("hll", []string{"a"}); n != 0 {
+		t.Errorf("PFAdd of a known element = %d, want 0", n)
+	}
+
+	s.PFAdd("seven", []string{"a", "b", "c", "d", "e", "f", "g"})
+	if count, _ := s.PFCount([]string{"seven"}); count != 7 {
+		t.Errorf("PFCount = %d, want 7", count)
+	}
+	s.PFAdd("seven", []string{"h"})
+	if count, _ := s.PFCount([]string{"seven"}); count != 8 {
+		t.Errorf("PFCount after the cached count went stale = %d, want 8", count)
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	s := NewInMemoryStore()
+	s.PFAdd("small", elementRange("a:", 100))
+	s.PFAdd("large", elementRange("b:", 20000))
+
+	if err := s.PFMerge("union", []string{"small", "large", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if encoding, _ := s.PFDebugEncoding("union"); encoding != "dense" {
+		t.Errorf("merge with a dense source is %s", encoding)
+	}
+
+	merged, _ := s.PFCount([]string{"union"})
+	together, _ := s.PFCount([]string{"small", "large"})
+	if merged != together {
+		t.Errorf("PFCount of the merge = %d, PFCount of both keys = %d", merged, together)
+	}
+	if relative := math.Abs(float64(merged)-20100) / 20100; relative > 0.03 {
+		t.Errorf("merged count = %d, want about 20100", merged)
+	}
+
+	registers, _ := s.PFDebugGetReg("union")
+	want := referenceRegisters(append(elementRange("a:", 100), elementRange("b:", 20000)...))
+	for i := range want {
+		if registers[i] != want[i] {
+			t.Fatalf("merged register %d = %d, want %d", i, registers[i], want[i])
+		}
+	}
+
+	if err := s.PFMerge("sparse", []string{"small"}); err != nil {
+		t.Fatal(err)
+	}
+	if encoding, _ := s.PFDebugEncoding("sparse"); encoding != "sparse" {
+		t.Errorf("merge of sparse sources is %s", encoding)
+	}
+}
+
+func TestHLLErrors(t *testing.T) {
+	s := NewInMemoryStore()
+	s.RPush("list", []string{"a"})
+	if _, err := s.PFAdd("list", []string{"a"}); err == nil {
+		t.Error("PFAdd on a list did not fail")
+	}
+
+	s.StringSet("string", "not an hll", 0, false, false, false, false)
+	if _, err := s.PFCount([]string{"string"}); !errors.Is(err, errInvalidHLL) {
+		t.Errorf("PFCount on a plain string = %v", err)
+	}
+
+	corrupted := newHLL()
+	hllSparseXZeroSet(corrupted[hllHeaderSize:], 100)
+	hllInvalidateCache(corrupted)
+	s.hllStore("corrupted", corrupted)
+	if _, err := s.PFCount([]string{"corrupted"}); !errors.Is(err, errCorruptedHLL) {
+		t.Errorf("PFCount on a corrupted HLL = %v", err)
+	}
+
+	if _, err := s.PFDebugEncoding("missing"); err == nil {
+		t.Error("PFDEBUG on a missing key did not fail")
+	}
+}
@@ -0,0 +1,839 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type jsonKind int
+
+const (
+	jsonNull jsonKind = iota
+	jsonBool
+	jsonInteger
+	jsonNumber
+	jsonString
+	jsonArray
+	jsonObject
+)
+
+func (kind jsonKind) String() string {
+	switch kind {
+	case jsonBool:
+		return "boolean"
+	case jsonInteger:
+		return "integer"
+	case jsonNumber:
+		return "number"
+	case jsonString:
+		return "string"
+	case jsonArray:
+		return "array"
+	case jsonObject:
+		return "object"
+	}
+	return "null"
+}
+
+// JSONValue is a node of a parsed JSON document. Objects keep their keys in
+// insertion order next to a map from key to value. Nodes are updated in
+// place, so replacing a value only needs a pointer to it.
+type JSONValue struct {
+	kind     jsonKind
+	boolean  bool
+	integer  int64
+	number   float64
+	str      string
+	elements []*JSONValue
+	keys     []string
+	fields   map[string]*JSONValue
+}
+
+func newJSONObject() *JSONValue {
+	return &JSONValue{kind: jsonObject, fields: make(map[string]*JSONValue)}
+}
+
+func (v *JSONValue) Encoding() string {
+	return "raw"
+}
+
+func (v *JSONValue) clone() *JSONValue {
+	c := *v
+	if v.kind == jsonArray {
+		c.elements = make([]*JSONValue, len(v.elements))
+		for i, element := range v.elements {
+			c.elements[i] = element.clone()
+		}
+	}
+	if v.kind == jsonObject {
+		c.keys = append([]string(nil), v.keys...)
+		c.fields = make(map[string]*JSONValue, len(v.fields))
+		for key, field := range v.fields {
+			c.fields[key] = field.clone()
+		}
+	}
+	return &c
+}
+
+func (v *JSONValue) isNumber() bool {
+	return v.kind == jsonInteger || v.kind == jsonNumber
+}
+
+func (v *JSONValue) float() float64 {
+	if v.kind == jsonInteger {
+		return float64(v.integer)
+	}
+	return v.number
+}
+
+// setField adds or replaces a field of an object.
+func (v *JSONValue) setField(key string, value *JSONValue) {
+	if _, ok := v.fields[key]; !ok {
+		v.keys = append(v.keys, key)
+	}
+	v.fields[key] = value
+}
+
+func (v *JSONValue) deleteField(key string) bool {
+	if _, ok := v.fields[key]; !ok {
+		return false
+	}
+	delete(v.fields, key)
+	for i, k := range v.keys {
+		if k == key {
+			v.keys = append(v.keys[:i], v.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// ParseJSON parses a complete JSON text.
+func ParseJSON(text string) (*JSONValue, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	value, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, jsonSyntaxError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("-ERR trailing characters after JSON value")
+	}
+	return value, nil
+}
+
+func jsonSyntaxError(err error) error {
+	if err == io.EOF {
+		return errors.New("-ERR EOF while parsing a value")
+	}
+	return errors.New("-ERR " + err.Error())
+}
+
+func parseJSONValue(dec *json.Decoder) (*JSONValue, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case nil:
+		return &JSONValue{kind: jsonNull}, nil
+	case bool:
+		return &JSONValue{kind: jsonBool, boolean: t}, nil
+	case string:
+		return &JSONValue{kind: jsonString, str: t}, nil
+	case json.Number:
+		if n, err := strconv.ParseInt(t.String(), 10, 64); err == nil {
+			return &JSONValue{kind: jsonInteger, integer: n}, nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("number %s out of range", t)
+		}
+		return &JSONValue{kind: jsonNumber, number: f}, nil
+	case json.Delim:
+		if t == '[' {
+			array := &JSONValue{kind: jsonArray}
+			for dec.More() {
+				element, err := parseJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				array.elements = append(array.elements, element)
+			}
+			_, err := dec.Token()
+			return array, err
+		}
+
+		object := newJSONObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			object.setField(key.(string), value)
+		}
+		_, err := dec.Token()
+		return object, err
+	}
+	return nil, errors.New("unexpected JSON token")
+}
+
+// JSONFormat is the INDENT, NEWLINE and SPACE of JSON.GET.
+type JSONFormat struct {
+	Indent  string
+	Newline string
+	Space   string
+}
+
+func (v *JSONValue) String() string {
+	var buf bytes.Buffer
+	v.write(&buf, JSONFormat{}, 0)
+	return buf.String()
+}
+
+func (v *JSONValue) write(buf *bytes.Buffer, format JSONFormat, depth int) {
+	newline := func(depth int) {
+		buf.WriteString(format.Newline)
+		for range depth {
+			buf.WriteString(format.Indent)
+		}
+	}
+
+	switch v.kind {
+	case jsonNull:
+		buf.WriteString("null")
+	case jsonBool:
+		buf.WriteString(strconv.FormatBool(v.boolean))
+	case jsonInteger:
+		buf.WriteString(strconv.FormatInt(v.integer, 10))
+	case jsonNumber:
+		buf.WriteString(formatJSONNumber(v.number))
+	case jsonString:
+		writeJSONString(buf, v.str)
+	case jsonArray:
+		buf.WriteByte('[')
+		for i, element := range v.elements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			element.write(buf, format, depth+1)
+		}
+		if len(v.elements) > 0 {
+			newline(depth)
+		}
+		buf.WriteByte(']')
+	case jsonObject:
+		buf.WriteByte('{')
+		for i, key := range v.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(depth + 1)
+			writeJSONString(buf, key)
+			buf.WriteByte(':')
+			buf.WriteString(format.Space)
+			v.fields[key].write(buf, format, depth+1)
+		}
+		if len(v.keys) > 0 {
+			newline(depth)
+		}
+		buf.WriteByte('}')
+	}
+}
+
+// formatJSONNumber keeps a decimal point on integral floats so they read
+// back as numbers rather than integers.
+func formatJSONNumber(f float64) string {
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if math.Abs(f) < 1e21 && !strings.ContainsAny(s, ".e") {
+		s = strconv.FormatFloat(f, 'f', -1, 64) + ".0"
+	}
+	return s
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	const hex = "0123456789abcdef"
+	buf.WriteByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= 0x20 && c != '"' && c != '\\' && c < utf8.RuneSelf {
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+		switch c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if c < 0x20 {
+				buf.WriteString(`\u00`)
+				buf.WriteByte(hex[c>>4])
+				buf.WriteByte(hex[c&0xf])
+				break
+			}
+			r, size := utf8.DecodeRuneInString(s[i:])
+			buf.WriteRune(r)
+			i += size
+			continue
+		}
+		i++
+	}
+	buf.WriteByte('"')
+}
+
+func (v *JSONValue) GobEncode() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
+func (v *JSONValue) GobDecode(data []byte) error {
+	parsed, err := ParseJSON(string(data))
+	if err != nil {
+		return err
+	}
+	*v = *parsed
+	return nil
+}
+
+func (v *JSONValue) format(format JSONFormat) string {
+	var buf bytes.Buffer
+	v.write(&buf, format, 0)
+	return buf.String()
+}
+
+var errJSONNoKey = errors.New("-ERR could not perform this operation on a key that doesn't exist")
+
+func errJSONPathMissing(path jsonPath) error {
+	return fmt.Errorf("-ERR Path '%s' does not exist", path.text)
+}
+
+func errJSONWrongType(expected jsonKind, found *JSONValue) error {
+	return fmt.Errorf("-WRONGTYPE wrong type of path value - expected %s but found %s", expected, found.kind)
+}
+
+func (s *InMemoryStore) jsonLocked(key string) (*JSONValue, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != JSONType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.JSONKV[key], nil
+}
+
+// jsonTargets returns the values path selects in root, with nil in place of
+// those that aren't of the given kind; jsonNumber stands for both kinds of
+// number. A legacy path selects only its first value and fails when there is
+// none or it is of another kind.
+func jsonTargets(root *JSONValue, path jsonPath, kind jsonKind) ([]*JSONValue, error) {
+	matches := path.match(root)
+	if path.legacy && len(matches) == 0 {
+		return nil, errJSONPathMissing(path)
+	}
+
+	targets := make([]*JSONValue, len(matches))
+	for i, match := range matches {
+		value := match.value
+		if value.kind == kind || kind == jsonNumber && value.isNumber() {
+			targets[i] = value
+		}
+		if path.legacy {
+			if targets[0] == nil {
+				return nil, errJSONWrongType(kind, value)
+			}
+			return targets[:1], nil
+		}
+	}
+	return targets, nil
+}
+
+// JSONSet sets the values path selects to the JSON text. When it selects
+// nothing and ends in a child name, the name is added to the objects its
+// parent path selects. A new key can only be created at the root.
+func (s *InMemoryStore) JSONSet(key string, pathText string, text string, nx bool, xx bool) (bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return false, err
+	}
+	value, err := ParseJSON(text)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if root == nil {
+		if !path.isRoot() {
+			return false, errors.New("-ERR new objects must be created at the root")
+		}
+		if xx {
+			return false, nil
+		}
+		s.KeyType[key] = JSONType
+		s.JSONKV[key] = value
+		return true, nil
+	}
+
+	if matches := path.match(root); len(matches) > 0 {
+		if nx {
+			return false, nil
+		}
+		for _, match := range matches {
+			*match.value = *value.clone()
+		}
+		return true, nil
+	}
+	if xx {
+		return false, nil
+	}
+
+	parent, name, ok := path.parentPath()
+	if !ok {
+		return false, nil
+	}
+	created := false
+	for _, match := range parent.match(root) {
+		if match.value.kind == jsonObject {
+			match.value.setField(name, value.clone())
+			created = true
+		}
+	}
+	return created, nil
+}
+
+// JSONGet serializes the values the paths select. A single legacy path
+// yields its value and a single JSONPath an array of its matches; several
+// paths yield an object keyed by path.
+func (s *InMemoryStore) JSONGet(key string, pathTexts []string, format JSONFormat) (string, bool, error) {
+	paths := make([]jsonPath, len(pathTexts))
+	for i, text := range pathTexts {
+		path, err := parseJSONPath(text)
+		if err != nil {
+			return "", false, err
+		}
+		paths[i] = path
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil || root == nil {
+		return "", false, err
+	}
+
+	get := func(path jsonPath) (*JSONValue, error) {
+		matches := path.match(root)
+		if path.legacy {
+			if len(matches) == 0 {
+				return nil, errJSONPathMissing(path)
+			}
+			return matches[0].value, nil
+		}
+		array := &JSONValue{kind: jsonArray}
+		for _, match := range matches {
+			array.elements = append(array.elements, match.value)
+		}
+		return array, nil
+	}
+
+	if len(paths) == 0 {
+		return root.format(format), true, nil
+	}
+	if len(paths) == 1 {
+		value, err := get(paths[0])
+		if err != nil {
+			return "", false, err
+		}
+		return value.format(format), true, nil
+	}
+
+	result := newJSONObject()
+	for _, path := range paths {
+		value, err := get(path)
+		if err != nil {
+			return "", false, err
+		}
+		result.setField(path.text, value)
+	}
+	return result.format(format), true, nil
+}
+
+// JSONMGet gets path from every key, reporting keys that don't hold JSON or
+// lack the path as missing.
+func (s *InMemoryStore) JSONMGet(keys []string, pathText string) ([]string, []bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make([]string, len(keys))
+	exists := make([]bool, len(keys))
+	for i, key := range keys {
+		root, err := s.jsonLocked(key)
+		if err != nil || root == nil {
+			continue
+		}
+		matches := path.match(root)
+		if path.legacy {
+			if len(matches) > 0 {
+				values[i], exists[i] = matches[0].value.String(), true
+			}
+			continue
+		}
+		array := &JSONValue{kind: jsonArray}
+		for _, match := range matches {
+			array.elements = append(array.elements, match.value)
+		}
+		values[i], exists[i] = array.String(), true
+	}
+	return values, exists, nil
+}
+
+// JSONDel deletes the values path selects, or the whole key for the root,
+// and returns how many it deleted.
+func (s *InMemoryStore) JSONDel(key string, pathText string) (int, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil || root == nil {
+		return 0, err
+	}
+	if path.isRoot() {
+		s.deleteKeyLocked(key)
+		return 1, nil
+	}
+
+	// Later array elements go first so the indices of earlier ones hold.
+	matches := path.match(root)
+	slices.SortStableFunc(matches, func(a jsonMatch, b jsonMatch) int {
+		return b.index - a.index
+	})
+
+	deleted := 0
+	for _, match := range matches {
+		parent := match.parent
+		switch parent.kind {
+		case jsonObject:
+			if parent.deleteField(match.name) {
+				deleted++
+			}
+		case jsonArray:
+			if match.index < len(parent.elements) && parent.elements[match.index] == match.value {
+				parent.elements = slices.Delete(parent.elements, match.index, match.index+1)
+				deleted++
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// JSONType returns the type of every value path selects.
+func (s *InMemoryStore) JSONType(key string, pathText string) ([]string, bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil || root == nil {
+		return nil, false, err
+	}
+
+	matches := path.match(root)
+	if path.legacy && len(matches) > 1 {
+		matches = matches[:1]
+	}
+	types := make([]string, len(matches))
+	for i, match := range matches {
+		types[i] = match.value.kind.String()
+	}
+	return types, true, nil
+}
+
+// JSONNumIncrBy adds the JSON number by to the numbers path selects and
+// returns the new value for a legacy path, or an array of the new values
+// with null for non-numbers otherwise.
+func (s *InMemoryStore) JSONNumIncrBy(key string, pathText string, by string) (string, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return "", err
+	}
+	increment, err := ParseJSON(by)
+	if err != nil || !increment.isNumber() {
+		return "", fmt.Errorf("-ERR expected a number but found '%s'", by)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil {
+		return "", err
+	}
+	if root == nil {
+		return "", errJSONNoKey
+	}
+	targets, err := jsonTargets(root, path, jsonNumber)
+	if err != nil {
+		return "", err
+	}
+
+	// Every sum is computed before any is stored, so an overflow leaves the
+	// document untouched.
+	sums := make([]*JSONValue, len(targets))
+	for i, target := range targets {
+		if target == nil {
+			continue
+		}
+		if target.kind == jsonInteger && increment.kind == jsonInteger {
+			sum := target.integer + increment.integer
+			if (sum > target.integer) == (increment.integer > 0) {
+				sums[i] = &JSONValue{kind: jsonInteger, integer: sum}
+				continue
+			}
+		}
+		sum := target.float() + increment.float()
+		if math.IsInf(sum, 0) || math.IsNaN(sum) {
+			return "", errors.New("-ERR result is not a number or infinity")
+		}
+		sums[i] = &JSONValue{kind: jsonNumber, number: sum}
+	}
+
+	results := &JSONValue{kind: jsonArray}
+	for i, target := range targets {
+		if target == nil {
+			results.elements = append(results.elements, &JSONValue{kind: jsonNull})
+			continue
+		}
+		*target = *sums[i]
+		results.elements = append(results.elements, sums[i])
+	}
+	if path.legacy {
+		return results.elements[0].String(), nil
+	}
+	return results.String(), nil
+}
+
+// JSONStrAppend appends the JSON string value to the strings path selects
+// and returns their new lengths.
+func (s *InMemoryStore) JSONStrAppend(key string, pathText string, text string) ([]int, []bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := ParseJSON(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	if value.kind != jsonString {
+		return nil, nil, errors.New("-ERR expected a JSON string value")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == nil {
+		return nil, nil, errJSONNoKey
+	}
+	targets, err := jsonTargets(root, path, jsonString)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lengths := make([]int, len(targets))
+	exists := make([]bool, len(targets))
+	for i, target := range targets {
+		if target != nil {
+			target.str += value.str
+			lengths[i], exists[i] = len(target.str), true
+		}
+	}
+	return lengths, exists, nil
+}
+
+func parseJSONValues(texts []string) ([]*JSONValue, error) {
+	values := make([]*JSONValue, len(texts))
+	for i, text := range texts {
+		value, err := ParseJSON(text)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// JSONArrInsert inserts the JSON values before index in the arrays path
+// selects, appending them when index is nil, and returns the new lengths. A
+// negative index counts from the end of each array.
+func (s *InMemoryStore) JSONArrInsert(key string, pathText string, index *int, texts []string) ([]int, []bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := parseJSONValues(texts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == nil {
+		return nil, nil, errJSONNoKey
+	}
+	targets, err := jsonTargets(root, path, jsonArray)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	positions := make([]int, len(targets))
+	for i, target := range targets {
+		if target == nil {
+			continue
+		}
+		positions[i] = len(target.elements)
+		if index != nil {
+			positions[i] = *index
+			if positions[i] < 0 {
+				positions[i] += len(target.elements)
+			}
+			if positions[i] < 0 || positions[i] > len(target.elements) {
+				return nil, nil, errors.New("-ERR index out of bounds")
+			}
+		}
+	}
+
+	lengths := make([]int, len(targets))
+	exists := make([]bool, len(targets))
+	for i, target := range targets {
+		if target == nil {
+			continue
+		}
+		inserted := make([]*JSONValue, len(values))
+		for j, value := range values {
+			inserted[j] = value.clone()
+		}
+		target.elements = slices.Insert(target.elements, positions[i], inserted...)
+		lengths[i], exists[i] = len(target.elements), true
+	}
+	return lengths, exists, nil
+}
+
+// JSONArrPop removes and returns the element at index of the arrays path
+// selects, clamping index to the array bounds. Empty arrays yield nothing.
+func (s *InMemoryStore) JSONArrPop(key string, pathText string, index int) ([]string, []bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == nil {
+		return nil, nil, errJSONNoKey
+	}
+	targets, err := jsonTargets(root, path, jsonArray)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	popped := make([]string, len(targets))
+	exists := make([]bool, len(targets))
+	for i, target := range targets {
+		if target == nil || len(target.elements) == 0 {
+			continue
+		}
+		position := index
+		if position < 0 {
+			position += len(target.elements)
+		}
+		position = min(max(position, 0), len(target.elements)-1)
+		popped[i], exists[i] = target.elements[position].String(), true
+		target.elements = slices.Delete(target.elements, position, position+1)
+	}
+	return popped, exists, nil
+}
+
+// JSONObjKeys returns the keys of the objects path selects.
+func (s *InMemoryStore) JSONObjKeys(key string, pathText string) ([][]string, []bool, error) {
+	path, err := parseJSONPath(pathText)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	root, err := s.jsonLocked(key)
+	if err != nil || root == nil {
+		return nil, nil, err
+	}
+	targets, err := jsonTargets(root, path, jsonObject)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([][]string, len(targets))
+	exists := make([]bool, len(targets))
+	for i, target := range targets {
+		if target != nil {
+			keys[i], exists[i] = slices.Clone(target.keys), true
+		}
+	}
+	return keys, exists, nil
+}
@@ -0,0 +1,461 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const jsonStoreDocument = `{"name":"shop","items":[{"id":1,"price":9.5,"tags":["a"]},{"id":2,"price":20,"tags":[]}],"owner":{"name":"ann","age":40}}`
+
+func newJSONStore(t *testing.T) *InMemoryStore {
+	t.Helper()
+	s := NewInMemoryStore()
+	if ok, err := s.JSONSet("doc", "$", jsonStoreDocument, false, false); !ok || err != nil {
+		t.Fatalf("JSONSet = %v, %v", ok, err)
+	}
+	return s
+}
+
+func jsonGet(t *testing.T, s *InMemoryStore, key string, paths ...string) string {
+	t.Helper()
+	value, ok, err := s.JSONGet(key, paths, JSONFormat{})
+	if err != nil || !ok {
+		t.Fatalf("JSONGet(%s, %v) = %v, %v", key, paths, ok, err)
+	}
+	return value
+}
+
+func TestParseJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{`{"b":1,"a":2}`, `{"b":1,"a":2}`},
+		{` [ 1 , 2.5 , "x" , null , true ] `, `[1,2.5,"x",null,true]`},
+		{`2.0`, `2.0`},
+		{`1e3`, `1000.0`},
+		{`"tab\tquote\"é"`, `"tab\tquote\"é"`},
+		{`"\u0001"`, `"\u0001"`},
+		{`{}`, `{}`},
+	}
+	for _, test := range tests {
+		value, err := ParseJSON(test.text)
+		if err != nil || value.String() != test.want {
+			t.Errorf("ParseJSON(%s) = %v, %v, want %s", test.text, value, err, test.want)
+		}
+	}
+
+	for _, text := range []string{``, `{`, `[1,]`, `{"a":1} x`, `nul`} {
+		if _, err := ParseJSON(text); err == nil {
+			t.Errorf("ParseJSON(%q) did not fail", text)
+		}
+	}
+}
+
+func TestJSONSet(t *testing.T) {
+	s := newJSONStore(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		value  string
+		nx, xx bool
+		ok     bool
+	}{
+		{"replace a field", "$.owner.age", "41", false, false, true},
+		{"add a field", "$.owner.city", `"rome"`, false, false, true},
+		{"NX on an existing path", "$.name", `"other"`, true, false, false},
+		{"XX on a missing path", "$.missing", "1", false, true, false},
+		{"NX on a missing path", "$.open", "true", true, false, true},
+		{"legacy path", ".owner.name", `"bob"`, false, false, true},
+		{"every match", "$.items[*].price", "0", false, false, true},
+		{"missing parent", "$.nothing.here", "1", false, false, false},
+		{"field of a non-object", "$.name.x", "1", false, false, false},
+	}
+	for _, test := range tests {
+		ok, err := s.JSONSet("doc", test.path, test.value, test.nx, test.xx)
+		if err != nil || ok != test.ok {
+			t.Errorf("%s: JSONSet = %v, %v, want %v", test.name, ok, err, test.ok)
+		}
+	}
+
+	want := `{"name":"shop","items":[{"id":1,"price":0,"tags":["a"]},{"id":2,"price":0,"tags":[]}],"owner":{"name":"bob","age":41,"city":"rome"},"open":true}`
+	if got := jsonGet(t, s, "doc"); got != want {
+		t.Errorf("document = %s\nwant %s", got, want)
+	}
+
+	// Values set at several paths are copies of each other.
+	s.JSONNumIncrBy("doc", "$.items[0].price", "1")
+	if got := jsonGet(t, s, "doc", "$.items[*].price"); got != "[1,0]" {
+		t.Errorf("prices = %s, want [1,0]", got)
+	}
+
+	if _, err := s.JSONSet("new", "$.a", "1", false, false); err == nil {
+		t.Error("JSONSet of a new key below the root did not fail")
+	}
+	if ok, _ := s.JSONSet("new", "$", "1", false, true); ok {
+		t.Error("JSONSet XX created a key")
+	}
+	if _, err := s.JSONSet("doc", "$", "{", false, false); err == nil {
+		t.Error("JSONSet of invalid JSON did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.JSONSet("list", "$", "1", false, false); err == nil {
+		t.Error("JSONSet on a list did not fail")
+	}
+}
+
+func TestJSONGet(t *testing.T) {
+	s := newJSONStore(t)
+
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"$.owner.name"}, `["ann"]`},
+		{[]string{".owner.name"}, `"ann"`},
+		{[]string{"owner"}, `{"name":"ann","age":40}`},
+		{[]string{"$.missing"}, `[]`},
+		{[]string{"$.items[*].id", ".name"}, `{"$.items[*].id":[1,2],".name":"shop"}`},
+	}
+	for _, test := range tests {
+		if got := jsonGet(t, s, "doc", test.paths...); got != test.want {
+			t.Errorf("JSONGet(%v) = %s, want %s", test.paths, got, test.want)
+		}
+	}
+
+	got, _, _ := s.JSONGet("doc", []string{".owner"}, JSONFormat{Indent: "  ", Newline: "\n", Space: " "})
+	if want := "{\n  \"name\": \"ann\",\n  \"age\": 40\n}"; got != want {
+		t.Errorf("formatted JSONGet = %q, want %q", got, want)
+	}
+
+	if _, _, err := s.JSONGet("doc", []string{".missing"}, JSONFormat{}); err == nil {
+		t.Error("JSONGet of a missing legacy path did not fail")
+	}
+	if _, ok, err := s.JSONGet("missing", nil, JSONFormat{}); ok || err != nil {
+		t.Errorf("JSONGet of a missing key = %v, %v", ok, err)
+	}
+}
+
+func TestJSONMGet(t *testing.T) {
+	s := newJSONStore(t)
+	s.JSONSet("other", "$", `{"name":"other"}`, false, false)
+	s.RPush("list", []string{"a"})
+
+	values, exists, err := s.JSONMGet([]string{"doc", "other", "list", "missing"}, "$.name")
+	if err != nil || !slices.Equal(values, []string{`["shop"]`, `["other"]`, "", ""}) || !slices.Equal(exists, []bool{true, true, false, false}) {
+		t.Errorf("JSONMGet = %q, %v, %v", values, exists, err)
+	}
+	values, exists, _ = s.JSONMGet([]string{"doc", "other"}, ".owner.age")
+	if !slices.Equal(values, []string{"40", ""}) || !slices.Equal(exists, []bool{true, false}) {
+		t.Errorf("JSONMGet of a legacy path = %q, %v", values, exists)
+	}
+}
+
+func TestJSONDel(t *testing.T) {
+	tests := []struct {
+		path string
+		n    int
+		want string
+	}{
+		{"$.owner.age", 1, `{"name":"ann"}`},
+		{"$.items[*]", 2, `[]`},
+		{"$.items[0,1,5]", 2, `[]`},
+		{"$.items[*].tags[0]", 1, `[{"id":1,"price":9.5,"tags":[]},{"id":2,"price":20,"tags":[]}]`},
+		{"$..name", 2, `{"age":40}`},
+		{"$.missing", 0, `{"name":"ann","age":40}`},
+	}
+	for _, test := range tests {
+		s := newJSONStore(t)
+		if n, err := s.JSONDel("doc", test.path); err != nil || n != test.n {
+			t.Errorf("JSONDel(%s) = %d, %v, want %d", test.path, n, err, test.n)
+		}
+		check := ".owner"
+		if test.want[0] == '[' {
+			check = ".items"
+		}
+		if got := jsonGet(t, s, "doc", check); got != test.want {
+			t.Errorf("after JSONDel(%s), %s = %s, want %s", test.path, check, got, test.want)
+		}
+	}
+
+	s := newJSONStore(t)
+	if n, _ := s.JSONDel("doc", "$"); n != 1 {
+		t.Errorf("JSONDel of the root = %d", n)
+	}
+	if _, ok := s.KeyType["doc"]; ok {
+		t.Error("JSONDel of the root left the key behind")
+	}
+	if n, err := s.JSONDel("doc", "$"); n != 0 || err != nil {
+		t.Errorf("JSONDel of a missing key = %d, %v", n, err)
+	}
+}
+
+func TestJSONType(t *testing.T) {
+	s := newJSONStore(t)
+	s.JSONSet("doc", "$.flag", "false", false, false)
+	s.JSONSet("doc", "$.none", "null", false, false)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"$", []string{"object"}},
+		{"$.items", []string{"array"}},
+		{"$.items[*].price", []string{"number", "integer"}},
+		{"$.flag", []string{"boolean"}},
+		{"$.none", []string{"null"}},
+		{"$.name", []string{"string"}},
+		{"$.missing", []string{}},
+		{"..price", []string{"number"}},
+	}
+	for _, test := range tests {
+		if got, ok, err := s.JSONType("doc", test.path); !ok || err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("JSONType(%s) = %v, %v, %v, want %v", test.path, got, ok, err, test.want)
+		}
+	}
+	if _, ok, _ := s.JSONType("missing", "$"); ok {
+		t.Error("JSONType of a missing key reported it")
+	}
+}
+
+func TestJSONNumIncrBy(t *testing.T) {
+	s := NewInMemoryStore()
+	s.JSONSet("doc", "$", `{"a":1,"b":2.5,"c":"x","big":9223372036854775807}`, false, false)
+
+	tests := []struct {
+		path string
+		by   string
+		want string
+	}{
+		{"$.a", "2", "[3]"},
+		{".a", "-1", "2"},
+		{"$.b", "0.5", "[3.0]"},
+		{"$.a", "1.5", "[3.5]"},
+		{"$.*", "1", "[4.5,4.0,null,9.223372036854776e+18]"},
+	}
+	for _, test := range tests {
+		if got, err := s.JSONNumIncrBy("doc", test.path, test.by); err != nil || got != test.want {
+			t.Errorf("JSONNumIncrBy(%s, %s) = %s, %v, want %s", test.path, test.by, got, err, test.want)
+		}
+	}
+
+	for _, test := range []struct{ key, path, by string }{
+		{"doc", ".c", "1"},
+		{"doc", ".missing", "1"},
+		{"doc", "$.a", `"1"`},
+		{"doc", "$.a", "1e308"},
+		{"missing", "$", "1"},
+	} {
+		s.JSONSet("doc", "$.a", "1.7e308", false, false)
+		if _, err := s.JSONNumIncrBy(test.key, test.path, test.by); err == nil {
+			t.Errorf("JSONNumIncrBy(%s, %s, %s) did not fail", test.key, test.path, test.by)
+		}
+	}
+	if got := jsonGet(t, s, "doc", ".a"); got != "1.7e+308" {
+		t.Errorf("overflowing increment left a = %s", got)
+	}
+}
+
+func TestJSONStrAppend(t *testing.T) {
+	s := newJSONStore(t)
+	lengths, exists, err := s.JSONStrAppend("doc", "$..name", `"!"`)
+	if err != nil || !slices.Equal(lengths, []int{5, 4}) || !slices.Equal(exists, []bool{true, true}) {
+		t.Errorf("JSONStrAppend = %v, %v, %v", lengths, exists, err)
+	}
+	lengths, exists, _ = s.JSONStrAppend("doc", "$.owner.*", `"?"`)
+	if !slices.Equal(lengths, []int{5, 0}) || !slices.Equal(exists, []bool{true, false}) {
+		t.Errorf("JSONStrAppend over mixed types = %v, %v", lengths, exists)
+	}
+	if got := jsonGet(t, s, "doc", "$..name"); got != `["shop!","ann!?"]` {
+		t.Errorf("names = %s", got)
+	}
+
+	if _, _, err := s.JSONStrAppend("doc", ".owner.age", `"x"`); err == nil {
+		t.Error("JSONStrAppend to a number through a legacy path did not fail")
+	}
+	if _, _, err := s.JSONStrAppend("doc", "$.name", "1"); err == nil {
+		t.Error("JSONStrAppend of a non-string did not fail")
+	}
+}
+
+func TestJSONArrays(t *testing.T) {
+	s := NewInMemoryStore()
+	s.JSONSet("doc", "$", `{"a":[1,2,3],"b":[],"c":{"a":"x"}}`, false, false)
+	index := func(i int) *int { return &i }
+
+	tests := []struct {
+		name    string
+		path    string
+		index   *int
+		values  []string
+		lengths []int
+		exists  []bool
+		want    string
+	}{
+		{"append", "$.a", nil, []string{"4", `"five"`}, []int{5}, []bool{true}, `[1,2,3,4,"five"]`},
+		{"insert at the front", "$.a", index(0), []string{"0"}, []int{6}, []bool{true}, `[0,1,2,3,4,"five"]`},
+		{"insert from the end", "$.a", index(-1), []string{"{}"}, []int{7}, []bool{true}, `[0,1,2,3,4,{},"five"]`},
+		{"insert at the end", "$.a", index(7), []string{"null"}, []int{8}, []bool{true}, `[0,1,2,3,4,{},"five",null]`},
+		{"skip non-arrays", "$..a", nil, []string{"9"}, []int{9, 0}, []bool{true, false}, `[0,1,2,3,4,{},"five",null,9]`},
+	}
+	for _, test := range tests {
+		lengths, exists, err := s.JSONArrInsert("doc", test.path, test.index, test.values)
+		if err != nil || !slices.Equal(lengths, test.lengths) || !slices.Equal(exists, test.exists) {
+			t.Errorf("%s: JSONArrInsert = %v, %v, %v", test.name, lengths, exists, err)
+		}
+		if got := jsonGet(t, s, "doc", ".a"); got != test.want {
+			t.Errorf("%s: a = %s, want %s", test.name, got, test.want)
+		}
+	}
+
+	if _, _, err := s.JSONArrInsert("doc", "$.b", index(1), []string{"1"}); err == nil {
+		t.Error("JSONArrInsert past the end did not fail")
+	}
+	if _, _, err := s.JSONArrInsert("doc", ".c", nil, []string{"1"}); err == nil {
+		t.Error("JSONArrInsert into an object through a legacy path did not fail")
+	}
+
+	pops := []struct {
+		index  int
+		popped string
+		want   string
+	}{
+		{-1, "9", `[0,1,2,3,4,{},"five",null]`},
+		{0, "0", `[1,2,3,4,{},"five",null]`},
+		{4, "{}", `[1,2,3,4,"five",null]`},
+		{100, "null", `[1,2,3,4,"five"]`},
+		{-100, "1", `[2,3,4,"five"]`},
+	}
+	for _, pop := range pops {
+		popped, exists, err := s.JSONArrPop("doc", ".a", pop.index)
+		if err != nil || !slices.Equal(popped, []string{pop.popped}) || !exists[0] {
+			t.Errorf("JSONArrPop(%d) = %q, %v, %v, want %s", pop.index, popped, exists, err, pop.popped)
+		}
+		if got := jsonGet(t, s, "doc", ".a"); got != pop.want {
+			t.Errorf("after JSONArrPop(%d), a = %s, want %s", pop.index, got, pop.want)
+		}
+	}
+	if _, exists, _ := s.JSONArrPop("doc", "$.b", -1); !slices.Equal(exists, []bool{false}) {
+		t.Errorf("JSONArrPop of an empty array = %v", exists)
+	}
+	if _, _, err := s.JSONArrPop("missing", "$", -1); err == nil {
+		t.Error("JSONArrPop of a missing key did not fail")
+	}
+}
+
+func TestJSONObjKeys(t *testing.T) {
+	s := newJSONStore(t)
+	keys, exists, err := s.JSONObjKeys("doc", "$..*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for i, k := range keys {
+		if exists[i] {
+			got = append(got, k)
+		}
+	}
+	want := [][]string{{"name", "age"}, {"id", "price", "tags"}, {"id", "price", "tags"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("JSONObjKeys($..*) = %v, want %v", got, want)
+	}
+
+	keys, _, _ = s.JSONObjKeys("doc", ".")
+	if !slices.Equal(keys[0], []string{"name", "items", "owner"}) {
+		t.Errorf("JSONObjKeys(.) = %v", keys)
+	}
+	if _, _, err := s.JSONObjKeys("doc", ".name"); err == nil {
+		t.Error("JSONObjKeys of a string through a legacy path did not fail")
+	}
+}
+
+func TestJSONPathMatch(t *testing.T) {
+	root, err := ParseJSON(`{"a":{"b":[10,20,30,40,50],"c":{"b":1}},"it's":2,"x.y":3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$", `[{"a":{"b":[10,20,30,40,50],"c":{"b":1}},"it's":2,"x.y":3}]`},
+		{"$.a.b[1]", `[20]`},
+		{"$.a.b[-1]", `[50]`},
+		{"$.a.b[0,2,9]", `[10,30]`},
+		{"$.a.b[1:3]", `[20,30]`},
+		{"$.a.b[-2:]", `[40,50]`},
+		{"$.a.b[:2]", `[10,20]`},
+		{"$.a.b[::2]", `[10,30,50]`},
+		{"$.a.b[3:1]", `[]`},
+		{"$.a.*", `[[10,20,30,40,50],{"b":1}]`},
+		{"$.a.b[*]", `[10,20,30,40,50]`},
+		{"$..b", `[[10,20,30,40,50],1]`},
+		{"$..b[0]", `[10]`},
+		{"$..[0]", `[10]`},
+		{`$['it\'s']`, `[2]`},
+		{`$["x.y", 'a']['c']`, `[{"b":1}]`},
+		{`$[ 'x.y' ]`, `[3]`},
+		{"$.a.b.c", `[]`},
+		{"a.c.b", `[1]`},
+	}
+	for _, test := range tests {
+		path, err := parseJSONPath(test.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%s) = %v", test.path, err)
+			continue
+		}
+		array := &JSONValue{kind: jsonArray}
+		for _, match := range path.match(root) {
+			array.elements = append(array.elements, match.value)
+		}
+		if got := array.String(); got != test.want {
+			t.Errorf("%s matched %s, want %s", test.path, got, test.want)
+		}
+	}
+
+	for _, text := range []string{"$.", "$[", "$[]", "$['a'", "$[1:2:0]", "$[a]", "$.a[1:2:3:4]", "$x", `$['a' 'b']`} {
+		if _, err := parseJSONPath(text); err == nil {
+			t.Errorf("parseJSONPath(%q) did not fail", text)
+		}
+	}
+}
+
+func TestJSONSnapshot(t *testing.T) {
+	value, _ := ParseJSON(jsonStoreDocument)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		t.Fatal(err)
+	}
+	var decoded JSONValue
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.String() != jsonStoreDocument {
+		t.Errorf("decoded %s", decoded.String())
+	}
+
+	path := filepath.Join(t.TempDir(), "dump.godb")
+	s := newJSONStore(t)
+	p, err := NewPersistence(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewInMemoryStore()
+	if _, err := NewPersistence(loaded, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := jsonGet(t, loaded, "doc", "."); got != jsonStoreDocument {
+		t.Errorf("loaded document = %s", got)
+	}
+	if types, _, _ := loaded.JSONType("doc", "$.items[0].price"); !slices.Equal(types, []string{"number"}) {
+		t.Errorf("loaded price type = %v", types)
+	}
+}
@@ -0,0 +1,331 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSON paths support the subset of JSONPath most clients rely on: the root
+// $, child names (.name and ['name']), indices and slices ([1], [-1],
+// [0:2]), unions ([0,2] and ['a','b']), wildcards (.* and [*]) and recursive
+// descent (..name). Paths that don't start with $ are legacy paths, which
+// are relative to the root and address a single value.
+
+type jsonSelectorKind int
+
+const (
+	selectNames jsonSelectorKind = iota
+	selectIndices
+	selectSlice
+	selectWildcard
+)
+
+type jsonSelector struct {
+	kind      jsonSelectorKind
+	recursive bool
+	names     []string
+	indices   []int
+	start     *int
+	end       *int
+	step      int
+}
+
+type jsonPath struct {
+	text      string
+	legacy    bool
+	selectors []jsonSelector
+}
+
+func (path jsonPath) isRoot() bool {
+	return len(path.selectors) == 0
+}
+
+// jsonMatch is a value matched by a path along with where it sits in its
+// parent, which is nil for the root.
+type jsonMatch struct {
+	value  *JSONValue
+	parent *JSONValue
+	name   string
+	index  int
+}
+
+func errInvalidJSONPath(text string) error {
+	return fmt.Errorf("-ERR invalid JSONPath '%s'", text)
+}
+
+func parseJSONPath(text string) (jsonPath, error) {
+	path := jsonPath{text: text, legacy: !strings.HasPrefix(text, "$")}
+
+	rest := text
+	if path.legacy {
+		if rest == "." {
+			rest = ""
+		} else if rest != "" && rest[0] != '.' && rest[0] != '[' {
+			rest = "." + rest
+		}
+	} else {
+		rest = rest[1:]
+	}
+
+	for rest != "" {
+		var selector jsonSelector
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			selector.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case rest[0] == '.':
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "" {
+				return jsonPath{}, errInvalidJSONPath(text)
+			}
+			if name == "*" {
+				selector.kind = selectWildcard
+			} else {
+				selector.kind = selectNames
+				selector.names = []string{name}
+			}
+			path.selectors = append(path.selectors, selector)
+			continue
+		case rest[0] != '[':
+			return jsonPath{}, errInvalidJSONPath(text)
+		}
+
+		end := jsonBracketEnd(rest)
+		if end < 0 {
+			return jsonPath{}, errInvalidJSONPath(text)
+		}
+		if err := parseJSONBracket(strings.TrimSpace(rest[1:end]), &selector); err != nil {
+			return jsonPath{}, errInvalidJSONPath(text)
+		}
+		rest = rest[end+1:]
+		path.selectors = append(path.selectors, selector)
+	}
+	return path, nil
+}
+
+// jsonBracketEnd returns the index of the bracket closing the one at the
+// start of s, skipping over quoted names.
+func jsonBracketEnd(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote != 0:
+		case s[i] == '\'' || s[i] == '"':
+			quote = s[i]
+		case s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseJSONBracket(inner string, selector *jsonSelector) error {
+	if inner == "*" {
+		selector.kind = selectWildcard
+		return nil
+	}
+	if inner == "" {
+		return errors.New("empty selector")
+	}
+
+	if inner[0] == '\'' || inner[0] == '"' {
+		selector.kind = selectNames
+		for inner != "" {
+			quote := inner[0]
+			end := 1
+			for end < len(inner) && inner[end] != quote {
+				if inner[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(inner) {
+				return errors.New("unterminated name")
+			}
+			name := inner[1:end]
+			if quote == '"' {
+				unquoted, err := strconv.Unquote(inner[:end+1])
+				if err != nil {
+					return err
+				}
+				name = unquoted
+			} else {
+				name = strings.ReplaceAll(name, `\'`, `'`)
+			}
+			selector.names = append(selector.names, name)
+
+			inner = strings.TrimSpace(inner[end+1:])
+			if inner == "" {
+				break
+			}
+			if inner[0] != ',' {
+				return errors.New("expected comma")
+			}
+			inner = strings.TrimSpace(inner[1:])
+			if inner == "" || inner[0] != '\'' && inner[0] != '"' {
+				return errors.New("expected name")
+			}
+		}
+		return nil
+	}
+
+	if strings.Contains(inner, ":") {
+		selector.kind = selectSlice
+		selector.step = 1
+		parts := strings.Split(inner, ":")
+		if len(parts) > 3 {
+			return errors.New("invalid slice")
+		}
+		bounds := []**int{&selector.start, &selector.end}
+		for i, part := range parts {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return err
+			}
+			if i == 2 {
+				if n <= 0 {
+					return errors.New("invalid step")
+				}
+				selector.step = n
+				continue
+			}
+			*bounds[i] = &n
+		}
+		return nil
+	}
+
+	selector.kind = selectIndices
+	for part := range strings.SplitSeq(inner, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return err
+		}
+		selector.indices = append(selector.indices, n)
+	}
+	return nil
+}
+
+// children appends the children of match the selector picks, ignoring
+// recursion.
+func (selector jsonSelector) children(match jsonMatch, matches []jsonMatch) []jsonMatch {
+	value := match.value
+	switch value.kind {
+	case jsonObject:
+		switch selector.kind {
+		case selectNames:
+			for _, name := range selector.names {
+				if field, ok := value.fields[name]; ok {
+					matches = append(matches, jsonMatch{value: field, parent: value, name: name})
+				}
+			}
+		case selectWildcard:
+			for _, name := range value.keys {
+				matches = append(matches, jsonMatch{value: value.fields[name], parent: value, name: name})
+			}
+		}
+	case jsonArray:
+		length := len(value.elements)
+		element := func(i int) jsonMatch {
+			return jsonMatch{value: value.elements[i], parent: value, index: i}
+		}
+		switch selector.kind {
+		case selectIndices:
+			for _, i := range selector.indices {
+				if i < 0 {
+					i += length
+				}
+				if i >= 0 && i < length {
+					matches = append(matches, element(i))
+				}
+			}
+		case selectSlice:
+			start, end := 0, length
+			if selector.start != nil {
+				start = *selector.start
+			}
+			if selector.end != nil {
+				end = *selector.end
+			}
+			if start < 0 {
+				start += length
+			}
+			if end < 0 {
+				end += length
+			}
+			start, end = max(start, 0), min(end, length)
+			for i := start; i < end; i += selector.step {
+				matches = append(matches, element(i))
+			}
+		case selectWildcard:
+			for i := range value.elements {
+				matches = append(matches, element(i))
+			}
+		}
+	}
+	return matches
+}
+
+// descend appends match and all of its descendants, parents first.
+func descend(match jsonMatch, matches []jsonMatch) []jsonMatch {
+	matches = append(matches, match)
+	children := jsonSelector{kind: selectWildcard}.children(match, nil)
+	for _, child := range children {
+		matches = descend(child, matches)
+	}
+	return matches
+}
+
+// match returns every value the path selects from root.
+func (path jsonPath) match(root *JSONValue) []jsonMatch {
+	matches := []jsonMatch{{value: root}}
+	for _, selector := range path.selectors {
+		var next []jsonMatch
+		for _, match := range matches {
+			if !selector.recursive {
+				next = selector.children(match, next)
+				continue
+			}
+			for _, descendant := range descend(match, nil) {
+				next = selector.children(descendant, next)
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// parentPath returns the path to the parent of the values the path selects
+// and the name it would create there, if the path ends in a single plain
+// child name.
+func (path jsonPath) parentPath() (jsonPath, string, bool) {
+	if path.isRoot() {
+		return jsonPath{}, "", false
+	}
+	last := path.selectors[len(path.selectors)-1]
+	if last.kind != selectNames || last.recursive || len(last.names) != 1 {
+		return jsonPath{}, "", false
+	}
+	parent := path
+	parent.selectors = path.selectors[:len(path.selectors)-1]
+	return parent, last.names[0], true
+}
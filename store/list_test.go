@@ -0,0 +1,180 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func listContents(t *testing.T, s *InMemoryStore, key string) []string {
+	t.Helper()
+	values, err := s.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestPushOrderAndPushX(t *testing.T) {
+	s := NewInMemoryStore()
+	if n, _ := s.LPushX("list", []string{"a"}); n != 0 {
+		t.Errorf("LPushX on a missing key = %d, want 0", n)
+	}
+	if n, _ := s.RPushX("list", []string{"a"}); n != 0 {
+		t.Errorf("RPushX on a missing key = %d, want 0", n)
+	}
+	if _, ok := s.KeyType["list"]; ok {
+		t.Fatal("PushX created the key")
+	}
+
+	s.LPush("list", []string{"a", "b", "c"})
+	s.RPushX("list", []string{"d", "e"})
+	if n, _ := s.LPushX("list", []string{"z"}); n != 6 {
+		t.Errorf("LPushX on an existing key = %d, want 6", n)
+	}
+	if got, want := listContents(t, s, "list"), []string{"z", "c", "b", "a", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("list = %v, want %v", got, want)
+	}
+}
+
+func TestLIndexAndLSet(t *testing.T) {
+	s := NewInMemoryStore()
+	s.RPush("list", []string{"a", "b", "c"})
+
+	tests := []struct {
+		index int
+		value string
+		ok    bool
+	}{
+		{0, "a", true},
+		{2, "c", true},
+		{-1, "c", true},
+		{-3, "a", true},
+		{3, "", false},
+		{-4, "", false},
+	}
+	for _, test := range tests {
+		value, ok, err := s.LIndex("list", test.index)
+		if err != nil || value != test.value || ok != test.ok {
+			t.Errorf("LIndex(%d) = %q, %v, %v", test.index, value, ok, err)
+		}
+	}
+	if _, ok, _ := s.LIndex("missing", 0); ok {
+		t.Error("LIndex on a missing key found a value")
+	}
+
+	if err := s.LSet("list", -1, "z"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LSet("list", 3, "z"); err == nil || err.Error() != "-ERR index out of range" {
+		t.Errorf("LSet out of range = %v", err)
+	}
+	if err := s.LSet("missing", 0, "z"); err == nil || err.Error() != "-ERR no such key" {
+		t.Errorf("LSet on a missing key = %v", err)
+	}
+	if got, want := listContents(t, s, "list"), []string{"a", "b", "z"}; !slices.Equal(got, want) {
+		t.Errorf("list = %v, want %v", got, want)
+	}
+}
+
+func TestLInsert(t *testing.T) {
+	s := NewInMemoryStore()
+	if n, _ := s.LInsert("list", true, "a", "x"); n != 0 {
+		t.Errorf("LInsert on a missing key = %d, want 0", n)
+	}
+
+	s.RPush("list", []string{"a", "b", "a"})
+	if n, _ := s.LInsert("list", true, "a", "x"); n != 4 {
+		t.Errorf("LInsert = %d, want 4", n)
+	}
+	if n, _ := s.LInsert("list", false, "b", "y"); n != 5 {
+		t.Errorf("LInsert = %d, want 5", n)
+	}
+	if n, _ := s.LInsert("list", false, "missing", "y"); n != -1 {
+		t.Errorf("LInsert with a missing pivot = %d, want -1", n)
+	}
+	if got, want := listContents(t, s, "list"), []string{"x", "a", "b", "y", "a"}; !slices.Equal(got, want) {
+		t.Errorf("list = %v, want %v", got, want)
+	}
+}
+
+func TestLRem(t *testing.T) {
+	tests := []struct {
+		count   int
+		removed int
+		want    []string
+	}{
+		{2, 2, []string{"b", "c", "a", "b", "a"}},
+		{-2, 2, []string{"a", "b", "a", "c", "b"}},
+		{0, 4, []string{"b", "c", "b"}},
+		{10, 4, []string{"b", "c", "b"}},
+	}
+	for _, test := range tests {
+		s := NewInMemoryStore()
+		s.RPush("list", []string{"a", "b", "a", "c", "a", "b", "a"})
+		removed, err := s.LRem("list", test.count, "a")
+		if err != nil || removed != test.removed {
+			t.Errorf("LRem(%d) = %d, %v, want %d", test.count, removed, err, test.removed)
+		}
+		if got := listContents(t, s, "list"); !slices.Equal(got, test.want) {
+			t.Errorf("LRem(%d) left %v, want %v", test.count, got, test.want)
+		}
+	}
+
+	s := NewInMemoryStore()
+	s.RPush("list", []string{"a", "a"})
+	s.LRem("list", 0, "a")
+	if _, ok := s.KeyType["list"]; ok {
+		t.Error("LRem left an empty list behind")
+	}
+}
+
+func TestLPos(t *testing.T) {
+	s := NewInMemoryStore()
+	s.RPush("list", []string{"a", "b", "c", "1", "2", "3", "c", "c"})
+
+	tests := []struct {
+		rank, count, maxLen int
+		want                []int
+	}{
+		{1, 1, 0, []int{2}},
+		{2, 1, 0, []int{6}},
+		{-1, 1, 0, []int{7}},
+		{-2, 1, 0, []int{6}},
+		{1, 0, 0, []int{2, 6, 7}},
+		{-1, 0, 0, []int{7, 6, 2}},
+		{2, 2, 0, []int{6, 7}},
+		{1, 0, 3, []int{2}},
+		{1, 0, 2, nil},
+		{4, 1, 0, nil},
+	}
+	for _, test := range tests {
+		got, err := s.LPos("list", "c", test.rank, test.count, test.maxLen)
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("LPos(rank %d, count %d, maxlen %d) = %v, %v, want %v", test.rank, test.count, test.maxLen, got, err, test.want)
+		}
+	}
+}
+
+func TestListWrongType(t *testing.T) {
+	s := NewInMemoryStore()
+	s.StringSet("string", "value", 0, false, false, false, false)
+
+	if _, _, err := s.LIndex("string", 0); err == nil {
+		t.Error("LIndex on a string did not fail")
+	}
+	if err := s.LSet("string", 0, "x"); err == nil {
+		t.Error("LSet on a string did not fail")
+	}
+	if _, err := s.LInsert("string", true, "a", "x"); err == nil {
+		t.Error("LInsert on a string did not fail")
+	}
+	if _, err := s.LRem("string", 0, "a"); err == nil {
+		t.Error("LRem on a string did not fail")
+	}
+	if _, err := s.LPos("string", "a", 1, 1, 0); err == nil {
+		t.Error("LPos on a string did not fail")
+	}
+	if _, err := s.LPushX("string", []string{"a"}); err == nil {
+		t.Error("LPushX on a string did not fail")
+	}
+}
@@ -72,11 +72,63 @@ func (p *Persistence) loadFileData() error {
 
 	dec := gob.NewDecoder(file)
 
+	p.memory.SnapshotVersion = 0
 	err = dec.Decode(p.memory)
 
 	if err != nil {
+		p.memory.SnapshotVersion = snapshotVersion
 		return err
 	}
+	if p.memory.SnapshotVersion == 0 {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := p.memory.convertLegacySnapshot(gob.NewDecoder(file)); err != nil {
+			return err
+		}
+	}
+	p.memory.rebuildSearchIndexes()
+	return nil
+}
+
+// snapshotVersion is saved with every snapshot. Snapshots without one may
+// come from before StoreValue replaced its Value and Expiry fields, which gob
+// silently drops when decoding them into the current shape.
+const snapshotVersion = 1
+
+// legacyStoreValue decodes a string key of an unversioned snapshot, which has
+// either the old fields or the current ones depending on when it was written;
+// Str and Int are only there so gob finds a matching field in both cases.
+type legacyStoreValue struct {
+	Value  string
+	Expiry *time.Time
+	Str    string
+	Int    int64
+}
+
+type legacySnapshot struct {
+	StringKV map[string]legacyStoreValue
+}
+
+// convertLegacySnapshot decodes an unversioned snapshot a second time to
+// recover the strings saved in the old StoreValue shape. An old value with an
+// empty Value and no Expiry already decoded as the same empty string.
+func (s *InMemoryStore) convertLegacySnapshot(dec *gob.Decoder) error {
+	var legacy legacySnapshot
+	if err := dec.Decode(&legacy); err != nil {
+		return err
+	}
+	for key, value := range legacy.StringKV {
+		if value.Value == "" && value.Expiry == nil {
+			continue
+		}
+		var expiresAt int64
+		if value.Expiry != nil {
+			expiresAt = value.Expiry.UnixMilli()
+		}
+		s.StringKV[key] = newStringValue(value.Value, expiresAt)
+	}
+	s.SnapshotVersion = snapshotVersion
 	return nil
 }
 
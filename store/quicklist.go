@@ -0,0 +1,570 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"sort"
+)
+
+// A node is closed to new entries once it holds this many entries or bytes,
+// matching the default list-max-listpack-size of -2 (8kb) in Redis.
+const (
+	quickListNodeMaxEntries = 128
+	quickListNodeMaxBytes   = 8192
+)
+
+// quickListNode packs its entries back to back like a listpack. Every entry is
+// laid out as
+//
+//	<uvarint data length> <data> <backlen>
+//
+// where backlen encodes the size of the first two parts so the node can also
+// be walked from its tail without decoding it from the front.
+type quickListNode struct {
+	entries []byte
+	count   int
+}
+
+func appendQuickListEntry(buf []byte, value string) []byte {
+	start := len(buf)
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	buf = append(buf, value...)
+	return appendBackLen(buf, len(buf)-start)
+}
+
+// appendBackLen writes n in 7-bit groups, most significant group first. Every
+// byte but the leftmost has its high bit set, so it can be decoded right to
+// left.
+func appendBackLen(buf []byte, n int) []byte {
+	var groups [10]byte
+	k := 0
+	for {
+		groups[k] = byte(n & 0x7f)
+		n >>= 7
+		k++
+		if n == 0 {
+			break
+		}
+	}
+	for i := k - 1; i >= 0; i-- {
+		b := groups[i]
+		if i != k-1 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+	}
+	return buf
+}
+
+func backLenSize(n int) int {
+	size := 1
+	for n >= 128 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+func quickListEntrySize(value string) int {
+	n := len(value) + uvarintSize(uint64(len(value)))
+	return n + backLenSize(n)
+}
+
+func uvarintSize(n uint64) int {
+	size := 1
+	for n >= 0x80 {
+		n >>= 7
+		size++
+	}
+	return size
+}
+
+// decodeEntry returns the entry starting at off and the offset of the next one.
+func decodeEntry(buf []byte, off int) (string, int) {
+	data, next := entryData(buf, off)
+	return string(data), next
+}
+
+// entryData is decodeEntry without copying the entry out of buf.
+func entryData(buf []byte, off int) ([]byte, int) {
+	n, w := binary.Uvarint(buf[off:])
+	start := off + w
+	end := start + int(n)
+	return buf[start:end], end + backLenSize(end-off)
+}
+
+func skipEntry(buf []byte, off int) int {
+	n, w := binary.Uvarint(buf[off:])
+	end := off + w + int(n)
+	return end + backLenSize(end-off)
+}
+
+// entryStartBefore returns the offset of the entry that ends right before end.
+func entryStartBefore(buf []byte, end int) int {
+	n, shift := 0, 0
+	p := end - 1
+	for {
+		b := buf[p]
+		n |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+		p--
+	}
+	return p - n
+}
+
+func (n *quickListNode) fits(value string) bool {
+	return n.count < quickListNodeMaxEntries && len(n.entries)+quickListEntrySize(value) <= quickListNodeMaxBytes
+}
+
+func (n *quickListNode) pushFront(value string) {
+	entries := make([]byte, 0, quickListEntrySize(value)+len(n.entries))
+	entries = appendQuickListEntry(entries, value)
+	n.entries = append(entries, n.entries...)
+	n.count++
+}
+
+func (n *quickListNode) pushBack(value string) {
+	n.entries = appendQuickListEntry(n.entries, value)
+	n.count++
+}
+
+func (n *quickListNode) popFront() string {
+	value, next := decodeEntry(n.entries, 0)
+	n.entries = n.entries[next:]
+	n.count--
+	return value
+}
+
+func (n *quickListNode) popBack() string {
+	start := entryStartBefore(n.entries, len(n.entries))
+	value, _ := decodeEntry(n.entries, start)
+	n.entries = n.entries[:start]
+	n.count--
+	return value
+}
+
+// offsetOf returns the byte offset of entry i, walking from whichever end of
+// the node is closer.
+func (n *quickListNode) offsetOf(i int) int {
+	if i <= n.count/2 {
+		off := 0
+		for range i {
+			off = skipEntry(n.entries, off)
+		}
+		return off
+	}
+
+	off := len(n.entries)
+	for range n.count - i {
+		off = entryStartBefore(n.entries, off)
+	}
+	return off
+}
+
+func (n *quickListNode) at(i int) string {
+	value, _ := decodeEntry(n.entries, n.offsetOf(i))
+	return value
+}
+
+func (n *quickListNode) values() []string {
+	values := make([]string, 0, n.count)
+	for off := 0; off < len(n.entries); {
+		var value string
+		value, off = decodeEntry(n.entries, off)
+		values = append(values, value)
+	}
+	return values
+}
+
+func (n *quickListNode) setValues(values []string) {
+	entries := make([]byte, 0, len(n.entries))
+	for _, value := range values {
+		entries = appendQuickListEntry(entries, value)
+	}
+	n.entries = entries
+	n.count = len(values)
+}
+
+func (n *quickListNode) replace(i int, value string) {
+	start := n.offsetOf(i)
+	end := skipEntry(n.entries, start)
+
+	entries := make([]byte, 0, len(n.entries)-(end-start)+quickListEntrySize(value))
+	entries = append(entries, n.entries[:start]...)
+	entries = appendQuickListEntry(entries, value)
+	n.entries = append(entries, n.entries[end:]...)
+}
+
+func (n *quickListNode) insert(i int, value string) {
+	start := n.offsetOf(i)
+
+	entries := make([]byte, 0, len(n.entries)+quickListEntrySize(value))
+	entries = append(entries, n.entries[:start]...)
+	entries = appendQuickListEntry(entries, value)
+	n.entries = append(entries, n.entries[start:]...)
+	n.count++
+}
+
+// QuickList is a list of packed nodes, so elements cost a few bytes of framing
+// instead of a heap allocated linked list element each. Indexed access binary
+// searches a lazily maintained table of per-node element offsets and then walks
+// at most half a node.
+type QuickList struct {
+	nodes  []*quickListNode
+	length int
+
+	// offsets[i] (plus headDelta when i > 0) is the number of elements before
+	// nodes[i]. Pushes and pops on the head node only touch headDelta, so
+	// queue-like usage does not invalidate the table; any other change marks
+	// the entries from dirtyFrom onwards as stale.
+	offsets   []int
+	headDelta int
+	dirtyFrom int
+}
+
+func NewQuickList() *QuickList {
+	return &QuickList{}
+}
+
+func (q *QuickList) Len() int {
+	return q.length
+}
+
+func (q *QuickList) nodeChanged(k int, delta int) {
+	q.length += delta
+	if k == 0 {
+		q.headDelta += delta
+	} else {
+		q.dirtyFrom = min(q.dirtyFrom, k+1)
+	}
+}
+
+func (q *QuickList) insertNode(k int, node *quickListNode) {
+	q.nodes = append(q.nodes, nil)
+	copy(q.nodes[k+1:], q.nodes[k:])
+	q.nodes[k] = node
+	q.offsets = append(q.offsets, 0)
+	q.dirtyFrom = min(q.dirtyFrom, k)
+}
+
+func (q *QuickList) removeNode(k int) {
+	q.nodes = append(q.nodes[:k], q.nodes[k+1:]...)
+	q.offsets = q.offsets[:len(q.nodes)]
+	q.dirtyFrom = min(q.dirtyFrom, k)
+}
+
+func (q *QuickList) offset(k int) int {
+	if k == 0 {
+		return 0
+	}
+	return q.offsets[k] + q.headDelta
+}
+
+func (q *QuickList) refreshOffsets() {
+	if q.dirtyFrom >= len(q.nodes) {
+		return
+	}
+	if q.dirtyFrom == 0 {
+		q.headDelta = 0
+		q.offsets[0] = 0
+		q.dirtyFrom = 1
+	}
+	for k := q.dirtyFrom; k < len(q.nodes); k++ {
+		q.offsets[k] = q.offset(k-1) + q.nodes[k-1].count - q.headDelta
+	}
+	q.dirtyFrom = len(q.nodes)
+}
+
+// locate returns the node holding element i and the position inside it.
+func (q *QuickList) locate(i int) (int, int) {
+	q.refreshOffsets()
+	k := sort.Search(len(q.nodes), func(k int) bool {
+		return q.offset(k) > i
+	}) - 1
+	return k, i - q.offset(k)
+}
+
+func (q *QuickList) PushFront(value string) {
+	if len(q.nodes) == 0 || !q.nodes[0].fits(value) {
+		q.insertNode(0, &quickListNode{})
+	}
+	q.nodes[0].pushFront(value)
+	q.nodeChanged(0, 1)
+}
+
+func (q *QuickList) PushBack(value string) {
+	if len(q.nodes) == 0 || !q.nodes[len(q.nodes)-1].fits(value) {
+		q.insertNode(len(q.nodes), &quickListNode{})
+	}
+	k := len(q.nodes) - 1
+	q.nodes[k].pushBack(value)
+	q.nodeChanged(k, 1)
+}
+
+func (q *QuickList) PopFront() (string, bool) {
+	if q.length == 0 {
+		return "", false
+	}
+	value := q.nodes[0].popFront()
+	q.nodeChanged(0, -1)
+	if q.nodes[0].count == 0 {
+		q.removeNode(0)
+	}
+	return value, true
+}
+
+func (q *QuickList) PopBack() (string, bool) {
+	if q.length == 0 {
+		return "", false
+	}
+	k := len(q.nodes) - 1
+	value := q.nodes[k].popBack()
+	q.nodeChanged(k, -1)
+	if q.nodes[k].count == 0 {
+		q.removeNode(k)
+	}
+	return value, true
+}
+
+// Index accepts negative indexes counting from the tail.
+func (q *QuickList) Index(i int) (string, bool) {
+	if i < 0 {
+		i += q.length
+	}
+	if i < 0 || i >= q.length {
+		return "", false
+	}
+	k, pos := q.locate(i)
+	return q.nodes[k].at(pos), true
+}
+
+func (q *QuickList) Set(i int, value string) bool {
+	if i < 0 {
+		i += q.length
+	}
+	if i < 0 || i >= q.length {
+		return false
+	}
+	k, pos := q.locate(i)
+	q.nodes[k].replace(pos, value)
+	return true
+}
+
+// Insert places value so that it ends up at index i, for 0 <= i <= Len().
+func (q *QuickList) Insert(i int, value string) {
+	if i == 0 {
+		q.PushFront(value)
+		return
+	}
+	if i == q.length {
+		q.PushBack(value)
+		return
+	}
+
+	// A full node is split in half, unless the value can go at the end of the
+	// previous node instead or the node holds a single entry that cannot be
+	// split, in which case the value gets a node of its own.
+	k, pos := q.locate(i)
+	switch {
+	case q.nodes[k].fits(value):
+	case pos == 0 && k > 0 && q.nodes[k-1].fits(value):
+		k, pos = k-1, q.nodes[k-1].count
+	case q.nodes[k].count <= 1:
+		q.insertNode(k, &quickListNode{})
+	default:
+		values := q.nodes[k].values()
+		half := len(values) / 2
+		q.nodes[k].setValues(values[:half])
+		tail := &quickListNode{}
+		tail.setValues(values[half:])
+		q.insertNode(k+1, tail)
+		q.dirtyFrom = min(q.dirtyFrom, k+1)
+		if pos >= half {
+			k, pos = k+1, pos-half
+		}
+	}
+	q.nodes[k].insert(pos, value)
+	q.nodeChanged(k, 1)
+}
+
+// Range returns the elements in [start, end]; both must already be valid
+// indexes as produced by normalizeRange.
+func (q *QuickList) Range(start int, end int) []string {
+	values := make([]string, 0, end-start+1)
+	k, pos := q.locate(start)
+	off := q.nodes[k].offsetOf(pos)
+	for len(values) < end-start+1 {
+		if off >= len(q.nodes[k].entries) {
+			k++
+			off = 0
+			continue
+		}
+		var value string
+		value, off = decodeEntry(q.nodes[k].entries, off)
+		values = append(values, value)
+	}
+	return values
+}
+
+// Trim keeps only the elements in [start, end], dropping whole nodes where
+// possible.
+func (q *QuickList) Trim(start int, end int) {
+	q.removeFront(start)
+	q.removeBack(q.length - (end - start + 1))
+}
+
+func (q *QuickList) removeFront(n int) {
+	for n > 0 && len(q.nodes) > 0 && q.nodes[0].count <= n {
+		n -= q.nodes[0].count
+		q.nodeChanged(0, -q.nodes[0].count)
+		q.removeNode(0)
+	}
+	for range n {
+		q.PopFront()
+	}
+}
+
+func (q *QuickList) removeBack(n int) {
+	for n > 0 && len(q.nodes) > 0 && q.nodes[len(q.nodes)-1].count <= n {
+		k := len(q.nodes) - 1
+		n -= q.nodes[k].count
+		q.nodeChanged(k, -q.nodes[k].count)
+		q.removeNode(k)
+	}
+	for range n {
+		q.PopBack()
+	}
+}
+
+// RemoveMatching deletes up to count occurrences of value scanning from the
+// head, or from the tail when fromTail is set. A count of zero removes every
+// occurrence.
+func (q *QuickList) RemoveMatching(value string, count int, fromTail bool) int {
+	removed := 0
+	for step := range len(q.nodes) {
+		if count != 0 && removed == count {
+			break
+		}
+
+		k := step
+		if fromTail {
+			k = len(q.nodes) - 1 - step
+		}
+		node := q.nodes[k]
+		if !bytes.Contains(node.entries, []byte(value)) {
+			continue
+		}
+
+		values := node.values()
+		kept := make([]string, 0, len(values))
+		nodeRemoved := 0
+		for j := range values {
+			idx := j
+			if fromTail {
+				idx = len(values) - 1 - j
+			}
+			if values[idx] == value && (count == 0 || removed+nodeRemoved < count) {
+				nodeRemoved++
+				continue
+			}
+			kept = append(kept, values[idx])
+		}
+		if nodeRemoved == 0 {
+			continue
+		}
+		if fromTail {
+			for a, b := 0, len(kept)-1; a < b; a, b = a+1, b-1 {
+				kept[a], kept[b] = kept[b], kept[a]
+			}
+		}
+
+		node.setValues(kept)
+		q.nodeChanged(k, -nodeRemoved)
+		removed += nodeRemoved
+	}
+
+	for k := len(q.nodes) - 1; k >= 0; k-- {
+		if q.nodes[k].count == 0 {
+			q.removeNode(k)
+		}
+	}
+	return removed
+}
+
+// Each calls fn with every element and its index from the head, walking from
+// the tail when fromTail is set, until fn returns false.
+func (q *QuickList) Each(fromTail bool, fn func(index int, value string) bool) {
+	if !fromTail {
+		index := 0
+		for _, node := range q.nodes {
+			for off := 0; off < len(node.entries); index++ {
+				var value string
+				value, off = decodeEntry(node.entries, off)
+				if !fn(index, value) {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	index := q.length - 1
+	for k := len(q.nodes) - 1; k >= 0; k-- {
+		entries := q.nodes[k].entries
+		for end := len(entries); end > 0; index-- {
+			start := entryStartBefore(entries, end)
+			value, _ := decodeEntry(entries, start)
+			if !fn(index, value) {
+				return
+			}
+			end = start
+		}
+	}
+}
+
+// Encoding reports "listpack" while the list fits in a single node, like
+// Redis does for small lists, and "quicklist" otherwise.
+func (q *QuickList) Encoding() string {
+	if len(q.nodes) <= 1 {
+		return "listpack"
+	}
+	return "quicklist"
+}
+
+type quickListSnapshot struct {
+	Nodes  [][]byte
+	Counts []int
+}
+
+func (q *QuickList) GobEncode() ([]byte, error) {
+	snapshot := quickListSnapshot{}
+	for _, node := range q.nodes {
+		snapshot.Nodes = append(snapshot.Nodes, node.entries)
+		snapshot.Counts = append(snapshot.Counts, node.count)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (q *QuickList) GobDecode(data []byte) error {
+	var snapshot quickListSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	*q = QuickList{}
+	for i, entries := range snapshot.Nodes {
+		q.nodes = append(q.nodes, &quickListNode{entries: entries, count: snapshot.Counts[i]})
+		q.length += snapshot.Counts[i]
+	}
+	q.offsets = make([]int, len(q.nodes))
+	return nil
+}
@@ -0,0 +1,306 @@
+package store
+
+import (
+	"container/list"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// checkQuickList verifies the node invariants and that q holds exactly want.
+func checkQuickList(t *testing.T, q *QuickList, want []string) {
+	t.Helper()
+	total := 0
+	for k, node := range q.nodes {
+		if node.count == 0 {
+			t.Fatalf("node %d of %d is empty", k, len(q.nodes))
+		}
+		if values := node.values(); len(values) != node.count {
+			t.Fatalf("node %d decodes to %d entries, count is %d", k, len(values), node.count)
+		}
+		total += node.count
+	}
+	if total != q.Len() || q.Len() != len(want) {
+		t.Fatalf("nodes hold %d entries, Len() = %d, want %d", total, q.Len(), len(want))
+	}
+
+	if len(want) > 0 {
+		if got := q.Range(0, len(want)-1); !slices.Equal(got, want) {
+			t.Fatalf("Range = %v, want %v", abbreviate(got), abbreviate(want))
+		}
+	}
+	for i := range want {
+		if value, ok := q.Index(i); !ok || value != want[i] {
+			t.Fatalf("Index(%d) = %q, %v, want %q", i, abbreviate([]string{value}), ok, abbreviate(want[i:i+1]))
+		}
+	}
+	var backwards []string
+	q.Each(true, func(index int, value string) bool {
+		if value != want[index] {
+			t.Fatalf("Each from the tail gave %q at %d", abbreviate([]string{value}), index)
+		}
+		backwards = append(backwards, value)
+		return true
+	})
+	if len(backwards) != len(want) {
+		t.Fatalf("Each from the tail visited %d elements, want %d", len(backwards), len(want))
+	}
+}
+
+// abbreviate shortens the large values used near the node size limit so
+// failures stay readable.
+func abbreviate(values []string) []string {
+	short := make([]string, len(values))
+	for i, value := range values {
+		if len(value) > 16 {
+			value = value[:8] + "...(" + strconv.Itoa(len(value)) + ")"
+		}
+		short[i] = value
+	}
+	return short
+}
+
+func TestQuickListInsertBeforeLargeEntry(t *testing.T) {
+	large := strings.Repeat("x", 8190)
+	q := NewQuickList()
+	q.PushBack("a")
+	q.PushBack(large)
+	q.PushBack("b")
+	q.Insert(1, "c")
+	checkQuickList(t, q, []string{"a", "c", large, "b"})
+
+	for _, want := range []string{"a", "c", large, "b"} {
+		if value, ok := q.PopFront(); !ok || value != want {
+			t.Fatalf("PopFront = %q, %v", abbreviate([]string{value}), ok)
+		}
+	}
+	checkQuickList(t, q, nil)
+}
+
+func TestQuickListInsertIntoFullSingleEntryNodes(t *testing.T) {
+	large := strings.Repeat("x", 8000)
+	q := NewQuickList()
+	want := []string{}
+	for i := range 4 {
+		value := strconv.Itoa(i) + large
+		q.PushBack(value)
+		want = append(want, value)
+	}
+	for _, i := range []int{1, 3, 5, 7, 2} {
+		value := strconv.Itoa(i) + large
+		q.Insert(i, value)
+		want = slices.Insert(want, i, value)
+		checkQuickList(t, q, want)
+	}
+}
+
+// randomQuickListValue mostly returns short values from a small vocabulary, so
+// RemoveMatching finds duplicates and nodes fill up on the entry limit, and
+// otherwise values around the node size limit.
+func randomQuickListValue(rng *rand.Rand) string {
+	if rng.Intn(8) > 0 {
+		return "v" + strconv.Itoa(rng.Intn(10))
+	}
+	sizes := []int{0, 1000, 4000, 8180, 8188, 8190, 8192, 9000}
+	return strconv.Itoa(rng.Intn(3)) + strings.Repeat("x", sizes[rng.Intn(len(sizes))])
+}
+
+func TestQuickListAgainstSlice(t *testing.T) {
+	for seed := range int64(150) {
+		rng := rand.New(rand.NewSource(seed))
+		q := NewQuickList()
+		var want []string
+
+		for step := range 400 {
+			switch op := rng.Intn(10); {
+			case op < 2:
+				value := randomQuickListValue(rng)
+				q.PushFront(value)
+				want = slices.Insert(want, 0, value)
+			case op < 4:
+				value := randomQuickListValue(rng)
+				q.PushBack(value)
+				want = append(want, value)
+			case op == 4:
+				value, ok := q.PopFront()
+				if ok != (len(want) > 0) || ok && value != want[0] {
+					t.Fatalf("seed %d step %d: PopFront = %q, %v", seed, step, abbreviate([]string{value}), ok)
+				}
+				if ok {
+					want = want[1:]
+				}
+			case op == 5:
+				value, ok := q.PopBack()
+				if ok != (len(want) > 0) || ok && value != want[len(want)-1] {
+					t.Fatalf("seed %d step %d: PopBack = %q, %v", seed, step, abbreviate([]string{value}), ok)
+				}
+				if ok {
+					want = want[:len(want)-1]
+				}
+			case op == 6:
+				i := rng.Intn(len(want) + 1)
+				value := randomQuickListValue(rng)
+				q.Insert(i, value)
+				want = slices.Insert(want, i, value)
+			case op == 7:
+				i := rng.Intn(len(want)+2) - 1
+				value := randomQuickListValue(rng)
+				ok := q.Set(i, value)
+				if ok != (i >= -1 && i < len(want) && len(want) > 0) {
+					t.Fatalf("seed %d step %d: Set(%d) = %v with %d elements", seed, step, i, ok, len(want))
+				}
+				if ok {
+					if i < 0 {
+						i += len(want)
+					}
+					want[i] = value
+				}
+			case op == 8:
+				value := "v" + strconv.Itoa(rng.Intn(10))
+				count := rng.Intn(3)
+				fromTail := rng.Intn(2) == 0
+				removed := q.RemoveMatching(value, count, fromTail)
+				want = removeMatchingReference(t, want, value, count, fromTail, removed)
+			default:
+				if len(want) == 0 || rng.Intn(4) > 0 {
+					continue
+				}
+				start := rng.Intn(len(want))
+				end := start + rng.Intn(len(want)-start)
+				q.Trim(start, end)
+				want = slices.Clone(want[start : end+1])
+			}
+			checkQuickList(t, q, want)
+		}
+
+		decoded := NewQuickList()
+		data, err := q.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		checkQuickList(t, decoded, want)
+	}
+}
+
+// removeMatchingReference removes the same occurrences RemoveMatching should
+// have, after checking it reported the right number.
+func removeMatchingReference(t *testing.T, values []string, value string, count int, fromTail bool, removed int) []string {
+	t.Helper()
+	kept := slices.Clone(values)
+	n := 0
+	for j := range values {
+		i := j
+		if fromTail {
+			i = len(values) - 1 - j
+		}
+		if values[i] == value && (count == 0 || n < count) {
+			kept[i] = "\x00removed"
+			n++
+		}
+	}
+	if n != removed {
+		t.Fatalf("RemoveMatching(%q, %d, %v) removed %d elements, want %d", value, count, fromTail, removed, n)
+	}
+	return slices.DeleteFunc(kept, func(v string) bool { return v == "\x00removed" })
+}
+
+// The linked list benchmarks reproduce how ListKV used container/list before
+// it switched to QuickList, walking from the front for indexed access.
+
+const benchmarkListSize = 100000
+
+func newBenchmarkQuickList() *QuickList {
+	q := NewQuickList()
+	for i := range benchmarkListSize {
+		q.PushBack(strconv.Itoa(i))
+	}
+	return q
+}
+
+func newBenchmarkLinkedList() *list.List {
+	l := list.New()
+	for i := range benchmarkListSize {
+		l.PushBack(strconv.Itoa(i))
+	}
+	return l
+}
+
+func linkedListRange(l *list.List, start int, end int) []string {
+	var values []string
+	element := l.Front()
+	for range start {
+		element = element.Next()
+	}
+	for i := start; i <= end; i++ {
+		values = append(values, element.Value.(string))
+		element = element.Next()
+	}
+	return values
+}
+
+func BenchmarkQuickListPushBack(b *testing.B) {
+	for b.Loop() {
+		newBenchmarkQuickList()
+	}
+}
+
+func BenchmarkLinkedListPushBack(b *testing.B) {
+	for b.Loop() {
+		newBenchmarkLinkedList()
+	}
+}
+
+func BenchmarkQuickListIndex(b *testing.B) {
+	q := newBenchmarkQuickList()
+	i := 0
+	for b.Loop() {
+		q.Index(i % benchmarkListSize)
+		i += 7919
+	}
+}
+
+func BenchmarkLinkedListIndex(b *testing.B) {
+	l := newBenchmarkLinkedList()
+	i := 0
+	for b.Loop() {
+		linkedListRange(l, i%benchmarkListSize, i%benchmarkListSize)
+		i += 7919
+	}
+}
+
+func BenchmarkQuickListRange(b *testing.B) {
+	q := newBenchmarkQuickList()
+	for b.Loop() {
+		q.Range(benchmarkListSize/2, benchmarkListSize/2+99)
+	}
+}
+
+func BenchmarkLinkedListRange(b *testing.B) {
+	l := newBenchmarkLinkedList()
+	for b.Loop() {
+		linkedListRange(l, benchmarkListSize/2, benchmarkListSize/2+99)
+	}
+}
+
+func BenchmarkQuickListQueue(b *testing.B) {
+	q := newBenchmarkQuickList()
+	for b.Loop() {
+		q.PushFront("job")
+		q.PopBack()
+		q.Index(benchmarkListSize / 2)
+	}
+}
+
+func BenchmarkLinkedListQueue(b *testing.B) {
+	l := newBenchmarkLinkedList()
+	for b.Loop() {
+		l.PushFront("job")
+		l.Remove(l.Back())
+		linkedListRange(l, benchmarkListSize/2, benchmarkListSize/2)
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// Both encodings are sampled, since an intset picks members by position in
+// its sorted slice and a hash table by position in its dense member slice.
+func newSamplingSets() map[string]*InMemoryStore {
+	intset := NewInMemoryStore()
+	hashtable := NewInMemoryStore()
+	for i := range 10 {
+		intset.SAdd("set", strconv.Itoa(i))
+		hashtable.SAdd("set", "member"+strconv.Itoa(i))
+	}
+	return map[string]*InMemoryStore{"intset": intset, "hashtable": hashtable}
+}
+
+// checkUniform fails when a member was picked more than 15% away from the
+// expected frequency, which for these sample sizes is well past five standard
+// deviations.
+func checkUniform(t *testing.T, name string, counts map[string]int, members int, samples int) {
+	t.Helper()
+	if len(counts) != members {
+		t.Errorf("%s: %d distinct members picked, want %d", name, len(counts), members)
+	}
+	expected := float64(samples) / float64(members)
+	for member, count := range counts {
+		if float64(count) < expected*0.85 || float64(count) > expected*1.15 {
+			t.Errorf("%s: %s picked %d times, expected about %.0f", name, member, count, expected)
+		}
+	}
+}
+
+func TestSRandMemberCounts(t *testing.T) {
+	for name, s := range newSamplingSets() {
+		for _, count := range []int{1, 3, 7, 10, 25} {
+			members, err := s.SRandMember("set", count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(members) != min(count, 10) {
+				t.Errorf("%s: SRandMember(%d) returned %d members", name, count, len(members))
+			}
+			if distinct := slices.Compact(sorted(members)); len(distinct) != len(members) {
+				t.Errorf("%s: SRandMember(%d) repeated members: %v", name, count, members)
+			}
+		}
+
+		members, _ := s.SRandMember("set", -25)
+		if len(members) != 25 {
+			t.Errorf("%s: SRandMember(-25) returned %d members", name, len(members))
+		}
+		for _, member := range members {
+			if n, _ := s.SIsMember("set", member); n != 1 {
+				t.Errorf("%s: SRandMember returned %q which is not a member", name, member)
+			}
+		}
+
+		if members, _ := s.SRandMember("set", 0); members != nil {
+			t.Errorf("%s: SRandMember(0) = %v", name, members)
+		}
+		if card, _ := s.SCard("set"); card != 10 {
+			t.Errorf("%s: SRandMember changed the set to %d members", name, card)
+		}
+	}
+}
+
+func TestSRandMemberIsUniform(t *testing.T) {
+	const samples = 20000
+	for name, s := range newSamplingSets() {
+		single := make(map[string]int)
+		for range samples {
+			members, _ := s.SRandMember("set", 1)
+			single[members[0]]++
+		}
+		checkUniform(t, name+" count 1", single, 10, samples)
+
+		// Positive counts take the sparse path below a third of the set and
+		// the shuffle path above it.
+		for _, count := range []int{3, 7} {
+			picked := make(map[string]int)
+			for range samples / count {
+				members, _ := s.SRandMember("set", count)
+				for _, member := range members {
+					picked[member]++
+				}
+			}
+			checkUniform(t, name+" count "+strconv.Itoa(count), picked, 10, samples/count*count)
+		}
+
+		repeated := make(map[string]int)
+		members, _ := s.SRandMember("set", -samples)
+		for _, member := range members {
+			repeated[member]++
+		}
+		checkUniform(t, name+" negative count", repeated, 10, samples)
+	}
+}
+
+func TestSPopIsUniform(t *testing.T) {
+	const samples = 20000
+	for name, s := range newSamplingSets() {
+		popped := make(map[string]int)
+		for range samples {
+			members, _ := s.SPop("set", 1)
+			popped[members[0]]++
+			if n, _ := s.SIsMember("set", members[0]); n != 0 {
+				t.Fatalf("%s: SPop left %q in the set", name, members[0])
+			}
+			s.SAdd("set", members[0])
+		}
+		checkUniform(t, name, popped, 10, samples)
+	}
+}
+
+func TestSPopDeletesEmptySet(t *testing.T) {
+	s := NewInMemoryStore()
+	addMembers(s, "set", "a", "b", "c")
+
+	members, err := s.SPop("set", 5)
+	if err != nil || !slices.Equal(sorted(members), []string{"a", "b", "c"}) {
+		t.Errorf("SPop(5) = %v, %v", members, err)
+	}
+	if _, ok := s.KeyType["set"]; ok {
+		t.Error("SPop left an empty set behind")
+	}
+	if members, _ := s.SPop("set", 1); members != nil {
+		t.Errorf("SPop on a missing key = %v", members)
+	}
+}
+
+func TestSMIsMember(t *testing.T) {
+	s := NewInMemoryStore()
+	addMembers(s, "set", "a", "1")
+
+	got, err := s.SMIsMember("set", []string{"a", "b", "1", "01"})
+	if err != nil || !slices.Equal(got, []int{1, 0, 1, 0}) {
+		t.Errorf("SMIsMember = %v, %v", got, err)
+	}
+	if got, _ := s.SMIsMember("missing", []string{"a", "b"}); !slices.Equal(got, []int{0, 0}) {
+		t.Errorf("SMIsMember on a missing key = %v", got)
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.SMIsMember("list", []string{"a"}); err == nil {
+		t.Error("SMIsMember on a list did not fail")
+	}
+}
@@ -0,0 +1,605 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A search index covers the hashes whose keys start with one of its
+// prefixes, as RediSearch indexes do. The index is updated whenever such a
+// hash changes, so a query never scans the keyspace. TEXT fields are split
+// into lowercase terms and TAG fields into tags, each with a posting list of
+// the keys holding it, and NUMERIC fields are kept in a sorted set of keys
+// scored by their value. A hash whose numeric field isn't a number is left
+// out of the index.
+const (
+	SearchText    = "TEXT"
+	SearchTag     = "TAG"
+	SearchNumeric = "NUMERIC"
+
+	SearchDefaultSeparator = ","
+)
+
+var errSearchNoIndex = errors.New("-ERR Unknown index name")
+
+// SearchField is a hash field in a schema. Queries refer to it by its Alias,
+// which is its Name unless one is given.
+type SearchField struct {
+	Name          string
+	Alias         string
+	Type          string
+	Separator     string
+	CaseSensitive bool
+	Sortable      bool
+}
+
+// SearchSchema is the definition of an index. An index without prefixes
+// covers every hash.
+type SearchSchema struct {
+	Prefixes []string
+	Fields   []SearchField
+}
+
+// searchDocument records what a hash added to the index, so that it can be
+// taken out again, along with the values of its indexed fields by alias.
+type searchDocument struct {
+	terms   map[string][]string
+	numbers map[string]float64
+	values  map[string]string
+}
+
+type SearchIndex struct {
+	schema   SearchSchema
+	docs     map[string]*searchDocument
+	postings map[string]map[string]map[string]struct{}
+	numbers  map[string]*SortedSet
+}
+
+func NewSearchIndex(schema SearchSchema) *SearchIndex {
+	index := &SearchIndex{
+		schema:   schema,
+		docs:     make(map[string]*searchDocument),
+		postings: make(map[string]map[string]map[string]struct{}),
+		numbers:  make(map[string]*SortedSet),
+	}
+	for _, field := range schema.Fields {
+		if field.Type == SearchNumeric {
+			index.numbers[field.Alias] = NewSortedSet()
+		} else {
+			index.postings[field.Alias] = make(map[string]map[string]struct{})
+		}
+	}
+	return index
+}
+
+func (index *SearchIndex) field(alias string) (SearchField, bool) {
+	i := slices.IndexFunc(index.schema.Fields, func(field SearchField) bool { return field.Alias == alias })
+	if i < 0 {
+		return SearchField{}, false
+	}
+	return index.schema.Fields[i], true
+}
+
+// fieldName returns the hash field a name refers to, which is either the
+// alias of an indexed field or a hash field.
+func (index *SearchIndex) fieldName(name string) string {
+	if field, ok := index.field(name); ok {
+		return field.Name
+	}
+	return name
+}
+
+func (index *SearchIndex) covers(key string) bool {
+	if len(index.schema.Prefixes) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(index.schema.Prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+func isSearchSeparator(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune(",.<>{}[]\"':;!@#$%^&*()-+=~|/\\", r)
+}
+
+// searchTerms splits text into its lowercase terms.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), isSearchSeparator)
+}
+
+func searchTags(value string, field SearchField) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, field.Separator) {
+		tag = strings.TrimSpace(tag)
+		if !field.CaseSensitive {
+			tag = strings.ToLower(tag)
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func (index *SearchIndex) add(key string, hash *Hash) {
+	doc := &searchDocument{
+		terms:   make(map[string][]string),
+		numbers: make(map[string]float64),
+		values:  make(map[string]string),
+	}
+	for _, field := range index.schema.Fields {
+		value, ok := hash.Get(field.Name)
+		if !ok {
+			continue
+		}
+		switch field.Type {
+		case SearchText:
+			doc.terms[field.Alias] = searchTerms(value)
+		case SearchTag:
+			doc.terms[field.Alias] = searchTags(value, field)
+		case SearchNumeric:
+			number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return
+			}
+			doc.numbers[field.Alias] = number
+		}
+		doc.values[field.Alias] = value
+	}
+
+	index.docs[key] = doc
+	for alias, terms := range doc.terms {
+		for _, term := range terms {
+			keys, ok := index.postings[alias][term]
+			if !ok {
+				keys = make(map[string]struct{})
+				index.postings[alias][term] = keys
+			}
+			keys[key] = struct{}{}
+		}
+	}
+	for alias, number := range doc.numbers {
+		index.numbers[alias].Set(key, number)
+	}
+}
+
+func (index *SearchIndex) remove(key string) {
+	doc, ok := index.docs[key]
+	if !ok {
+		return
+	}
+	delete(index.docs, key)
+	for alias, terms := range doc.terms {
+		for _, term := range terms {
+			keys := index.postings[alias][term]
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(index.postings[alias], term)
+			}
+		}
+	}
+	for alias := range doc.numbers {
+		index.numbers[alias].Remove(key)
+	}
+}
+
+// GobEncode saves only the schema. The documents are indexed again from the
+// hashes once the store has been loaded.
+func (index *SearchIndex) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(index.schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (index *SearchIndex) GobDecode(data []byte) error {
+	var schema SearchSchema
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&schema); err != nil {
+		return err
+	}
+	*index = *NewSearchIndex(schema)
+	return nil
+}
+
+// indexHashLocked brings every index covering key up to date with the hash
+// stored there, taking the key out of them if there is none.
+func (s *InMemoryStore) indexHashLocked(key string) {
+	if len(s.SearchIndexes) == 0 {
+		return
+	}
+	hash := s.HashSetKV[key]
+	for _, index := range s.SearchIndexes {
+		if !index.covers(key) {
+			continue
+		}
+		index.remove(key)
+		if hash != nil {
+			index.add(key, hash)
+		}
+	}
+}
+
+// rebuildSearchIndexes indexes every hash again, which a freshly loaded
+// store needs as indexes are saved without their documents.
+func (s *InMemoryStore) rebuildSearchIndexes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, index := range s.SearchIndexes {
+		index = NewSearchIndex(index.schema)
+		s.SearchIndexes[name] = index
+		for key, hash := range s.HashSetKV {
+			if index.covers(key) {
+				index.add(key, hash)
+			}
+		}
+	}
+}
+
+func (s *InMemoryStore) FTCreate(name string, schema SearchSchema) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.SearchIndexes[name]; ok {
+		return errors.New("-ERR Index already exists")
+	}
+	index := NewSearchIndex(schema)
+	for key, hash := range s.HashSetKV {
+		if index.covers(key) {
+			index.add(key, hash)
+		}
+	}
+	s.SearchIndexes[name] = index
+	return nil
+}
+
+// FTDropIndex removes an index, and with deleteDocs the hashes it covers.
+func (s *InMemoryStore) FTDropIndex(name string, deleteDocs bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, ok := s.SearchIndexes[name]
+	if !ok {
+		return errSearchNoIndex
+	}
+	delete(s.SearchIndexes, name)
+	if deleteDocs {
+		for key := range index.docs {
+			s.deleteKeyLocked(key)
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryStore) FTList() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.SearchIndexes))
+}
+
+// matchLocked returns the keys matching a query, sorted. Fields past their
+// expiry are only removed when their hash is next touched, so the hashes
+// that matched are checked, and the query evaluated again if any of them
+// lost fields.
+func (s *InMemoryStore) matchLocked(index *SearchIndex, node searchNode) []string {
+	keys := node.eval(index)
+	changed := false
+	for key := range keys {
+		if hash := s.HashSetKV[key]; hash != nil && hash.expire() > 0 {
+			s.hashChangedLocked(key, hash)
+			changed = true
+		}
+	}
+	if changed {
+		keys = node.eval(index)
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
+
+// SearchQuery is a FT.SEARCH request. Results are sorted by key unless
+// SortBy names a field, and Return limits the fields returned, which are
+// all of those of the hash when it is empty.
+type SearchQuery struct {
+	Query      string
+	NoContent  bool
+	Return     []string
+	SortBy     string
+	Descending bool
+	Offset     int
+	Limit      int
+}
+
+// SearchResult is a matching key with the requested fields of its hash.
+type SearchResult struct {
+	Key    string
+	Fields []string
+	Values []string
+}
+
+// compareSearchValues orders values numerically when both are numbers and
+// as strings otherwise. Missing values come last in either direction.
+func compareSearchValues(a string, aok bool, b string, bok bool, descending bool) int {
+	switch {
+	case !aok && !bok:
+		return 0
+	case !aok:
+		return 1
+	case !bok:
+		return -1
+	}
+
+	order := strings.Compare(a, b)
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX == nil && errY == nil {
+		order = compareFloats(x, y)
+	}
+	if descending {
+		return -order
+	}
+	return order
+}
+
+// FTSearch returns the number of keys matching the query and the page of
+// them selected by its offset and limit.
+func (s *InMemoryStore) FTSearch(name string, query SearchQuery) (int, []SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, ok := s.SearchIndexes[name]
+	if !ok {
+		return 0, nil, errSearchNoIndex
+	}
+	node, err := parseSearchQuery(query.Query, index)
+	if err != nil {
+		return 0, nil, err
+	}
+	if query.SortBy != "" {
+		if _, ok := index.field(query.SortBy); !ok {
+			return 0, nil, fmt.Errorf("-ERR Property `%s` not loaded nor in schema", query.SortBy)
+		}
+	}
+
+	keys := s.matchLocked(index, node)
+	if query.SortBy != "" {
+		slices.SortStableFunc(keys, func(a string, b string) int {
+			x, xok := index.docs[a].values[query.SortBy]
+			y, yok := index.docs[b].values[query.SortBy]
+			return compareSearchValues(x, xok, y, yok, query.Descending)
+		})
+	}
+
+	total := len(keys)
+	keys = keys[min(query.Offset, len(keys)):]
+	keys = keys[:min(query.Limit, len(keys))]
+	results := make([]SearchResult, len(keys))
+	for i, key := range keys {
+		results[i].Key = key
+		if query.NoContent {
+			continue
+		}
+		hash := s.HashSetKV[key]
+		if len(query.Return) == 0 {
+			hash.Each(func(field string, value string) bool {
+				results[i].Fields = append(results[i].Fields, field)
+				results[i].Values = append(results[i].Values, value)
+				return true
+			})
+			continue
+		}
+		for _, field := range query.Return {
+			if value, ok := hash.Get(index.fieldName(field)); ok {
+				results[i].Fields = append(results[i].Fields, field)
+				results[i].Values = append(results[i].Values, value)
+			}
+		}
+	}
+	return total, results, nil
+}
+
+// AggregateReducer reduces the rows of a group to the value of Alias. COUNT
+// takes no property; COUNT_DISTINCT, SUM, AVG, MIN and MAX take one.
+type AggregateReducer struct {
+	Function string
+	Property string
+	Alias    string
+}
+
+// AggregateStep is a step of a FT.AGGREGATE pipeline. LOAD adds hash fields
+// to the rows, with * for all of them, GROUPBY groups the rows by their
+// properties and reduces each group to a row, SORTBY orders the rows and
+// LIMIT pages them.
+type AggregateStep struct {
+	Kind       string
+	Properties []string
+	Descending []bool
+	Reducers   []AggregateReducer
+	Offset     int
+	Limit      int
+}
+
+// searchRow is a row of an aggregation. Rows start out as the matching
+// documents, whose indexed fields can be read without being loaded.
+type searchRow struct {
+	key    string
+	fields []string
+	values []string
+}
+
+func (row *searchRow) get(index *SearchIndex, name string) (string, bool) {
+	if i := slices.Index(row.fields, name); i >= 0 {
+		return row.values[i], true
+	}
+	if row.key == "" {
+		return "", false
+	}
+	value, ok := index.docs[row.key].values[name]
+	return value, ok
+}
+
+func (row *searchRow) set(name string, value string) {
+	if i := slices.Index(row.fields, name); i >= 0 {
+		row.values[i] = value
+		return
+	}
+	row.fields = append(row.fields, name)
+	row.values = append(row.values, value)
+}
+
+func formatSearchNumber(number float64) string {
+	switch {
+	case math.IsInf(number, 1):
+		return "inf"
+	case math.IsInf(number, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
+
+func (reducer AggregateReducer) reduce(index *SearchIndex, rows []*searchRow) string {
+	switch reducer.Function {
+	case "COUNT":
+		return strconv.Itoa(len(rows))
+	case "COUNT_DISTINCT":
+		distinct := make(map[string]struct{})
+		for _, row := range rows {
+			if value, ok := row.get(index, reducer.Property); ok {
+				distinct[value] = struct{}{}
+			}
+		}
+		return strconv.Itoa(len(distinct))
+	}
+
+	var numbers []float64
+	for _, row := range rows {
+		value, ok := row.get(index, reducer.Property)
+		if !ok {
+			continue
+		}
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	result := 0.0
+	switch reducer.Function {
+	case "SUM", "AVG":
+		for _, number := range numbers {
+			result += number
+		}
+		if reducer.Function == "AVG" && len(numbers) > 0 {
+			result /= float64(len(numbers))
+		}
+	case "MIN":
+		result = math.Inf(1)
+		for _, number := range numbers {
+			result = min(result, number)
+		}
+	case "MAX":
+		result = math.Inf(-1)
+		for _, number := range numbers {
+			result = max(result, number)
+		}
+	}
+	return formatSearchNumber(result)
+}
+
+func groupRows(index *SearchIndex, rows []*searchRow, step AggregateStep) []*searchRow {
+	var groups []*searchRow
+	var ids []string
+	members := make(map[string][]*searchRow)
+	for _, row := range rows {
+		group := &searchRow{}
+		for _, property := range step.Properties {
+			if value, ok := row.get(index, property); ok {
+				group.set(property, value)
+			}
+		}
+		id := strings.Join(group.fields, "\x00") + "\x01" + strings.Join(group.values, "\x00")
+		if _, ok := members[id]; !ok {
+			groups = append(groups, group)
+			ids = append(ids, id)
+		}
+		members[id] = append(members[id], row)
+	}
+
+	for i, group := range groups {
+		for _, reducer := range step.Reducers {
+			group.set(reducer.Alias, reducer.reduce(index, members[ids[i]]))
+		}
+	}
+	return groups
+}
+
+// FTAggregate runs the pipeline over the documents matching the query,
+// which start out sorted by key.
+func (s *InMemoryStore) FTAggregate(name string, query string, steps []AggregateStep) ([]SearchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, ok := s.SearchIndexes[name]
+	if !ok {
+		return nil, errSearchNoIndex
+	}
+	node, err := parseSearchQuery(query, index)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*searchRow
+	for _, key := range s.matchLocked(index, node) {
+		rows = append(rows, &searchRow{key: key})
+	}
+	for _, step := range steps {
+		switch step.Kind {
+		case "LOAD":
+			for _, row := range rows {
+				if row.key == "" {
+					continue
+				}
+				hash := s.HashSetKV[row.key]
+				if slices.Contains(step.Properties, "*") {
+					hash.Each(func(field string, value string) bool {
+						row.set(field, value)
+						return true
+					})
+					continue
+				}
+				for _, property := range step.Properties {
+					if value, ok := hash.Get(index.fieldName(property)); ok {
+						row.set(property, value)
+					}
+				}
+			}
+		case "GROUPBY":
+			rows = groupRows(index, rows, step)
+		case "SORTBY":
+			slices.SortStableFunc(rows, func(a *searchRow, b *searchRow) int {
+				for i, property := range step.Properties {
+					x, xok := a.get(index, property)
+					y, yok := b.get(index, property)
+					if order := compareSearchValues(x, xok, y, yok, step.Descending[i]); order != 0 {
+						return order
+					}
+				}
+				return 0
+			})
+		case "LIMIT":
+			rows = rows[min(step.Offset, len(rows)):]
+			rows = rows[:min(step.Limit, len(rows))]
+		}
+	}
+
+	results := make([]SearchResult, len(rows))
+	for i, row := range rows {
+		results[i] = SearchResult{Fields: row.fields, Values: row.values}
+	}
+	return results, nil
+}
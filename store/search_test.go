@@ -0,0 +1,367 @@
+package store
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var productSchema = SearchSchema{
+	Prefixes: []string{"product:"},
+	Fields: []SearchField{
+		{Name: "title", Alias: "title", Type: SearchText},
+		{Name: "tags", Alias: "tags", Type: SearchTag, Separator: SearchDefaultSeparator},
+		{Name: "price", Alias: "price", Type: SearchNumeric, Sortable: true},
+		{Name: "brand", Alias: "maker", Type: SearchTag, Separator: SearchDefaultSeparator},
+	},
+}
+
+// newProductStore indexes four products. A fifth has a price that isn't a
+// number and another hash lies outside the index's prefix, so neither is
+// indexed.
+func newProductStore(t *testing.T) *InMemoryStore {
+	t.Helper()
+	s := NewInMemoryStore()
+	for key, fields := range map[string][]string{
+		"product:1": {"title", "Red Running Shoes", "tags", "sport,Shoes", "price", "80", "brand", "Acme"},
+		"product:2": {"title", "Blue running shorts", "tags", "sport,clothing", "price", "35", "brand", "Acme"},
+		"product:3": {"title", "Leather shoes", "tags", "formal, shoes", "price", "120", "brand", "Bolt"},
+		"product:4": {"title", "Wool socks", "tags", "clothing", "price", "8", "brand", "Bolt"},
+		"product:5": {"title", "Red hiking boots", "tags", "outdoor", "price", "n/a", "brand", "Acme"},
+		"other:1":   {"title", "Red shoes", "tags", "sport", "price", "10"},
+	} {
+		for i := 0; i < len(fields); i += 2 {
+			s.HSet(key, fields[i], fields[i+1])
+		}
+	}
+	if err := s.FTCreate("products", productSchema); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func searchKeys(t *testing.T, s *InMemoryStore, query string) []string {
+	t.Helper()
+	total, results, err := s.FTSearch("products", SearchQuery{Query: query, NoContent: true, Limit: 100})
+	if err != nil {
+		t.Fatalf("FTSearch(%s) = %v", query, err)
+	}
+	var keys []string
+	for _, result := range results {
+		keys = append(keys, result.Key)
+	}
+	if total != len(keys) {
+		t.Errorf("FTSearch(%s) counted %d keys but returned %d", query, total, len(keys))
+	}
+	return keys
+}
+
+func TestFTSearchQueries(t *testing.T) {
+	s := newProductStore(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"*", []string{"product:1", "product:2", "product:3", "product:4"}},
+		{"running", []string{"product:1", "product:2"}},
+		{"RUNNING shoes", []string{"product:1"}},
+		{"run*", []string{"product:1", "product:2"}},
+		{"*oes", []string{"product:1", "product:3"}},
+		{"*ort*", []string{"product:2"}},
+		{"red", []string{"product:1"}},
+		{"missing", nil},
+		{"@title:shoes", []string{"product:1", "product:3"}},
+		{"@title:(running | socks)", []string{"product:1", "product:2", "product:4"}},
+		{"@tags:{shoes}", []string{"product:1", "product:3"}},
+		{"@tags:{formal | clothing}", []string{"product:2", "product:3", "product:4"}},
+		{"@tags:{sho*}", []string{"product:1", "product:3"}},
+		{"@maker:{bolt}", []string{"product:3", "product:4"}},
+		{"@price:[35 80]", []string{"product:1", "product:2"}},
+		{"@price:[(35 +inf]", []string{"product:1", "product:3"}},
+		{"@price:[-inf (35]", []string{"product:4"}},
+		{"@price:[200 300]", nil},
+		{"shoes -@maker:{acme}", []string{"product:3"}},
+		{"-running", []string{"product:3", "product:4"}},
+		{"(red | wool) @price:[0 100]", []string{"product:1", "product:4"}},
+		{"@tags:{clothing} | @price:[100 inf]", []string{"product:2", "product:3", "product:4"}},
+	}
+	for _, test := range tests {
+		if got := searchKeys(t, s, test.query); !slices.Equal(got, test.want) {
+			t.Errorf("FTSearch(%s) = %v, want %v", test.query, got, test.want)
+		}
+	}
+}
+
+func TestFTSearchSyntax(t *testing.T) {
+	s := newProductStore(t)
+	for _, query := range []string{"", "(red", "red)", "@nofield:red", "@title red", "@price:{red}", "@tags:[1 2]", "@tags:{red", "@tags:{}", "@price:[a 1]", "@price:[1 2", "@title:(@tags:{red})"} {
+		if _, _, err := s.FTSearch("products", SearchQuery{Query: query, Limit: 10}); err == nil {
+			t.Errorf("FTSearch(%q) did not fail", query)
+		}
+	}
+	if _, _, err := s.FTSearch("missing", SearchQuery{Query: "*", Limit: 10}); err == nil {
+		t.Error("FTSearch of a missing index did not fail")
+	}
+	if _, _, err := s.FTSearch("products", SearchQuery{Query: "*", SortBy: "color", Limit: 10}); err == nil {
+		t.Error("FTSearch sorted by a field outside the schema did not fail")
+	}
+}
+
+func TestFTSearchResults(t *testing.T) {
+	s := newProductStore(t)
+
+	total, results, err := s.FTSearch("products", SearchQuery{Query: "*", SortBy: "price", Descending: true, Offset: 1, Limit: 2, Return: []string{"maker", "title", "color"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || len(results) != 2 {
+		t.Fatalf("FTSearch counted %d and returned %d results", total, len(results))
+	}
+	want := []SearchResult{
+		{Key: "product:1", Fields: []string{"maker", "title"}, Values: []string{"Acme", "Red Running Shoes"}},
+		{Key: "product:2", Fields: []string{"maker", "title"}, Values: []string{"Acme", "Blue running shorts"}},
+	}
+	for i, result := range results {
+		if result.Key != want[i].Key || !slices.Equal(result.Fields, want[i].Fields) || !slices.Equal(result.Values, want[i].Values) {
+			t.Errorf("result %d = %+v, want %+v", i, result, want[i])
+		}
+	}
+
+	// Prices sort as numbers, so 8 comes before 35.
+	_, results, _ = s.FTSearch("products", SearchQuery{Query: "*", SortBy: "price", NoContent: true, Limit: 10})
+	var keys []string
+	for _, result := range results {
+		keys = append(keys, result.Key)
+		if result.Fields != nil {
+			t.Errorf("NOCONTENT result %s has fields %v", result.Key, result.Fields)
+		}
+	}
+	if !slices.Equal(keys, []string{"product:4", "product:2", "product:1", "product:3"}) {
+		t.Errorf("sorted by price = %v", keys)
+	}
+
+	_, results, _ = s.FTSearch("products", SearchQuery{Query: "socks", Limit: 10})
+	contents := make(map[string]string)
+	for i, field := range results[0].Fields {
+		contents[field] = results[0].Values[i]
+	}
+	if len(contents) != 4 || contents["brand"] != "Bolt" || contents["price"] != "8" {
+		t.Errorf("full result = %v", contents)
+	}
+
+	if total, results, _ := s.FTSearch("products", SearchQuery{Query: "*", Offset: 10, Limit: 10}); total != 4 || len(results) != 0 {
+		t.Errorf("offset past the end counted %d and returned %d results", total, len(results))
+	}
+}
+
+// TestFTSearchMaintenance checks that the index follows the hashes it covers
+// through writes, deletions and field expiry.
+func TestFTSearchMaintenance(t *testing.T) {
+	s := newProductStore(t)
+
+	s.HSet("product:4", "price", "200")
+	if got := searchKeys(t, s, "@price:[100 +inf]"); !slices.Equal(got, []string{"product:3", "product:4"}) {
+		t.Errorf("after HSET of a price = %v", got)
+	}
+	if got := searchKeys(t, s, "@price:[0 10]"); got != nil {
+		t.Errorf("old price still matches %v", got)
+	}
+
+	s.HSet("product:5", "price", "50")
+	if got := searchKeys(t, s, "boots"); !slices.Equal(got, []string{"product:5"}) {
+		t.Errorf("after fixing a price = %v", got)
+	}
+	s.HSet("product:5", "price", "free")
+	if got := searchKeys(t, s, "boots"); got != nil {
+		t.Errorf("after breaking a price = %v", got)
+	}
+
+	s.HDel("product:1", []string{"title"})
+	if got := searchKeys(t, s, "red"); got != nil {
+		t.Errorf("after HDEL of a title = %v", got)
+	}
+	if got := searchKeys(t, s, "@tags:{sport}"); !slices.Equal(got, []string{"product:1", "product:2"}) {
+		t.Errorf("other fields of the hash = %v", got)
+	}
+
+	s.HSet("product:6", "title", "Red scarf")
+	s.HSet("other:2", "title", "Red scarf")
+	if got := searchKeys(t, s, "scarf"); !slices.Equal(got, []string{"product:6"}) {
+		t.Errorf("after HSET of a new hash = %v", got)
+	}
+
+	if n := s.NumKeyExists([]string{"product:3", "product:6"}, true); n != 2 {
+		t.Fatalf("DEL removed %d keys", n)
+	}
+	if got := searchKeys(t, s, "*"); !slices.Equal(got, []string{"product:1", "product:2", "product:4"}) {
+		t.Errorf("after DEL = %v", got)
+	}
+
+	// Expired fields are only removed when their hash is next touched, which
+	// the search has to do itself.
+	expireFields(s, "product:2", "tags")
+	if got := searchKeys(t, s, "@tags:{sport}"); !slices.Equal(got, []string{"product:1"}) {
+		t.Errorf("after a tag expired = %v", got)
+	}
+	expireFields(s, "product:4", "title", "tags", "price", "brand")
+	if got := searchKeys(t, s, "*"); !slices.Equal(got, []string{"product:1", "product:2"}) {
+		t.Errorf("after a hash expired = %v", got)
+	}
+	if n := s.NumKeyExists([]string{"product:4"}, false); n != 0 {
+		t.Error("fully expired hash still exists")
+	}
+
+	s.NumKeyExists([]string{"product:1", "product:2", "product:5"}, true)
+	if got := searchKeys(t, s, "*"); got != nil {
+		t.Errorf("after deleting every hash = %v", got)
+	}
+	index := s.SearchIndexes["products"]
+	if len(index.docs) != 0 || len(index.postings["title"]) != 0 || len(index.postings["tags"]) != 0 || index.numbers["price"].Len() != 0 {
+		t.Errorf("empty index still holds %d documents", len(index.docs))
+	}
+}
+
+func TestFTCreateAndDrop(t *testing.T) {
+	s := newProductStore(t)
+	if err := s.FTCreate("products", productSchema); err == nil {
+		t.Error("FTCreate of an existing index did not fail")
+	}
+	if err := s.FTCreate("everything", SearchSchema{Fields: []SearchField{{Name: "title", Alias: "title", Type: SearchText}}}); err != nil {
+		t.Fatal(err)
+	}
+	if names := s.FTList(); !slices.Equal(names, []string{"everything", "products"}) {
+		t.Errorf("FTList = %v", names)
+	}
+	if _, results, _ := s.FTSearch("everything", SearchQuery{Query: "red", NoContent: true, Limit: 10}); len(results) != 3 {
+		t.Errorf("an index without prefixes found %d red hashes, want 3", len(results))
+	}
+
+	if err := s.FTDropIndex("everything", false); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.NumKeyExists([]string{"other:1", "product:1"}, false); n != 2 {
+		t.Errorf("FTDropIndex without deleting documents removed hashes")
+	}
+	if err := s.FTDropIndex("products", true); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.NumKeyExists([]string{"product:1", "product:2", "product:3", "product:4"}, false); n != 0 {
+		t.Errorf("FTDropIndex left %d indexed hashes", n)
+	}
+	// The hash that wasn't indexed is kept, as RediSearch only deletes the
+	// documents of the index.
+	if n := s.NumKeyExists([]string{"product:5", "other:1"}, false); n != 2 {
+		t.Errorf("FTDropIndex removed hashes it didn't index")
+	}
+	if names := s.FTList(); len(names) != 0 {
+		t.Errorf("FTList after dropping = %v", names)
+	}
+	if err := s.FTDropIndex("products", false); err == nil {
+		t.Error("FTDropIndex of a missing index did not fail")
+	}
+}
+
+func TestFTAggregate(t *testing.T) {
+	s := newProductStore(t)
+
+	results, err := s.FTAggregate("products", "*", []AggregateStep{
+		{Kind: "GROUPBY", Properties: []string{"maker"}, Reducers: []AggregateReducer{
+			{Function: "COUNT", Alias: "count"},
+			{Function: "SUM", Property: "price", Alias: "total"},
+			{Function: "AVG", Property: "price", Alias: "average"},
+			{Function: "MIN", Property: "price", Alias: "cheapest"},
+			{Function: "MAX", Property: "price", Alias: "dearest"},
+			{Function: "COUNT_DISTINCT", Property: "tags", Alias: "tagsets"},
+		}},
+		{Kind: "SORTBY", Properties: []string{"total"}, Descending: []bool{true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := []string{"maker", "count", "total", "average", "cheapest", "dearest", "tagsets"}
+	want := [][]string{
+		{"Bolt", "2", "128", "64", "8", "120", "2"},
+		{"Acme", "2", "115", "57.5", "35", "80", "2"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("FTAggregate returned %d rows", len(results))
+	}
+	for i, result := range results {
+		if result.Key != "" || !slices.Equal(result.Fields, fields) || !slices.Equal(result.Values, want[i]) {
+			t.Errorf("row %d = %v %v, want %v", i, result.Fields, result.Values, want[i])
+		}
+	}
+
+	results, _ = s.FTAggregate("products", "@price:[0 100]", []AggregateStep{
+		{Kind: "LOAD", Properties: []string{"title", "maker"}},
+		{Kind: "SORTBY", Properties: []string{"maker", "price"}, Descending: []bool{true, false}},
+		{Kind: "LIMIT", Offset: 1, Limit: 5},
+	})
+	var titles []string
+	for _, result := range results {
+		if !slices.Equal(result.Fields, []string{"title", "maker"}) {
+			t.Errorf("loaded fields = %v", result.Fields)
+		}
+		titles = append(titles, result.Values[0])
+	}
+	if !slices.Equal(titles, []string{"Blue running shorts", "Red Running Shoes"}) {
+		t.Errorf("loaded, sorted and limited titles = %v", titles)
+	}
+
+	results, _ = s.FTAggregate("products", "@tags:{clothing}", []AggregateStep{{Kind: "LOAD", Properties: []string{"*"}}})
+	if len(results) != 2 || len(results[0].Fields) != 4 {
+		t.Errorf("LOAD * = %v", results)
+	}
+
+	// Grouping no rows makes no groups.
+	results, _ = s.FTAggregate("products", "@price:[1000 +inf]", []AggregateStep{
+		{Kind: "GROUPBY", Reducers: []AggregateReducer{{Function: "COUNT", Alias: "count"}}},
+	})
+	if len(results) != 0 {
+		t.Errorf("grouping no rows = %v", results)
+	}
+
+	if _, err := s.FTAggregate("missing", "*", nil); err == nil {
+		t.Error("FTAggregate of a missing index did not fail")
+	}
+	if _, err := s.FTAggregate("products", "@price:[", nil); err == nil {
+		t.Error("FTAggregate of a bad query did not fail")
+	}
+}
+
+// TestFTSearchPersistence saves a store with an index and checks that the
+// loaded index holds the documents again and keeps following the hashes.
+func TestFTSearchPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.godb")
+	s := newProductStore(t)
+	p, err := NewPersistence(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewInMemoryStore()
+	if _, err := NewPersistence(loaded, path); err != nil {
+		t.Fatal(err)
+	}
+	if names := loaded.FTList(); !slices.Equal(names, []string{"products"}) {
+		t.Fatalf("loaded indexes = %v", names)
+	}
+	for _, query := range []string{"*", "run*", "@tags:{shoes}", "@price:[(35 +inf]", "@maker:{bolt}"} {
+		if got, want := searchKeys(t, loaded, query), searchKeys(t, s, query); !slices.Equal(got, want) {
+			t.Errorf("loaded FTSearch(%s) = %v, want %v", query, got, want)
+		}
+	}
+	if index := loaded.SearchIndexes["products"]; index.schema.Fields[3].Alias != "maker" || !slices.Equal(index.schema.Prefixes, []string{"product:"}) {
+		t.Errorf("loaded schema = %+v", index.schema)
+	}
+
+	loaded.HSet("product:9", "title", "Running socks")
+	loaded.HDel("product:2", []string{"title"})
+	if got := searchKeys(t, loaded, "running"); !slices.Equal(got, []string{"product:1", "product:9"}) {
+		t.Errorf("loaded index after writes = %v", got)
+	}
+}
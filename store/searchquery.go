@@ -0,0 +1,433 @@
+package store
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Search queries support a subset of the RediSearch query syntax. Terms
+// separated by spaces must all match and alternatives are separated by |,
+// with - negating a term and parentheses grouping them. A term is either
+// * for every document, a word, a prefix like hel*, or a field
+// restriction: @title:word or @title:(word | other) for TEXT fields,
+// @tags:{red | green} for TAG fields and @price:[10 (20] for NUMERIC
+// fields, where ( makes a bound exclusive and -inf and +inf are accepted.
+// Words and tags match as a suffix when they start with * and as a prefix
+// when they end with it. Special characters in them are escaped with a
+// backslash.
+
+type searchNode interface {
+	eval(index *SearchIndex) map[string]struct{}
+}
+
+type searchAll struct{}
+
+// searchTerm matches a term, or the terms starting or ending with it if
+// prefix or suffix are set, in the given field, or in every TEXT field if
+// there is none.
+type searchTerm struct {
+	field  string
+	term   string
+	prefix bool
+	suffix bool
+}
+
+type searchTagMatch struct {
+	field string
+	tags  []searchTerm
+}
+
+type searchRange struct {
+	field string
+	r     ScoreRange
+}
+
+type searchNot struct {
+	node searchNode
+}
+
+type searchAnd struct {
+	nodes []searchNode
+}
+
+type searchOr struct {
+	nodes []searchNode
+}
+
+func (node searchAll) eval(index *SearchIndex) map[string]struct{} {
+	keys := make(map[string]struct{}, len(index.docs))
+	for key := range index.docs {
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+func (node searchTerm) matches(term string) bool {
+	switch {
+	case node.prefix && node.suffix:
+		return strings.Contains(term, node.term)
+	case node.prefix:
+		return strings.HasPrefix(term, node.term)
+	}
+	return strings.HasSuffix(term, node.term)
+}
+
+// lookup adds the keys of the postings of field matching a term.
+func (node searchTerm) lookup(index *SearchIndex, field string, keys map[string]struct{}) {
+	if !node.prefix && !node.suffix {
+		maps.Copy(keys, index.postings[field][node.term])
+		return
+	}
+	for term, postings := range index.postings[field] {
+		if node.matches(term) {
+			maps.Copy(keys, postings)
+		}
+	}
+}
+
+func (node searchTerm) eval(index *SearchIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	if node.field != "" {
+		node.lookup(index, node.field, keys)
+		return keys
+	}
+	for _, field := range index.schema.Fields {
+		if field.Type == SearchText {
+			node.lookup(index, field.Alias, keys)
+		}
+	}
+	return keys
+}
+
+func (node searchTagMatch) eval(index *SearchIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, tag := range node.tags {
+		tag.lookup(index, node.field, keys)
+	}
+	return keys
+}
+
+func (node searchRange) eval(index *SearchIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, member := range index.numbers[node.field].RangeByScore(node.r, false, 0, -1) {
+		keys[member.Member] = struct{}{}
+	}
+	return keys
+}
+
+func (node searchNot) eval(index *SearchIndex) map[string]struct{} {
+	keys := searchAll{}.eval(index)
+	for key := range node.node.eval(index) {
+		delete(keys, key)
+	}
+	return keys
+}
+
+func (node searchAnd) eval(index *SearchIndex) map[string]struct{} {
+	keys := node.nodes[0].eval(index)
+	for _, other := range node.nodes[1:] {
+		matched := other.eval(index)
+		for key := range keys {
+			if _, ok := matched[key]; !ok {
+				delete(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func (node searchOr) eval(index *SearchIndex) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, other := range node.nodes {
+		maps.Copy(keys, other.eval(index))
+	}
+	return keys
+}
+
+type searchParser struct {
+	query string
+	pos   int
+	index *SearchIndex
+}
+
+func (p *searchParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("-ERR Syntax error at offset %d near %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *searchParser) skipSpaces() {
+	for p.pos < len(p.query) && unicode.IsSpace(rune(p.query[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *searchParser) peek() byte {
+	p.skipSpaces()
+	if p.pos >= len(p.query) {
+		return 0
+	}
+	return p.query[p.pos]
+}
+
+// parseUnion parses alternatives separated by |. Words in it match the
+// TEXT field named by field, or all of them if field is empty.
+func (p *searchParser) parseUnion(field string) (searchNode, error) {
+	var nodes []searchNode
+	for {
+		node, err := p.parseIntersection(field)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return searchOr{nodes: nodes}, nil
+}
+
+func (p *searchParser) parseIntersection(field string) (searchNode, error) {
+	var nodes []searchNode
+	for {
+		if c := p.peek(); c == 0 || c == '|' || c == ')' {
+			break
+		}
+		node, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	switch len(nodes) {
+	case 0:
+		return nil, p.errorf("end of expression")
+	case 1:
+		return nodes[0], nil
+	}
+	return searchAnd{nodes: nodes}, nil
+}
+
+func (p *searchParser) parseUnary(field string) (searchNode, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		node, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		return searchNot{node: node}, nil
+	case '(':
+		p.pos++
+		node, err := p.parseUnion(field)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case '*':
+		if p.pos+1 == len(p.query) || isSearchSeparator(rune(p.query[p.pos+1])) {
+			p.pos++
+			return searchAll{}, nil
+		}
+	case '@':
+		if field != "" {
+			return nil, p.errorf("@ inside a field restriction")
+		}
+		return p.parseField()
+	}
+
+	return p.parseTerm(field)
+}
+
+func (p *searchParser) parseTerm(field string) (searchNode, error) {
+	term := searchTerm{field: field}
+	if p.peek() == '*' {
+		term.suffix = true
+		p.pos++
+	}
+	word, prefix := p.parseWord()
+	if word == "" {
+		return nil, p.errorf("`%s`", p.query[p.pos:min(p.pos+1, len(p.query))])
+	}
+	term.term, term.prefix = strings.ToLower(word), prefix
+	return term, nil
+}
+
+// parseWord reads a word up to the next separator and reports whether it
+// ends with the * of a prefix.
+func (p *searchParser) parseWord() (string, bool) {
+	var word strings.Builder
+	for p.pos < len(p.query) {
+		c := p.query[p.pos]
+		if c == '\\' && p.pos+1 < len(p.query) {
+			word.WriteByte(p.query[p.pos+1])
+			p.pos += 2
+			continue
+		}
+		if c == '*' && word.Len() > 0 {
+			p.pos++
+			return word.String(), true
+		}
+		if isSearchSeparator(rune(c)) {
+			break
+		}
+		word.WriteByte(c)
+		p.pos++
+	}
+	return word.String(), false
+}
+
+func (p *searchParser) parseField() (searchNode, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.query) && (p.query[p.pos] == '_' || unicode.IsLetter(rune(p.query[p.pos])) || unicode.IsDigit(rune(p.query[p.pos]))) {
+		p.pos++
+	}
+	alias := p.query[start:p.pos]
+	if p.pos >= len(p.query) || p.query[p.pos] != ':' {
+		return nil, p.errorf("@%s", alias)
+	}
+	p.pos++
+	field, ok := p.index.field(alias)
+	if !ok {
+		return nil, fmt.Errorf("-ERR Unknown field `%s`", alias)
+	}
+
+	switch field.Type {
+	case SearchTag:
+		if p.peek() != '{' {
+			return nil, fmt.Errorf("-ERR Field `%s` is a TAG field, expected {tags}", alias)
+		}
+		p.pos++
+		return p.parseTags(field)
+	case SearchNumeric:
+		if p.peek() != '[' {
+			return nil, fmt.Errorf("-ERR Field `%s` is a NUMERIC field, expected [min max]", alias)
+		}
+		p.pos++
+		return p.parseRange(field)
+	}
+
+	if p.peek() == '(' {
+		p.pos++
+		node, err := p.parseUnion(field.Alias)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseTerm(field.Alias)
+}
+
+// parseTags parses the tags after the opening brace, which unlike words may
+// contain spaces and punctuation; only |, } and * need escaping.
+func (p *searchParser) parseTags(field SearchField) (searchNode, error) {
+	node := searchTagMatch{field: field.Alias}
+	for {
+		var tag strings.Builder
+		prefix, suffix := false, false
+		for p.pos < len(p.query) && p.query[p.pos] != '|' && p.query[p.pos] != '}' {
+			c := p.query[p.pos]
+			p.pos++
+			switch {
+			case c == '\\' && p.pos < len(p.query):
+				c = p.query[p.pos]
+				p.pos++
+			case c == '*' && strings.TrimSpace(tag.String()) == "" && !suffix:
+				suffix = true
+				continue
+			case c == '*':
+				prefix = true
+				continue
+			case unicode.IsSpace(rune(c)):
+				tag.WriteByte(c)
+				continue
+			}
+			if prefix {
+				return nil, p.errorf("*")
+			}
+			tag.WriteByte(c)
+		}
+		if p.pos >= len(p.query) {
+			return nil, p.errorf("missing }")
+		}
+
+		value := strings.TrimSpace(tag.String())
+		if !field.CaseSensitive {
+			value = strings.ToLower(value)
+		}
+		if value == "" {
+			return nil, p.errorf("empty tag")
+		}
+		node.tags = append(node.tags, searchTerm{term: value, prefix: prefix, suffix: suffix})
+		if p.query[p.pos] == '}' {
+			p.pos++
+			return node, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *searchParser) parseBound(field SearchField) (float64, bool, error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.query) && !unicode.IsSpace(rune(p.query[p.pos])) && p.query[p.pos] != ']' {
+		p.pos++
+	}
+	text := p.query[start:p.pos]
+	exclusive := strings.HasPrefix(text, "(")
+	text = strings.TrimPrefix(text, "(")
+	switch strings.ToLower(text) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "inf", "+inf":
+		return math.Inf(1), exclusive, nil
+	}
+	bound, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(bound) {
+		return 0, false, fmt.Errorf("-ERR Expected a number in numeric range of field `%s`", field.Alias)
+	}
+	return bound, exclusive, nil
+}
+
+func (p *searchParser) parseRange(field SearchField) (searchNode, error) {
+	node := searchRange{field: field.Alias}
+	var err error
+	if node.r.Min, node.r.MinExclusive, err = p.parseBound(field); err != nil {
+		return nil, err
+	}
+	if node.r.Max, node.r.MaxExclusive, err = p.parseBound(field); err != nil {
+		return nil, err
+	}
+	if p.peek() != ']' {
+		return nil, p.errorf("missing ]")
+	}
+	p.pos++
+	return node, nil
+}
+
+func parseSearchQuery(query string, index *SearchIndex) (searchNode, error) {
+	p := &searchParser{query: query, index: index}
+	node, err := p.parseUnion("")
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != 0 {
+		return nil, p.errorf("`%c`", p.query[p.pos])
+	}
+	return node, nil
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+)
+
+// Set starts out as an intset, a sorted slice of integers, for as long as all
+// of its members are canonical integers and there are at most
+// set-max-intset-entries of them. Past that it becomes a hash table, keeping
+// its members in a dense slice alongside an index into it so a uniformly
+// random member can be picked or removed in constant time.
+type Set struct {
+	intset  bool
+	ints    []int64
+	members []string
+	index   map[string]int
+}
+
+func NewSet() *Set {
+	return &Set{intset: true}
+}
+
+func (set *Set) Len() int {
+	if set.intset {
+		return len(set.ints)
+	}
+	return len(set.members)
+}
+
+func (set *Set) Encoding() string {
+	if set.intset {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+func (set *Set) Contains(member string) bool {
+	if set.intset {
+		n, ok := parseCanonicalInt(member)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(set.ints, n)
+		return found
+	}
+	_, ok := set.index[member]
+	return ok
+}
+
+// Add converts an intset to a hash table when member is not an integer or the
+// intset already holds maxIntsetEntries members.
+func (set *Set) Add(member string, maxIntsetEntries int) bool {
+	if set.intset {
+		if n, ok := parseCanonicalInt(member); ok {
+			i, found := slices.BinarySearch(set.ints, n)
+			if found {
+				return false
+			}
+			if len(set.ints) < maxIntsetEntries {
+				set.ints = slices.Insert(set.ints, i, n)
+				return true
+			}
+		}
+		set.convertToHashtable()
+	}
+
+	if _, ok := set.index[member]; ok {
+		return false
+	}
+	set.index[member] = len(set.members)
+	set.members = append(set.members, member)
+	return true
+}
+
+func (set *Set) convertToHashtable() {
+	set.members = make([]string, len(set.ints))
+	set.index = make(map[string]int, len(set.ints))
+	for i, n := range set.ints {
+		set.members[i] = strconv.FormatInt(n, 10)
+		set.index[set.members[i]] = i
+	}
+	set.intset = false
+	set.ints = nil
+}
+
+func (set *Set) Remove(member string) bool {
+	if set.intset {
+		n, ok := parseCanonicalInt(member)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(set.ints, n)
+		if !found {
+			return false
+		}
+		set.removeAt(i)
+		return true
+	}
+
+	i, ok := set.index[member]
+	if !ok {
+		return false
+	}
+	set.removeAt(i)
+	return true
+}
+
+func (set *Set) at(i int) string {
+	if set.intset {
+		return strconv.FormatInt(set.ints[i], 10)
+	}
+	return set.members[i]
+}
+
+// removeAt moves the last member of a hash table into slot i.
+func (set *Set) removeAt(i int) string {
+	member := set.at(i)
+	if set.intset {
+		set.ints = slices.Delete(set.ints, i, i+1)
+		return member
+	}
+
+	last := len(set.members) - 1
+	set.members[i] = set.members[last]
+	set.index[set.members[i]] = i
+	set.members[last] = ""
+	set.members = set.members[:last]
+	delete(set.index, member)
+	return member
+}
+
+// Each calls fn for every member until it returns false.
+func (set *Set) Each(fn func(member string) bool) {
+	for i := range set.Len() {
+		if !fn(set.at(i)) {
+			return
+		}
+	}
+}
+
+// Members returns a copy of the members in no particular order.
+func (set *Set) Members() []string {
+	members := make([]string, set.Len())
+	for i := range members {
+		members[i] = set.at(i)
+	}
+	return members
+}
+
+// Random returns count members picked uniformly at random. A positive count
+// returns distinct members, at most all of them; a negative count returns
+// exactly -count members that may repeat.
+func (set *Set) Random(count int) []string {
+	indices := randomIndices(set.Len(), count)
+	members := make([]string, len(indices))
+	for i, index := range indices {
+		members[i] = set.at(index)
+	}
+	return members
+}
+
+// randomIndices picks count indices below n uniformly at random, following
+// the sign convention of Set.Random.
+func randomIndices(n int, count int) []int {
+	if count < 0 {
+		indices := make([]int, -count)
+		for i := range indices {
+			indices[i] = rand.IntN(n)
+		}
+		return indices
+	}
+
+	count = min(count, n)
+	if count*3 > n {
+		// Partial Fisher-Yates shuffle.
+		indices := make([]int, n)
+		for i := range indices {
+			indices[i] = i
+		}
+		for i := range count {
+			j := i + rand.IntN(n-i)
+			indices[i], indices[j] = indices[j], indices[i]
+		}
+		return indices[:count]
+	}
+
+	picked := make(map[int]struct{}, count)
+	indices := make([]int, 0, count)
+	for len(indices) < count {
+		i := rand.IntN(n)
+		if _, ok := picked[i]; ok {
+			continue
+		}
+		picked[i] = struct{}{}
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// Pop removes and returns up to count members picked uniformly at random.
+func (set *Set) Pop(count int) []string {
+	count = min(count, set.Len())
+	members := make([]string, count)
+	for i := range members {
+		members[i] = set.removeAt(rand.IntN(set.Len()))
+	}
+	return members
+}
+
+type setSnapshot struct {
+	Intset  bool
+	Ints    []int64
+	Members []string
+}
+
+func (set *Set) GobEncode() ([]byte, error) {
+	snapshot := setSnapshot{Intset: set.intset, Ints: set.ints, Members: set.members}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (set *Set) GobDecode(data []byte) error {
+	var snapshot setSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	*set = Set{intset: snapshot.Intset, ints: snapshot.Ints}
+	if !set.intset {
+		set.members = snapshot.Members
+		set.index = make(map[string]int, len(set.members))
+		for i, member := range set.members {
+			set.index[member] = i
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+func addMembers(s *InMemoryStore, key string, members ...string) {
+	for _, member := range members {
+		s.SAdd(key, member)
+	}
+}
+
+func sorted(values []string) []string {
+	values = slices.Clone(values)
+	slices.Sort(values)
+	return values
+}
+
+func newAlgebraStore() *InMemoryStore {
+	s := NewInMemoryStore()
+	addMembers(s, "a", "1", "2", "3", "x")
+	addMembers(s, "b", "2", "3", "4")
+	addMembers(s, "c", "3", "x", "y")
+	return s
+}
+
+func TestSetAlgebra(t *testing.T) {
+	s := newAlgebraStore()
+	tests := []struct {
+		name string
+		op   func([]string) ([]string, error)
+		keys []string
+		want []string
+	}{
+		{"SInter", s.SInter, []string{"a", "b"}, []string{"2", "3"}},
+		{"SInter", s.SInter, []string{"a", "b", "c"}, []string{"3"}},
+		{"SInter", s.SInter, []string{"a", "missing"}, nil},
+		{"SUnion", s.SUnion, []string{"b", "c"}, []string{"2", "3", "4", "x", "y"}},
+		{"SUnion", s.SUnion, []string{"missing", "b"}, []string{"2", "3", "4"}},
+		{"SDiff", s.SDiff, []string{"a", "b"}, []string{"1", "x"}},
+		{"SDiff", s.SDiff, []string{"a", "b", "c"}, []string{"1"}},
+		{"SDiff", s.SDiff, []string{"a", "missing"}, []string{"1", "2", "3", "x"}},
+		{"SDiff", s.SDiff, []string{"missing", "a"}, nil},
+	}
+	for _, test := range tests {
+		got, err := test.op(test.keys)
+		if err != nil || !slices.Equal(sorted(got), test.want) {
+			t.Errorf("%s(%v) = %v, %v, want %v", test.name, test.keys, got, err, test.want)
+		}
+	}
+}
+
+func TestSetAlgebraStore(t *testing.T) {
+	s := newAlgebraStore()
+
+	if n, err := s.SUnionStore("dest", []string{"a", "c"}); err != nil || n != 5 {
+		t.Errorf("SUnionStore = %d, %v, want 5", n, err)
+	}
+	if members, _ := s.SMembers("dest"); !slices.Equal(sorted(members), []string{"1", "2", "3", "x", "y"}) {
+		t.Errorf("SUnionStore stored %v", members)
+	}
+
+	s.StringSet("string", "value", 0, false, false, false, false)
+	if n, err := s.SInterStore("string", []string{"a", "b"}); err != nil || n != 2 {
+		t.Errorf("SInterStore over a string = %d, %v, want 2", n, err)
+	}
+	if s.KeyType["string"] != SetType {
+		t.Error("SInterStore did not replace the string destination")
+	}
+
+	if n, _ := s.SDiffStore("dest", []string{"b", "a", "c"}); n != 1 {
+		t.Errorf("SDiffStore = %d, want 1", n)
+	}
+	if n, _ := s.SInterStore("dest", []string{"a", "missing"}); n != 0 {
+		t.Errorf("SInterStore of an empty intersection = %d", n)
+	}
+	if _, ok := s.KeyType["dest"]; ok {
+		t.Error("empty result left the destination behind")
+	}
+
+	// The destination may also be one of the sources.
+	if n, _ := s.SUnionStore("a", []string{"a", "b"}); n != 5 {
+		t.Errorf("SUnionStore into a source = %d, want 5", n)
+	}
+}
+
+func TestSInterCard(t *testing.T) {
+	s := newAlgebraStore()
+	tests := []struct {
+		keys  []string
+		limit int
+		want  int
+	}{
+		{[]string{"a", "b"}, 0, 2},
+		{[]string{"a", "b"}, 1, 1},
+		{[]string{"a", "b"}, 10, 2},
+		{[]string{"a", "b", "c"}, 0, 1},
+		{[]string{"a", "missing"}, 0, 0},
+	}
+	for _, test := range tests {
+		if n, err := s.SInterCard(test.keys, test.limit); err != nil || n != test.want {
+			t.Errorf("SInterCard(%v, %d) = %d, %v, want %d", test.keys, test.limit, n, err, test.want)
+		}
+	}
+}
+
+func TestSetAlgebraWrongType(t *testing.T) {
+	s := newAlgebraStore()
+	s.RPush("list", []string{"a"})
+
+	if _, err := s.SUnion([]string{"a", "list"}); err == nil {
+		t.Error("SUnion with a list did not fail")
+	}
+	if _, err := s.SDiff([]string{"missing", "list"}); err == nil {
+		t.Error("SDiff with a list did not fail")
+	}
+	if _, err := s.SInterCard([]string{"list"}, 0); err == nil {
+		t.Error("SInterCard with a list did not fail")
+	}
+	if _, err := s.SInterStore("dest", []string{"a", "list"}); err == nil {
+		t.Error("SInterStore with a list did not fail")
+	}
+	if _, ok := s.KeyType["dest"]; ok {
+		t.Error("failed SInterStore created the destination")
+	}
+}
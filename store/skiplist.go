@@ -0,0 +1,327 @@
+package store
+
+import "math/rand/v2"
+
+// The skiplist is a port of the zskiplist used by Redis sorted sets. Nodes are
+// ordered by score and then by member, and every forward link records how
+// many nodes it skips so ranks can be computed while descending.
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+type skipList struct {
+	header *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		header: &skipListNode{level: make([]skipListLevel, skipListMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// before reports whether node sorts before the (score, member) pair.
+func (node *skipListNode) before(score float64, member string) bool {
+	return node.score < score || (node.score == score && node.member < member)
+}
+
+// insert assumes member is not in the skiplist yet.
+func (zsl *skipList) insert(score float64, member string) *skipListNode {
+	var update [skipListMaxLevel]*skipListNode
+	var rank [skipListMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i != zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkipListLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &skipListNode{member: member, score: score, level: make([]skipListLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+// findUpdate returns, for every level, the last node before (score, member).
+func (zsl *skipList) findUpdate(score float64, member string) [skipListMaxLevel]*skipListNode {
+	var update [skipListMaxLevel]*skipListNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	return update
+}
+
+func (zsl *skipList) deleteNode(x *skipListNode, update *[skipListMaxLevel]*skipListNode) {
+	for i := range zsl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+func (zsl *skipList) delete(score float64, member string) bool {
+	update := zsl.findUpdate(score, member)
+	x := update[0].level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+	zsl.deleteNode(x, &update)
+	return true
+}
+
+// updateScore moves member from curScore to newScore, reusing its node when
+// the new score keeps it in place.
+func (zsl *skipList) updateScore(curScore float64, member string, newScore float64) {
+	update := zsl.findUpdate(curScore, member)
+	x := update[0].level[0].forward
+
+	if (x.backward == nil || x.backward.before(newScore, member)) &&
+		(x.level[0].forward == nil || !x.level[0].forward.before(newScore, member)) {
+		x.score = newScore
+		return
+	}
+
+	zsl.deleteNode(x, &update)
+	zsl.insert(newScore, member)
+}
+
+// rank returns the 1-based rank of (score, member), or 0 if it is not there.
+func (zsl *skipList) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.before(score, member) || (x.level[i].forward.score == score && x.level[i].forward.member == member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank
+		}
+	}
+	return 0
+}
+
+// byRank returns the node at the 1-based rank.
+func (zsl *skipList) byRank(rank int) *skipListNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// ScoreRange is a score interval as used by ZRANGE BYSCORE and ZCOUNT.
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r ScoreRange) aboveMin(score float64) bool {
+	if r.MinExclusive {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r ScoreRange) belowMax(score float64) bool {
+	if r.MaxExclusive {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+func (r ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinExclusive || r.MaxExclusive))
+}
+
+// LexBound is one end of a LexRange. Infinite is -1 for "-" and 1 for "+".
+type LexBound struct {
+	Value     string
+	Exclusive bool
+	Infinite  int
+}
+
+// LexRange is a member interval as used by ZRANGE BYLEX and ZLEXCOUNT.
+type LexRange struct {
+	Min LexBound
+	Max LexBound
+}
+
+func (r LexRange) aboveMin(member string) bool {
+	switch {
+	case r.Min.Infinite < 0:
+		return true
+	case r.Min.Infinite > 0:
+		return false
+	case r.Min.Exclusive:
+		return member > r.Min.Value
+	}
+	return member >= r.Min.Value
+}
+
+func (r LexRange) belowMax(member string) bool {
+	switch {
+	case r.Max.Infinite > 0:
+		return true
+	case r.Max.Infinite < 0:
+		return false
+	case r.Max.Exclusive:
+		return member < r.Max.Value
+	}
+	return member <= r.Max.Value
+}
+
+func (r LexRange) empty() bool {
+	if r.Min.Infinite > 0 || r.Max.Infinite < 0 {
+		return true
+	}
+	if r.Min.Infinite < 0 || r.Max.Infinite > 0 {
+		return false
+	}
+	return r.Min.Value > r.Max.Value || (r.Min.Value == r.Max.Value && (r.Min.Exclusive || r.Max.Exclusive))
+}
+
+// nodeRange abstracts over score and lex ranges so the skiplist can search
+// both the same way.
+type nodeRange interface {
+	aboveMin(node *skipListNode) bool
+	belowMax(node *skipListNode) bool
+	isEmpty() bool
+}
+
+type scoreNodeRange ScoreRange
+
+func (r scoreNodeRange) aboveMin(node *skipListNode) bool { return ScoreRange(r).aboveMin(node.score) }
+func (r scoreNodeRange) belowMax(node *skipListNode) bool { return ScoreRange(r).belowMax(node.score) }
+func (r scoreNodeRange) isEmpty() bool                    { return ScoreRange(r).empty() }
+
+type lexNodeRange LexRange
+
+func (r lexNodeRange) aboveMin(node *skipListNode) bool { return LexRange(r).aboveMin(node.member) }
+func (r lexNodeRange) belowMax(node *skipListNode) bool { return LexRange(r).belowMax(node.member) }
+func (r lexNodeRange) isEmpty() bool                    { return LexRange(r).empty() }
+
+func (zsl *skipList) overlaps(r nodeRange) bool {
+	if r.isEmpty() || zsl.tail == nil {
+		return false
+	}
+	return r.aboveMin(zsl.tail) && r.belowMax(zsl.header.level[0].forward)
+}
+
+func (zsl *skipList) firstInRange(r nodeRange) *skipListNode {
+	if !zsl.overlaps(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	x = x.level[0].forward
+	if !r.belowMax(x) {
+		return nil
+	}
+	return x
+}
+
+func (zsl *skipList) lastInRange(r nodeRange) *skipListNode {
+	if !zsl.overlaps(r) {
+		return nil
+	}
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward) {
+			x = x.level[i].forward
+		}
+	}
+	if x == zsl.header || !r.aboveMin(x) {
+		return nil
+	}
+	return x
+}
@@ -0,0 +1,872 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"math"
+	"slices"
+	"time"
+)
+
+var errScoreNaN = errors.New("-ERR resulting score is not a number (NaN)")
+
+// SortedSet pairs a dict from member to score with a skiplist ordered by
+// score, like a Redis sorted set once it outgrows its listpack encoding.
+type SortedSet struct {
+	dict map[string]float64
+	zsl  *skipList
+}
+
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{dict: make(map[string]float64), zsl: newSkipList()}
+}
+
+func (z *SortedSet) Len() int {
+	return len(z.dict)
+}
+
+func (z *SortedSet) Encoding() string {
+	return "skiplist"
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// Set adds member or moves it to score, reporting whether it was added.
+func (z *SortedSet) Set(member string, score float64) bool {
+	current, ok := z.dict[member]
+	if !ok {
+		z.dict[member] = score
+		z.zsl.insert(score, member)
+		return true
+	}
+	if current != score {
+		z.zsl.updateScore(current, member, score)
+		z.dict[member] = score
+	}
+	return false
+}
+
+func (z *SortedSet) Remove(member string) bool {
+	score, ok := z.dict[member]
+	if !ok {
+		return false
+	}
+	delete(z.dict, member)
+	z.zsl.delete(score, member)
+	return true
+}
+
+// Rank returns the 0-based rank of member, counted from the highest score if
+// reverse is set.
+func (z *SortedSet) Rank(member string, reverse bool) (int, bool) {
+	score, ok := z.dict[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if reverse {
+		return z.Len() - rank, true
+	}
+	return rank - 1, true
+}
+
+// collect walks count members from node, or all of them if count is
+// negative.
+func collect(node *skipListNode, reverse bool, count int, inRange func(*skipListNode) bool) []ScoredMember {
+	var members []ScoredMember
+	for node != nil && count != 0 && inRange(node) {
+		members = append(members, ScoredMember{Member: node.member, Score: node.score})
+		count--
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return members
+}
+
+// RangeByRank returns the members between the 0-based ranks start and end,
+// which must already be normalized.
+func (z *SortedSet) RangeByRank(start int, end int, reverse bool) []ScoredMember {
+	rank := start + 1
+	if reverse {
+		rank = z.Len() - start
+	}
+	return collect(z.zsl.byRank(rank), reverse, end-start+1, func(*skipListNode) bool { return true })
+}
+
+func (z *SortedSet) rangeIn(r nodeRange, reverse bool, offset int, count int) []ScoredMember {
+	var node *skipListNode
+	var inRange func(*skipListNode) bool
+	if reverse {
+		node, inRange = z.zsl.lastInRange(r), r.aboveMin
+	} else {
+		node, inRange = z.zsl.firstInRange(r), r.belowMax
+	}
+
+	for ; node != nil && offset > 0 && inRange(node); offset-- {
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return collect(node, reverse, count, inRange)
+}
+
+// RangeByScore skips offset members of the range and returns count of the
+// rest, or all of them if count is negative.
+func (z *SortedSet) RangeByScore(r ScoreRange, reverse bool, offset int, count int) []ScoredMember {
+	return z.rangeIn(scoreNodeRange(r), reverse, offset, count)
+}
+
+// RangeByLex is RangeByScore for a member range; it assumes all members
+// share the same score.
+func (z *SortedSet) RangeByLex(r LexRange, reverse bool, offset int, count int) []ScoredMember {
+	return z.rangeIn(lexNodeRange(r), reverse, offset, count)
+}
+
+func (z *SortedSet) countIn(r nodeRange) int {
+	first := z.zsl.firstInRange(r)
+	if first == nil {
+		return 0
+	}
+	last := z.zsl.lastInRange(r)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+func (z *SortedSet) CountByScore(r ScoreRange) int {
+	return z.countIn(scoreNodeRange(r))
+}
+
+func (z *SortedSet) CountByLex(r LexRange) int {
+	return z.countIn(lexNodeRange(r))
+}
+
+type sortedSetSnapshot struct {
+	Members []string
+	Scores  []float64
+}
+
+func (z *SortedSet) GobEncode() ([]byte, error) {
+	snapshot := sortedSetSnapshot{}
+	for node := z.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+		snapshot.Members = append(snapshot.Members, node.member)
+		snapshot.Scores = append(snapshot.Scores, node.score)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (z *SortedSet) GobDecode(data []byte) error {
+	var snapshot sortedSetSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	*z = *NewSortedSet()
+	for i, member := range snapshot.Members {
+		z.Set(member, snapshot.Scores[i])
+	}
+	return nil
+}
+
+// sortedSetLocked returns the sorted set stored at key, or nil if there is
+// none.
+func (s *InMemoryStore) sortedSetLocked(key string) (*SortedSet, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != SortedSetType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.SortedSetKV[key], nil
+}
+
+// ZAddOptions are the flags of ZADD.
+type ZAddOptions struct {
+	NX   bool
+	XX   bool
+	GT   bool
+	LT   bool
+	CH   bool
+	Incr bool
+}
+
+// ZAdd returns the number of members added, plus those updated if CH is set.
+// With INCR it adds the single score to the member's current one and instead
+// returns the new score, and false if the flags prevented the update.
+func (s *InMemoryStore) ZAdd(key string, members []ScoredMember, options ZAddOptions) (int, float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	changed := 0
+	var newScore float64
+	applied := false
+	for _, member := range members {
+		current, exists := 0.0, false
+		if zset != nil {
+			current, exists = zset.Score(member.Member)
+		}
+
+		if exists {
+			if options.NX {
+				continue
+			}
+			newScore = member.Score
+			if options.Incr {
+				newScore += current
+				if math.IsNaN(newScore) {
+					return 0, 0, false, errScoreNaN
+				}
+			}
+			if (options.LT && newScore >= current) || (options.GT && newScore <= current) {
+				continue
+			}
+			applied = true
+			if newScore != current {
+				zset.Set(member.Member, newScore)
+				if options.CH {
+					changed++
+				}
+			}
+			continue
+		}
+
+		if options.XX {
+			continue
+		}
+		if zset == nil {
+			zset = NewSortedSet()
+			s.SortedSetKV[key] = zset
+			s.KeyType[key] = SortedSetType
+		}
+		newScore = member.Score
+		applied = true
+		zset.Set(member.Member, newScore)
+		changed++
+	}
+
+	if zset != nil {
+		s.signalKeyAsReady(key)
+		s.serveBlockedClients()
+	}
+	return changed, newScore, applied, nil
+}
+
+func (s *InMemoryStore) ZIncrBy(key string, increment float64, member string) (float64, error) {
+	_, score, _, err := s.ZAdd(key, []ScoredMember{{Member: member, Score: increment}}, ZAddOptions{Incr: true})
+	return score, err
+}
+
+func (s *InMemoryStore) ZScore(key string, member string) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score, ok := zset.Score(member)
+	return score, ok, nil
+}
+
+func (s *InMemoryStore) ZMScore(key string, members []string) ([]float64, []bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scores := make([]float64, len(members))
+	exists := make([]bool, len(members))
+	if zset != nil {
+		for i, member := range members {
+			scores[i], exists[i] = zset.Score(member)
+		}
+	}
+	return scores, exists, nil
+}
+
+// ZRem deletes the key once its last member is gone.
+func (s *InMemoryStore) ZRem(key string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, member := range members {
+		if zset.Remove(member) {
+			removed++
+		}
+	}
+	if zset.Len() == 0 {
+		s.deleteKeyLocked(key)
+	}
+	return removed, nil
+}
+
+func (s *InMemoryStore) ZCard(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.Len(), nil
+}
+
+func (s *InMemoryStore) ZCount(key string, r ScoreRange) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.CountByScore(r), nil
+}
+
+func (s *InMemoryStore) ZLexCount(key string, r LexRange) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.CountByLex(r), nil
+}
+
+// ZRank returns the 0-based rank of member and its score.
+func (s *InMemoryStore) ZRank(key string, member string, reverse bool) (int, float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, 0, false, err
+	}
+
+	rank, ok := zset.Rank(member, reverse)
+	if !ok {
+		return 0, 0, false, nil
+	}
+	score, _ := zset.Score(member)
+	return rank, score, true, nil
+}
+
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+// ZRangeSpec describes a ZRANGE query. Start and Stop are ranks, which may be
+// negative to count from the end; Offset and Count apply to score and lex
+// ranges only, with a negative Count meaning no limit.
+type ZRangeSpec struct {
+	By      ZRangeBy
+	Start   int
+	Stop    int
+	Score   ScoreRange
+	Lex     LexRange
+	Reverse bool
+	Offset  int
+	Count   int
+}
+
+func (z *SortedSet) Range(spec ZRangeSpec) []ScoredMember {
+	switch spec.By {
+	case ZRangeByScore:
+		return z.RangeByScore(spec.Score, spec.Reverse, spec.Offset, spec.Count)
+	case ZRangeByLex:
+		return z.RangeByLex(spec.Lex, spec.Reverse, spec.Offset, spec.Count)
+	}
+
+	start, end, ok := normalizeRange(spec.Start, spec.Stop, z.Len())
+	if !ok {
+		return nil
+	}
+	return z.RangeByRank(start, end, spec.Reverse)
+}
+
+func (s *InMemoryStore) ZRange(key string, spec ZRangeSpec) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	return zset.Range(spec), nil
+}
+
+// ZRangeStore stores the result of a ZRANGE query at destination, replacing
+// whatever was there, and returns its cardinality.
+func (s *InMemoryStore) ZRangeStore(destination string, key string, spec ZRangeSpec) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil {
+		return 0, err
+	}
+
+	result := NewSortedSet()
+	if zset != nil {
+		for _, member := range zset.Range(spec) {
+			result.Set(member.Member, member.Score)
+		}
+	}
+	cardinality := s.storeSortedSetLocked(destination, result)
+	s.serveBlockedClients()
+	return cardinality, nil
+}
+
+// ZRemRange removes the members a ZRANGE query would return.
+func (s *InMemoryStore) ZRemRange(key string, spec ZRangeSpec) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetLocked(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+
+	members := zset.Range(spec)
+	for _, member := range members {
+		zset.Remove(member.Member)
+	}
+	if zset.Len() == 0 {
+		s.deleteKeyLocked(key)
+	}
+	return len(members), nil
+}
+
+// storeSortedSetLocked replaces whatever is stored at key with zset, deleting
+// the key when zset is empty.
+func (s *InMemoryStore) storeSortedSetLocked(key string, zset *SortedSet) int {
+	s.deleteKeyLocked(key)
+	if zset.Len() == 0 {
+		return 0
+	}
+	s.SortedSetKV[key] = zset
+	s.KeyType[key] = SortedSetType
+	s.signalKeyAsReady(key)
+	return zset.Len()
+}
+
+type ZAggregate int
+
+const (
+	ZAggregateSum ZAggregate = iota
+	ZAggregateMin
+	ZAggregateMax
+)
+
+func (aggregate ZAggregate) apply(current float64, value float64) float64 {
+	switch aggregate {
+	case ZAggregateMin:
+		return min(current, value)
+	case ZAggregateMax:
+		return max(current, value)
+	}
+	if sum := current + value; !math.IsNaN(sum) {
+		return sum
+	}
+	// inf + -inf
+	return 0
+}
+
+// zsetInput is an input of the sorted set operations: a sorted set, a plain
+// set whose members all score 1, or nothing for a missing key.
+type zsetInput struct {
+	zset   *SortedSet
+	set    *Set
+	weight float64
+}
+
+func (in zsetInput) len() int {
+	switch {
+	case in.zset != nil:
+		return in.zset.Len()
+	case in.set != nil:
+		return in.set.Len()
+	}
+	return 0
+}
+
+func (in zsetInput) score(member string) (float64, bool) {
+	switch {
+	case in.zset != nil:
+		return in.zset.Score(member)
+	case in.set != nil:
+		return 1, in.set.Contains(member)
+	}
+	return 0, false
+}
+
+func (in zsetInput) each(fn func(member string, score float64) bool) {
+	switch {
+	case in.zset != nil:
+		for member, score := range in.zset.dict {
+			if !fn(member, score) {
+				return
+			}
+		}
+	case in.set != nil:
+		in.set.Each(func(member string) bool {
+			return fn(member, 1)
+		})
+	}
+}
+
+// weighted multiplies score by the input's weight, treating inf * 0 as 0.
+func (in zsetInput) weighted(score float64) float64 {
+	if value := score * in.weight; !math.IsNaN(value) {
+		return value
+	}
+	return 0
+}
+
+// zsetInputsLocked looks up the inputs of a sorted set operation. weights may
+// be nil, in which case every input has weight 1.
+func (s *InMemoryStore) zsetInputsLocked(keys []string, weights []float64) ([]zsetInput, error) {
+	inputs := make([]zsetInput, len(keys))
+	for i, key := range keys {
+		inputs[i].weight = 1
+		if weights != nil {
+			inputs[i].weight = weights[i]
+		}
+
+		keyType, ok := s.KeyType[key]
+		if !ok {
+			continue
+		}
+		switch keyType {
+		case SortedSetType:
+			inputs[i].zset = s.SortedSetKV[key]
+		case SetType:
+			inputs[i].set = s.SetKV[key]
+		default:
+			return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	}
+	return inputs, nil
+}
+
+func zsetUnion(inputs []zsetInput, aggregate ZAggregate) *SortedSet {
+	scores := make(map[string]float64)
+	for _, input := range inputs {
+		input.each(func(member string, score float64) bool {
+			value := input.weighted(score)
+			if current, ok := scores[member]; ok {
+				value = aggregate.apply(current, value)
+			}
+			scores[member] = value
+			return true
+		})
+	}
+
+	result := NewSortedSet()
+	for member, score := range scores {
+		result.Set(member, score)
+	}
+	return result
+}
+
+// zsetInter walks the smallest input and probes the others, stopping once
+// limit members have been found. A limit of 0 means no limit.
+func zsetInter(inputs []zsetInput, aggregate ZAggregate, limit int) *SortedSet {
+	inputs = slices.Clone(inputs)
+	slices.SortStableFunc(inputs, func(a, b zsetInput) int {
+		return a.len() - b.len()
+	})
+
+	result := NewSortedSet()
+	if len(inputs) == 0 || inputs[0].len() == 0 {
+		return result
+	}
+
+	inputs[0].each(func(member string, score float64) bool {
+		value := inputs[0].weighted(score)
+		for _, input := range inputs[1:] {
+			other, ok := input.score(member)
+			if !ok {
+				return true
+			}
+			value = aggregate.apply(value, input.weighted(other))
+		}
+		result.Set(member, value)
+		return limit == 0 || result.Len() < limit
+	})
+	return result
+}
+
+func zsetDiff(inputs []zsetInput) *SortedSet {
+	result := NewSortedSet()
+	inputs[0].each(func(member string, score float64) bool {
+		for _, input := range inputs[1:] {
+			if _, ok := input.score(member); ok {
+				return true
+			}
+		}
+		result.Set(member, score)
+		return true
+	})
+	return result
+}
+
+func (s *InMemoryStore) zsetOperation(keys []string, weights []float64, op func([]zsetInput) *SortedSet) (*SortedSet, error) {
+	inputs, err := s.zsetInputsLocked(keys, weights)
+	if err != nil {
+		return nil, err
+	}
+	return op(inputs), nil
+}
+
+// ZUnion returns the union of the sorted sets, or plain sets, at keys with
+// every score multiplied by the weight of its input.
+func (s *InMemoryStore) ZUnion(keys []string, weights []float64, aggregate ZAggregate) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, weights, func(inputs []zsetInput) *SortedSet {
+		return zsetUnion(inputs, aggregate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.RangeByRank(0, result.Len()-1, false), nil
+}
+
+func (s *InMemoryStore) ZUnionStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, weights, func(inputs []zsetInput) *SortedSet {
+		return zsetUnion(inputs, aggregate)
+	})
+	if err != nil {
+		return 0, err
+	}
+	cardinality := s.storeSortedSetLocked(destination, result)
+	s.serveBlockedClients()
+	return cardinality, nil
+}
+
+func (s *InMemoryStore) ZInter(keys []string, weights []float64, aggregate ZAggregate) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, weights, func(inputs []zsetInput) *SortedSet {
+		return zsetInter(inputs, aggregate, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.RangeByRank(0, result.Len()-1, false), nil
+}
+
+func (s *InMemoryStore) ZInterStore(destination string, keys []string, weights []float64, aggregate ZAggregate) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, weights, func(inputs []zsetInput) *SortedSet {
+		return zsetInter(inputs, aggregate, 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+	cardinality := s.storeSortedSetLocked(destination, result)
+	s.serveBlockedClients()
+	return cardinality, nil
+}
+
+func (s *InMemoryStore) ZInterCard(keys []string, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, nil, func(inputs []zsetInput) *SortedSet {
+		return zsetInter(inputs, ZAggregateSum, limit)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.Len(), nil
+}
+
+// ZDiff returns the members of the first input that are in none of the
+// others, with their scores in the first input.
+func (s *InMemoryStore) ZDiff(keys []string) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, nil, zsetDiff)
+	if err != nil {
+		return nil, err
+	}
+	return result.RangeByRank(0, result.Len()-1, false), nil
+}
+
+func (s *InMemoryStore) ZDiffStore(destination string, keys []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, err := s.zsetOperation(keys, nil, zsetDiff)
+	if err != nil {
+		return 0, err
+	}
+	cardinality := s.storeSortedSetLocked(destination, result)
+	s.serveBlockedClients()
+	return cardinality, nil
+}
+
+// PopMin removes and returns up to count members with the lowest scores, or
+// the highest ones if max is set.
+func (z *SortedSet) PopMin(count int, max bool) []ScoredMember {
+	count = min(count, z.Len())
+	if count == 0 {
+		return nil
+	}
+
+	members := z.RangeByRank(0, count-1, max)
+	for _, member := range members {
+		z.Remove(member.Member)
+	}
+	return members
+}
+
+// zsetPopLocked must be called with the key already known not to hold
+// another type. It deletes the key once its last member is popped.
+func (s *InMemoryStore) zsetPopLocked(key string, max bool, count int) []ScoredMember {
+	zset := s.SortedSetKV[key]
+	if zset == nil {
+		return nil
+	}
+
+	members := zset.PopMin(count, max)
+	if zset.Len() == 0 {
+		s.deleteKeyLocked(key)
+	}
+	return members
+}
+
+// zsetRestoreLocked puts back members popped for a client that was
+// cancelled before it could take them.
+func (s *InMemoryStore) zsetRestoreLocked(key string, members []ScoredMember) {
+	if len(members) == 0 {
+		return
+	}
+
+	zset := s.SortedSetKV[key]
+	if zset == nil {
+		zset = NewSortedSet()
+		s.SortedSetKV[key] = zset
+		s.KeyType[key] = SortedSetType
+	}
+	for _, member := range members {
+		zset.Set(member.Member, member.Score)
+	}
+	s.signalKeyAsReady(key)
+}
+
+func (s *InMemoryStore) ZPop(key string, max bool, count int) ([]ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.sortedSetLocked(key); err != nil {
+		return nil, err
+	}
+	return s.zsetPopLocked(key, max, count), nil
+}
+
+func (s *InMemoryStore) zsetsLocked(keys []string) error {
+	for _, key := range keys {
+		if _, err := s.sortedSetLocked(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ZMPop pops from the first non-empty sorted set among keys and returns its
+// key along with the popped members.
+func (s *InMemoryStore) ZMPop(keys []string, max bool, count int) (string, []ScoredMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.zsetsLocked(keys); err != nil {
+		return "", nil, err
+	}
+
+	for _, key := range keys {
+		if members := s.zsetPopLocked(key, max, count); len(members) > 0 {
+			return key, members, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// BZMPop is ZMPop that blocks until one of the keys holds a sorted set, or the
+// timeout expires.
+func (s *InMemoryStore) BZMPop(ctx context.Context, keys []string, max bool, count int, timeout time.Duration) (string, []ScoredMember, bool, error) {
+	s.mu.Lock()
+
+	if err := s.zsetsLocked(keys); err != nil {
+		s.mu.Unlock()
+		return "", nil, false, err
+	}
+
+	for _, key := range keys {
+		if members := s.zsetPopLocked(key, max, count); len(members) > 0 {
+			s.mu.Unlock()
+			return key, members, true, nil
+		}
+	}
+
+	var poppedKey string
+	var poppedMembers []ScoredMember
+	served, err := s.blockOn(ctx, keys, timeout, func(key string) bool {
+		if keyType, ok := s.KeyType[key]; !ok || keyType != SortedSetType {
+			return false
+		}
+		poppedKey = key
+		poppedMembers = s.zsetPopLocked(key, max, count)
+		return true
+	}, func() {
+		s.zsetRestoreLocked(poppedKey, poppedMembers)
+	})
+	if !served {
+		return "", nil, false, err
+	}
+	return poppedKey, poppedMembers, true, nil
+}
@@ -0,0 +1,194 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func newRollupStore() *InMemoryStore {
+	s := NewInMemoryStore()
+	s.ZAdd("week1", []ScoredMember{{Member: "a", Score: 1}, {Member: "b", Score: 2}, {Member: "c", Score: 3}}, ZAddOptions{})
+	s.ZAdd("week2", []ScoredMember{{Member: "b", Score: 10}, {Member: "c", Score: 20}, {Member: "d", Score: 30}}, ZAddOptions{})
+	addMembers(s, "set", "c", "d", "e")
+	return s
+}
+
+func TestZUnionAndZInter(t *testing.T) {
+	s := newRollupStore()
+	tests := []struct {
+		name      string
+		op        func([]string, []float64, ZAggregate) ([]ScoredMember, error)
+		keys      []string
+		weights   []float64
+		aggregate ZAggregate
+		want      []ScoredMember
+	}{
+		{"ZUnion", s.ZUnion, []string{"week1", "week2"}, nil, ZAggregateSum,
+			[]ScoredMember{{"a", 1}, {"b", 12}, {"c", 23}, {"d", 30}}},
+		{"ZUnion MIN", s.ZUnion, []string{"week1", "week2"}, nil, ZAggregateMin,
+			[]ScoredMember{{"a", 1}, {"b", 2}, {"c", 3}, {"d", 30}}},
+		{"ZUnion WEIGHTS", s.ZUnion, []string{"week1", "week2"}, []float64{10, 0.5}, ZAggregateSum,
+			[]ScoredMember{{"a", 10}, {"d", 15}, {"b", 25}, {"c", 40}}},
+		{"ZUnion with a set", s.ZUnion, []string{"week1", "set"}, nil, ZAggregateMax,
+			[]ScoredMember{{"a", 1}, {"d", 1}, {"e", 1}, {"b", 2}, {"c", 3}}},
+		{"ZUnion with a missing key", s.ZUnion, []string{"missing", "week1"}, nil, ZAggregateSum,
+			[]ScoredMember{{"a", 1}, {"b", 2}, {"c", 3}}},
+		{"ZInter", s.ZInter, []string{"week1", "week2"}, nil, ZAggregateSum,
+			[]ScoredMember{{"b", 12}, {"c", 23}}},
+		{"ZInter MAX", s.ZInter, []string{"week1", "week2"}, nil, ZAggregateMax,
+			[]ScoredMember{{"b", 10}, {"c", 20}}},
+		{"ZInter with a set", s.ZInter, []string{"week2", "set"}, []float64{1, 100}, ZAggregateSum,
+			[]ScoredMember{{"c", 120}, {"d", 130}}},
+		{"ZInter with a missing key", s.ZInter, []string{"week1", "missing"}, nil, ZAggregateSum, nil},
+	}
+	for _, test := range tests {
+		got, err := test.op(test.keys, test.weights, test.aggregate)
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("%s = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+}
+
+func TestZAggregateInfinities(t *testing.T) {
+	s := NewInMemoryStore()
+	s.ZAdd("plus", []ScoredMember{{"a", math.Inf(1)}}, ZAddOptions{})
+	s.ZAdd("minus", []ScoredMember{{"a", math.Inf(-1)}}, ZAddOptions{})
+
+	if got, _ := s.ZUnion([]string{"plus", "minus"}, nil, ZAggregateSum); got[0].Score != 0 {
+		t.Errorf("inf + -inf = %v, want 0", got[0].Score)
+	}
+	if got, _ := s.ZUnion([]string{"plus"}, []float64{0}, ZAggregateSum); got[0].Score != 0 {
+		t.Errorf("inf * 0 = %v, want 0", got[0].Score)
+	}
+}
+
+func TestZDiffAndZInterCard(t *testing.T) {
+	s := newRollupStore()
+	if got, _ := s.ZDiff([]string{"week1", "week2"}); !slices.Equal(got, []ScoredMember{{"a", 1}}) {
+		t.Errorf("ZDiff = %v", got)
+	}
+	if got, _ := s.ZDiff([]string{"week2", "set"}); !slices.Equal(got, []ScoredMember{{"b", 10}}) {
+		t.Errorf("ZDiff with a set = %v", got)
+	}
+	if got, _ := s.ZDiff([]string{"missing", "week1"}); len(got) != 0 {
+		t.Errorf("ZDiff from a missing key = %v", got)
+	}
+
+	tests := []struct {
+		keys  []string
+		limit int
+		want  int
+	}{
+		{[]string{"week1", "week2"}, 0, 2},
+		{[]string{"week1", "week2"}, 1, 1},
+		{[]string{"week1", "week2", "set"}, 0, 1},
+		{[]string{"week1", "missing"}, 0, 0},
+	}
+	for _, test := range tests {
+		if n, err := s.ZInterCard(test.keys, test.limit); err != nil || n != test.want {
+			t.Errorf("ZInterCard(%v, %d) = %d, %v, want %d", test.keys, test.limit, n, err, test.want)
+		}
+	}
+}
+
+func TestZStoreCommands(t *testing.T) {
+	s := newRollupStore()
+	s.StringSet("dest", "value", 0, false, false, false, false)
+
+	if n, err := s.ZUnionStore("dest", []string{"week1", "week2"}, nil, ZAggregateSum); err != nil || n != 4 {
+		t.Errorf("ZUnionStore = %d, %v", n, err)
+	}
+	if s.KeyType["dest"] != SortedSetType {
+		t.Error("ZUnionStore did not replace the string destination")
+	}
+	if score, _, _ := s.ZScore("dest", "c"); score != 23 {
+		t.Errorf("stored score of c = %v", score)
+	}
+
+	// The destination may be one of the sources.
+	if n, _ := s.ZInterStore("week1", []string{"week1", "week2"}, nil, ZAggregateSum); n != 2 {
+		t.Errorf("ZInterStore into a source = %d", n)
+	}
+	if got, _ := s.ZRange("week1", ZRangeSpec{Stop: -1}); !slices.Equal(got, []ScoredMember{{"b", 12}, {"c", 23}}) {
+		t.Errorf("week1 after ZInterStore = %v", got)
+	}
+
+	if n, _ := s.ZDiffStore("dest", []string{"week2", "week2"}); n != 0 {
+		t.Errorf("ZDiffStore of an empty difference = %d", n)
+	}
+	if _, ok := s.KeyType["dest"]; ok {
+		t.Error("empty result left the destination behind")
+	}
+
+	spec := ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 15, Max: math.Inf(1)}, Count: -1}
+	if n, _ := s.ZRangeStore("dest", "week2", spec); n != 2 {
+		t.Errorf("ZRangeStore = %d", n)
+	}
+	if got, _ := s.ZRange("dest", ZRangeSpec{Stop: -1}); !slices.Equal(members(got), []string{"c", "d"}) {
+		t.Errorf("ZRangeStore stored %v", got)
+	}
+	if n, _ := s.ZRangeStore("dest", "missing", spec); n != 0 {
+		t.Errorf("ZRangeStore from a missing key = %d", n)
+	}
+	if _, ok := s.KeyType["dest"]; ok {
+		t.Error("ZRangeStore of nothing left the destination behind")
+	}
+}
+
+func TestZRemRange(t *testing.T) {
+	minusInf, plusInf := LexBound{Infinite: -1}, LexBound{Infinite: 1}
+	tests := []struct {
+		name string
+		spec ZRangeSpec
+		want []string
+	}{
+		{"by rank", ZRangeSpec{Start: 1, Stop: 2}, []string{"a", "d", "e"}},
+		{"by negative rank", ZRangeSpec{Start: -2, Stop: -1}, []string{"a", "b", "c"}},
+		{"by empty rank range", ZRangeSpec{Start: 3, Stop: 1}, []string{"a", "b", "c", "d", "e"}},
+		{"by score", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 2, Max: 3, MaxExclusive: true}, Count: -1}, []string{"a", "d", "e"}},
+		{"by lex", ZRangeSpec{By: ZRangeByLex, Lex: LexRange{Min: LexBound{Value: "c"}, Max: plusInf}, Count: -1}, []string{"a", "b"}},
+		{"everything", ZRangeSpec{By: ZRangeByLex, Lex: LexRange{Min: minusInf, Max: plusInf}, Count: -1}, nil},
+	}
+	for _, test := range tests {
+		s := NewInMemoryStore()
+		// Equal scores so the lex ranges are meaningful, except for d and e.
+		s.ZAdd("zset", []ScoredMember{{"a", 2}, {"b", 2}, {"c", 2}, {"d", 3}, {"e", 3}}, ZAddOptions{})
+		if test.spec.By != ZRangeByLex {
+			s.ZAdd("zset", []ScoredMember{{"a", 1}}, ZAddOptions{})
+		}
+
+		n, err := s.ZRemRange("zset", test.spec)
+		if err != nil || n != 5-len(test.want) {
+			t.Errorf("%s: ZRemRange = %d, %v, want %d", test.name, n, err, 5-len(test.want))
+		}
+		got, _ := s.ZRange("zset", ZRangeSpec{Stop: -1})
+		if !slices.Equal(members(got), test.want) {
+			t.Errorf("%s: left %v, want %v", test.name, members(got), test.want)
+		}
+		if _, ok := s.KeyType["zset"]; ok != (test.want != nil) {
+			t.Errorf("%s: key exists = %v", test.name, ok)
+		}
+	}
+}
+
+func TestZOperationsWrongType(t *testing.T) {
+	s := newRollupStore()
+	s.RPush("list", []string{"a"})
+
+	if _, err := s.ZUnion([]string{"week1", "list"}, nil, ZAggregateSum); err == nil {
+		t.Error("ZUnion with a list did not fail")
+	}
+	if _, err := s.ZInterStore("dest", []string{"list"}, nil, ZAggregateSum); err == nil {
+		t.Error("ZInterStore with a list did not fail")
+	}
+	if _, ok := s.KeyType["dest"]; ok {
+		t.Error("failed ZInterStore created the destination")
+	}
+	if _, err := s.ZDiff([]string{"missing", "list"}); err == nil {
+		t.Error("ZDiff with a list did not fail")
+	}
+	if _, err := s.ZRemRange("list", ZRangeSpec{Stop: -1}); err == nil {
+		t.Error("ZRemRange on a list did not fail")
+	}
+}
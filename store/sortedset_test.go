@@ -0,0 +1,315 @@
+package store
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// checkSkipList compares zset with want, which must be sorted, and checks
+// the backward links, the tail and the span of every forward link.
+func checkSkipList(t *testing.T, name string, zset *SortedSet, want []ScoredMember) {
+	t.Helper()
+	zsl := zset.zsl
+	if zsl.length != len(want) || zset.Len() != len(want) {
+		t.Fatalf("%s: length %d, dict %d, want %d", name, zsl.length, zset.Len(), len(want))
+	}
+
+	ranks := make(map[*skipListNode]int)
+	var previous *skipListNode
+	i := 0
+	for node := zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+		if i >= len(want) || node.member != want[i].Member || node.score != want[i].Score {
+			t.Fatalf("%s: node %d is %s %v, want %v", name, i, node.member, node.score, want)
+		}
+		if node.backward != previous {
+			t.Fatalf("%s: node %d has the wrong backward link", name, i)
+		}
+		i++
+		ranks[node] = i
+		previous = node
+	}
+	if zsl.tail != previous {
+		t.Fatalf("%s: tail is not the last node", name)
+	}
+
+	for level := range zsl.level {
+		rank := 0
+		for x := zsl.header; x.level[level].forward != nil; x = x.level[level].forward {
+			rank += x.level[level].span
+			if ranks[x.level[level].forward] != rank {
+				t.Fatalf("%s: spans at level %d reach rank %d for a node of rank %d", name, level, rank, ranks[x.level[level].forward])
+			}
+		}
+	}
+}
+
+func compareScored(a ScoredMember, b ScoredMember) int {
+	return cmp.Or(cmp.Compare(a.Score, b.Score), cmp.Compare(a.Member, b.Member))
+}
+
+func TestSortedSetAgainstSlice(t *testing.T) {
+	for seed := range int64(40) {
+		rng := rand.New(rand.NewSource(seed))
+		name := "seed " + strconv.FormatInt(seed, 10)
+		zset := NewSortedSet()
+		scores := make(map[string]float64)
+
+		for range 400 {
+			member := "m" + strconv.Itoa(rng.Intn(60))
+			// Few distinct scores, so ties are ordered by member.
+			score := float64(rng.Intn(10))
+			if rng.Intn(4) == 0 {
+				_, existed := scores[member]
+				if zset.Remove(member) != existed {
+					t.Fatalf("%s: Remove(%s) disagrees with the reference", name, member)
+				}
+				delete(scores, member)
+			} else {
+				_, existed := scores[member]
+				if zset.Set(member, score) == existed {
+					t.Fatalf("%s: Set(%s) disagrees with the reference", name, member)
+				}
+				scores[member] = score
+			}
+		}
+
+		var want []ScoredMember
+		for member, score := range scores {
+			want = append(want, ScoredMember{Member: member, Score: score})
+		}
+		slices.SortFunc(want, compareScored)
+		checkSkipList(t, name, zset, want)
+
+		for i, member := range want {
+			if rank, _ := zset.Rank(member.Member, false); rank != i {
+				t.Fatalf("%s: Rank(%s) = %d, want %d", name, member.Member, rank, i)
+			}
+			if rank, _ := zset.Rank(member.Member, true); rank != len(want)-1-i {
+				t.Fatalf("%s: reverse Rank(%s) = %d, want %d", name, member.Member, rank, len(want)-1-i)
+			}
+		}
+
+		r := ScoreRange{Min: 2, Max: 6, MinExclusive: true}
+		var inRange []ScoredMember
+		for _, member := range want {
+			if member.Score > 2 && member.Score <= 6 {
+				inRange = append(inRange, member)
+			}
+		}
+		if got := zset.CountByScore(r); got != len(inRange) {
+			t.Fatalf("%s: CountByScore = %d, want %d", name, got, len(inRange))
+		}
+		if got := zset.RangeByScore(r, false, 2, 5); !slices.Equal(got, inRange[min(2, len(inRange)):min(7, len(inRange))]) {
+			t.Fatalf("%s: RangeByScore LIMIT 2 5 = %v", name, got)
+		}
+		reversed := slices.Clone(inRange)
+		slices.Reverse(reversed)
+		if got := zset.RangeByScore(r, true, 0, -1); !slices.Equal(got, reversed) {
+			t.Fatalf("%s: reverse RangeByScore = %v", name, got)
+		}
+		if got := zset.RangeByRank(3, 9, false); !slices.Equal(got, want[3:10]) {
+			t.Fatalf("%s: RangeByRank(3, 9) = %v", name, got)
+		}
+
+		data, err := zset.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewSortedSet()
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		checkSkipList(t, name+" decoded", decoded, want)
+	}
+}
+
+func TestZAddOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ZAddOptions
+		score   float64
+		member  string
+		changed int
+		want    float64
+		exists  bool
+	}{
+		{"plain update", ZAddOptions{}, 5, "a", 0, 5, true},
+		{"CH update", ZAddOptions{CH: true}, 5, "a", 1, 5, true},
+		{"CH same score", ZAddOptions{CH: true}, 2, "a", 0, 2, true},
+		{"add", ZAddOptions{}, 1, "new", 1, 1, true},
+		{"NX existing", ZAddOptions{NX: true}, 5, "a", 0, 2, true},
+		{"NX new", ZAddOptions{NX: true}, 5, "new", 1, 5, true},
+		{"XX existing", ZAddOptions{XX: true, CH: true}, 5, "a", 1, 5, true},
+		{"XX new", ZAddOptions{XX: true}, 5, "new", 0, 0, false},
+		{"GT higher", ZAddOptions{GT: true, CH: true}, 3, "a", 1, 3, true},
+		{"GT lower", ZAddOptions{GT: true, CH: true}, 1, "a", 0, 2, true},
+		{"GT new", ZAddOptions{GT: true}, 1, "new", 1, 1, true},
+		{"LT lower", ZAddOptions{LT: true, CH: true}, 1, "a", 1, 1, true},
+		{"LT higher", ZAddOptions{LT: true, CH: true}, 3, "a", 0, 2, true},
+	}
+	for _, test := range tests {
+		s := NewInMemoryStore()
+		s.ZAdd("zset", []ScoredMember{{Member: "a", Score: 2}}, ZAddOptions{})
+
+		changed, _, _, err := s.ZAdd("zset", []ScoredMember{{Member: test.member, Score: test.score}}, test.options)
+		if err != nil || changed != test.changed {
+			t.Errorf("%s: ZAdd = %d, %v, want %d", test.name, changed, err, test.changed)
+		}
+		if score, ok, _ := s.ZScore("zset", test.member); ok != test.exists || score != test.want {
+			t.Errorf("%s: score = %v, %v, want %v, %v", test.name, score, ok, test.want, test.exists)
+		}
+	}
+}
+
+func TestZAddIncr(t *testing.T) {
+	s := NewInMemoryStore()
+	if score, _ := s.ZIncrBy("zset", 2.5, "a"); score != 2.5 {
+		t.Errorf("ZIncrBy of a new member = %v", score)
+	}
+	if score, _ := s.ZIncrBy("zset", -1, "a"); score != 1.5 {
+		t.Errorf("ZIncrBy = %v", score)
+	}
+
+	incr := []ScoredMember{{Member: "a", Score: -1}}
+	if _, _, applied, _ := s.ZAdd("zset", incr, ZAddOptions{Incr: true, GT: true}); applied {
+		t.Error("ZADD GT INCR applied a decrement")
+	}
+	if _, score, applied, _ := s.ZAdd("zset", incr, ZAddOptions{Incr: true, LT: true}); !applied || score != 0.5 {
+		t.Errorf("ZADD LT INCR = %v, %v", score, applied)
+	}
+	if _, _, applied, _ := s.ZAdd("zset", incr, ZAddOptions{Incr: true, NX: true}); applied {
+		t.Error("ZADD NX INCR applied to an existing member")
+	}
+
+	s.ZAdd("zset", []ScoredMember{{Member: "inf", Score: math.Inf(1)}}, ZAddOptions{})
+	if _, err := s.ZIncrBy("zset", math.Inf(-1), "inf"); err != errScoreNaN {
+		t.Errorf("ZIncrBy to NaN = %v", err)
+	}
+	if score, _, _ := s.ZScore("zset", "inf"); !math.IsInf(score, 1) {
+		t.Errorf("failed ZIncrBy changed the score to %v", score)
+	}
+}
+
+func newLeaderboard() *InMemoryStore {
+	s := NewInMemoryStore()
+	s.ZAdd("zset", []ScoredMember{
+		{Member: "a", Score: 1}, {Member: "b", Score: 2}, {Member: "c", Score: 2},
+		{Member: "d", Score: 3}, {Member: "e", Score: 5},
+	}, ZAddOptions{})
+	return s
+}
+
+func members(scored []ScoredMember) []string {
+	var names []string
+	for _, member := range scored {
+		names = append(names, member.Member)
+	}
+	return names
+}
+
+func TestZRange(t *testing.T) {
+	s := newLeaderboard()
+	tests := []struct {
+		name string
+		spec ZRangeSpec
+		want []string
+	}{
+		{"all", ZRangeSpec{Start: 0, Stop: -1}, []string{"a", "b", "c", "d", "e"}},
+		{"negative ranks", ZRangeSpec{Start: -2, Stop: -1}, []string{"d", "e"}},
+		{"out of range", ZRangeSpec{Start: 5, Stop: 10}, nil},
+		{"reversed ranks", ZRangeSpec{Start: 0, Stop: 1, Reverse: true}, []string{"e", "d"}},
+		{"by score", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 2, Max: 3}, Count: -1}, []string{"b", "c", "d"}},
+		{"exclusive score", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 2, Max: 5, MinExclusive: true, MaxExclusive: true}, Count: -1}, []string{"d"}},
+		{"infinite score", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, Offset: 1, Count: 2}, []string{"b", "c"}},
+		{"reversed score", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 1, Max: 2}, Reverse: true, Count: -1}, []string{"c", "b", "a"}},
+		{"empty score range", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 3, Max: 3, MinExclusive: true}, Count: -1}, nil},
+		{"offset past the end", ZRangeSpec{By: ZRangeByScore, Score: ScoreRange{Min: 0, Max: 10}, Offset: 9, Count: -1}, nil},
+	}
+	for _, test := range tests {
+		got, err := s.ZRange("zset", test.spec)
+		if err != nil || !slices.Equal(members(got), test.want) {
+			t.Errorf("%s: ZRange = %v, %v, want %v", test.name, members(got), err, test.want)
+		}
+	}
+}
+
+func TestZRangeByLex(t *testing.T) {
+	s := NewInMemoryStore()
+	for _, member := range []string{"a", "b", "c", "d", "e"} {
+		s.ZAdd("lex", []ScoredMember{{Member: member}}, ZAddOptions{})
+	}
+	minusInf, plusInf := LexBound{Infinite: -1}, LexBound{Infinite: 1}
+	tests := []struct {
+		name string
+		r    LexRange
+		want []string
+	}{
+		{"all", LexRange{Min: minusInf, Max: plusInf}, []string{"a", "b", "c", "d", "e"}},
+		{"inclusive", LexRange{Min: LexBound{Value: "b"}, Max: LexBound{Value: "d"}}, []string{"b", "c", "d"}},
+		{"exclusive", LexRange{Min: LexBound{Value: "b", Exclusive: true}, Max: LexBound{Value: "d", Exclusive: true}}, []string{"c"}},
+		{"open ended", LexRange{Min: LexBound{Value: "cc"}, Max: plusInf}, []string{"d", "e"}},
+		{"inverted", LexRange{Min: plusInf, Max: minusInf}, nil},
+	}
+	for _, test := range tests {
+		got, _ := s.ZRange("lex", ZRangeSpec{By: ZRangeByLex, Lex: test.r, Count: -1})
+		if !slices.Equal(members(got), test.want) {
+			t.Errorf("%s: ZRANGE BYLEX = %v, want %v", test.name, members(got), test.want)
+		}
+		if n, _ := s.ZLexCount("lex", test.r); n != len(test.want) {
+			t.Errorf("%s: ZLexCount = %d, want %d", test.name, n, len(test.want))
+		}
+	}
+}
+
+func TestZRankAndScores(t *testing.T) {
+	s := newLeaderboard()
+	if rank, score, ok, _ := s.ZRank("zset", "c", false); !ok || rank != 2 || score != 2 {
+		t.Errorf("ZRank(c) = %d, %v, %v", rank, score, ok)
+	}
+	if rank, _, ok, _ := s.ZRank("zset", "c", true); !ok || rank != 2 {
+		t.Errorf("ZRevRank(c) = %d, %v", rank, ok)
+	}
+	if rank, _, ok, _ := s.ZRank("zset", "e", true); !ok || rank != 0 {
+		t.Errorf("ZRevRank(e) = %d, %v", rank, ok)
+	}
+	if _, _, ok, _ := s.ZRank("zset", "missing", false); ok {
+		t.Error("ZRank found a missing member")
+	}
+
+	scores, exists, _ := s.ZMScore("zset", []string{"a", "missing", "e"})
+	if !slices.Equal(scores, []float64{1, 0, 5}) || !slices.Equal(exists, []bool{true, false, true}) {
+		t.Errorf("ZMScore = %v, %v", scores, exists)
+	}
+	if n, _ := s.ZCount("zset", ScoreRange{Min: 2, Max: 3}); n != 3 {
+		t.Errorf("ZCount = %d", n)
+	}
+	if n, _ := s.ZCount("zset", ScoreRange{Min: 6, Max: 1}); n != 0 {
+		t.Errorf("ZCount of an inverted range = %d", n)
+	}
+}
+
+func TestZRemDeletesEmptySet(t *testing.T) {
+	s := newLeaderboard()
+	if n, _ := s.ZRem("zset", []string{"a", "missing", "a"}); n != 1 {
+		t.Errorf("ZRem = %d", n)
+	}
+	if n, _ := s.ZCard("zset"); n != 4 {
+		t.Errorf("ZCard = %d", n)
+	}
+	s.ZRem("zset", []string{"b", "c", "d", "e"})
+	if _, ok := s.KeyType["zset"]; ok {
+		t.Error("ZRem left an empty sorted set behind")
+	}
+
+	s.RPush("list", []string{"a"})
+	if _, _, _, err := s.ZAdd("list", []ScoredMember{{Member: "a"}}, ZAddOptions{}); err == nil {
+		t.Error("ZAdd on a list did not fail")
+	}
+	if _, err := s.ZRange("list", ZRangeSpec{Stop: -1}); err == nil {
+		t.Error("ZRange on a list did not fail")
+	}
+}
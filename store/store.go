@@ -1,7 +1,7 @@
 package store
 
 import (
-	"container/list"
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -12,9 +12,98 @@ import (
 	"time"
 )
 
+type StringEncoding uint8
+
+const (
+	RawEncoding StringEncoding = iota
+	IntEncoding
+	EmbStrEncoding
+)
+
+// embStrSizeLimit is the longest string that is still reported as embstr,
+// matching the OBJ_ENCODING_EMBSTR_SIZE_LIMIT used by Redis.
+const embStrSizeLimit = 44
+
+// StoreValue holds a string key. Values that are the canonical decimal form of
+// an int64 are kept in Int so counters never go through a string round trip;
+// everything else lives in Str. ExpiresAt is a unix timestamp in milliseconds,
+// zero meaning the key never expires.
 type StoreValue struct {
-	Value  string
-	Expiry *time.Time
+	Encoding  StringEncoding
+	Int       int64
+	Str       string
+	ExpiresAt int64
+}
+
+func newStringValue(value string, expiresAt int64) StoreValue {
+	if n, ok := parseCanonicalInt(value); ok {
+		return StoreValue{Encoding: IntEncoding, Int: n, ExpiresAt: expiresAt}
+	}
+	if len(value) <= embStrSizeLimit {
+		return StoreValue{Encoding: EmbStrEncoding, Str: value, ExpiresAt: expiresAt}
+	}
+	return StoreValue{Encoding: RawEncoding, Str: value, ExpiresAt: expiresAt}
+}
+
+// parseCanonicalInt only accepts strings that format back to themselves, so
+// "007" or "+1" keep their exact bytes instead of being normalised.
+func parseCanonicalInt(value string) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 {
+		return 0, false
+	}
+	if value[0] == '+' || (value[0] == '0' && len(value) > 1) {
+		return 0, false
+	}
+	if value[0] == '-' && (len(value) == 1 || value[1] == '0') {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func (v StoreValue) String() string {
+	if v.Encoding == IntEncoding {
+		return strconv.FormatInt(v.Int, 10)
+	}
+	return v.Str
+}
+
+func (v StoreValue) Len() int {
+	if v.Encoding != IntEncoding {
+		return len(v.Str)
+	}
+	n := v.Int
+	length := 1
+	if n < 0 {
+		length++
+		if n == math.MinInt64 {
+			return 20
+		}
+		n = -n
+	}
+	for n >= 10 {
+		n /= 10
+		length++
+	}
+	return length
+}
+
+func (v StoreValue) HasExpiry() bool {
+	return v.ExpiresAt != 0
+}
+
+func (e StringEncoding) String() string {
+	switch e {
+	case IntEncoding:
+		return "int"
+	case EmbStrEncoding:
+		return "embstr"
+	default:
+		return "raw"
+	}
 }
 
 type StoreType int
@@ -25,141 +114,172 @@ const (
 	SetType
 	HashType
 	SortedSetType
-)
-
-type BlockingOperationType int
-
-const (
-	BLPop BlockingOperationType = iota
-	BRPop
-	BLMove
+	StreamType
+	JSONType
+	BloomType
+	CuckooType
+	TopKType
+	CMSType
+	TDigestType
+	TimeSeriesType
+	VectorSetType
 )
 
 type InMemoryStore struct {
-	KeyType               map[string]StoreType
-	StringKV              map[string]StoreValue
-	ListKV                map[string]*list.List
-	SetKV                 map[string]map[string]struct{}
-	HashSetKV             map[string]map[string]string
-	BlockedListOperations map[string][]OperationInfo
-	mu                    sync.RWMutex
-}
-
-type OperationInfo struct {
-	Client        chan ListElement
-	OperationType BlockingOperationType
-
-	// for BLMove
-	PopFromLeft bool
-}
-type ListElement struct {
-	key   string
-	value string
+	// SnapshotVersion is saved with every snapshot; see convertLegacySnapshot.
+	SnapshotVersion int
+	KeyType         map[string]StoreType
+	StringKV        map[string]StoreValue
+	ListKV          map[string]*QuickList
+	SetKV           map[string]*Set
+	HashSetKV       map[string]*Hash
+	SortedSetKV     map[string]*SortedSet
+	StreamKV        map[string]*Stream
+	JSONKV          map[string]*JSONValue
+	BloomKV         map[string]*BloomFilter
+	CuckooKV        map[string]*CuckooFilter
+	TopKKV          map[string]*TopK
+	CMSKV           map[string]*CountMinSketch
+	TDigestKV       map[string]*TDigest
+	TimeSeriesKV    map[string]*TimeSeries
+	VectorSetKV     map[string]*VectorSet
+	SearchIndexes   map[string]*SearchIndex
+	config          encodingConfig
+	blockedClients  map[string][]*blockedClient
+	readyKeys       []string
+	readyKeySet     map[string]struct{}
+	inTransaction   bool
+	commandMu       sync.RWMutex
+	mu              sync.RWMutex
 }
 
 func NewInMemoryStore() *InMemoryStore {
 	s := &InMemoryStore{
-		KeyType:               make(map[string]StoreType),
-		StringKV:              make(map[string]StoreValue),
-		ListKV:                make(map[string]*list.List),
-		SetKV:                 make(map[string]map[string]struct{}),
-		HashSetKV:             make(map[string]map[string]string),
-		BlockedListOperations: make(map[string][]OperationInfo),
+		SnapshotVersion: snapshotVersion,
+		KeyType:         make(map[string]StoreType),
+		StringKV:        make(map[string]StoreValue),
+		ListKV:          make(map[string]*QuickList),
+		SetKV:           make(map[string]*Set),
+		HashSetKV:       make(map[string]*Hash),
+		SortedSetKV:     make(map[string]*SortedSet),
+		StreamKV:        make(map[string]*Stream),
+		JSONKV:          make(map[string]*JSONValue),
+		BloomKV:         make(map[string]*BloomFilter),
+		CuckooKV:        make(map[string]*CuckooFilter),
+		TopKKV:          make(map[string]*TopK),
+		CMSKV:           make(map[string]*CountMinSketch),
+		TDigestKV:       make(map[string]*TDigest),
+		TimeSeriesKV:    make(map[string]*TimeSeries),
+		VectorSetKV:     make(map[string]*VectorSet),
+		SearchIndexes:   make(map[string]*SearchIndex),
+		config:          defaultEncodingConfig,
+		blockedClients:  make(map[string][]*blockedClient),
+		readyKeySet:     make(map[string]struct{}),
 	}
 	s.BackgroundKeyCleanup(15000)
 	return s
 }
 
-func NewList() *list.List {
-	return list.New()
+func NewList() *QuickList {
+	return NewQuickList()
+}
+
+func (s *InMemoryStore) LPush(key string, values []string) (int, error) {
+	return s.listPush(key, values, true, false)
 }
 
-func (s *InMemoryStore) LPush(key string, values []string) error {
+func (s *InMemoryStore) RPush(key string, values []string) (int, error) {
+	return s.listPush(key, values, false, false)
+}
+
+func (s *InMemoryStore) LPushX(key string, values []string) (int, error) {
+	return s.listPush(key, values, true, true)
+}
+
+func (s *InMemoryStore) RPushX(key string, values []string) (int, error) {
+	return s.listPush(key, values, false, true)
+}
+
+// listPush returns the length of the list right after the push, before any
+// blocked client has been served from it.
+func (s *InMemoryStore) listPush(key string, values []string, left bool, onlyIfExists bool) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	keyType, ok := s.KeyType[key]
 	if ok && keyType != ListType {
-		return errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+		return 0, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if _, ok := s.ListKV[key]; !ok {
-		s.ListKV[key] = NewList()
-		s.KeyType[key] = ListType
+	if _, ok := s.ListKV[key]; !ok && onlyIfExists {
+		return 0, nil
 	}
 
 	for _, value := range values {
-		s.ListKV[key].PushFront(value)
+		s.listPushLocked(key, value, left)
 	}
+	length := s.ListKV[key].Len()
 
-	if requests, isBlocked := s.BlockedListOperations[key]; isBlocked {
-		opInfo := requests[0]
-		var value string
+	s.serveBlockedClients()
+	return length, nil
+}
 
-		if opInfo.OperationType == BRPop || (opInfo.OperationType == BLMove && !opInfo.PopFromLeft) {
-			value = s.ListKV[key].Remove(s.ListKV[key].Back()).(string)
-		} else if opInfo.OperationType == BLPop || (opInfo.OperationType == BLMove && opInfo.PopFromLeft) {
-			value = s.ListKV[key].Remove(s.ListKV[key].Front()).(string)
-		}
+// listPushLocked and listPopLocked must be called with s.mu held and with the
+// key already known not to hold another type. They create and delete the key
+// as needed and signal clients blocked on it.
+func (s *InMemoryStore) listPushLocked(key string, value string, left bool) {
+	l, ok := s.ListKV[key]
+	if !ok {
+		l = NewList()
+		s.ListKV[key] = l
+		s.KeyType[key] = ListType
+	}
 
-		if s.ListKV[key].Len() == 0 {
-			delete(s.ListKV, key)
-			delete(s.KeyType, key)
-		}
+	if left {
+		l.PushFront(value)
+	} else {
+		l.PushBack(value)
+	}
+	s.signalKeyAsReady(key)
+}
 
-		opInfo.Client <- ListElement{key: key, value: value}
-		if len(requests) > 1 {
-			s.BlockedListOperations[key] = requests[1:]
-		} else {
-			delete(s.BlockedListOperations, key)
-		}
-		return nil
+func (s *InMemoryStore) listPopLocked(key string, left bool) (string, bool) {
+	l, ok := s.ListKV[key]
+	if !ok {
+		return "", false
 	}
 
-	return nil
-}
-func (s *InMemoryStore) RPush(key string, values []string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	keyType, ok := s.KeyType[key]
-	if ok && keyType != ListType {
-		return errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	var value string
+	if left {
+		value, _ = l.PopFront()
+	} else {
+		value, _ = l.PopBack()
 	}
-	if _, ok := s.ListKV[key]; !ok {
-		s.ListKV[key] = NewList()
-		s.KeyType[key] = ListType
+	if l.Len() == 0 {
+		delete(s.ListKV, key)
+		delete(s.KeyType, key)
 	}
+	return value, true
+}
 
-	for _, value := range values {
-		s.ListKV[key].PushBack(value)
+// listMoveLocked pops from source and pushes onto destination, failing with
+// WRONGTYPE before touching anything if either key holds another type.
+func (s *InMemoryStore) listMoveLocked(source string, destination string, leftSrc bool, leftDest bool) (string, bool, error) {
+	if keyType, ok := s.KeyType[source]; ok && keyType != ListType {
+		return "", false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-
-	if requests, isBlocked := s.BlockedListOperations[key]; isBlocked {
-		opInfo := requests[0]
-		var value string
-
-		if opInfo.OperationType == BRPop || (opInfo.OperationType == BLMove && !opInfo.PopFromLeft) {
-			value = s.ListKV[key].Remove(s.ListKV[key].Back()).(string)
-		} else if opInfo.OperationType == BLPop || (opInfo.OperationType == BLMove && opInfo.PopFromLeft) {
-			value = s.ListKV[key].Remove(s.ListKV[key].Front()).(string)
-		}
-
-		if s.ListKV[key].Len() == 0 {
-			delete(s.ListKV, key)
-			delete(s.KeyType, key)
-		}
-
-		opInfo.Client <- ListElement{key: key, value: value}
-		if len(requests) > 1 {
-			s.BlockedListOperations[key] = requests[1:]
-		} else {
-			delete(s.BlockedListOperations, key)
-		}
-		return nil
+	if keyType, ok := s.KeyType[destination]; ok && keyType != ListType {
+		return "", false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	return nil
+	value, ok := s.listPopLocked(source, leftSrc)
+	if !ok {
+		return "", false, nil
+	}
+	s.listPushLocked(destination, value, leftDest)
+	return value, true, nil
 }
+
 func (s *InMemoryStore) LPop(key string) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -167,16 +287,12 @@ func (s *InMemoryStore) LPop(key string) (string, error) {
 	if ok && keyType != ListType {
 		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	if _, ok := s.ListKV[key]; !ok {
-		return "", errors.New("_")
-	}
 
-	value := s.ListKV[key].Remove(s.ListKV[key].Front())
-	if s.ListKV[key].Len() == 0 {
-		delete(s.ListKV, key)
-		delete(s.KeyType, key)
+	value, ok := s.listPopLocked(key, true)
+	if !ok {
+		return "", errors.New("_")
 	}
-	return value.(string), nil
+	return value, nil
 }
 func (s *InMemoryStore) RPop(key string) (string, error) {
 	s.mu.Lock()
@@ -185,186 +301,118 @@ func (s *InMemoryStore) RPop(key string) (string, error) {
 	if ok && keyType != ListType {
 		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	if _, ok := s.ListKV[key]; !ok {
+
+	value, ok := s.listPopLocked(key, false)
+	if !ok {
 		return "", errors.New("_")
 	}
-	value := s.ListKV[key].Remove(s.ListKV[key].Back())
-	if s.ListKV[key].Len() == 0 {
-		delete(s.ListKV, key)
-		delete(s.KeyType, key)
-	}
-	return value.(string), nil
+	return value, nil
 }
-func (s *InMemoryStore) BLPop(keys []string, timeout time.Duration, popFromRight bool) (string, string, error) {
+
+// LMPop pops up to count elements from the first non-empty list among keys.
+func (s *InMemoryStore) LMPop(keys []string, left bool, count int) (string, []string, error) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	for _, key := range keys {
 		keyType, ok := s.KeyType[key]
 		if ok && keyType != ListType {
-			s.mu.Unlock()
-			return "", "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+			return "", nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
 	}
 
 	for _, key := range keys {
-		if _, ok := s.ListKV[key]; ok {
-			var value string
-			if popFromRight {
-				value = s.ListKV[key].Remove(s.ListKV[key].Back()).(string)
-			} else {
-				value = s.ListKV[key].Remove(s.ListKV[key].Front()).(string)
-			}
-			if s.ListKV[key].Len() == 0 {
-				delete(s.ListKV, key)
-				delete(s.KeyType, key)
-			}
-			s.mu.Unlock()
-			return key, value, nil
+		if values := s.listPopManyLocked(key, left, count); len(values) > 0 {
+			return key, values, nil
 		}
 	}
+	return "", nil, nil
+}
 
-	resultChan := make(chan ListElement, 1)
-
-	cleanupFunc := func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-
-		for _, key := range keys {
-			for i, opInfo := range s.BlockedListOperations[key] {
-				if opInfo.Client == resultChan {
-					s.BlockedListOperations[key] = slices.Delete(s.BlockedListOperations[key], i, i+1)
-					break
-				}
-			}
-
-			if len(s.BlockedListOperations[key]) == 0 {
-				delete(s.BlockedListOperations, key)
-			}
+func (s *InMemoryStore) listPopManyLocked(key string, left bool, count int) []string {
+	var values []string
+	for range count {
+		value, ok := s.listPopLocked(key, left)
+		if !ok {
+			break
 		}
+		values = append(values, value)
 	}
+	return values
+}
 
-	var operationType BlockingOperationType
-	if popFromRight {
-		operationType = BRPop
-	} else {
-		operationType = BLPop
-	}
-
-	operationInfo := OperationInfo{
-		Client:        resultChan,
-		OperationType: operationType,
-	}
-	for _, key := range keys {
-		s.BlockedListOperations[key] = append(s.BlockedListOperations[key], operationInfo)
-	}
-
-	s.mu.Unlock()
-	var timer <-chan time.Time
-	if timeout > 0 {
-		timer = time.After(timeout)
-	} else {
-		timer = make(<-chan time.Time)
-	}
-
-	select {
-	case result := <-resultChan:
-		cleanupFunc()
-		return result.key, result.value, nil
-	case <-timer:
-		cleanupFunc()
-		return "", "", nil
+func (s *InMemoryStore) BLPop(ctx context.Context, keys []string, timeout time.Duration, popFromRight bool) (string, string, bool, error) {
+	key, values, ok, err := s.BLMPop(ctx, keys, !popFromRight, 1, timeout)
+	if !ok || err != nil {
+		return "", "", ok, err
 	}
+	return key, values[0], true, nil
 }
 
-func (s *InMemoryStore) BLMove(source string, destination string, leftSrc bool, leftDest bool, timeout time.Duration) (string, error) {
+// BLMPop reports false when the timeout expired before any of the keys could
+// serve the client.
+func (s *InMemoryStore) BLMPop(ctx context.Context, keys []string, left bool, count int, timeout time.Duration) (string, []string, bool, error) {
 	s.mu.Lock()
 
-	srcKeyType, ok := s.KeyType[source]
-	if ok && srcKeyType != ListType {
-		s.mu.Unlock()
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	destKeyType, ok := s.KeyType[destination]
-	if ok && destKeyType != ListType {
-		s.mu.Unlock()
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
-	}
-
-	if _, ok := s.ListKV[destination]; !ok {
-		s.ListKV[destination] = NewList()
-		s.KeyType[destination] = ListType
+	for _, key := range keys {
+		keyType, ok := s.KeyType[key]
+		if ok && keyType != ListType {
+			s.mu.Unlock()
+			return "", nil, false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
 	}
 
-	if _, ok := s.ListKV[source]; ok {
-		var value string
-		if leftSrc {
-			value = s.ListKV[source].Remove(s.ListKV[source].Front()).(string)
-		} else {
-			value = s.ListKV[source].Remove(s.ListKV[source].Back()).(string)
-		}
-		if s.ListKV[source].Len() == 0 {
-			delete(s.ListKV, source)
-			delete(s.KeyType, source)
-		}
-		if leftDest {
-			s.ListKV[destination].PushFront(value)
-		} else {
-			s.ListKV[destination].PushBack(value)
+	for _, key := range keys {
+		if values := s.listPopManyLocked(key, left, count); len(values) > 0 {
+			s.mu.Unlock()
+			return key, values, true, nil
 		}
-
-		s.mu.Unlock()
-		return value, nil
 	}
 
-	resultChan := make(chan ListElement, 1)
-
-	cleanupFunc := func() {
-		s.mu.Lock()
-		defer s.mu.Unlock()
-
-		for i, opInfo := range s.BlockedListOperations[source] {
-			if opInfo.Client == resultChan {
-				s.BlockedListOperations[source] = slices.Delete(s.BlockedListOperations[source], i, i+1)
-				break
-			}
+	var poppedKey string
+	var poppedValues []string
+	served, err := s.blockOn(ctx, keys, timeout, func(key string) bool {
+		if keyType, ok := s.KeyType[key]; !ok || keyType != ListType {
+			return false
 		}
-
-		if len(s.BlockedListOperations[source]) == 0 {
-			delete(s.BlockedListOperations, source)
+		poppedKey = key
+		poppedValues = s.listPopManyLocked(key, left, count)
+		return true
+	}, func() {
+		for i := len(poppedValues) - 1; i >= 0; i-- {
+			s.listPushLocked(poppedKey, poppedValues[i], left)
 		}
+	})
+	if !served {
+		return "", nil, false, err
 	}
-	operationInfo := OperationInfo{
-		Client:        resultChan,
-		OperationType: BLMove,
-		PopFromLeft:   leftSrc,
-	}
-	s.BlockedListOperations[source] = append(s.BlockedListOperations[source], operationInfo)
+	return poppedKey, poppedValues, true, nil
+}
 
-	s.mu.Unlock()
-	var timer <-chan time.Time
-	if timeout > 0 {
-		timer = time.After(timeout)
-	} else {
-		timer = make(<-chan time.Time)
-	}
+// BLMove applies the move atomically when the client is served, so a client
+// cancelled after that point leaves the element in destination rather than
+// losing it.
+func (s *InMemoryStore) BLMove(ctx context.Context, source string, destination string, leftSrc bool, leftDest bool, timeout time.Duration) (string, bool, error) {
+	s.mu.Lock()
 
-	select {
-	case result := <-resultChan:
-		cleanupFunc()
+	value, ok, err := s.listMoveLocked(source, destination, leftSrc, leftDest)
+	if err != nil || ok {
+		s.serveBlockedClients()
+		s.mu.Unlock()
+		return value, ok, err
+	}
 
-		s.mu.Lock()
-		if leftDest {
-			s.ListKV[destination].PushFront(result.value)
-		} else {
-			s.ListKV[destination].PushBack(result.value)
+	served, cancelErr := s.blockOn(ctx, []string{source}, timeout, func(key string) bool {
+		if keyType, ok := s.KeyType[key]; !ok || keyType != ListType {
+			return false
 		}
-		s.mu.Unlock()
-		return result.value, nil
-	case <-timer:
-		cleanupFunc()
-		return "", nil
+		value, ok, err = s.listMoveLocked(source, destination, leftSrc, leftDest)
+		return ok || err != nil
+	}, nil)
+	if !served {
+		return "", false, cancelErr
 	}
+	return value, err == nil, err
 }
 
 func (s *InMemoryStore) LLen(key string) (int, error) {
@@ -392,37 +440,12 @@ func (s *InMemoryStore) LRange(key string, start int, end int) ([]string, error)
 		return nil, nil
 	}
 
-	listLen := s.ListKV[key].Len()
-	if start >= listLen || start > end {
+	start, end, ok = normalizeRange(start, end, s.ListKV[key].Len())
+	if !ok {
 		return nil, nil
 	}
 
-	if start < 0 {
-		start = listLen + start
-	}
-	if end < 0 {
-		end = listLen + end
-	}
-	if end >= listLen {
-		end = listLen - 1
-	}
-
-	var values []string
-	curElement := s.ListKV[key].Front()
-	curIndex := 0
-
-	for curElement != nil && curIndex < start {
-		curElement = curElement.Next()
-		curIndex++
-	}
-
-	for curElement != nil && curIndex <= end {
-		values = append(values, curElement.Value.(string))
-		curElement = curElement.Next()
-		curIndex++
-	}
-
-	return values, nil
+	return s.ListKV[key].Range(start, end), nil
 }
 
 func (s *InMemoryStore) LTrim(key string, start int, end int) error {
@@ -435,35 +458,14 @@ func (s *InMemoryStore) LTrim(key string, start int, end int) error {
 	if _, ok := s.ListKV[key]; !ok {
 		return nil
 	}
-	listLen := s.ListKV[key].Len()
-	if start >= listLen || start > end {
+	start, end, ok = normalizeRange(start, end, s.ListKV[key].Len())
+	if !ok {
 		delete(s.ListKV, key)
 		delete(s.KeyType, key)
 		return nil
 	}
 
-	if start < 0 {
-		start = listLen + start
-	}
-	if end < 0 {
-		end = listLen + end
-	}
-
-	if end >= listLen {
-		end = listLen - 1
-	}
-	for range start {
-		s.ListKV[key].Remove(s.ListKV[key].Front())
-	}
-
-	for i := end + 1; i < listLen; i++ {
-		s.ListKV[key].Remove(s.ListKV[key].Back())
-	}
-	if s.ListKV[key].Len() == 0 {
-		delete(s.ListKV, key)
-		delete(s.KeyType, key)
-	}
-
+	s.ListKV[key].Trim(start, end)
 	return nil
 }
 
@@ -471,89 +473,219 @@ func (s *InMemoryStore) LMove(source string, destination string, leftSrc bool, l
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	srcType, ok := s.KeyType[source]
-	if ok && srcType != ListType {
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	value, ok, err := s.listMoveLocked(source, destination, leftSrc, leftDest)
+	if err != nil {
+		return "", err
 	}
-	destType, ok := s.KeyType[destination]
-	if ok && destType != ListType {
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	if !ok {
+		return "", errors.New("_")
 	}
 
-	if _, ok := s.ListKV[source]; !ok {
-		return "", errors.New("_")
+	s.serveBlockedClients()
+	return value, nil
+}
+
+// normalizeRange resolves negative indexes the way LRANGE and LTRIM do and
+// reports false when the resulting range is empty.
+func normalizeRange(start int, end int, length int) (int, int, bool) {
+	if start < 0 {
+		start = length + start
 	}
-	if _, ok := s.ListKV[destination]; !ok {
-		s.ListKV[destination] = NewList()
-		s.KeyType[destination] = ListType
+	if end < 0 {
+		end = length + end
 	}
-
-	var value string
-	if leftSrc {
-		value = s.ListKV[source].Remove(s.ListKV[source].Front()).(string)
-	} else {
-		value = s.ListKV[source].Remove(s.ListKV[source].Back()).(string)
+	if start < 0 {
+		start = 0
 	}
-
-	if s.ListKV[source].Len() == 0 {
-		delete(s.ListKV, source)
-		delete(s.KeyType, source)
+	if start > end || start >= length {
+		return 0, 0, false
 	}
-
-	if leftDest {
-		s.ListKV[destination].PushFront(value)
-	} else {
-		s.ListKV[destination].PushBack(value)
+	if end >= length {
+		end = length - 1
 	}
-
-	return value, nil
+	return start, end, true
 }
 
-func hasExpired(expiry *time.Time) bool {
-	if expiry == nil {
-		return false
-	}
+func (s *InMemoryStore) LIndex(key string, index int) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	timeLeft := int64(time.Until(*expiry).Seconds())
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != ListType {
+		return "", false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if _, ok := s.ListKV[key]; !ok {
+		return "", false, nil
+	}
 
-	return timeLeft <= 0
+	value, ok := s.ListKV[key].Index(index)
+	return value, ok, nil
 }
 
-func (s *InMemoryStore) StringGet(key string) (StoreValue, bool, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *InMemoryStore) LSet(key string, index int, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	keyType, ok := s.KeyType[key]
-	if !ok {
-		return StoreValue{}, false, nil
+	if ok && keyType != ListType {
+		return errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	if keyType != StringType {
-		return StoreValue{}, false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	if _, ok := s.ListKV[key]; !ok {
+		return errors.New("-ERR no such key")
 	}
-	value, ok := s.StringKV[key]
 
-	if hasExpired(value.Expiry) {
-		delete(s.StringKV, key)
-		delete(s.KeyType, key)
-		return StoreValue{}, false, nil
+	if !s.ListKV[key].Set(index, value) {
+		return errors.New("-ERR index out of range")
 	}
-
-	return value, ok, nil
+	return nil
 }
 
-func (s *InMemoryStore) StringSet(key string, value string, expiry *time.Time, nx bool, xx bool, ttl bool, get bool) (string, error) {
+// LInsert returns the new length of the list, -1 when the pivot is missing and
+// 0 when the key does not exist.
+func (s *InMemoryStore) LInsert(key string, before bool, pivot string, value string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	keyType, ok := s.KeyType[key]
-	if ok && keyType != StringType {
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	if ok && keyType != ListType {
+		return 0, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if _, ok := s.ListKV[key]; !ok {
+		return 0, nil
 	}
 
-	oldVal, ok := s.StringKV[key]
-	if hasExpired(oldVal.Expiry) {
-		delete(s.StringKV, key)
-		delete(s.KeyType, key)
+	l := s.ListKV[key]
+	position := -1
+	l.Each(false, func(index int, element string) bool {
+		if element != pivot {
+			return true
+		}
+		position = index
+		return false
+	})
+	if position == -1 {
+		return -1, nil
+	}
+
+	if !before {
+		position++
+	}
+	l.Insert(position, value)
+	return l.Len(), nil
+}
+
+// LRem removes up to count occurrences of value scanning from the head, from
+// the tail when count is negative, or every occurrence when count is zero.
+func (s *InMemoryStore) LRem(key string, count int, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != ListType {
+		return 0, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if _, ok := s.ListKV[key]; !ok {
+		return 0, nil
+	}
+
+	l := s.ListKV[key]
+	var removed int
+	if count >= 0 {
+		removed = l.RemoveMatching(value, count, false)
+	} else {
+		removed = l.RemoveMatching(value, -count, true)
+	}
+
+	if l.Len() == 0 {
+		delete(s.ListKV, key)
+		delete(s.KeyType, key)
+	}
+	return removed, nil
+}
+
+// LPos returns the head-based indexes of the matches. A negative rank scans
+// from the tail, count 0 returns every match and maxLen 0 compares the whole
+// list.
+func (s *InMemoryStore) LPos(key string, value string, rank int, count int, maxLen int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != ListType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	if _, ok := s.ListKV[key]; !ok {
+		return nil, nil
+	}
+
+	var positions []int
+	skip := rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+
+	compared := 0
+	s.ListKV[key].Each(rank < 0, func(index int, element string) bool {
+		if maxLen != 0 && compared == maxLen {
+			return false
+		}
+		compared++
+		if element != value {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, index)
+		return count == 0 || len(positions) < count
+	})
+	return positions, nil
+}
+
+func hasExpired(expiresAt int64) bool {
+	if expiresAt == 0 {
+		return false
+	}
+
+	return expiresAt <= time.Now().UnixMilli()
+}
+
+func (s *InMemoryStore) StringGet(key string) (StoreValue, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if !ok {
+		return StoreValue{}, false, nil
+	}
+	if keyType != StringType {
+		return StoreValue{}, false, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	value, ok := s.StringKV[key]
+
+	if hasExpired(value.ExpiresAt) {
+		delete(s.StringKV, key)
+		delete(s.KeyType, key)
+		return StoreValue{}, false, nil
+	}
+
+	return value, ok, nil
+}
+
+func (s *InMemoryStore) StringSet(key string, value string, expiresAt int64, nx bool, xx bool, ttl bool, get bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != StringType {
+		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	oldVal, ok := s.StringKV[key]
+	if hasExpired(oldVal.ExpiresAt) {
+		delete(s.StringKV, key)
+		delete(s.KeyType, key)
 		ok = false
 	}
 	if nx && ok {
@@ -566,17 +698,18 @@ func (s *InMemoryStore) StringSet(key string, value string, expiry *time.Time, n
 
 	if ttl {
 		if !ok {
-			expiry = nil
+			expiresAt = 0
 		} else {
-			expiry = oldVal.Expiry
+			expiresAt = oldVal.ExpiresAt
 		}
 	}
 
-	s.StringKV[key] = StoreValue{Value: value, Expiry: expiry}
+	s.StringKV[key] = newStringValue(value, expiresAt)
 	s.KeyType[key] = StringType
 	if get {
 		if ok {
-			return fmt.Sprintf("$%d\r\n%s\r\n", len(oldVal.Value), oldVal.Value), nil
+			oldStr := oldVal.String()
+			return fmt.Sprintf("$%d\r\n%s\r\n", len(oldStr), oldStr), nil
 		} else {
 			return "_\r\n", nil
 		}
@@ -590,6 +723,9 @@ func (s *InMemoryStore) GetKeys(pattern string) []string {
 	defer s.mu.Unlock()
 	var matchedKeys []string
 	for k := range s.KeyType {
+		if !s.keyExistsLocked(k) {
+			continue
+		}
 		if match, _ := regexp.MatchString(pattern, k); match {
 			matchedKeys = append(matchedKeys, k)
 		}
@@ -609,32 +745,146 @@ func (s *InMemoryStore) Increment(key string, by int64) (int64, error) {
 
 	storeValue, ok := s.StringKV[key]
 
-	if !ok {
-		s.StringKV[key] = StoreValue{Value: fmt.Sprintf("%d", by)}
-		s.KeyType[key] = StringType
-		return by, nil
-	}
-
-	if hasExpired(storeValue.Expiry) {
-		delete(s.StringKV, key)
-		delete(s.KeyType, key)
-		s.StringKV[key] = StoreValue{Value: fmt.Sprintf("%d", by)}
+	if !ok || hasExpired(storeValue.ExpiresAt) {
+		s.StringKV[key] = StoreValue{Encoding: IntEncoding, Int: by}
 		s.KeyType[key] = StringType
 		return by, nil
 	}
 
-	value, err := strconv.ParseInt(storeValue.Value, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("-ERR value is not an integer or out of range")
+	if storeValue.Encoding != IntEncoding {
+		value, ok := parseCanonicalInt(storeValue.Str)
+		if !ok {
+			return 0, fmt.Errorf("-ERR value is not an integer or out of range")
+		}
+		storeValue = StoreValue{Encoding: IntEncoding, Int: value, ExpiresAt: storeValue.ExpiresAt}
 	}
 
+	value := storeValue.Int
 	if (by > 0 && value > math.MaxInt64-by) || (by < 0 && value < math.MinInt64-by) {
 		return 0, fmt.Errorf("-ERR increment or decrement would overflow")
 	}
 
-	value += by
-	s.StringKV[key] = StoreValue{Value: fmt.Sprintf("%d", value), Expiry: storeValue.Expiry}
-	return value, nil
+	storeValue.Int = value + by
+	s.StringKV[key] = storeValue
+	return storeValue.Int, nil
+}
+
+// Append always leaves a raw value behind, as Redis does, since the result is
+// assumed to keep growing.
+func (s *InMemoryStore) Append(key string, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != StringType {
+		return 0, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	storeValue, ok := s.StringKV[key]
+	if !ok || hasExpired(storeValue.ExpiresAt) {
+		storeValue = StoreValue{}
+	}
+
+	storeValue = StoreValue{Encoding: RawEncoding, Str: storeValue.String() + value, ExpiresAt: storeValue.ExpiresAt}
+	s.StringKV[key] = storeValue
+	s.KeyType[key] = StringType
+	return len(storeValue.Str), nil
+}
+
+func (s *InMemoryStore) ObjectEncoding(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.keyExistsLocked(key) {
+		return "", false
+	}
+
+	switch s.KeyType[key] {
+	case StringType:
+		return s.StringKV[key].Encoding.String(), true
+	case ListType:
+		return s.ListKV[key].Encoding(), true
+	case SetType:
+		return s.SetKV[key].Encoding(), true
+	case HashType:
+		return s.HashSetKV[key].Encoding(), true
+	case SortedSetType:
+		return s.SortedSetKV[key].Encoding(), true
+	case StreamType:
+		return s.StreamKV[key].Encoding(), true
+	case JSONType:
+		return s.JSONKV[key].Encoding(), true
+	case BloomType:
+		return s.BloomKV[key].Encoding(), true
+	case CuckooType:
+		return s.CuckooKV[key].Encoding(), true
+	case TopKType:
+		return s.TopKKV[key].Encoding(), true
+	case CMSType:
+		return s.CMSKV[key].Encoding(), true
+	case TDigestType:
+		return s.TDigestKV[key].Encoding(), true
+	case TimeSeriesType:
+		return s.TimeSeriesKV[key].Encoding(), true
+	case VectorSetType:
+		return s.VectorSetKV[key].Encoding(), true
+	}
+	return "", false
+}
+
+// keyExistsLocked reports whether key holds a value. An expired string, or a
+// hash whose fields have all expired, is deleted first and reported missing.
+func (s *InMemoryStore) keyExistsLocked(key string) bool {
+	switch keyType, ok := s.KeyType[key]; {
+	case !ok:
+		return false
+	case keyType == StringType:
+		if hasExpired(s.StringKV[key].ExpiresAt) {
+			s.deleteKeyLocked(key)
+			return false
+		}
+	case keyType == HashType:
+		hash, _ := s.hashLocked(key)
+		return hash != nil
+	}
+	return true
+}
+
+// deleteKeyLocked removes key whatever type it holds.
+func (s *InMemoryStore) deleteKeyLocked(key string) {
+	switch s.KeyType[key] {
+	case StringType:
+		delete(s.StringKV, key)
+	case ListType:
+		delete(s.ListKV, key)
+	case SetType:
+		delete(s.SetKV, key)
+	case HashType:
+		delete(s.HashSetKV, key)
+		s.indexHashLocked(key)
+	case SortedSetType:
+		delete(s.SortedSetKV, key)
+	case StreamType:
+		delete(s.StreamKV, key)
+	case JSONType:
+		delete(s.JSONKV, key)
+	case BloomType:
+		delete(s.BloomKV, key)
+	case CuckooType:
+		delete(s.CuckooKV, key)
+	case TopKType:
+		delete(s.TopKKV, key)
+	case CMSType:
+		delete(s.CMSKV, key)
+	case TDigestType:
+		delete(s.TDigestKV, key)
+	case TimeSeriesType:
+		s.unlinkTimeSeriesLocked(key)
+		delete(s.TimeSeriesKV, key)
+	case VectorSetType:
+		delete(s.VectorSetKV, key)
+	}
+	delete(s.KeyType, key)
 }
 
 func (s *InMemoryStore) NumKeyExists(keys []string, shouldDelete bool) int {
@@ -644,14 +894,9 @@ func (s *InMemoryStore) NumKeyExists(keys []string, shouldDelete bool) int {
 	count := 0
 
 	for _, k := range keys {
-		if keyType, ok := s.KeyType[k]; ok {
+		if s.keyExistsLocked(k) {
 			if shouldDelete {
-				if keyType == StringType {
-					delete(s.StringKV, k)
-				} else if keyType == ListType {
-					delete(s.ListKV, k)
-				}
-				delete(s.KeyType, k)
+				s.deleteKeyLocked(k)
 			}
 			count++
 		}
@@ -670,12 +915,11 @@ func (s *InMemoryStore) SAdd(key string, value string) (int, error) {
 	}
 
 	if _, ok := s.SetKV[key]; !ok {
-		s.SetKV[key] = make(map[string]struct{})
+		s.SetKV[key] = NewSet()
 		s.KeyType[key] = SetType
 	}
 
-	if _, ok := s.SetKV[key][value]; !ok {
-		s.SetKV[key][value] = struct{}{}
+	if s.SetKV[key].Add(value, s.config.setMaxIntsetEntries) {
 		return 1, nil
 	}
 	return 0, nil
@@ -693,9 +937,8 @@ func (s *InMemoryStore) SRem(key string, value string) (int, error) {
 		return 0, nil
 	}
 
-	if _, ok := s.SetKV[key][value]; ok {
-		delete(s.SetKV[key], value)
-		if len(s.SetKV[key]) == 0 {
+	if s.SetKV[key].Remove(value) {
+		if s.SetKV[key].Len() == 0 {
 			delete(s.SetKV, key)
 			delete(s.KeyType, key)
 		}
@@ -717,40 +960,187 @@ func (s *InMemoryStore) SIsMember(key string, value string) (int, error) {
 		return 0, nil
 	}
 
-	if _, ok := s.SetKV[key][value]; ok {
+	if s.SetKV[key].Contains(value) {
 		return 1, nil
 	}
 	return 0, nil
 }
 
-func (s *InMemoryStore) SInter(keys []string) ([]string, error) {
+func (s *InMemoryStore) SMIsMember(key string, values []string) ([]int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	elementsFreq := make(map[string]int)
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != SetType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
 
-	for _, key := range keys {
+	set := s.SetKV[key]
+	result := make([]int, len(values))
+	for i, value := range values {
+		if set != nil && set.Contains(value) {
+			result[i] = 1
+		}
+	}
+	return result, nil
+}
+
+// setsLocked looks up the sets stored at keys, using nil for missing keys.
+func (s *InMemoryStore) setsLocked(keys []string) ([]*Set, error) {
+	sets := make([]*Set, len(keys))
+	for i, key := range keys {
 		keyType, ok := s.KeyType[key]
 		if ok && keyType != SetType {
 			return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 		}
+		sets[i] = s.SetKV[key]
+	}
+	return sets, nil
+}
 
-		if _, ok := s.SetKV[key]; !ok {
-			return nil, nil
+func setLen(set *Set) int {
+	if set == nil {
+		return 0
+	}
+	return set.Len()
+}
+
+// setInter walks the smallest set and probes the others, stopping once limit
+// members have been found. A limit of 0 means no limit.
+func setInter(sets []*Set, limit int) []string {
+	sets = slices.Clone(sets)
+	slices.SortFunc(sets, func(a, b *Set) int {
+		return setLen(a) - setLen(b)
+	})
+	if len(sets) == 0 || setLen(sets[0]) == 0 {
+		return nil
+	}
+
+	var result []string
+	sets[0].Each(func(element string) bool {
+		for _, set := range sets[1:] {
+			if !set.Contains(element) {
+				return true
+			}
 		}
+		result = append(result, element)
+		return limit == 0 || len(result) < limit
+	})
+	return result
+}
 
-		for element := range s.SetKV[key] {
-			elementsFreq[element]++
+func setUnion(sets []*Set) []string {
+	elements := make(map[string]struct{})
+	var result []string
+	for _, set := range sets {
+		if set == nil {
+			continue
 		}
+		set.Each(func(element string) bool {
+			if _, ok := elements[element]; !ok {
+				elements[element] = struct{}{}
+				result = append(result, element)
+			}
+			return true
+		})
+	}
+	return result
+}
+
+func setDiff(sets []*Set) []string {
+	if sets[0] == nil {
+		return nil
 	}
 
 	var result []string
-	for element, freq := range elementsFreq {
-		if freq == len(keys) {
-			result = append(result, element)
+	sets[0].Each(func(element string) bool {
+		for _, set := range sets[1:] {
+			if set != nil && set.Contains(element) {
+				return true
+			}
 		}
+		result = append(result, element)
+		return true
+	})
+	return result
+}
+
+// storeSetLocked replaces whatever is stored at key with a set of members,
+// deleting the key when there are none.
+func (s *InMemoryStore) storeSetLocked(key string, members []string) int {
+	s.deleteKeyLocked(key)
+	if len(members) == 0 {
+		return 0
 	}
-	return result, nil
+
+	set := NewSet()
+	for _, member := range members {
+		set.Add(member, s.config.setMaxIntsetEntries)
+	}
+	s.SetKV[key] = set
+	s.KeyType[key] = SetType
+	return set.Len()
+}
+
+func (s *InMemoryStore) setAlgebra(keys []string, op func([]*Set) []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sets, err := s.setsLocked(keys)
+	if err != nil {
+		return nil, err
+	}
+	return op(sets), nil
+}
+
+func (s *InMemoryStore) setAlgebraStore(destination string, keys []string, op func([]*Set) []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sets, err := s.setsLocked(keys)
+	if err != nil {
+		return 0, err
+	}
+	return s.storeSetLocked(destination, op(sets)), nil
+}
+
+func setInterAll(sets []*Set) []string {
+	return setInter(sets, 0)
+}
+
+func (s *InMemoryStore) SInter(keys []string) ([]string, error) {
+	return s.setAlgebra(keys, setInterAll)
+}
+
+func (s *InMemoryStore) SInterStore(destination string, keys []string) (int, error) {
+	return s.setAlgebraStore(destination, keys, setInterAll)
+}
+
+func (s *InMemoryStore) SInterCard(keys []string, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sets, err := s.setsLocked(keys)
+	if err != nil {
+		return 0, err
+	}
+	return len(setInter(sets, limit)), nil
+}
+
+func (s *InMemoryStore) SUnion(keys []string) ([]string, error) {
+	return s.setAlgebra(keys, setUnion)
+}
+
+func (s *InMemoryStore) SUnionStore(destination string, keys []string) (int, error) {
+	return s.setAlgebraStore(destination, keys, setUnion)
+}
+
+func (s *InMemoryStore) SDiff(keys []string) ([]string, error) {
+	return s.setAlgebra(keys, setDiff)
+}
+
+func (s *InMemoryStore) SDiffStore(destination string, keys []string) (int, error) {
+	return s.setAlgebraStore(destination, keys, setDiff)
 }
 
 func (s *InMemoryStore) SCard(key string) (int, error) {
@@ -766,7 +1156,7 @@ func (s *InMemoryStore) SCard(key string) (int, error) {
 		return 0, nil
 	}
 
-	return len(s.SetKV[key]), nil
+	return s.SetKV[key].Len(), nil
 }
 
 func (s *InMemoryStore) SMembers(key string) ([]string, error) {
@@ -782,39 +1172,7 @@ func (s *InMemoryStore) SMembers(key string) ([]string, error) {
 		return nil, nil
 	}
 
-	var members []string
-	for member := range s.SetKV[key] {
-		members = append(members, member)
-	}
-	return members, nil
-}
-
-func (s *InMemoryStore) SUnion(keys []string) ([]string, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	elements := make(map[string]struct{})
-
-	for _, key := range keys {
-		keyType, ok := s.KeyType[key]
-		if ok && keyType != SetType {
-			return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-
-		if _, ok := s.SetKV[key]; !ok {
-			continue
-		}
-
-		for element := range s.SetKV[key] {
-			elements[element] = struct{}{}
-		}
-	}
-
-	var result []string
-	for element := range elements {
-		result = append(result, element)
-	}
-	return result, nil
+	return s.SetKV[key].Members(), nil
 }
 
 func (s *InMemoryStore) SMove(source string, destination string, value string) (int, error) {
@@ -835,81 +1193,491 @@ func (s *InMemoryStore) SMove(source string, destination string, value string) (
 		return 0, nil
 	}
 
+	if !s.SetKV[source].Remove(value) {
+		return 0, nil
+	}
+	if s.SetKV[source].Len() == 0 {
+		delete(s.SetKV, source)
+		delete(s.KeyType, source)
+	}
+
 	if _, ok := s.SetKV[destination]; !ok {
-		s.SetKV[destination] = make(map[string]struct{})
+		s.SetKV[destination] = NewSet()
 		s.KeyType[destination] = SetType
 	}
+	s.SetKV[destination].Add(value, s.config.setMaxIntsetEntries)
+	return 1, nil
+}
 
-	if _, ok := s.SetKV[source][value]; ok {
-		delete(s.SetKV[source], value)
-		s.SetKV[destination][value] = struct{}{}
-		return 1, nil
+// SRandMember returns count random members; see Set.Random for how the sign
+// of count is interpreted.
+func (s *InMemoryStore) SRandMember(key string, count int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != SetType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	return 0, nil
+
+	if _, ok := s.SetKV[key]; !ok || count == 0 {
+		return nil, nil
+	}
+
+	return s.SetKV[key].Random(count), nil
 }
 
-func (s *InMemoryStore) SPop(key string) (string, error) {
+// SPop removes up to count members picked uniformly at random.
+func (s *InMemoryStore) SPop(key string, count int) ([]string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	keyType, ok := s.KeyType[key]
 	if ok && keyType != SetType {
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
 	if _, ok := s.SetKV[key]; !ok {
-		return "", nil
+		return nil, nil
 	}
 
-	for element := range s.SetKV[key] {
-		delete(s.SetKV[key], element)
-		if len(s.SetKV[key]) == 0 {
-			delete(s.SetKV, key)
-			delete(s.KeyType, key)
-		}
-		return element, nil
+	members := s.SetKV[key].Pop(count)
+	if s.SetKV[key].Len() == 0 {
+		delete(s.SetKV, key)
+		delete(s.KeyType, key)
 	}
-	return "", nil
+	return members, nil
 }
 
 func (s *InMemoryStore) HSet(key string, field string, value string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if hash == nil {
+		hash = NewHash()
+		s.HashSetKV[key] = hash
+		s.KeyType[key] = HashType
+	}
+
+	added := hash.Set(field, value, s.config.hashMaxListpackEntries, s.config.hashMaxListpackValue)
+	s.indexHashLocked(key)
+	if added {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// hashLocked returns the hash stored at key, or nil if there is none. Expired
+// fields are deleted first, and with them the key if no field is left.
+func (s *InMemoryStore) hashLocked(key string) (*Hash, error) {
 	keyType, ok := s.KeyType[key]
 	if ok && keyType != HashType {
-		return 0, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if _, ok := s.HashSetKV[key]; !ok {
-		s.HashSetKV[key] = make(map[string]string)
+	hash := s.HashSetKV[key]
+	if hash != nil && hash.expire() > 0 {
+		s.hashChangedLocked(key, hash)
+		if hash.Len() == 0 {
+			return nil, nil
+		}
+	}
+	return hash, nil
+}
+
+// hashChangedLocked removes key once its hash has no fields left, and
+// otherwise updates the search indexes covering it.
+func (s *InMemoryStore) hashChangedLocked(key string, hash *Hash) {
+	if hash == nil {
+		return
+	}
+	if hash.Len() == 0 {
+		s.deleteKeyLocked(key)
+		return
+	}
+	s.indexHashLocked(key)
+}
+
+func (s *InMemoryStore) HSetNX(key string, field string, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return false, err
+	}
+
+	if hash == nil {
+		hash = NewHash()
+		s.HashSetKV[key] = hash
 		s.KeyType[key] = HashType
-		s.HashSetKV[key][field] = value
-		return 1, nil
+	} else if _, ok := hash.Get(field); ok {
+		return false, nil
 	}
 
-	s.HashSetKV[key][field] = value
-	return 0, nil
+	hash.Set(field, value, s.config.hashMaxListpackEntries, s.config.hashMaxListpackValue)
+	s.indexHashLocked(key)
+	return true, nil
 }
 
-func (s *InMemoryStore) HGet(key string, field string) (string, error) {
+// HGet reports whether field exists, so an empty value can be told apart from
+// a missing field.
+func (s *InMemoryStore) HGet(key string, field string) (string, bool, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	keyType, ok := s.KeyType[key]
-	if ok && keyType != HashType {
-		return "", errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return "", false, err
 	}
 
-	if _, ok := s.HashSetKV[key]; !ok {
-		return "", nil
+	value, ok := hash.Get(field)
+	return value, ok, nil
+}
+
+// HMGet returns the value of each field and whether it exists.
+func (s *InMemoryStore) HMGet(key string, fields []string) ([]string, []bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	value, ok := s.HashSetKV[key][field]
-	if !ok {
-		return "", nil
+	values := make([]string, len(fields))
+	exists := make([]bool, len(fields))
+	if hash != nil {
+		for i, field := range fields {
+			values[i], exists[i] = hash.Get(field)
+		}
 	}
-	return value, nil
+	return values, exists, nil
+}
+
+// HDel deletes the key once its last field is gone.
+func (s *InMemoryStore) HDel(key string, fields []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, field := range fields {
+		if hash.Delete(field) {
+			deleted++
+		}
+	}
+	s.hashChangedLocked(key, hash)
+	return deleted, nil
+}
+
+func (s *InMemoryStore) HGetAll(key string) ([]string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return nil, nil, err
+	}
+
+	fields := make([]string, 0, hash.Len())
+	values := make([]string, 0, hash.Len())
+	hash.Each(func(field string, value string) bool {
+		fields = append(fields, field)
+		values = append(values, value)
+		return true
+	})
+	return fields, values, nil
+}
+
+func (s *InMemoryStore) HLen(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	return hash.Len(), nil
+}
+
+func (s *InMemoryStore) HExists(key string, field string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return false, err
+	}
+
+	_, ok := hash.Get(field)
+	return ok, nil
+}
+
+func (s *InMemoryStore) HStrLen(key string, field string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+
+	value, _ := hash.Get(field)
+	return len(value), nil
+}
+
+// HRandField returns count random fields and their values; see Set.Random for
+// how the sign of count is interpreted.
+func (s *InMemoryStore) HRandField(key string, count int) ([]string, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil || hash == nil || count == 0 {
+		return nil, nil, err
+	}
+
+	fields, values := hash.Random(count)
+	return fields, values, nil
+}
+
+// HExpire sets the expiry of each field to expiresAt, in unix milliseconds,
+// subject to condition (NX, XX, GT, LT or ""). For every field it returns -2
+// if the field does not exist, 0 if the condition was not met, 1 if the
+// expiry was set and 2 if expiresAt has already passed and the field was
+// deleted.
+func (s *InMemoryStore) HExpire(key string, expiresAt int64, condition string, fields []string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			results[i] = -2
+			continue
+		}
+		if _, ok := hash.Get(field); !ok {
+			results[i] = -2
+			continue
+		}
+
+		current := hash.Expiry(field)
+		switch condition {
+		case "NX":
+			if current != 0 {
+				continue
+			}
+		case "XX":
+			if current == 0 {
+				continue
+			}
+		case "GT":
+			if current == 0 || expiresAt <= current {
+				continue
+			}
+		case "LT":
+			if current != 0 && expiresAt >= current {
+				continue
+			}
+		}
+
+		if expiresAt <= time.Now().UnixMilli() {
+			hash.Delete(field)
+			results[i] = 2
+			continue
+		}
+		hash.SetExpiry(field, expiresAt)
+		results[i] = 1
+	}
+	s.hashChangedLocked(key, hash)
+	return results, nil
+}
+
+// HPTTL returns the remaining time to live of each field in milliseconds, -1
+// for a field without a TTL and -2 for a missing field.
+func (s *InMemoryStore) HPTTL(key string, fields []string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int64, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			results[i] = -2
+			continue
+		}
+		if _, ok := hash.Get(field); !ok {
+			results[i] = -2
+			continue
+		}
+
+		expiresAt := hash.Expiry(field)
+		if expiresAt == 0 {
+			results[i] = -1
+			continue
+		}
+		results[i] = max(expiresAt-time.Now().UnixMilli(), 0)
+	}
+	return results, nil
+}
+
+// HPersist removes the TTL of each field, returning -2 for a missing field,
+// -1 for a field without a TTL and 1 otherwise.
+func (s *InMemoryStore) HPersist(key string, fields []string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int, len(fields))
+	for i, field := range fields {
+		if hash == nil {
+			results[i] = -2
+			continue
+		}
+		if _, ok := hash.Get(field); !ok {
+			results[i] = -2
+			continue
+		}
+		if hash.Expiry(field) == 0 {
+			results[i] = -1
+			continue
+		}
+		hash.SetExpiry(field, 0)
+		results[i] = 1
+	}
+	return results, nil
+}
+
+// HGetEx returns the value of each field like HMGet and then sets the expiry
+// of the fields that exist to expiresAt, or removes it if persist is set. An
+// expiresAt of 0 leaves the TTLs untouched.
+func (s *InMemoryStore) HGetEx(key string, fields []string, expiresAt int64, persist bool) ([]string, []bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]string, len(fields))
+	exists := make([]bool, len(fields))
+	if hash == nil {
+		return values, exists, nil
+	}
+
+	expired := expiresAt != 0 && expiresAt <= time.Now().UnixMilli()
+	for i, field := range fields {
+		values[i], exists[i] = hash.Get(field)
+		if !exists[i] {
+			continue
+		}
+
+		switch {
+		case persist:
+			hash.SetExpiry(field, 0)
+		case expired:
+			hash.Delete(field)
+		case expiresAt != 0:
+			hash.SetExpiry(field, expiresAt)
+		}
+	}
+	s.hashChangedLocked(key, hash)
+	return values, exists, nil
+}
+
+// HSetEx sets every field to its value and expiry, reporting false without
+// changing anything if condition FNX finds a field that exists or FXX one
+// that does not. An expiresAt of 0 removes the TTLs unless keepTTL is set.
+func (s *InMemoryStore) HSetEx(key string, fields []string, values []string, condition string, expiresAt int64, keepTTL bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return false, err
+	}
+
+	for _, field := range fields {
+		exists := false
+		if hash != nil {
+			_, exists = hash.Get(field)
+		}
+		if (condition == "FNX" && exists) || (condition == "FXX" && !exists) {
+			return false, nil
+		}
+	}
+
+	if hash == nil {
+		hash = NewHash()
+		s.HashSetKV[key] = hash
+		s.KeyType[key] = HashType
+	}
+
+	expired := expiresAt != 0 && expiresAt <= time.Now().UnixMilli()
+	for i, field := range fields {
+		previous := hash.Expiry(field)
+		hash.Set(field, values[i], s.config.hashMaxListpackEntries, s.config.hashMaxListpackValue)
+
+		switch {
+		case expired:
+			hash.Delete(field)
+		case expiresAt != 0:
+			hash.SetExpiry(field, expiresAt)
+		case keepTTL:
+			hash.SetExpiry(field, previous)
+		}
+	}
+	s.hashChangedLocked(key, hash)
+	return true, nil
+}
+
+// HGetDel returns the value of each field like HMGet and deletes the fields,
+// deleting the key once its last field is gone.
+func (s *InMemoryStore) HGetDel(key string, fields []string) ([]string, []bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := s.hashLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]string, len(fields))
+	exists := make([]bool, len(fields))
+	if hash == nil {
+		return values, exists, nil
+	}
+
+	for i, field := range fields {
+		values[i], exists[i] = hash.Get(field)
+		hash.Delete(field)
+	}
+	s.hashChangedLocked(key, hash)
+	return values, exists, nil
 }
 
 func (s *InMemoryStore) BackgroundKeyCleanup(sleepTime time.Duration) {
@@ -917,14 +1685,21 @@ func (s *InMemoryStore) BackgroundKeyCleanup(sleepTime time.Duration) {
 		for {
 			time.Sleep(sleepTime * time.Millisecond)
 
+			s.commandMu.RLock()
 			s.mu.Lock()
 			for k, v := range s.StringKV {
-				if hasExpired(v.Expiry) {
+				if hasExpired(v.ExpiresAt) {
 					delete(s.KeyType, k)
 					delete(s.StringKV, k)
 				}
 			}
+			for k, hash := range s.HashSetKV {
+				if hash.expire() > 0 {
+					s.hashChangedLocked(k, hash)
+				}
+			}
 			s.mu.Unlock()
+			s.commandMu.RUnlock()
 		}
 	}()
 }
@@ -0,0 +1,636 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+var errStreamIDTooSmall = errors.New("-ERR The ID specified in XADD is equal or smaller than the target stream top item")
+
+// StreamID is a stream entry ID: a millisecond timestamp and a sequence
+// number distinguishing entries added within the same millisecond.
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID sorts after every other ID.
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+// Next returns the smallest ID greater than id, or false if id is the
+// largest possible ID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID smaller than id, or false if id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry holds the field-value pairs of an entry flattened into Fields.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// Stream keeps its entries in chunks of at most stream-node-max-entries
+// entries, ordered by ID, standing in for the radix tree of listpacks Redis
+// uses. Appends only ever touch the last chunk, lookups binary search the
+// chunks and then the entries within one, and trimming from the head can drop
+// whole chunks at once.
+type Stream struct {
+	chunks       [][]StreamEntry
+	length       int
+	lastID       StreamID
+	maxDeletedID StreamID
+	entriesAdded uint64
+	groups       map[string]*streamGroup
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*streamGroup)}
+}
+
+func (stream *Stream) Len() int {
+	return stream.length
+}
+
+func (stream *Stream) Encoding() string {
+	return "stream"
+}
+
+func (stream *Stream) LastID() StreamID {
+	return stream.lastID
+}
+
+func (stream *Stream) first() (StreamEntry, bool) {
+	if stream.length == 0 {
+		return StreamEntry{}, false
+	}
+	return stream.chunks[0][0], true
+}
+
+func (stream *Stream) last() (StreamEntry, bool) {
+	if stream.length == 0 {
+		return StreamEntry{}, false
+	}
+	chunk := stream.chunks[len(stream.chunks)-1]
+	return chunk[len(chunk)-1], true
+}
+
+// append assumes entry's ID is greater than every ID in the stream.
+func (stream *Stream) append(entry StreamEntry, maxChunkEntries int) {
+	n := len(stream.chunks)
+	if n == 0 || len(stream.chunks[n-1]) >= max(maxChunkEntries, 1) {
+		stream.chunks = append(stream.chunks, nil)
+		n++
+	}
+	stream.chunks[n-1] = append(stream.chunks[n-1], entry)
+	stream.length++
+	stream.lastID = entry.ID
+	stream.entriesAdded++
+}
+
+// Each calls fn for the entries with IDs between start and end inclusive,
+// in descending order when reverse is set, until it returns false.
+func (stream *Stream) Each(start StreamID, end StreamID, reverse bool, fn func(entry StreamEntry) bool) {
+	if start.Compare(end) > 0 {
+		return
+	}
+
+	if !reverse {
+		i := sort.Search(len(stream.chunks), func(i int) bool {
+			chunk := stream.chunks[i]
+			return chunk[len(chunk)-1].ID.Compare(start) >= 0
+		})
+		for ; i < len(stream.chunks); i++ {
+			chunk := stream.chunks[i]
+			j := sort.Search(len(chunk), func(j int) bool { return chunk[j].ID.Compare(start) >= 0 })
+			for ; j < len(chunk); j++ {
+				if chunk[j].ID.Compare(end) > 0 || !fn(chunk[j]) {
+					return
+				}
+			}
+		}
+		return
+	}
+
+	i := sort.Search(len(stream.chunks), func(i int) bool {
+		return stream.chunks[i][0].ID.Compare(end) > 0
+	}) - 1
+	for ; i >= 0; i-- {
+		chunk := stream.chunks[i]
+		j := sort.Search(len(chunk), func(j int) bool { return chunk[j].ID.Compare(end) > 0 }) - 1
+		for ; j >= 0; j-- {
+			if chunk[j].ID.Compare(start) < 0 || !fn(chunk[j]) {
+				return
+			}
+		}
+	}
+}
+
+// Range returns up to count entries between start and end inclusive; a
+// negative count returns all of them.
+func (stream *Stream) Range(start StreamID, end StreamID, reverse bool, count int) []StreamEntry {
+	var entries []StreamEntry
+	if count == 0 {
+		return entries
+	}
+	stream.Each(start, end, reverse, func(entry StreamEntry) bool {
+		entries = append(entries, entry)
+		return count < 0 || len(entries) < count
+	})
+	return entries
+}
+
+// find returns the chunk and the position within it of the entry with id.
+func (stream *Stream) find(id StreamID) (int, int, bool) {
+	i := sort.Search(len(stream.chunks), func(i int) bool {
+		chunk := stream.chunks[i]
+		return chunk[len(chunk)-1].ID.Compare(id) >= 0
+	})
+	if i == len(stream.chunks) {
+		return 0, 0, false
+	}
+	chunk := stream.chunks[i]
+	j := sort.Search(len(chunk), func(j int) bool { return chunk[j].ID.Compare(id) >= 0 })
+	return i, j, chunk[j].ID == id
+}
+
+func (stream *Stream) Get(id StreamID) (StreamEntry, bool) {
+	i, j, ok := stream.find(id)
+	if !ok {
+		return StreamEntry{}, false
+	}
+	return stream.chunks[i][j], true
+}
+
+func (stream *Stream) Delete(id StreamID) bool {
+	i, j, ok := stream.find(id)
+	if !ok {
+		return false
+	}
+	chunk := stream.chunks[i]
+
+	if len(chunk) == 1 {
+		stream.chunks = append(stream.chunks[:i], stream.chunks[i+1:]...)
+	} else {
+		stream.chunks[i] = append(chunk[:j], chunk[j+1:]...)
+	}
+	stream.length--
+	if id.Compare(stream.maxDeletedID) > 0 {
+		stream.maxDeletedID = id
+	}
+	return true
+}
+
+// StreamTrimStrategy selects what XTRIM and XADD trim by.
+type StreamTrimStrategy int
+
+const (
+	StreamTrimNone StreamTrimStrategy = iota
+	StreamTrimMaxLen
+	StreamTrimMinID
+)
+
+// StreamTrim is a MAXLEN or MINID trimming request. An approximate trim only
+// removes whole chunks, and at most Limit entries unless Limit is 0.
+type StreamTrim struct {
+	Strategy StreamTrimStrategy
+	MaxLen   int
+	MinID    StreamID
+	Approx   bool
+	Limit    int
+}
+
+// trimmable reports whether the entry at the head of the stream, or the
+// entire first chunk for an approximate trim, is past the threshold.
+func (stream *Stream) trimmable(trim StreamTrim) bool {
+	chunk := stream.chunks[0]
+	if trim.Strategy == StreamTrimMaxLen {
+		if trim.Approx {
+			return stream.length-len(chunk) >= trim.MaxLen
+		}
+		return stream.length > trim.MaxLen
+	}
+	if trim.Approx {
+		return chunk[len(chunk)-1].ID.Compare(trim.MinID) < 0
+	}
+	return chunk[0].ID.Compare(trim.MinID) < 0
+}
+
+// Trim evicts entries from the head of the stream and returns how many.
+func (stream *Stream) Trim(trim StreamTrim) int {
+	if trim.Strategy == StreamTrimNone {
+		return 0
+	}
+
+	removed := 0
+	for stream.length > 0 && stream.trimmable(trim) {
+		chunk := stream.chunks[0]
+		if trim.Approx {
+			if trim.Limit > 0 && removed+len(chunk) > trim.Limit {
+				break
+			}
+			stream.chunks = stream.chunks[1:]
+			stream.length -= len(chunk)
+			removed += len(chunk)
+			continue
+		}
+
+		if len(chunk) == 1 {
+			stream.chunks = stream.chunks[1:]
+		} else {
+			stream.chunks[0] = chunk[1:]
+		}
+		stream.length--
+		removed++
+	}
+	return removed
+}
+
+type streamSnapshot struct {
+	Entries      []StreamEntry
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	Groups       []streamGroupSnapshot
+}
+
+func (stream *Stream) GobEncode() ([]byte, error) {
+	snapshot := streamSnapshot{
+		Entries:      stream.Range(StreamID{}, MaxStreamID, false, -1),
+		LastID:       stream.lastID,
+		MaxDeletedID: stream.maxDeletedID,
+		EntriesAdded: stream.entriesAdded,
+	}
+	for _, name := range stream.groupNames() {
+		snapshot.Groups = append(snapshot.Groups, stream.groups[name].snapshot(name))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (stream *Stream) GobDecode(data []byte) error {
+	var snapshot streamSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	*stream = *NewStream()
+	for _, entry := range snapshot.Entries {
+		stream.append(entry, defaultEncodingConfig.streamNodeMaxEntries)
+	}
+	stream.lastID = snapshot.LastID
+	stream.maxDeletedID = snapshot.MaxDeletedID
+	stream.entriesAdded = snapshot.EntriesAdded
+	for _, group := range snapshot.Groups {
+		stream.groups[group.Name] = restoreStreamGroup(group)
+	}
+	return nil
+}
+
+func (s *InMemoryStore) streamLocked(key string) (*Stream, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != StreamType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.StreamKV[key], nil
+}
+
+// XAddOptions are the arguments of XADD besides the key and the fields. The
+// ID is generated entirely when AutoID is set, and its sequence number only
+// when AutoSeq is.
+type XAddOptions struct {
+	ID         StreamID
+	AutoID     bool
+	AutoSeq    bool
+	NoMkStream bool
+	Trim       StreamTrim
+}
+
+// nextID returns the ID XADD should give a new entry.
+func (stream *Stream) nextID(options XAddOptions) (StreamID, error) {
+	last := stream.lastID
+	switch {
+	case options.AutoID:
+		ms := uint64(max(time.Now().UnixMilli(), 0))
+		if ms > last.Ms {
+			return StreamID{Ms: ms}, nil
+		}
+		id, ok := last.Next()
+		if !ok {
+			return id, errors.New("-ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	case options.AutoSeq:
+		id := StreamID{Ms: options.ID.Ms}
+		if id.Ms == last.Ms {
+			if last.Seq == math.MaxUint64 {
+				return StreamID{}, errStreamIDTooSmall
+			}
+			id.Seq = last.Seq + 1
+		}
+		if id.Compare(last) <= 0 {
+			return StreamID{}, errStreamIDTooSmall
+		}
+		return id, nil
+	}
+
+	if options.ID == (StreamID{}) {
+		return StreamID{}, errors.New("-ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if options.ID.Compare(last) <= 0 {
+		return StreamID{}, errStreamIDTooSmall
+	}
+	return options.ID, nil
+}
+
+// XAdd returns false without adding anything if NoMkStream is set and the key
+// does not exist.
+func (s *InMemoryStore) XAdd(key string, fields []string, options XAddOptions) (StreamID, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if stream == nil {
+		if options.NoMkStream {
+			return StreamID{}, false, nil
+		}
+		stream = NewStream()
+	}
+
+	id, err := stream.nextID(options)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+
+	if _, ok := s.StreamKV[key]; !ok {
+		s.StreamKV[key] = stream
+		s.KeyType[key] = StreamType
+	}
+	stream.append(StreamEntry{ID: id, Fields: fields}, s.config.streamNodeMaxEntries)
+	stream.Trim(s.streamTrimLimit(options.Trim))
+
+	s.signalKeyAsReady(key)
+	s.serveBlockedClients()
+	return id, true, nil
+}
+
+// streamTrimLimit applies the default LIMIT of approximate trims.
+func (s *InMemoryStore) streamTrimLimit(trim StreamTrim) StreamTrim {
+	if trim.Approx && trim.Limit < 0 {
+		trim.Limit = 100 * s.config.streamNodeMaxEntries
+	}
+	return trim
+}
+
+func (s *InMemoryStore) XLen(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return stream.Len(), nil
+}
+
+func (s *InMemoryStore) XRange(key string, start StreamID, end StreamID, reverse bool, count int) ([]StreamEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil || stream == nil {
+		return nil, err
+	}
+	return stream.Range(start, end, reverse, count), nil
+}
+
+// XDel leaves the stream in place even once its last entry is deleted.
+func (s *InMemoryStore) XDel(key string, ids []StreamID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, id := range ids {
+		if stream.Delete(id) {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *InMemoryStore) XTrim(key string, trim StreamTrim) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil || stream == nil {
+		return 0, err
+	}
+	return stream.Trim(s.streamTrimLimit(trim)), nil
+}
+
+// XSetID sets the last ID of a stream, and optionally its entries-added
+// counter and max deleted ID when entriesAdded and maxDeletedID are not nil.
+func (s *InMemoryStore) XSetID(key string, lastID StreamID, entriesAdded *uint64, maxDeletedID *StreamID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return errors.New("-ERR no such key")
+	}
+
+	if top, ok := stream.last(); ok && lastID.Compare(top.ID) < 0 {
+		return errors.New("-ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	if entriesAdded != nil && *entriesAdded < uint64(stream.Len()) {
+		return errors.New("-ERR The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if maxDeletedID != nil && lastID.Compare(*maxDeletedID) < 0 {
+		return errors.New("-ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+	}
+
+	stream.lastID = lastID
+	if entriesAdded != nil {
+		stream.entriesAdded = *entriesAdded
+	}
+	if maxDeletedID != nil {
+		stream.maxDeletedID = *maxDeletedID
+	}
+	return nil
+}
+
+// StreamInfo is the reply of XINFO STREAM.
+type StreamInfo struct {
+	Length       int
+	Chunks       int
+	LastID       StreamID
+	MaxDeletedID StreamID
+	EntriesAdded uint64
+	FirstID      StreamID
+	Groups       int
+	FirstEntry   *StreamEntry
+	LastEntry    *StreamEntry
+}
+
+func (s *InMemoryStore) XInfoStream(key string) (StreamInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	if stream == nil {
+		return StreamInfo{}, errors.New("-ERR no such key")
+	}
+
+	info := StreamInfo{
+		Length:       stream.Len(),
+		Chunks:       len(stream.chunks),
+		LastID:       stream.lastID,
+		MaxDeletedID: stream.maxDeletedID,
+		EntriesAdded: stream.entriesAdded,
+		Groups:       len(stream.groups),
+	}
+	if first, ok := stream.first(); ok {
+		info.FirstID = first.ID
+		info.FirstEntry = &first
+	}
+	if last, ok := stream.last(); ok {
+		info.LastEntry = &last
+	}
+	return info, nil
+}
+
+// StreamRead is one stream of an XREAD, read past ID, or past its last ID
+// at the time of the call when Last ("$") is set. XREADGROUP sets Last for
+// ">", reading the entries never delivered to the group.
+type StreamRead struct {
+	Key  string
+	ID   StreamID
+	Last bool
+}
+
+type StreamReadResult struct {
+	Key     string
+	Entries []StreamEntry
+}
+
+// XRead returns the entries past the requested IDs of every stream that has
+// any, at most count per stream unless count is negative. When none do and
+// block is set, it waits for the first XADD to one of the streams, or the
+// timeout, and returns what that stream got.
+func (s *InMemoryStore) XRead(ctx context.Context, reads []StreamRead, count int, block bool, timeout time.Duration) ([]StreamReadResult, error) {
+	s.mu.Lock()
+
+	for i, read := range reads {
+		stream, err := s.streamLocked(read.Key)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		if read.Last {
+			reads[i].ID = StreamID{}
+			if stream != nil {
+				reads[i].ID = stream.lastID
+			}
+		}
+	}
+
+	readLocked := func(read StreamRead) []StreamEntry {
+		stream := s.StreamKV[read.Key]
+		start, ok := read.ID.Next()
+		if stream == nil || !ok {
+			return nil
+		}
+		return stream.Range(start, MaxStreamID, false, count)
+	}
+
+	var results []StreamReadResult
+	for _, read := range reads {
+		if entries := readLocked(read); len(entries) > 0 {
+			results = append(results, StreamReadResult{Key: read.Key, Entries: entries})
+		}
+	}
+	if len(results) > 0 || !block {
+		s.mu.Unlock()
+		return results, nil
+	}
+
+	keys := make([]string, len(reads))
+	for i, read := range reads {
+		keys[i] = read.Key
+	}
+	_, err := s.blockOn(ctx, keys, timeout, func(key string) bool {
+		if keyType, ok := s.KeyType[key]; !ok || keyType != StreamType {
+			return false
+		}
+		for _, read := range reads {
+			if read.Key != key {
+				continue
+			}
+			if entries := readLocked(read); len(entries) > 0 {
+				results = []StreamReadResult{{Key: key, Entries: entries}}
+				return true
+			}
+		}
+		return false
+	}, nil)
+	return results, err
+}
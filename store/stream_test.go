@@ -0,0 +1,315 @@
+package store
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func streamIDs(entries []StreamEntry) []StreamID {
+	var ids []StreamID
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	return ids
+}
+
+// checkStream compares the entries of stream with want and checks that no
+// chunk is empty or holds more than maxChunkEntries entries.
+func checkStream(t *testing.T, name string, stream *Stream, want []StreamID, maxChunkEntries int) {
+	t.Helper()
+	var ids []StreamID
+	for i, chunk := range stream.chunks {
+		if len(chunk) == 0 || len(chunk) > maxChunkEntries {
+			t.Fatalf("%s: chunk %d holds %d entries", name, i, len(chunk))
+		}
+		for _, entry := range chunk {
+			ids = append(ids, entry.ID)
+		}
+	}
+	if !slices.Equal(ids, want) || stream.Len() != len(want) {
+		t.Fatalf("%s: stream holds %v with length %d, want %v", name, ids, stream.Len(), want)
+	}
+}
+
+func TestStreamIDNeighbours(t *testing.T) {
+	if id, ok := (StreamID{1, math.MaxUint64}).Next(); !ok || id != (StreamID{2, 0}) {
+		t.Errorf("Next of 1-max = %v, %v", id, ok)
+	}
+	if _, ok := MaxStreamID.Next(); ok {
+		t.Error("MaxStreamID has a next ID")
+	}
+	if id, ok := (StreamID{2, 0}).Prev(); !ok || id != (StreamID{1, math.MaxUint64}) {
+		t.Errorf("Prev of 2-0 = %v, %v", id, ok)
+	}
+	if _, ok := (StreamID{}).Prev(); ok {
+		t.Error("0-0 has a previous ID")
+	}
+}
+
+func TestXAddIDs(t *testing.T) {
+	s := NewInMemoryStore()
+	tests := []struct {
+		name    string
+		options XAddOptions
+		want    StreamID
+		fails   bool
+	}{
+		{"0-0", XAddOptions{}, StreamID{}, true},
+		{"sequence only", XAddOptions{ID: StreamID{Ms: 0}, AutoSeq: true}, StreamID{0, 1}, false},
+		{"explicit", XAddOptions{ID: StreamID{5, 3}}, StreamID{5, 3}, false},
+		{"equal", XAddOptions{ID: StreamID{5, 3}}, StreamID{}, true},
+		{"smaller", XAddOptions{ID: StreamID{4, 9}}, StreamID{}, true},
+		{"same millisecond", XAddOptions{ID: StreamID{Ms: 5}, AutoSeq: true}, StreamID{5, 4}, false},
+		{"earlier millisecond", XAddOptions{ID: StreamID{Ms: 4}, AutoSeq: true}, StreamID{}, true},
+		{"later millisecond", XAddOptions{ID: StreamID{Ms: 7}, AutoSeq: true}, StreamID{7, 0}, false},
+	}
+	for _, test := range tests {
+		id, _, err := s.XAdd("stream", []string{"f", "v"}, test.options)
+		if (err != nil) != test.fails || (!test.fails && id != test.want) {
+			t.Errorf("%s: XAdd = %v, %v, want %v", test.name, id, err, test.want)
+		}
+	}
+
+	// Automatic IDs keep increasing even if the last ID is in the future.
+	future := StreamID{Ms: uint64(time.Now().UnixMilli()) + 100000, Seq: 7}
+	s.XSetID("stream", future, nil, nil)
+	if id, _, _ := s.XAdd("stream", []string{"f", "v"}, XAddOptions{AutoID: true}); id != (StreamID{future.Ms, 8}) {
+		t.Errorf("automatic ID after a future last ID = %v", id)
+	}
+
+	s.XSetID("stream", StreamID{math.MaxUint64, math.MaxUint64}, nil, nil)
+	if _, _, err := s.XAdd("stream", []string{"f", "v"}, XAddOptions{AutoID: true}); err == nil {
+		t.Error("XAdd after the last possible ID did not fail")
+	}
+}
+
+func TestXAddNoMkStreamAndTrim(t *testing.T) {
+	s := NewInMemoryStore()
+	if _, ok, err := s.XAdd("stream", []string{"f", "v"}, XAddOptions{AutoID: true, NoMkStream: true}); ok || err != nil {
+		t.Errorf("XAdd NOMKSTREAM = %v, %v", ok, err)
+	}
+	if _, ok := s.KeyType["stream"]; ok {
+		t.Error("XAdd NOMKSTREAM created the stream")
+	}
+
+	trim := StreamTrim{Strategy: StreamTrimMaxLen, MaxLen: 3, Limit: -1}
+	for i := range 5 {
+		s.XAdd("stream", []string{"n", strconv.Itoa(i)}, XAddOptions{ID: StreamID{Ms: uint64(i + 1)}, Trim: trim})
+	}
+	entries, _ := s.XRange("stream", StreamID{}, MaxStreamID, false, -1)
+	if !slices.Equal(streamIDs(entries), []StreamID{{3, 0}, {4, 0}, {5, 0}}) {
+		t.Errorf("XAdd MAXLEN 3 left %v", streamIDs(entries))
+	}
+	if info, _ := s.XInfoStream("stream"); info.EntriesAdded != 5 || info.FirstID != (StreamID{3, 0}) || info.LastID != (StreamID{5, 0}) {
+		t.Errorf("XInfoStream = %+v", info)
+	}
+}
+
+func TestStreamAgainstSlice(t *testing.T) {
+	const maxChunkEntries = 4
+	for seed := range int64(30) {
+		rng := rand.New(rand.NewSource(seed))
+		name := "seed " + strconv.FormatInt(seed, 10)
+		stream := NewStream()
+		var want []StreamID
+		next := StreamID{Ms: 1}
+
+		for range 300 {
+			switch op := rng.Intn(10); {
+			case op < 5:
+				stream.append(StreamEntry{ID: next}, maxChunkEntries)
+				want = append(want, next)
+				if rng.Intn(2) == 0 {
+					next.Seq++
+				} else {
+					next = StreamID{Ms: next.Ms + uint64(rng.Intn(3)) + 1}
+				}
+			case op < 8 && len(want) > 0:
+				id := want[rng.Intn(len(want))]
+				if !stream.Delete(id) {
+					t.Fatalf("%s: Delete(%v) found nothing", name, id)
+				}
+				want = slices.DeleteFunc(want, func(other StreamID) bool { return other == id })
+				if stream.Delete(id) {
+					t.Fatalf("%s: Delete(%v) twice succeeded", name, id)
+				}
+			case op == 8:
+				maxLen := rng.Intn(len(want) + 2)
+				stream.Trim(StreamTrim{Strategy: StreamTrimMaxLen, MaxLen: maxLen})
+				want = want[max(len(want)-maxLen, 0):]
+			case op == 9 && len(want) > 0:
+				minID := want[rng.Intn(len(want))]
+				stream.Trim(StreamTrim{Strategy: StreamTrimMinID, MinID: minID})
+				want = slices.DeleteFunc(want, func(id StreamID) bool { return id.Compare(minID) < 0 })
+			}
+			checkStream(t, name, stream, want, maxChunkEntries)
+
+			start := StreamID{Ms: uint64(rng.Intn(int(next.Ms) + 1)), Seq: uint64(rng.Intn(3))}
+			end := StreamID{Ms: start.Ms + uint64(rng.Intn(20)), Seq: uint64(rng.Intn(3))}
+			count := rng.Intn(6) - 1
+			var inRange []StreamID
+			for _, id := range want {
+				if id.Compare(start) >= 0 && id.Compare(end) <= 0 {
+					inRange = append(inRange, id)
+				}
+			}
+			reversed := slices.Clone(inRange)
+			slices.Reverse(reversed)
+			if count >= 0 {
+				inRange, reversed = inRange[:min(count, len(inRange))], reversed[:min(count, len(reversed))]
+			}
+			if got := streamIDs(stream.Range(start, end, false, count)); !slices.Equal(got, inRange) {
+				t.Fatalf("%s: Range(%v, %v, %d) = %v, want %v", name, start, end, count, got, inRange)
+			}
+			if got := streamIDs(stream.Range(start, end, true, count)); !slices.Equal(got, reversed) {
+				t.Fatalf("%s: reverse Range(%v, %v, %d) = %v, want %v", name, start, end, count, got, reversed)
+			}
+		}
+
+		data, err := stream.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := NewStream()
+		if err := decoded.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		checkStream(t, name+" decoded", decoded, want, defaultEncodingConfig.streamNodeMaxEntries)
+		if decoded.lastID != stream.lastID || decoded.maxDeletedID != stream.maxDeletedID || decoded.entriesAdded != stream.entriesAdded {
+			t.Fatalf("%s: decoded counters differ", name)
+		}
+	}
+}
+
+func TestApproximateTrim(t *testing.T) {
+	stream := NewStream()
+	for i := range 10 {
+		stream.append(StreamEntry{ID: StreamID{Ms: uint64(i + 1)}}, 3)
+	}
+
+	// Chunks of 3, 3, 3 and 1: only whole chunks go.
+	if n := stream.Trim(StreamTrim{Strategy: StreamTrimMaxLen, MaxLen: 5, Approx: true}); n != 3 || stream.Len() != 7 {
+		t.Errorf("MAXLEN ~ 5 removed %d, left %d", n, stream.Len())
+	}
+	if n := stream.Trim(StreamTrim{Strategy: StreamTrimMinID, MinID: StreamID{Ms: 8}, Approx: true, Limit: 2}); n != 0 {
+		t.Errorf("MINID ~ with LIMIT below a chunk removed %d", n)
+	}
+	if n := stream.Trim(StreamTrim{Strategy: StreamTrimMinID, MinID: StreamID{Ms: 8}, Approx: true}); n != 3 || stream.Len() != 4 {
+		t.Errorf("MINID ~ 8 removed %d, left %d", n, stream.Len())
+	}
+	if first, _ := stream.first(); first.ID != (StreamID{Ms: 7}) {
+		t.Errorf("first entry after trimming = %v", first.ID)
+	}
+}
+
+func TestXDelAndXSetID(t *testing.T) {
+	s := NewInMemoryStore()
+	for i := range 3 {
+		s.XAdd("stream", []string{"n", strconv.Itoa(i)}, XAddOptions{ID: StreamID{Ms: uint64(i + 1)}})
+	}
+	if n, _ := s.XDel("stream", []StreamID{{2, 0}, {9, 0}, {2, 0}}); n != 1 {
+		t.Errorf("XDel = %d", n)
+	}
+	s.XDel("stream", []StreamID{{1, 0}, {3, 0}})
+	if n, _ := s.XLen("stream"); n != 0 || s.KeyType["stream"] != StreamType {
+		t.Error("XDel of every entry did not leave an empty stream")
+	}
+
+	info, _ := s.XInfoStream("stream")
+	if info.MaxDeletedID != (StreamID{3, 0}) || info.FirstEntry != nil {
+		t.Errorf("XInfoStream = %+v", info)
+	}
+	deleted := StreamID{5, 0}
+	if err := s.XSetID("stream", StreamID{4, 0}, nil, &deleted); err == nil {
+		t.Error("XSetID below the given max deleted ID did not fail")
+	}
+	if err := s.XSetID("missing", StreamID{1, 0}, nil, nil); err == nil {
+		t.Error("XSetID on a missing key did not fail")
+	}
+
+	s.XAdd("other", []string{"f", "v"}, XAddOptions{ID: StreamID{5, 0}})
+	if err := s.XSetID("other", StreamID{4, 0}, nil, nil); err == nil {
+		t.Error("XSetID below the top entry did not fail")
+	}
+	added := uint64(0)
+	if err := s.XSetID("other", StreamID{6, 0}, &added, nil); err == nil {
+		t.Error("XSetID with entries_added below the length did not fail")
+	}
+}
+
+func startXRead(s *InMemoryStore, reads []StreamRead, count int, timeout time.Duration) <-chan []StreamReadResult {
+	result := make(chan []StreamReadResult, 1)
+	go func() {
+		s.BeginCommand()
+		defer s.EndCommand()
+		results, _ := s.XRead(context.Background(), reads, count, true, timeout)
+		result <- results
+	}()
+	return result
+}
+
+func TestXRead(t *testing.T) {
+	s := NewInMemoryStore()
+	for i := range 4 {
+		s.XAdd("a", []string{"n", strconv.Itoa(i)}, XAddOptions{ID: StreamID{Ms: uint64(i + 1)}})
+	}
+	s.XAdd("b", []string{"n", "0"}, XAddOptions{ID: StreamID{Ms: 1}})
+
+	reads := []StreamRead{{Key: "a", ID: StreamID{Ms: 2}}, {Key: "b", ID: StreamID{Ms: 1}}, {Key: "missing"}}
+	results, err := s.XRead(context.Background(), reads, 1, false, 0)
+	if err != nil || len(results) != 1 || results[0].Key != "a" || !slices.Equal(streamIDs(results[0].Entries), []StreamID{{3, 0}}) {
+		t.Errorf("XRead COUNT 1 = %+v, %v", results, err)
+	}
+
+	s.RPush("list", []string{"a"})
+	if _, err := s.XRead(context.Background(), []StreamRead{{Key: "list"}}, -1, false, 0); err == nil {
+		t.Error("XRead of a list did not fail")
+	}
+}
+
+func TestXReadBlocksForNewEntries(t *testing.T) {
+	s := NewInMemoryStore()
+	s.XAdd("stream", []string{"n", "old"}, XAddOptions{ID: StreamID{Ms: 1}})
+
+	// "$" only sees entries added after the call.
+	result := startXRead(s, []StreamRead{{Key: "missing"}, {Key: "stream", Last: true}}, -1, 0)
+	waitBlocked(t, s, "stream", 1)
+	s.XAdd("stream", []string{"n", "new"}, XAddOptions{ID: StreamID{Ms: 2}})
+
+	select {
+	case results := <-result:
+		if len(results) != 1 || results[0].Key != "stream" || !slices.Equal(streamIDs(results[0].Entries), []StreamID{{2, 0}}) {
+			t.Errorf("blocked XRead = %+v", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked XRead was never served")
+	}
+
+	// A stream created while blocked serves a read from 0-0.
+	result = startXRead(s, []StreamRead{{Key: "created", Last: true}}, -1, 0)
+	waitBlocked(t, s, "created", 1)
+	s.XAdd("created", []string{"n", "first"}, XAddOptions{ID: StreamID{Ms: 1}})
+	select {
+	case results := <-result:
+		if len(results) != 1 || !slices.Equal(streamIDs(results[0].Entries), []StreamID{{1, 0}}) {
+			t.Errorf("XRead of a created stream = %+v", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("XRead of a created stream was never served")
+	}
+
+	result = startXRead(s, []StreamRead{{Key: "stream", Last: true}}, -1, 30*time.Millisecond)
+	select {
+	case results := <-result:
+		if results != nil {
+			t.Errorf("timed out XRead = %+v", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("XRead never timed out")
+	}
+}
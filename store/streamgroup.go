@@ -0,0 +1,812 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// entriesReadUnknown marks a group whose entries-read counter can no longer
+// be derived, e.g. after entries were deleted behind its last delivered ID.
+const entriesReadUnknown = -1
+
+// streamPendingEntry is an entry delivered to a consumer of a group but not
+// acknowledged yet.
+type streamPendingEntry struct {
+	id            StreamID
+	consumer      *streamConsumer
+	deliveryTime  int64
+	deliveryCount uint64
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64
+	activeTime int64
+	pending    map[StreamID]*streamPendingEntry
+}
+
+// streamGroup is a consumer group. Its pending entries list is kept sorted by
+// ID so XPENDING and XAUTOCLAIM can scan ranges of it, alongside an index
+// for lookups by ID.
+type streamGroup struct {
+	lastID      StreamID
+	entriesRead int64
+	pending     []*streamPendingEntry
+	pendingByID map[StreamID]*streamPendingEntry
+	consumers   map[string]*streamConsumer
+}
+
+func newStreamGroup(lastID StreamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pendingByID: make(map[StreamID]*streamPendingEntry),
+		consumers:   make(map[string]*streamConsumer),
+	}
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// consumer returns the named consumer, creating it if needed, and records
+// that it was just seen.
+func (group *streamGroup) consumer(name string, now int64) *streamConsumer {
+	consumer, ok := group.consumers[name]
+	if !ok {
+		consumer = &streamConsumer{
+			name:       name,
+			activeTime: -1,
+			pending:    make(map[StreamID]*streamPendingEntry),
+		}
+		group.consumers[name] = consumer
+	}
+	consumer.seenTime = now
+	return consumer
+}
+
+// pendingIndex returns the position of the first pending entry at or after id.
+func (group *streamGroup) pendingIndex(id StreamID) int {
+	return sort.Search(len(group.pending), func(i int) bool {
+		return group.pending[i].id.Compare(id) >= 0
+	})
+}
+
+// deliver records that id was delivered to consumer, taking it over from
+// whichever consumer had it before.
+func (group *streamGroup) deliver(id StreamID, consumer *streamConsumer, now int64) *streamPendingEntry {
+	entry, ok := group.pendingByID[id]
+	if !ok {
+		entry = &streamPendingEntry{id: id}
+		group.pendingByID[id] = entry
+		group.pending = slices.Insert(group.pending, group.pendingIndex(id), entry)
+	}
+	entry.deliveryTime = now
+	entry.deliveryCount = 1
+	group.assign(entry, consumer)
+	return entry
+}
+
+func (group *streamGroup) assign(entry *streamPendingEntry, consumer *streamConsumer) {
+	if entry.consumer != nil {
+		delete(entry.consumer.pending, entry.id)
+	}
+	entry.consumer = consumer
+	consumer.pending[entry.id] = entry
+}
+
+func (group *streamGroup) ack(id StreamID) bool {
+	entry, ok := group.pendingByID[id]
+	if !ok {
+		return false
+	}
+	delete(group.pendingByID, id)
+	delete(entry.consumer.pending, id)
+	i := group.pendingIndex(id)
+	group.pending = slices.Delete(group.pending, i, i+1)
+	return true
+}
+
+// deleteConsumer drops the consumer along with its pending entries, and
+// returns how many it had.
+func (group *streamGroup) deleteConsumer(name string) int {
+	consumer, ok := group.consumers[name]
+	if !ok {
+		return 0
+	}
+	pending := len(consumer.pending)
+	for id := range consumer.pending {
+		group.ack(id)
+	}
+	delete(group.consumers, name)
+	return pending
+}
+
+// consumerPending returns the IDs pending for consumer after id, in order.
+func (group *streamGroup) consumerPending(consumer *streamConsumer, after StreamID, count int) []StreamID {
+	var ids []StreamID
+	start, ok := after.Next()
+	if !ok {
+		return ids
+	}
+	for _, entry := range group.pending[group.pendingIndex(start):] {
+		if count >= 0 && len(ids) == count {
+			break
+		}
+		if entry.consumer == consumer {
+			ids = append(ids, entry.id)
+		}
+	}
+	return ids
+}
+
+func (stream *Stream) groupNames() []string {
+	names := make([]string, 0, len(stream.groups))
+	for name := range stream.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// hasTombstones reports whether entries after start may have been deleted.
+func (stream *Stream) hasTombstones(start StreamID) bool {
+	if stream.length == 0 || stream.maxDeletedID == (StreamID{}) {
+		return false
+	}
+	return start.Compare(stream.maxDeletedID) <= 0
+}
+
+// estimateEntriesRead returns how many entries were ever added up to and
+// including id, if that can still be told.
+func (stream *Stream) estimateEntriesRead(id StreamID) int64 {
+	if stream.entriesAdded == 0 {
+		return 0
+	}
+	if stream.length == 0 && id.Compare(stream.lastID) < 1 {
+		return int64(stream.entriesAdded)
+	}
+
+	switch cmp := id.Compare(stream.lastID); {
+	case cmp == 0:
+		return int64(stream.entriesAdded)
+	case cmp > 0:
+		return entriesReadUnknown
+	}
+
+	first, _ := stream.first()
+	if stream.maxDeletedID == (StreamID{}) || stream.maxDeletedID.Compare(first.ID) < 0 {
+		// Nothing was deleted past the first entry, so the entries before it
+		// are exactly those trimmed or deleted from the head.
+		switch cmp := id.Compare(first.ID); {
+		case cmp < 0:
+			return int64(stream.entriesAdded) - int64(stream.length)
+		case cmp == 0:
+			return int64(stream.entriesAdded) - int64(stream.length) + 1
+		}
+	}
+	return entriesReadUnknown
+}
+
+// advance moves the group past id, the last entry it was just delivered.
+func (group *streamGroup) advance(stream *Stream, id StreamID) {
+	group.lastID = id
+	if group.entriesRead != entriesReadUnknown && !stream.hasTombstones(id) {
+		group.entriesRead++
+	} else if stream.entriesAdded > 0 {
+		group.entriesRead = stream.estimateEntriesRead(id)
+	}
+}
+
+// lag returns how many entries the group has yet to be delivered, if known.
+func (stream *Stream) lag(group *streamGroup) (int64, bool) {
+	if stream.entriesAdded == 0 {
+		return 0, true
+	}
+	if group.entriesRead != entriesReadUnknown && !stream.hasTombstones(group.lastID) {
+		return int64(stream.entriesAdded) - group.entriesRead, true
+	}
+	entriesRead := stream.estimateEntriesRead(group.lastID)
+	if entriesRead == entriesReadUnknown {
+		return 0, false
+	}
+	return int64(stream.entriesAdded) - entriesRead, true
+}
+
+type streamPendingSnapshot struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+type streamConsumerSnapshot struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+}
+
+type streamGroupSnapshot struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     []streamPendingSnapshot
+	Consumers   []streamConsumerSnapshot
+}
+
+func (group *streamGroup) snapshot(name string) streamGroupSnapshot {
+	snapshot := streamGroupSnapshot{Name: name, LastID: group.lastID, EntriesRead: group.entriesRead}
+	for _, entry := range group.pending {
+		snapshot.Pending = append(snapshot.Pending, streamPendingSnapshot{
+			ID:            entry.id,
+			Consumer:      entry.consumer.name,
+			DeliveryTime:  entry.deliveryTime,
+			DeliveryCount: entry.deliveryCount,
+		})
+	}
+	for _, consumer := range group.consumers {
+		snapshot.Consumers = append(snapshot.Consumers, streamConsumerSnapshot{
+			Name:       consumer.name,
+			SeenTime:   consumer.seenTime,
+			ActiveTime: consumer.activeTime,
+		})
+	}
+	return snapshot
+}
+
+func restoreStreamGroup(snapshot streamGroupSnapshot) *streamGroup {
+	group := newStreamGroup(snapshot.LastID, snapshot.EntriesRead)
+	for _, consumer := range snapshot.Consumers {
+		group.consumer(consumer.Name, consumer.SeenTime).activeTime = consumer.ActiveTime
+	}
+	for _, pending := range snapshot.Pending {
+		consumer := group.consumers[pending.Consumer]
+		if consumer == nil {
+			consumer = group.consumer(pending.Consumer, pending.DeliveryTime)
+		}
+		entry := group.deliver(pending.ID, consumer, pending.DeliveryTime)
+		entry.deliveryCount = pending.DeliveryCount
+	}
+	return group
+}
+
+func errNoGroup(key string, group string) error {
+	return fmt.Errorf("-NOGROUP No such key '%s' or consumer group '%s'", key, group)
+}
+
+func errNoSuchGroup(key string, group string) error {
+	return fmt.Errorf("-NOGROUP No such consumer group '%s' for key name '%s'", group, key)
+}
+
+var errXGroupNoKey = errors.New("-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+
+// streamGroupLocked looks up a group, returning notFound if either the
+// stream or the group does not exist.
+func (s *InMemoryStore) streamGroupLocked(key string, name string, notFound error) (*Stream, *streamGroup, error) {
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stream == nil || stream.groups[name] == nil {
+		return nil, nil, notFound
+	}
+	return stream, stream.groups[name], nil
+}
+
+// XGroupCreate creates a group that has been delivered everything up to id,
+// or up to the last ID of the stream when last ("$") is set. A negative
+// entriesRead leaves the counter to be derived.
+func (s *InMemoryStore) XGroupCreate(key string, name string, id StreamID, last bool, mkStream bool, entriesRead int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		if !mkStream {
+			return errXGroupNoKey
+		}
+		stream = NewStream()
+		s.StreamKV[key] = stream
+		s.KeyType[key] = StreamType
+	}
+	if _, ok := stream.groups[name]; ok {
+		return errors.New("-BUSYGROUP Consumer Group name already exists")
+	}
+
+	if last {
+		id = stream.lastID
+		if entriesRead < 0 {
+			entriesRead = int64(stream.entriesAdded)
+		}
+	}
+	stream.groups[name] = newStreamGroup(id, max(entriesRead, entriesReadUnknown))
+	return nil
+}
+
+func (s *InMemoryStore) XGroupSetID(key string, name string, id StreamID, last bool, entriesRead int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return errXGroupNoKey
+	}
+	group := stream.groups[name]
+	if group == nil {
+		return errNoSuchGroup(key, name)
+	}
+
+	if last {
+		id = stream.lastID
+	}
+	group.lastID = id
+	group.entriesRead = max(entriesRead, entriesReadUnknown)
+	return nil
+}
+
+func (s *InMemoryStore) XGroupDestroy(key string, name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, errXGroupNoKey
+	}
+	if _, ok := stream.groups[name]; !ok {
+		return false, nil
+	}
+	delete(stream.groups, name)
+
+	// Readers blocked on the group find out it is gone.
+	s.signalKeyAsReady(key)
+	s.serveBlockedClients()
+	return true, nil
+}
+
+func (s *InMemoryStore) XGroupCreateConsumer(key string, name string, consumer string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if stream == nil {
+		return false, errXGroupNoKey
+	}
+	group := stream.groups[name]
+	if group == nil {
+		return false, errNoSuchGroup(key, name)
+	}
+	if _, ok := group.consumers[consumer]; ok {
+		return false, nil
+	}
+	group.consumer(consumer, nowMillis())
+	return true, nil
+}
+
+// XGroupDelConsumer returns how many entries were pending for the consumer.
+func (s *InMemoryStore) XGroupDelConsumer(key string, name string, consumer string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	if stream == nil {
+		return 0, errXGroupNoKey
+	}
+	group := stream.groups[name]
+	if group == nil {
+		return 0, errNoSuchGroup(key, name)
+	}
+	return group.deleteConsumer(consumer), nil
+}
+
+// readGroupLocked delivers to consumer up to count entries the group has not
+// been delivered yet, or for a history read returns up to count of the
+// entries already pending for it after read.ID. Pending entries that were
+// deleted from the stream come back with nil Fields.
+func (stream *Stream) readGroupLocked(group *streamGroup, consumer *streamConsumer, read StreamRead, count int, noAck bool, now int64) []StreamEntry {
+	var entries []StreamEntry
+	if !read.Last {
+		for _, id := range group.consumerPending(consumer, read.ID, count) {
+			entry, ok := stream.Get(id)
+			if !ok {
+				entry = StreamEntry{ID: id}
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	start, ok := group.lastID.Next()
+	if !ok {
+		return entries
+	}
+	entries = stream.Range(start, MaxStreamID, false, count)
+	for _, entry := range entries {
+		group.advance(stream, entry.ID)
+		if !noAck {
+			group.deliver(entry.ID, consumer, now)
+		}
+	}
+	if len(entries) > 0 {
+		consumer.activeTime = now
+	}
+	return entries
+}
+
+// XReadGroup reads on behalf of consumer. Like XRead, it blocks when block is
+// set and every stream is read with ">" and none has anything new.
+func (s *InMemoryStore) XReadGroup(ctx context.Context, name string, consumerName string, reads []StreamRead, count int, noAck bool, block bool, timeout time.Duration) ([]StreamReadResult, error) {
+	s.mu.Lock()
+
+	noGroup := func(key string) error {
+		return fmt.Errorf("%w in XREADGROUP with GROUP option", errNoGroup(key, name))
+	}
+	for _, read := range reads {
+		if _, _, err := s.streamGroupLocked(read.Key, name, noGroup(read.Key)); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+
+	now := nowMillis()
+	var results []StreamReadResult
+	history := false
+	for _, read := range reads {
+		stream := s.StreamKV[read.Key]
+		group := stream.groups[name]
+		entries := stream.readGroupLocked(group, group.consumer(consumerName, now), read, count, noAck, now)
+		if len(entries) > 0 || !read.Last {
+			results = append(results, StreamReadResult{Key: read.Key, Entries: entries})
+		}
+		history = history || !read.Last
+	}
+	if len(results) > 0 || history || !block {
+		s.mu.Unlock()
+		return results, nil
+	}
+
+	keys := make([]string, len(reads))
+	for i, read := range reads {
+		keys[i] = read.Key
+	}
+	var groupErr error
+	_, err := s.blockOn(ctx, keys, timeout, func(key string) bool {
+		stream, group, err := s.streamGroupLocked(key, name, noGroup(key))
+		if err != nil {
+			groupErr = err
+			return true
+		}
+		now := nowMillis()
+		entries := stream.readGroupLocked(group, group.consumer(consumerName, now), StreamRead{Key: key, Last: true}, count, noAck, now)
+		if len(entries) == 0 {
+			return false
+		}
+		results = []StreamReadResult{{Key: key, Entries: entries}}
+		return true
+	}, nil)
+	if groupErr != nil {
+		return nil, groupErr
+	}
+	return results, err
+}
+
+// XAck returns how many of the IDs were pending; a missing group counts as
+// having none.
+func (s *InMemoryStore) XAck(key string, name string, ids []StreamID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, group, err := s.streamGroupLocked(key, name, nil)
+	if err != nil || group == nil {
+		return 0, err
+	}
+
+	acked := 0
+	for _, id := range ids {
+		if group.ack(id) {
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// ConsumerPending is the number of entries pending for a consumer.
+type ConsumerPending struct {
+	Consumer string
+	Count    int
+}
+
+// PendingSummary is the reply of XPENDING without a range.
+type PendingSummary struct {
+	Count     int
+	First     StreamID
+	Last      StreamID
+	Consumers []ConsumerPending
+}
+
+func (s *InMemoryStore) XPendingSummary(key string, name string) (PendingSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, group, err := s.streamGroupLocked(key, name, errNoGroup(key, name))
+	if err != nil {
+		return PendingSummary{}, err
+	}
+
+	summary := PendingSummary{Count: len(group.pending)}
+	if summary.Count == 0 {
+		return summary, nil
+	}
+	summary.First = group.pending[0].id
+	summary.Last = group.pending[len(group.pending)-1].id
+	for _, consumer := range group.consumers {
+		if len(consumer.pending) > 0 {
+			summary.Consumers = append(summary.Consumers, ConsumerPending{Consumer: consumer.name, Count: len(consumer.pending)})
+		}
+	}
+	slices.SortFunc(summary.Consumers, func(a ConsumerPending, b ConsumerPending) int {
+		return strings.Compare(a.Consumer, b.Consumer)
+	})
+	return summary, nil
+}
+
+// PendingEntry is one entry of the extended form of XPENDING.
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	Idle          int64
+	DeliveryCount uint64
+}
+
+// XPendingQuery is the extended form of XPENDING: up to Count entries
+// between Start and End idle for at least MinIdle milliseconds, only those of
+// Consumer if it is not empty.
+type XPendingQuery struct {
+	Start    StreamID
+	End      StreamID
+	Count    int
+	MinIdle  int64
+	Consumer string
+}
+
+func (s *InMemoryStore) XPending(key string, name string, query XPendingQuery) ([]PendingEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, group, err := s.streamGroupLocked(key, name, errNoGroup(key, name))
+	if err != nil {
+		return nil, err
+	}
+
+	now := nowMillis()
+	var entries []PendingEntry
+	for _, entry := range group.pending[group.pendingIndex(query.Start):] {
+		if len(entries) >= query.Count || entry.id.Compare(query.End) > 0 {
+			break
+		}
+		if query.Consumer != "" && entry.consumer.name != query.Consumer {
+			continue
+		}
+		idle := now - entry.deliveryTime
+		if idle < query.MinIdle {
+			continue
+		}
+		entries = append(entries, PendingEntry{
+			ID:            entry.id,
+			Consumer:      entry.consumer.name,
+			Idle:          idle,
+			DeliveryCount: entry.deliveryCount,
+		})
+	}
+	return entries, nil
+}
+
+// XClaimOptions are the options of XCLAIM. DeliveryTime, when not zero, is
+// the unix time in milliseconds to record as the last delivery, and a
+// negative RetryCount leaves the delivery count alone.
+type XClaimOptions struct {
+	DeliveryTime int64
+	RetryCount   int64
+	Force        bool
+	JustID       bool
+	LastID       *StreamID
+}
+
+// claimLocked hands a pending entry over to consumer if it has been idle for
+// at least minIdle milliseconds. An entry deleted from the stream in the
+// meantime is dropped from the pending list instead and reported as missing.
+func (stream *Stream) claimLocked(group *streamGroup, consumer *streamConsumer, entry *streamPendingEntry, minIdle int64, options XClaimOptions, now int64) (StreamEntry, bool, bool) {
+	streamEntry, ok := stream.Get(entry.id)
+	if !ok {
+		group.ack(entry.id)
+		return StreamEntry{}, false, false
+	}
+	if minIdle > 0 && now-entry.deliveryTime < minIdle {
+		return StreamEntry{}, false, true
+	}
+
+	group.assign(entry, consumer)
+	entry.deliveryTime = now
+	if options.DeliveryTime != 0 {
+		entry.deliveryTime = options.DeliveryTime
+	}
+	if options.RetryCount >= 0 {
+		entry.deliveryCount = uint64(options.RetryCount)
+	} else if !options.JustID {
+		entry.deliveryCount++
+	}
+	consumer.activeTime = now
+	return streamEntry, true, true
+}
+
+func (s *InMemoryStore) XClaim(key string, name string, consumerName string, minIdle int64, ids []StreamID, options XClaimOptions) ([]StreamEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, group, err := s.streamGroupLocked(key, name, errNoGroup(key, name))
+	if err != nil {
+		return nil, err
+	}
+
+	if options.LastID != nil && options.LastID.Compare(group.lastID) > 0 {
+		group.lastID = *options.LastID
+	}
+
+	now := nowMillis()
+	consumer := group.consumer(consumerName, now)
+	var claimed []StreamEntry
+	for _, id := range ids {
+		entry, ok := group.pendingByID[id]
+		if !ok {
+			if _, exists := stream.Get(id); !options.Force || !exists {
+				continue
+			}
+			entry = group.deliver(id, consumer, now)
+		}
+		if streamEntry, ok, _ := stream.claimLocked(group, consumer, entry, minIdle, options, now); ok {
+			claimed = append(claimed, streamEntry)
+		}
+	}
+	return claimed, nil
+}
+
+// XAutoClaim claims up to count entries idle for at least minIdle
+// milliseconds, scanning the pending list from start. It returns the ID to
+// continue the scan from, 0-0 once it is complete, along with the entries
+// claimed and the IDs of the ones that turned out to be deleted.
+func (s *InMemoryStore) XAutoClaim(key string, name string, consumerName string, minIdle int64, start StreamID, count int, justID bool) (StreamID, []StreamEntry, []StreamID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, group, err := s.streamGroupLocked(key, name, errNoGroup(key, name))
+	if err != nil {
+		return StreamID{}, nil, nil, err
+	}
+
+	now := nowMillis()
+	consumer := group.consumer(consumerName, now)
+	options := XClaimOptions{RetryCount: -1, JustID: justID}
+
+	var claimed []StreamEntry
+	var deleted []StreamID
+	attempts := count * 10
+	i := group.pendingIndex(start)
+	for ; i < len(group.pending) && attempts > 0 && count > 0; attempts-- {
+		entry := group.pending[i]
+		streamEntry, ok, stillPending := stream.claimLocked(group, consumer, entry, minIdle, options, now)
+		if !stillPending {
+			deleted = append(deleted, entry.id)
+			count--
+			continue
+		}
+		if ok {
+			claimed = append(claimed, streamEntry)
+			count--
+		}
+		i++
+	}
+
+	next := StreamID{}
+	if i < len(group.pending) {
+		next = group.pending[i].id
+	}
+	return next, claimed, deleted, nil
+}
+
+// StreamGroupInfo is one group in the reply of XINFO GROUPS. EntriesRead is
+// negative and LagKnown false when they cannot be told.
+type StreamGroupInfo struct {
+	Name            string
+	Consumers       int
+	Pending         int
+	LastDeliveredID StreamID
+	EntriesRead     int64
+	Lag             int64
+	LagKnown        bool
+}
+
+func (s *InMemoryStore) XInfoGroups(key string) ([]StreamGroupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stream, err := s.streamLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if stream == nil {
+		return nil, errors.New("-ERR no such key")
+	}
+
+	infos := make([]StreamGroupInfo, 0, len(stream.groups))
+	for _, name := range stream.groupNames() {
+		group := stream.groups[name]
+		lag, known := stream.lag(group)
+		infos = append(infos, StreamGroupInfo{
+			Name:            name,
+			Consumers:       len(group.consumers),
+			Pending:         len(group.pending),
+			LastDeliveredID: group.lastID,
+			EntriesRead:     group.entriesRead,
+			Lag:             lag,
+			LagKnown:        known,
+		})
+	}
+	return infos, nil
+}
+
+// StreamConsumerInfo is one consumer in the reply of XINFO CONSUMERS, with
+// Inactive -1 for a consumer that never read or claimed anything.
+type StreamConsumerInfo struct {
+	Name     string
+	Pending  int
+	Idle     int64
+	Inactive int64
+}
+
+func (s *InMemoryStore) XInfoConsumers(key string, name string) ([]StreamConsumerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, group, err := s.streamGroupLocked(key, name, errNoSuchGroup(key, name))
+	if err != nil {
+		return nil, err
+	}
+
+	now := nowMillis()
+	infos := make([]StreamConsumerInfo, 0, len(group.consumers))
+	for _, consumer := range group.consumers {
+		info := StreamConsumerInfo{
+			Name:     consumer.name,
+			Pending:  len(consumer.pending),
+			Idle:     now - consumer.seenTime,
+			Inactive: -1,
+		}
+		if consumer.activeTime >= 0 {
+			info.Inactive = now - consumer.activeTime
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a StreamConsumerInfo, b StreamConsumerInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos, nil
+}
@@ -0,0 +1,425 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGroupStream returns a store whose stream holds entries 1-0 to n-0 and a
+// group "group" created before any of them.
+func newGroupStream(t *testing.T, n int) *InMemoryStore {
+	t.Helper()
+	s := NewInMemoryStore()
+	if err := s.XGroupCreate("stream", "group", StreamID{}, false, true, -1); err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		s.XAdd("stream", []string{"n", strconv.Itoa(i + 1)}, XAddOptions{ID: StreamID{Ms: uint64(i + 1)}})
+	}
+	return s
+}
+
+func readGroup(t *testing.T, s *InMemoryStore, consumer string, id string, count int) []StreamEntry {
+	t.Helper()
+	read := StreamRead{Key: "stream", Last: id == ">"}
+	if id != ">" {
+		ms, _ := strconv.ParseUint(id, 10, 64)
+		read.ID = StreamID{Ms: ms}
+	}
+	results, err := s.XReadGroup(context.Background(), "group", consumer, []StreamRead{read}, count, false, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return results[0].Entries
+}
+
+// idleFor backdates every pending entry of the group by ms milliseconds.
+func idleFor(s *InMemoryStore, ms int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.StreamKV["stream"].groups["group"].pending {
+		entry.deliveryTime -= ms
+	}
+}
+
+func ids(ms ...uint64) []StreamID {
+	var result []StreamID
+	for _, m := range ms {
+		result = append(result, StreamID{Ms: m})
+	}
+	return result
+}
+
+func TestXGroupCreate(t *testing.T) {
+	s := NewInMemoryStore()
+	if err := s.XGroupCreate("stream", "group", StreamID{}, false, false, -1); err != errXGroupNoKey {
+		t.Errorf("XGroupCreate without MKSTREAM = %v", err)
+	}
+	if err := s.XGroupCreate("stream", "group", StreamID{}, true, true, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.XGroupCreate("stream", "group", StreamID{}, true, true, -1); err == nil || !strings.HasPrefix(err.Error(), "-BUSYGROUP") {
+		t.Errorf("creating an existing group = %v", err)
+	}
+	s.RPush("list", []string{"a"})
+	if err := s.XGroupCreate("list", "group", StreamID{}, false, true, -1); err == nil {
+		t.Error("XGroupCreate on a list did not fail")
+	}
+
+	if ok, _ := s.XGroupCreateConsumer("stream", "group", "alice"); !ok {
+		t.Error("XGroupCreateConsumer did not create the consumer")
+	}
+	if ok, _ := s.XGroupCreateConsumer("stream", "group", "alice"); ok {
+		t.Error("XGroupCreateConsumer created an existing consumer")
+	}
+	if _, err := s.XGroupCreateConsumer("stream", "missing", "alice"); err == nil {
+		t.Error("XGroupCreateConsumer in a missing group did not fail")
+	}
+	if ok, _ := s.XGroupDestroy("stream", "group"); !ok {
+		t.Error("XGroupDestroy did not destroy the group")
+	}
+	if ok, _ := s.XGroupDestroy("stream", "group"); ok {
+		t.Error("XGroupDestroy destroyed a missing group")
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	s := newGroupStream(t, 5)
+
+	if got := streamIDs(readGroup(t, s, "alice", ">", 2)); !slices.Equal(got, ids(1, 2)) {
+		t.Errorf("alice read %v", got)
+	}
+	if got := streamIDs(readGroup(t, s, "bob", ">", -1)); !slices.Equal(got, ids(3, 4, 5)) {
+		t.Errorf("bob read %v", got)
+	}
+	if got := readGroup(t, s, "alice", ">", -1); got != nil {
+		t.Errorf("alice read %v after everything was delivered", got)
+	}
+
+	// History reads return the consumer's own pending entries only.
+	if got := streamIDs(readGroup(t, s, "alice", "0", -1)); !slices.Equal(got, ids(1, 2)) {
+		t.Errorf("alice history = %v", got)
+	}
+	if got := streamIDs(readGroup(t, s, "bob", "3", 1)); !slices.Equal(got, ids(4)) {
+		t.Errorf("bob history after 3 = %v", got)
+	}
+
+	if n, _ := s.XAck("stream", "group", ids(1, 1, 9)); n != 1 {
+		t.Errorf("XAck = %d", n)
+	}
+	if got := streamIDs(readGroup(t, s, "alice", "0", -1)); !slices.Equal(got, ids(2)) {
+		t.Errorf("alice history after XAck = %v", got)
+	}
+
+	// A pending entry deleted from the stream comes back without fields.
+	s.XDel("stream", ids(2))
+	if got := readGroup(t, s, "alice", "0", -1); len(got) != 1 || got[0].ID != (StreamID{Ms: 2}) || got[0].Fields != nil {
+		t.Errorf("history of a deleted entry = %+v", got)
+	}
+
+	if _, err := s.XReadGroup(context.Background(), "missing", "alice", []StreamRead{{Key: "stream", Last: true}}, -1, false, false, 0); err == nil || !strings.HasPrefix(err.Error(), "-NOGROUP") {
+		t.Errorf("XReadGroup of a missing group = %v", err)
+	}
+}
+
+func TestXReadGroupNoAck(t *testing.T) {
+	s := newGroupStream(t, 3)
+	results, _ := s.XReadGroup(context.Background(), "group", "alice", []StreamRead{{Key: "stream", Last: true}}, -1, true, false, 0)
+	if len(results) != 1 || len(results[0].Entries) != 3 {
+		t.Errorf("XREADGROUP NOACK = %+v", results)
+	}
+	if summary, _ := s.XPendingSummary("stream", "group"); summary.Count != 0 {
+		t.Errorf("NOACK left %d entries pending", summary.Count)
+	}
+}
+
+func TestXPending(t *testing.T) {
+	s := newGroupStream(t, 6)
+	readGroup(t, s, "bob", ">", 2)
+	idleFor(s, 1000)
+	readGroup(t, s, "alice", ">", -1)
+
+	summary, _ := s.XPendingSummary("stream", "group")
+	want := []ConsumerPending{{"alice", 4}, {"bob", 2}}
+	if summary.Count != 6 || summary.First != (StreamID{Ms: 1}) || summary.Last != (StreamID{Ms: 6}) || !slices.Equal(summary.Consumers, want) {
+		t.Errorf("XPendingSummary = %+v", summary)
+	}
+
+	tests := []struct {
+		name  string
+		query XPendingQuery
+		want  []StreamID
+	}{
+		{"all", XPendingQuery{End: MaxStreamID, Count: 10}, ids(1, 2, 3, 4, 5, 6)},
+		{"count", XPendingQuery{End: MaxStreamID, Count: 2}, ids(1, 2)},
+		{"range", XPendingQuery{Start: StreamID{Ms: 2}, End: StreamID{Ms: 4}, Count: 10}, ids(2, 3, 4)},
+		{"consumer", XPendingQuery{End: MaxStreamID, Count: 10, Consumer: "alice"}, ids(3, 4, 5, 6)},
+		{"idle", XPendingQuery{End: MaxStreamID, Count: 10, MinIdle: 500}, ids(1, 2)},
+	}
+	for _, test := range tests {
+		entries, err := s.XPending("stream", "group", test.query)
+		var got []StreamID
+		for _, entry := range entries {
+			got = append(got, entry.ID)
+		}
+		if err != nil || !slices.Equal(got, test.want) {
+			t.Errorf("%s: XPending = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+
+	entries, _ := s.XPending("stream", "group", XPendingQuery{End: MaxStreamID, Count: 1})
+	if entries[0].Consumer != "bob" || entries[0].DeliveryCount != 1 || entries[0].Idle < 1000 {
+		t.Errorf("XPending entry = %+v", entries[0])
+	}
+	if _, err := s.XPendingSummary("stream", "missing"); err == nil {
+		t.Error("XPendingSummary of a missing group did not fail")
+	}
+}
+
+func TestXClaim(t *testing.T) {
+	s := newGroupStream(t, 4)
+	readGroup(t, s, "alice", ">", 3)
+	idleFor(s, 1000)
+
+	claimed, _ := s.XClaim("stream", "group", "bob", 500, ids(1, 2, 9), XClaimOptions{RetryCount: -1})
+	if !slices.Equal(streamIDs(claimed), ids(1, 2)) || claimed[0].Fields[1] != "1" {
+		t.Errorf("XClaim = %+v", claimed)
+	}
+	// Claimed entries are no longer idle.
+	if claimed, _ := s.XClaim("stream", "group", "carol", 500, ids(1), XClaimOptions{RetryCount: -1}); claimed != nil {
+		t.Errorf("XClaim of a fresh entry = %+v", claimed)
+	}
+
+	s.XClaim("stream", "group", "carol", 0, ids(3), XClaimOptions{RetryCount: 7})
+	s.XClaim("stream", "group", "carol", 0, ids(2), XClaimOptions{RetryCount: -1, JustID: true})
+	entries, _ := s.XPending("stream", "group", XPendingQuery{End: MaxStreamID, Count: 10})
+	want := []PendingEntry{{ID: StreamID{Ms: 1}, Consumer: "bob", DeliveryCount: 2}, {ID: StreamID{Ms: 2}, Consumer: "carol", DeliveryCount: 2}, {ID: StreamID{Ms: 3}, Consumer: "carol", DeliveryCount: 7}}
+	for i := range entries {
+		entries[i].Idle = 0
+	}
+	if !slices.Equal(entries, want) {
+		t.Errorf("pending after XClaim = %+v, want %+v", entries, want)
+	}
+
+	// FORCE claims entries that were never delivered, as long as they exist.
+	claimed, _ = s.XClaim("stream", "group", "bob", 0, ids(4, 5), XClaimOptions{RetryCount: -1, Force: true})
+	if !slices.Equal(streamIDs(claimed), ids(4)) {
+		t.Errorf("XClaim FORCE = %v", streamIDs(claimed))
+	}
+
+	// Deleted entries are dropped from the pending list.
+	s.XDel("stream", ids(1))
+	if claimed, _ := s.XClaim("stream", "group", "bob", 0, ids(1), XClaimOptions{RetryCount: -1}); claimed != nil {
+		t.Errorf("XClaim of a deleted entry = %+v", claimed)
+	}
+	if summary, _ := s.XPendingSummary("stream", "group"); summary.Count != 3 || summary.First != (StreamID{Ms: 2}) {
+		t.Errorf("pending after claiming a deleted entry = %+v", summary)
+	}
+}
+
+func TestXAutoClaimCursor(t *testing.T) {
+	s := newGroupStream(t, 25)
+	readGroup(t, s, "alice", ">", -1)
+	idleFor(s, 1000)
+
+	cursor := StreamID{}
+	var cursors, claimed []StreamID
+	for range 10 {
+		next, entries, deleted, err := s.XAutoClaim("stream", "group", "bob", 500, cursor, 10, false)
+		if err != nil || len(deleted) != 0 {
+			t.Fatalf("XAutoClaim = %v, %v", deleted, err)
+		}
+		claimed = append(claimed, streamIDs(entries)...)
+		cursors = append(cursors, next)
+		if next == (StreamID{}) {
+			break
+		}
+		cursor = next
+	}
+	if !slices.Equal(cursors, ids(11, 21, 0)) {
+		t.Errorf("XAutoClaim cursors = %v, want 11-0, 21-0 and then 0-0", cursors)
+	}
+	var want []StreamID
+	for i := range 25 {
+		want = append(want, StreamID{Ms: uint64(i + 1)})
+	}
+	if !slices.Equal(claimed, want) {
+		t.Errorf("XAutoClaim claimed %v over the whole scan", claimed)
+	}
+
+	// Everything now belongs to bob and is no longer idle.
+	next, entries, _, _ := s.XAutoClaim("stream", "group", "carol", 500, StreamID{}, 100, true)
+	if entries != nil || next != (StreamID{}) {
+		t.Errorf("XAutoClaim of fresh entries = %v, %v", next, entries)
+	}
+	if summary, _ := s.XPendingSummary("stream", "group"); !slices.Equal(summary.Consumers, []ConsumerPending{{"bob", 25}}) {
+		t.Errorf("pending after XAutoClaim = %+v", summary.Consumers)
+	}
+}
+
+func TestXAutoClaimAttemptsAndDeletes(t *testing.T) {
+	s := newGroupStream(t, 15)
+	readGroup(t, s, "alice", ">", -1)
+
+	// With no entry idle enough, a scan of count 1 gives up after 10
+	// attempts and returns where to continue.
+	next, entries, _, _ := s.XAutoClaim("stream", "group", "bob", 1000, StreamID{}, 1, false)
+	if entries != nil || next != (StreamID{Ms: 11}) {
+		t.Errorf("XAutoClaim with nothing idle = %v, %v, want cursor 11-0", next, entries)
+	}
+
+	idleFor(s, 1000)
+	s.XDel("stream", ids(2, 3))
+	next, entries, deleted, _ := s.XAutoClaim("stream", "group", "bob", 500, StreamID{}, 3, false)
+	if !slices.Equal(streamIDs(entries), ids(1)) || !slices.Equal(deleted, ids(2, 3)) || next != (StreamID{Ms: 4}) {
+		t.Errorf("XAutoClaim over deleted entries = %v, %v, %v", next, streamIDs(entries), deleted)
+	}
+	if summary, _ := s.XPendingSummary("stream", "group"); summary.Count != 13 {
+		t.Errorf("deleted entries still pending: %d", summary.Count)
+	}
+
+	// JUSTID returns the entries without bumping their delivery count.
+	_, entries, _, _ = s.XAutoClaim("stream", "group", "carol", 500, StreamID{Ms: 4}, 1, true)
+	pending, _ := s.XPending("stream", "group", XPendingQuery{Start: StreamID{Ms: 4}, End: StreamID{Ms: 4}, Count: 1})
+	if !slices.Equal(streamIDs(entries), ids(4)) || pending[0].Consumer != "carol" || pending[0].DeliveryCount != 1 {
+		t.Errorf("XAUTOCLAIM JUSTID = %v, pending %+v", streamIDs(entries), pending)
+	}
+}
+
+func TestXGroupDelConsumer(t *testing.T) {
+	s := newGroupStream(t, 3)
+	readGroup(t, s, "alice", ">", 2)
+	readGroup(t, s, "bob", ">", 1)
+
+	if n, _ := s.XGroupDelConsumer("stream", "group", "alice"); n != 2 {
+		t.Errorf("XGroupDelConsumer = %d", n)
+	}
+	summary, _ := s.XPendingSummary("stream", "group")
+	if summary.Count != 1 || !slices.Equal(summary.Consumers, []ConsumerPending{{"bob", 1}}) {
+		t.Errorf("pending after deleting alice = %+v", summary)
+	}
+	consumers, _ := s.XInfoConsumers("stream", "group")
+	if len(consumers) != 1 || consumers[0].Name != "bob" || consumers[0].Pending != 1 {
+		t.Errorf("XInfoConsumers = %+v", consumers)
+	}
+}
+
+func TestXInfoGroupsLag(t *testing.T) {
+	s := NewInMemoryStore()
+	for i := range 3 {
+		s.XAdd("stream", []string{"n", strconv.Itoa(i)}, XAddOptions{ID: StreamID{Ms: uint64(i + 1)}})
+	}
+	s.XGroupCreate("stream", "group", StreamID{}, false, false, -1)
+	s.XGroupCreate("stream", "latest", StreamID{}, true, false, -1)
+
+	groups, _ := s.XInfoGroups("stream")
+	if len(groups) != 2 || groups[0].Name != "group" || groups[0].Lag != 3 || !groups[0].LagKnown ||
+		groups[1].Lag != 0 || groups[1].EntriesRead != 3 {
+		t.Errorf("XInfoGroups = %+v", groups)
+	}
+
+	readGroup(t, s, "alice", ">", 2)
+	groups, _ = s.XInfoGroups("stream")
+	if groups[0].EntriesRead != 2 || groups[0].Lag != 1 || groups[0].LastDeliveredID != (StreamID{Ms: 2}) || groups[0].Pending != 2 {
+		t.Errorf("XInfoGroups after reading 2 = %+v", groups[0])
+	}
+
+	// Deleting an undelivered entry from the middle makes the lag unknown.
+	s.XAdd("stream", []string{"n", "3"}, XAddOptions{ID: StreamID{Ms: 4}})
+	s.XDel("stream", ids(3))
+	groups, _ = s.XInfoGroups("stream")
+	if groups[0].LagKnown {
+		t.Errorf("lag known after a deletion past the group: %+v", groups[0])
+	}
+}
+
+func TestXReadGroupBlocks(t *testing.T) {
+	s := newGroupStream(t, 0)
+	read := func() <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			s.BeginCommand()
+			defer s.EndCommand()
+			results, err := s.XReadGroup(context.Background(), "group", "alice", []StreamRead{{Key: "stream", Last: true}}, -1, false, true, 0)
+			if err == nil && (len(results) != 1 || len(results[0].Entries) != 1) {
+				err = errors.New("unexpected results")
+			}
+			result <- err
+		}()
+		return result
+	}
+
+	result := read()
+	waitBlocked(t, s, "stream", 1)
+	s.XAdd("stream", []string{"f", "v"}, XAddOptions{ID: StreamID{Ms: 1}})
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("blocked XReadGroup = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked XReadGroup was never served")
+	}
+
+	// Destroying the group wakes its readers with an error.
+	result = read()
+	waitBlocked(t, s, "stream", 1)
+	s.XGroupDestroy("stream", "group")
+	select {
+	case err := <-result:
+		if err == nil || !strings.HasPrefix(err.Error(), "-NOGROUP") {
+			t.Errorf("XReadGroup on a destroyed group = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("XGroupDestroy did not wake the reader")
+	}
+}
+
+func TestStreamGroupSnapshot(t *testing.T) {
+	s := newGroupStream(t, 4)
+	readGroup(t, s, "alice", ">", 3)
+	s.XClaim("stream", "group", "bob", 0, ids(2), XClaimOptions{RetryCount: 5})
+	s.XGroupCreateConsumer("stream", "group", "idle")
+
+	data, err := s.StreamKV["stream"].GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewStream()
+	if err := decoded.GobDecode(data); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewInMemoryStore()
+	restored.StreamKV["stream"] = decoded
+	restored.KeyType["stream"] = StreamType
+
+	// Idle times keep running, so compare the entries with them cleared.
+	query := XPendingQuery{End: MaxStreamID, Count: 10}
+	before, _ := s.XPending("stream", "group", query)
+	after, _ := restored.XPending("stream", "group", query)
+	for i := range before {
+		before[i].Idle = 0
+	}
+	for i := range after {
+		after[i].Idle = 0
+	}
+	if !slices.Equal(before, after) || len(after) != 3 {
+		t.Errorf("pending entries after reload = %+v, want %+v", after, before)
+	}
+
+	consumers, _ := restored.XInfoConsumers("stream", "group")
+	if len(consumers) != 3 || consumers[1].Name != "bob" || consumers[1].Pending != 1 || consumers[1].Inactive < 0 || consumers[2].Inactive != -1 {
+		t.Errorf("consumers after reload = %+v", consumers)
+	}
+	if got := streamIDs(readGroup(t, restored, "carol", ">", -1)); !slices.Equal(got, ids(4)) {
+		t.Errorf("read after reload = %v, want the undelivered entry", got)
+	}
+}
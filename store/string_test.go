@@ -0,0 +1,197 @@
+package store
+
+import (
+	"encoding/gob"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStringValueEncoding(t *testing.T) {
+	tests := []struct {
+		value    string
+		encoding StringEncoding
+	}{
+		{"0", IntEncoding},
+		{"-1", IntEncoding},
+		{"9223372036854775807", IntEncoding},
+		{"-9223372036854775808", IntEncoding},
+		{"9223372036854775808", EmbStrEncoding},
+		{"007", EmbStrEncoding},
+		{"+1", EmbStrEncoding},
+		{"-0", EmbStrEncoding},
+		{"-", EmbStrEncoding},
+		{"", EmbStrEncoding},
+		{"1.5", EmbStrEncoding},
+		{strings.Repeat("a", embStrSizeLimit), EmbStrEncoding},
+		{strings.Repeat("a", embStrSizeLimit+1), RawEncoding},
+	}
+	for _, test := range tests {
+		value := newStringValue(test.value, 0)
+		if value.Encoding != test.encoding {
+			t.Errorf("newStringValue(%q).Encoding = %v, want %v", test.value, value.Encoding, test.encoding)
+		}
+		if value.String() != test.value {
+			t.Errorf("newStringValue(%q).String() = %q", test.value, value.String())
+		}
+		if value.Len() != len(test.value) {
+			t.Errorf("newStringValue(%q).Len() = %d, want %d", test.value, value.Len(), len(test.value))
+		}
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	s := NewInMemoryStore()
+
+	if n, err := s.Increment("counter", 5); err != nil || n != 5 {
+		t.Fatalf("Increment on a missing key = %d, %v", n, err)
+	}
+	if n, err := s.Increment("counter", -7); err != nil || n != -2 {
+		t.Fatalf("Increment = %d, %v, want -2", n, err)
+	}
+	if encoding, _ := s.ObjectEncoding("counter"); encoding != "int" {
+		t.Errorf("encoding after Increment = %s, want int", encoding)
+	}
+
+	s.StringSet("max", "9223372036854775807", 0, false, false, false, false)
+	if _, err := s.Increment("max", 1); err == nil {
+		t.Error("Increment past MaxInt64 did not fail")
+	}
+	s.StringSet("min", "-9223372036854775808", 0, false, false, false, false)
+	if _, err := s.Increment("min", math.MinInt64); err == nil {
+		t.Error("Increment past MinInt64 did not fail")
+	}
+
+	s.StringSet("padded", "007", 0, false, false, false, false)
+	if _, err := s.Increment("padded", 1); err == nil {
+		t.Error("Increment of a non canonical integer did not fail")
+	}
+	if value, _, _ := s.StringGet("padded"); value.String() != "007" {
+		t.Errorf("failed Increment changed the value to %q", value.String())
+	}
+}
+
+func TestIncrementKeepsExpiry(t *testing.T) {
+	s := NewInMemoryStore()
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+	s.StringSet("counter", "10", expiresAt, false, false, false, false)
+	s.Increment("counter", 1)
+
+	value, ok, _ := s.StringGet("counter")
+	if !ok || value.Int != 11 || value.ExpiresAt != expiresAt {
+		t.Errorf("StringGet = %+v, %v, want 11 expiring at %d", value, ok, expiresAt)
+	}
+}
+
+func TestAppendConvertsToRaw(t *testing.T) {
+	s := NewInMemoryStore()
+	s.StringSet("key", "12", 0, false, false, false, false)
+	if n, err := s.Append("key", "34"); err != nil || n != 4 {
+		t.Fatalf("Append = %d, %v", n, err)
+	}
+	value, _, _ := s.StringGet("key")
+	if value.Encoding != RawEncoding || value.String() != "1234" {
+		t.Errorf("value after Append = %+v, want raw 1234", value)
+	}
+}
+
+func TestStringExpiry(t *testing.T) {
+	s := NewInMemoryStore()
+	s.StringSet("key", "value", time.Now().Add(-time.Second).UnixMilli(), false, false, false, false)
+	if _, ok, _ := s.StringGet("key"); ok {
+		t.Error("expired key is still returned")
+	}
+	if _, ok := s.ObjectEncoding("key"); ok {
+		t.Error("expired key still has an encoding")
+	}
+}
+
+// legacyStore has the shape InMemoryStore was saved in before StoreValue
+// replaced Value and Expiry.
+type legacyStore struct {
+	KeyType  map[string]StoreType
+	StringKV map[string]struct {
+		Value  string
+		Expiry *time.Time
+	}
+}
+
+func TestLoadLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.godb")
+	expiry := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	legacy := legacyStore{
+		KeyType: map[string]StoreType{"name": StringType, "count": StringType, "session": StringType},
+		StringKV: map[string]struct {
+			Value  string
+			Expiry *time.Time
+		}{
+			"name":    {Value: "goredis"},
+			"count":   {Value: "42"},
+			"session": {Value: "token", Expiry: &expiry},
+		},
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode(legacy); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	s := NewInMemoryStore()
+	if _, err := NewPersistence(s, path); err != nil {
+		t.Fatal(err)
+	}
+	if s.SnapshotVersion != snapshotVersion {
+		t.Errorf("SnapshotVersion = %d after conversion", s.SnapshotVersion)
+	}
+
+	tests := []struct {
+		key       string
+		value     string
+		encoding  StringEncoding
+		expiresAt int64
+	}{
+		{"name", "goredis", EmbStrEncoding, 0},
+		{"count", "42", IntEncoding, 0},
+		{"session", "token", EmbStrEncoding, expiry.UnixMilli()},
+	}
+	for _, test := range tests {
+		value, ok, err := s.StringGet(test.key)
+		if err != nil || !ok {
+			t.Fatalf("StringGet(%q) = %v, %v", test.key, ok, err)
+		}
+		if value.String() != test.value || value.Encoding != test.encoding || value.ExpiresAt != test.expiresAt {
+			t.Errorf("StringGet(%q) = %+v, want %q (%v) expiring at %d", test.key, value, test.value, test.encoding, test.expiresAt)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.godb")
+	s := NewInMemoryStore()
+	p, err := NewPersistence(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.StringSet("count", "42", 0, false, false, false, false)
+	s.StringSet("empty", "", 0, false, false, false, false)
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := NewInMemoryStore()
+	if _, err := NewPersistence(loaded, path); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"count": "42", "empty": ""} {
+		if value, ok, _ := loaded.StringGet(key); !ok || value.String() != want {
+			t.Errorf("StringGet(%q) = %q, %v, want %q", key, value.String(), ok, want)
+		}
+	}
+}
@@ -0,0 +1,488 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+	"slices"
+)
+
+// TDigest summarizes a distribution with centroids, weighted means of
+// nearby samples, following Dunning's merging t-digest. Samples are buffered
+// and periodically merged into the centroids under the k1 scale function,
+// which allows large centroids around the median and keeps them small at the
+// tails, so extreme quantiles stay accurate.
+const TDigestDefaultCompression = 100
+
+var errTDigestNoKey = errors.New("-ERR T-Digest: key does not exist")
+
+type centroid struct {
+	Mean   float64
+	Weight float64
+}
+
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	weight      float64
+	min         float64
+	max         float64
+}
+
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (td *TDigest) Encoding() string {
+	return "raw"
+}
+
+func (td *TDigest) Len() float64 {
+	return td.weight
+}
+
+// scale maps a quantile to the k1 scale, on which every merged centroid
+// spans at most one unit.
+func (td *TDigest) scale(q float64) float64 {
+	return td.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (td *TDigest) addCentroid(c centroid) {
+	td.buffer = append(td.buffer, c)
+	td.weight += c.Weight
+	td.min = min(td.min, c.Mean)
+	td.max = max(td.max, c.Mean)
+	if len(td.buffer) >= int(td.compression)*5 {
+		td.compress()
+	}
+}
+
+func (td *TDigest) Add(value float64) {
+	td.addCentroid(centroid{Mean: value, Weight: 1})
+}
+
+// compress merges the buffered samples into the centroids.
+func (td *TDigest) compress() {
+	if len(td.buffer) == 0 {
+		return
+	}
+	all := append(td.centroids, td.buffer...)
+	td.buffer = nil
+	slices.SortStableFunc(all, func(a centroid, b centroid) int {
+		switch {
+		case a.Mean < b.Mean:
+			return -1
+		case a.Mean > b.Mean:
+			return 1
+		}
+		return 0
+	})
+
+	merged := make([]centroid, 0, len(all))
+	current := all[0]
+	weightSoFar := 0.0
+	for _, next := range all[1:] {
+		q0 := weightSoFar / td.weight
+		q1 := (weightSoFar + current.Weight + next.Weight) / td.weight
+		if td.scale(q1)-td.scale(q0) <= 1 {
+			current.Weight += next.Weight
+			current.Mean += (next.Mean - current.Mean) * next.Weight / current.Weight
+			continue
+		}
+		merged = append(merged, current)
+		weightSoFar += current.Weight
+		current = next
+	}
+	td.centroids = append(merged, current)
+}
+
+// Merge adds every centroid of other.
+func (td *TDigest) Merge(other *TDigest) {
+	other.compress()
+	for _, c := range other.centroids {
+		td.addCentroid(c)
+	}
+	if other.weight > 0 {
+		td.min = min(td.min, other.min)
+		td.max = max(td.max, other.max)
+	}
+	td.compress()
+}
+
+func (td *TDigest) Min() float64 {
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	return td.min
+}
+
+func (td *TDigest) Max() float64 {
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	return td.max
+}
+
+// Quantile estimates the value below which a fraction q of the samples fall,
+// interpolating between centroid means and treating the extreme centroids
+// as spread out towards the minimum and maximum.
+func (td *TDigest) Quantile(q float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	centroids := td.centroids
+	if q <= 0 {
+		return td.min
+	}
+	if q >= 1 {
+		return td.max
+	}
+	if len(centroids) == 1 {
+		return centroids[0].Mean
+	}
+
+	index := q * td.weight
+	first, last := centroids[0], centroids[len(centroids)-1]
+	if index < 1 {
+		return td.min
+	}
+	if first.Weight > 1 && index < first.Weight/2 {
+		return td.min + (index-1)/(first.Weight/2-1)*(first.Mean-td.min)
+	}
+	if index > td.weight-1 {
+		return td.max
+	}
+	if last.Weight > 1 && td.weight-index <= last.Weight/2 {
+		return td.max - (td.weight-index-1)/(last.Weight/2-1)*(td.max-last.Mean)
+	}
+
+	weightSoFar := first.Weight / 2
+	for i := 0; i < len(centroids)-1; i++ {
+		left, right := centroids[i], centroids[i+1]
+		dw := (left.Weight + right.Weight) / 2
+		if weightSoFar+dw > index {
+			// Singleton centroids are exact samples, so they cover half a unit
+			// on either side rather than being interpolated over.
+			leftUnit := 0.0
+			if left.Weight == 1 {
+				if index-weightSoFar < 0.5 {
+					return left.Mean
+				}
+				leftUnit = 0.5
+			}
+			rightUnit := 0.0
+			if right.Weight == 1 {
+				if weightSoFar+dw-index <= 0.5 {
+					return right.Mean
+				}
+				rightUnit = 0.5
+			}
+			z1 := index - weightSoFar - leftUnit
+			z2 := weightSoFar + dw - index - rightUnit
+			return (left.Mean*z2 + right.Mean*z1) / (z1 + z2)
+		}
+		weightSoFar += dw
+	}
+
+	z1 := index - td.weight - last.Weight/2
+	z2 := last.Weight/2 - z1
+	return (last.Mean*z1 + td.max*z2) / (z1 + z2)
+}
+
+// CDF estimates the fraction of samples below value, counting samples equal
+// to it as half.
+func (td *TDigest) CDF(value float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+	if value < td.min {
+		return 0
+	}
+	if value > td.max {
+		return 1
+	}
+	centroids := td.centroids
+	if len(centroids) == 1 {
+		if td.max == td.min {
+			return 0.5
+		}
+		return (value - td.min) / (td.max - td.min)
+	}
+
+	first, last := centroids[0], centroids[len(centroids)-1]
+	if value < first.Mean {
+		if first.Mean-td.min > 0 {
+			if value == td.min {
+				return 0.5 / td.weight
+			}
+			return (1 + (value-td.min)/(first.Mean-td.min)*(first.Weight/2-1)) / td.weight
+		}
+		return 0
+	}
+	if value > last.Mean {
+		if td.max-last.Mean > 0 {
+			if value == td.max {
+				return 1 - 0.5/td.weight
+			}
+			return 1 - (1+(td.max-value)/(td.max-last.Mean)*(last.Weight/2-1))/td.weight
+		}
+		return 1
+	}
+
+	weightSoFar := 0.0
+	for i := 0; i < len(centroids); i++ {
+		c := centroids[i]
+		if c.Mean == value {
+			equal := 0.0
+			for j := i; j < len(centroids) && centroids[j].Mean == value; j++ {
+				equal += centroids[j].Weight
+			}
+			return (weightSoFar + equal/2) / td.weight
+		}
+		next := centroids[i+1]
+		if c.Mean < value && value < next.Mean {
+			leftExcluded, rightExcluded := 0.0, 0.0
+			if c.Weight == 1 {
+				leftExcluded = 0.5
+			}
+			if next.Weight == 1 {
+				rightExcluded = 0.5
+			}
+			dw := (c.Weight+next.Weight)/2 - leftExcluded - rightExcluded
+			base := weightSoFar + c.Weight/2 + leftExcluded
+			return (base + dw*(value-c.Mean)/(next.Mean-c.Mean)) / td.weight
+		}
+		weightSoFar += c.Weight
+	}
+	return 1
+}
+
+// TrimmedMean returns the mean of the samples between the low and high
+// quantiles, counting the centroids that straddle them in part.
+func (td *TDigest) TrimmedMean(low float64, high float64) float64 {
+	td.compress()
+	if td.weight == 0 {
+		return math.NaN()
+	}
+
+	lowIndex, highIndex := low*td.weight, high*td.weight
+	sum, weight, weightSoFar := 0.0, 0.0, 0.0
+	for _, c := range td.centroids {
+		start, end := weightSoFar, weightSoFar+c.Weight
+		weightSoFar = end
+		overlap := min(end, highIndex) - max(start, lowIndex)
+		if overlap <= 0 {
+			continue
+		}
+		sum += c.Mean * overlap
+		weight += overlap
+	}
+	if weight == 0 {
+		return math.NaN()
+	}
+	return sum / weight
+}
+
+type tdigestSnapshot struct {
+	Compression float64
+	Centroids   []centroid
+	Weight      float64
+	Min         float64
+	Max         float64
+}
+
+// GobEncode saves the buffered samples as centroids of their own, which are
+// merged with the rest once the digest is next queried.
+func (td *TDigest) GobEncode() ([]byte, error) {
+	centroids := append(slices.Clone(td.centroids), td.buffer...)
+	snapshot := tdigestSnapshot{Compression: td.compression, Centroids: centroids, Weight: td.weight, Min: td.min, Max: td.max}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (td *TDigest) GobDecode(data []byte) error {
+	var snapshot tdigestSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	td.compression, td.buffer, td.weight = snapshot.Compression, snapshot.Centroids, snapshot.Weight
+	td.min, td.max, td.centroids = snapshot.Min, snapshot.Max, nil
+	return nil
+}
+
+func (s *InMemoryStore) tdigestLocked(key string) (*TDigest, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != TDigestType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.TDigestKV[key], nil
+}
+
+// tdigestExistingLocked is tdigestLocked for commands that need the key to
+// exist.
+func (s *InMemoryStore) tdigestExistingLocked(key string) (*TDigest, error) {
+	td, err := s.tdigestLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if td == nil {
+		return nil, errTDigestNoKey
+	}
+	return td, nil
+}
+
+func (s *InMemoryStore) TDigestCreate(key string, compression float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR T-Digest: key already exists")
+	}
+	s.KeyType[key] = TDigestType
+	s.TDigestKV[key] = NewTDigest(compression)
+	return nil
+}
+
+func (s *InMemoryStore) TDigestAdd(key string, values []float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		td.Add(value)
+	}
+	return nil
+}
+
+// TDigestMerge merges the source digests into destination, creating it with
+// the given compression, or the largest of the sources' when it is zero. With
+// override an existing destination is replaced rather than merged into.
+func (s *InMemoryStore) TDigestMerge(destination string, sources []string, compression float64, override bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digests := make([]*TDigest, len(sources))
+	for i, source := range sources {
+		td, err := s.tdigestExistingLocked(source)
+		if err != nil {
+			return err
+		}
+		digests[i] = td
+	}
+	dest, err := s.tdigestLocked(destination)
+	if err != nil {
+		return err
+	}
+
+	if compression == 0 {
+		for _, td := range digests {
+			compression = max(compression, td.compression)
+		}
+		if dest != nil && !override {
+			compression = max(compression, dest.compression)
+		}
+	}
+	merged := NewTDigest(compression)
+	if dest != nil && !override {
+		merged.Merge(dest)
+	}
+	for _, td := range digests {
+		merged.Merge(td)
+	}
+	s.KeyType[destination] = TDigestType
+	s.TDigestKV[destination] = merged
+	return nil
+}
+
+func (s *InMemoryStore) TDigestQuantile(key string, quantiles []float64) ([]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(quantiles))
+	for i, q := range quantiles {
+		values[i] = td.Quantile(q)
+	}
+	return values, nil
+}
+
+func (s *InMemoryStore) TDigestCDF(key string, values []float64) ([]float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	fractions := make([]float64, len(values))
+	for i, value := range values {
+		fractions[i] = td.CDF(value)
+	}
+	return fractions, nil
+}
+
+// TDigestRank estimates the number of samples below each value, counting
+// those equal to it as half and rounding halves down as RedisBloom does.
+// Values below the minimum rank -1, and every value ranks -2 in an empty
+// digest.
+func (s *InMemoryStore) TDigestRank(key string, values []float64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	ranks := make([]int64, len(values))
+	for i, value := range values {
+		switch {
+		case td.Len() == 0:
+			ranks[i] = -2
+		case value < td.min:
+			ranks[i] = -1
+		case value > td.max:
+			ranks[i] = int64(td.Len())
+		default:
+			ranks[i] = int64(math.Ceil(td.CDF(value)*td.Len() - 0.5))
+		}
+	}
+	return ranks, nil
+}
+
+// TDigestMinMax returns the smallest and largest sample, NaN when empty.
+func (s *InMemoryStore) TDigestMinMax(key string) (float64, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return td.Min(), td.Max(), nil
+}
+
+func (s *InMemoryStore) TDigestTrimmedMean(key string, low float64, high float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	td, err := s.tdigestExistingLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	return td.TrimmedMean(low, high), nil
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"testing"
+)
+
+var tdigestQuantiles = []float64{0.0001, 0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999, 0.9999}
+
+// checkQuantiles compares the quantiles td estimates with the sorted
+// samples it was fed. The error is measured in rank, and is allowed to be
+// smaller towards the tails, where t-digest keeps its centroids small.
+func checkQuantiles(t *testing.T, name string, td *TDigest, samples []float64) {
+	t.Helper()
+	previous := math.Inf(-1)
+	for _, q := range tdigestQuantiles {
+		estimate := td.Quantile(q)
+		if estimate < previous {
+			t.Errorf("%s: quantile %v is %v, below the previous quantile %v", name, q, estimate, previous)
+		}
+		previous = estimate
+
+		below := sort.SearchFloat64s(samples, estimate)
+		notAbove := sort.Search(len(samples), func(i int) bool { return samples[i] > estimate })
+		low, high := float64(below)/float64(len(samples)), float64(notAbove)/float64(len(samples))
+		rankError := max(low-q, q-high, 0)
+
+		bound := 0.002
+		if q < 0.01 || q > 0.99 {
+			bound = 0.0005
+		}
+		if rankError > bound {
+			t.Errorf("%s: quantile %v is %v, which ranks between %v and %v", name, q, estimate, low, high)
+		}
+	}
+}
+
+func newTDigestSamples(rng *rand.Rand, n int, sample func() float64) (*TDigest, []float64) {
+	td := NewTDigest(TDigestDefaultCompression)
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = sample()
+		td.Add(samples[i])
+	}
+	slices.Sort(samples)
+	return td, samples
+}
+
+func TestTDigestAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	distributions := []struct {
+		name   string
+		sample func() float64
+	}{
+		{"uniform", rng.Float64},
+		{"normal", rng.NormFloat64},
+		{"exponential", rng.ExpFloat64},
+		{"discrete", func() float64 { return float64(rng.Intn(10)) }},
+	}
+	for _, distribution := range distributions {
+		td, samples := newTDigestSamples(rng, 100000, distribution.sample)
+		checkQuantiles(t, distribution.name, td, samples)
+		if len(td.centroids) > 2*TDigestDefaultCompression {
+			t.Errorf("%s: %d centroids for compression %d", distribution.name, len(td.centroids), TDigestDefaultCompression)
+		}
+		if td.Min() != samples[0] || td.Max() != samples[len(samples)-1] {
+			t.Errorf("%s: min and max %v, %v, want %v, %v", distribution.name, td.Min(), td.Max(), samples[0], samples[len(samples)-1])
+		}
+
+		previous := 0.0
+		for _, q := range tdigestQuantiles {
+			value := samples[int(q*float64(len(samples)))]
+			cdf := td.CDF(value)
+			if cdf < previous {
+				t.Errorf("%s: CDF(%v) = %v, below the CDF of a smaller value", distribution.name, value, cdf)
+			}
+			previous = cdf
+		}
+	}
+}
+
+// TestTDigestMerge merges digests of differently distributed samples and
+// checks the result is as accurate as a digest of all of them.
+func TestTDigestMerge(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	normal, normalSamples := newTDigestSamples(rng, 50000, rng.NormFloat64)
+	shifted, shiftedSamples := newTDigestSamples(rng, 30000, func() float64 { return 3 + rng.ExpFloat64() })
+	small, smallSamples := newTDigestSamples(rng, 7, rng.NormFloat64)
+
+	merged := NewTDigest(TDigestDefaultCompression)
+	for _, td := range []*TDigest{normal, shifted, small} {
+		merged.Merge(td)
+	}
+	all := slices.Concat(normalSamples, shiftedSamples, smallSamples)
+	slices.Sort(all)
+
+	if merged.Len() != float64(len(all)) || merged.Min() != all[0] || merged.Max() != all[len(all)-1] {
+		t.Errorf("merged digest holds %v samples between %v and %v", merged.Len(), merged.Min(), merged.Max())
+	}
+	checkQuantiles(t, "merged", merged, all)
+
+	// Merging leaves the sources alone.
+	if normal.Len() != 50000 || small.Len() != 7 {
+		t.Errorf("sources hold %v and %v samples after merging", normal.Len(), small.Len())
+	}
+	checkQuantiles(t, "merged source", normal, normalSamples)
+}
+
+// The expected values are the ones the RedisBloom documentation gives for
+// the same commands.
+func TestTDigestMatchesRedis(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TDigestCreate("t", 1000)
+	s.TDigestAdd("t", []float64{1, 2, 2, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 5})
+	if got, _ := s.TDigestQuantile("t", []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}); !slices.Equal(got, []float64{1, 2, 3, 3, 4, 4, 4, 5, 5, 5, 5}) {
+		t.Errorf("TDigestQuantile = %v", got)
+	}
+	got, _ := s.TDigestCDF("t", []float64{0, 1, 2, 3, 4, 5, 6})
+	want := []float64{0, 0.5 / 15, 2 / 15.0, 4.5 / 15, 8 / 15.0, 12.5 / 15, 1}
+	if !slices.EqualFunc(got, want, func(a, b float64) bool { return near(a, b, 1e-12) }) {
+		t.Errorf("TDigestCDF = %v, want %v", got, want)
+	}
+
+	s.TDigestCreate("s", 1000)
+	s.TDigestAdd("s", []float64{10, 20, 30, 40, 50, 60})
+	if got, _ := s.TDigestRank("s", []float64{0, 10, 20, 30, 40, 50, 60, 70}); !slices.Equal(got, []int64{-1, 0, 1, 2, 3, 4, 5, 6}) {
+		t.Errorf("TDigestRank = %v", got)
+	}
+	s.TDigestCreate("r", 1000)
+	s.TDigestAdd("r", []float64{10, 10, 10, 10, 20, 20})
+	if got, _ := s.TDigestRank("r", []float64{10, 20}); !slices.Equal(got, []int64{2, 5}) {
+		t.Errorf("TDigestRank with repeated values = %v", got)
+	}
+
+	s.TDigestCreate("m", 1000)
+	s.TDigestAdd("m", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+	for _, test := range []struct{ low, high, want float64 }{{0.1, 0.6, 4}, {0.3, 0.9, 6.5}, {0, 1, 5.5}} {
+		if got, _ := s.TDigestTrimmedMean("m", test.low, test.high); got != test.want {
+			t.Errorf("TDigestTrimmedMean(%v, %v) = %v, want %v", test.low, test.high, got, test.want)
+		}
+	}
+	if low, high, _ := s.TDigestMinMax("m"); low != 1 || high != 10 {
+		t.Errorf("TDigestMinMax = %v, %v", low, high)
+	}
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TDigestCreate("t", 100)
+	if got, _ := s.TDigestQuantile("t", []float64{0.5}); !math.IsNaN(got[0]) {
+		t.Errorf("quantile of an empty digest = %v", got)
+	}
+	if got, _ := s.TDigestCDF("t", []float64{1}); !math.IsNaN(got[0]) {
+		t.Errorf("CDF of an empty digest = %v", got)
+	}
+	if got, _ := s.TDigestRank("t", []float64{1}); got[0] != -2 {
+		t.Errorf("rank in an empty digest = %v", got)
+	}
+	if low, high, _ := s.TDigestMinMax("t"); !math.IsNaN(low) || !math.IsNaN(high) {
+		t.Errorf("min and max of an empty digest = %v, %v", low, high)
+	}
+	if got, _ := s.TDigestTrimmedMean("t", 0, 1); !math.IsNaN(got) {
+		t.Errorf("trimmed mean of an empty digest = %v", got)
+	}
+}
+
+func TestTDigestMergeCommand(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TDigestCreate("a", 50)
+	s.TDigestCreate("b", 200)
+	s.TDigestAdd("a", []float64{1, 2, 3})
+	s.TDigestAdd("b", []float64{4, 5})
+
+	if err := s.TDigestMerge("dest", []string{"a", "b"}, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if td := s.TDigestKV["dest"]; td.compression != 200 || td.Len() != 5 {
+		t.Errorf("merged digest has compression %v and %v samples", td.compression, td.Len())
+	}
+
+	// An existing destination is merged into unless overridden.
+	s.TDigestMerge("dest", []string{"a"}, 0, false)
+	if low, high, _ := s.TDigestMinMax("dest"); s.TDigestKV["dest"].Len() != 8 || low != 1 || high != 5 {
+		t.Errorf("merge into dest holds %v samples between %v and %v", s.TDigestKV["dest"].Len(), low, high)
+	}
+	s.TDigestMerge("dest", []string{"a"}, 10, true)
+	if td := s.TDigestKV["dest"]; td.compression != 10 || td.Len() != 3 {
+		t.Errorf("overriding merge has compression %v and %v samples", td.compression, td.Len())
+	}
+
+	// The destination may be one of the sources.
+	s.TDigestMerge("a", []string{"a", "b"}, 0, false)
+	if got := s.TDigestKV["a"].Len(); got != 8 {
+		t.Errorf("merge into a source holds %v samples, want 8", got)
+	}
+
+	if err := s.TDigestMerge("dest", []string{"a", "missing"}, 0, false); err == nil {
+		t.Error("TDigestMerge from a missing key did not fail")
+	}
+	if err := s.TDigestAdd("missing", []float64{1}); err == nil {
+		t.Error("TDigestAdd on a missing key did not fail")
+	}
+	if err := s.TDigestCreate("a", 100); err == nil {
+		t.Error("TDigestCreate of an existing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if err := s.TDigestMerge("list", []string{"a"}, 0, false); err == nil {
+		t.Error("TDigestMerge into a list did not fail")
+	}
+}
+
+func TestTDigestSnapshot(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	td, samples := newTDigestSamples(rng, 1234, rng.NormFloat64)
+	if len(td.buffer) == 0 {
+		t.Fatal("digest has no buffered samples to save")
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(td); err != nil {
+		t.Fatal(err)
+	}
+	var decoded TDigest
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Len() != td.Len() || decoded.Min() != td.Min() || decoded.Max() != td.Max() || decoded.compression != td.compression {
+		t.Errorf("decoded %v samples between %v and %v", decoded.Len(), decoded.Min(), decoded.Max())
+	}
+	for _, q := range tdigestQuantiles {
+		if got, want := decoded.Quantile(q), td.Quantile(q); got != want {
+			t.Errorf("decoded quantile %v is %v, want %v", q, got, want)
+		}
+	}
+	if got, want := decoded.CDF(samples[len(samples)/3]), td.CDF(samples[len(samples)/3]); got != want {
+		t.Errorf("decoded CDF is %v, want %v", got, want)
+	}
+}
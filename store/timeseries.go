@@ -0,0 +1,564 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duplicate policies decide what happens when a sample is added at a
+// timestamp that already has one.
+const (
+	TSDuplicateBlock = "BLOCK"
+	TSDuplicateFirst = "FIRST"
+	TSDuplicateLast  = "LAST"
+	TSDuplicateMin   = "MIN"
+	TSDuplicateMax   = "MAX"
+	TSDuplicateSum   = "SUM"
+)
+
+var errTSNoKey = errors.New("-ERR TSDB: the key does not exist")
+
+// TSSample is a value at a timestamp in milliseconds.
+type TSSample struct {
+	Timestamp int64
+	Value     float64
+}
+
+type TSLabel struct {
+	Name  string
+	Value string
+}
+
+// TSOptions are the options of TS.CREATE, which TS.ADD and TS.INCRBY also
+// take for the series they create. A zero Retention keeps samples forever.
+type TSOptions struct {
+	Retention       int64
+	DuplicatePolicy string
+	Labels          []TSLabel
+}
+
+// TSAggregation groups samples into buckets of BucketDuration milliseconds
+// starting at multiples of it offset by Align, and reduces each bucket to a
+// single sample at its start. An empty Type means no aggregation.
+type TSAggregation struct {
+	Type           string
+	BucketDuration int64
+	Align          int64
+}
+
+func (aggregation TSAggregation) bucketStart(timestamp int64) int64 {
+	offset := (timestamp - aggregation.Align) % aggregation.BucketDuration
+	if offset < 0 {
+		offset += aggregation.BucketDuration
+	}
+	return timestamp - offset
+}
+
+// reduce aggregates a non-empty run of samples.
+func (aggregation TSAggregation) reduce(samples []TSSample) float64 {
+	switch aggregation.Type {
+	case "sum", "avg":
+		sum := 0.0
+		for _, sample := range samples {
+			sum += sample.Value
+		}
+		if aggregation.Type == "avg" {
+			return sum / float64(len(samples))
+		}
+		return sum
+	case "min":
+		lowest := math.Inf(1)
+		for _, sample := range samples {
+			lowest = min(lowest, sample.Value)
+		}
+		return lowest
+	case "max":
+		highest := math.Inf(-1)
+		for _, sample := range samples {
+			highest = max(highest, sample.Value)
+		}
+		return highest
+	case "count":
+		return float64(len(samples))
+	}
+	return samples[len(samples)-1].Value
+}
+
+// apply aggregates samples, which are in timestamp order, bucket by bucket.
+func (aggregation TSAggregation) apply(samples []TSSample) []TSSample {
+	var result []TSSample
+	for start := 0; start < len(samples); {
+		bucket := aggregation.bucketStart(samples[start].Timestamp)
+		end := start + 1
+		for end < len(samples) && samples[end].Timestamp < bucket+aggregation.BucketDuration {
+			end++
+		}
+		// Alignment can put the first bucket before the epoch, which is
+		// reported as starting at zero.
+		result = append(result, TSSample{Timestamp: max(bucket, 0), Value: aggregation.reduce(samples[start:end])})
+		start = end
+	}
+	return result
+}
+
+// tsRule is a compaction rule that aggregates the samples of its series into
+// the series at destination. The bucket that is still filling up is open;
+// it is written once a sample lands in a later bucket.
+type tsRule struct {
+	destination string
+	aggregation TSAggregation
+	open        int64
+	hasOpen     bool
+}
+
+type TimeSeries struct {
+	samples         []TSSample
+	retention       int64
+	duplicatePolicy string
+	labels          []TSLabel
+	rules           []*tsRule
+	source          string
+}
+
+func NewTimeSeries(options TSOptions) *TimeSeries {
+	policy := options.DuplicatePolicy
+	if policy == "" {
+		policy = TSDuplicateBlock
+	}
+	return &TimeSeries{retention: options.Retention, duplicatePolicy: policy, labels: options.Labels}
+}
+
+func (ts *TimeSeries) Encoding() string {
+	return "raw"
+}
+
+func (ts *TimeSeries) last() (TSSample, bool) {
+	if len(ts.samples) == 0 {
+		return TSSample{}, false
+	}
+	return ts.samples[len(ts.samples)-1], true
+}
+
+func (ts *TimeSeries) search(timestamp int64) int {
+	return sort.Search(len(ts.samples), func(i int) bool {
+		return ts.samples[i].Timestamp >= timestamp
+	})
+}
+
+// upsert adds sample, resolving a clash with an existing sample by policy,
+// and drops the samples that fall out of the retention window.
+func (ts *TimeSeries) upsert(sample TSSample, policy string) error {
+	if last, ok := ts.last(); ok && ts.retention > 0 && sample.Timestamp < last.Timestamp-ts.retention {
+		return errors.New("-ERR TSDB: Timestamp is older than retention")
+	}
+
+	i := ts.search(sample.Timestamp)
+	if i < len(ts.samples) && ts.samples[i].Timestamp == sample.Timestamp {
+		existing := &ts.samples[i]
+		switch policy {
+		case TSDuplicateBlock:
+			return errors.New("-ERR TSDB: Error at upsert, update is not supported when DUPLICATE_POLICY is set to BLOCK mode")
+		case TSDuplicateLast:
+			existing.Value = sample.Value
+		case TSDuplicateMin:
+			existing.Value = min(existing.Value, sample.Value)
+		case TSDuplicateMax:
+			existing.Value = max(existing.Value, sample.Value)
+		case TSDuplicateSum:
+			existing.Value += sample.Value
+		}
+		return nil
+	}
+	ts.samples = slices.Insert(ts.samples, i, sample)
+
+	if ts.retention > 0 {
+		last, _ := ts.last()
+		if expired := ts.search(last.Timestamp - ts.retention); expired > 0 {
+			ts.samples = slices.Delete(ts.samples, 0, expired)
+		}
+	}
+	return nil
+}
+
+// Range returns the samples from from to to inclusive.
+func (ts *TimeSeries) Range(from int64, to int64) []TSSample {
+	start, end := ts.search(from), len(ts.samples)
+	if to < math.MaxInt64 {
+		end = ts.search(to + 1)
+	}
+	if start >= end {
+		return nil
+	}
+	return ts.samples[start:end]
+}
+
+func (ts *TimeSeries) label(name string) (string, bool) {
+	for _, label := range ts.labels {
+		if label.Name == name {
+			return label.Value, true
+		}
+	}
+	return "", false
+}
+
+type tsRuleSnapshot struct {
+	Destination string
+	Aggregation TSAggregation
+	Open        int64
+	HasOpen     bool
+}
+
+type timeSeriesSnapshot struct {
+	Samples         []TSSample
+	Retention       int64
+	DuplicatePolicy string
+	Labels          []TSLabel
+	Rules           []tsRuleSnapshot
+	Source          string
+}
+
+func (ts *TimeSeries) GobEncode() ([]byte, error) {
+	snapshot := timeSeriesSnapshot{
+		Samples:         ts.samples,
+		Retention:       ts.retention,
+		DuplicatePolicy: ts.duplicatePolicy,
+		Labels:          ts.labels,
+		Source:          ts.source,
+	}
+	for _, rule := range ts.rules {
+		snapshot.Rules = append(snapshot.Rules, tsRuleSnapshot{
+			Destination: rule.destination,
+			Aggregation: rule.aggregation,
+			Open:        rule.open,
+			HasOpen:     rule.hasOpen,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (ts *TimeSeries) GobDecode(data []byte) error {
+	var snapshot timeSeriesSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	ts.samples, ts.retention, ts.duplicatePolicy = snapshot.Samples, snapshot.Retention, snapshot.DuplicatePolicy
+	ts.labels, ts.source, ts.rules = snapshot.Labels, snapshot.Source, nil
+	for _, rule := range snapshot.Rules {
+		ts.rules = append(ts.rules, &tsRule{
+			destination: rule.Destination,
+			aggregation: rule.Aggregation,
+			open:        rule.Open,
+			hasOpen:     rule.HasOpen,
+		})
+	}
+	return nil
+}
+
+func (s *InMemoryStore) timeSeriesLocked(key string) (*TimeSeries, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != TimeSeriesType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.TimeSeriesKV[key], nil
+}
+
+// timeSeriesOrCreateLocked returns the series at key, creating it with
+// options if needed.
+func (s *InMemoryStore) timeSeriesOrCreateLocked(key string, options TSOptions) (*TimeSeries, error) {
+	ts, err := s.timeSeriesLocked(key)
+	if err != nil || ts != nil {
+		return ts, err
+	}
+	ts = NewTimeSeries(options)
+	s.KeyType[key] = TimeSeriesType
+	s.TimeSeriesKV[key] = ts
+	return ts, nil
+}
+
+func (s *InMemoryStore) TSCreate(key string, options TSOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR TSDB: key already exists")
+	}
+	s.KeyType[key] = TimeSeriesType
+	s.TimeSeriesKV[key] = NewTimeSeries(options)
+	return nil
+}
+
+// tsAddLocked adds a sample to ts and feeds it to the series' compaction
+// rules. An empty policy stands for the series' own.
+func (s *InMemoryStore) tsAddLocked(ts *TimeSeries, sample TSSample, policy string) error {
+	if policy == "" {
+		policy = ts.duplicatePolicy
+	}
+	if err := ts.upsert(sample, policy); err != nil {
+		return err
+	}
+
+	for _, rule := range ts.rules {
+		dest, err := s.timeSeriesLocked(rule.destination)
+		if err != nil || dest == nil {
+			continue
+		}
+		bucket := rule.aggregation.bucketStart(sample.Timestamp)
+		switch {
+		case !rule.hasOpen:
+			rule.open, rule.hasOpen = bucket, true
+		case bucket > rule.open:
+			s.tsCompactLocked(ts, dest, rule.aggregation, rule.open)
+			rule.open = bucket
+		case bucket < rule.open:
+			// A late sample changes a bucket that was already written.
+			s.tsCompactLocked(ts, dest, rule.aggregation, bucket)
+		}
+	}
+	return nil
+}
+
+// tsCompactLocked writes the aggregate of the bucket starting at bucket to
+// dest.
+func (s *InMemoryStore) tsCompactLocked(ts *TimeSeries, dest *TimeSeries, aggregation TSAggregation, bucket int64) {
+	samples := ts.Range(bucket, bucket+aggregation.BucketDuration-1)
+	if len(samples) == 0 {
+		return
+	}
+	s.tsAddLocked(dest, TSSample{Timestamp: bucket, Value: aggregation.reduce(samples)}, TSDuplicateLast)
+}
+
+// TSAdd adds a sample to the series at key, creating it with options if
+// needed. A nil timestamp stands for the current time.
+func (s *InMemoryStore) TSAdd(key string, timestamp *int64, value float64, options TSOptions, onDuplicate string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, err := s.timeSeriesOrCreateLocked(key, options)
+	if err != nil {
+		return 0, err
+	}
+	sample := TSSample{Timestamp: time.Now().UnixMilli(), Value: value}
+	if timestamp != nil {
+		sample.Timestamp = *timestamp
+	}
+	if err := s.tsAddLocked(ts, sample, onDuplicate); err != nil {
+		return 0, err
+	}
+	return sample.Timestamp, nil
+}
+
+// TSMAdd adds one sample to each series, which must already exist, failing
+// each sample on its own.
+func (s *InMemoryStore) TSMAdd(keys []string, samples []TSSample) []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		ts, err := s.timeSeriesLocked(key)
+		if err == nil && ts == nil {
+			err = errTSNoKey
+		}
+		if err == nil {
+			err = s.tsAddLocked(ts, samples[i], "")
+		}
+		errs[i] = err
+	}
+	return errs
+}
+
+// TSIncrBy adds value to the latest sample of the series at key, at the
+// given timestamp or the current time, which can't be earlier than the
+// latest sample.
+func (s *InMemoryStore) TSIncrBy(key string, value float64, timestamp *int64, options TSOptions) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, err := s.timeSeriesOrCreateLocked(key, options)
+	if err != nil {
+		return 0, err
+	}
+	sample := TSSample{Timestamp: time.Now().UnixMilli(), Value: value}
+	if timestamp != nil {
+		sample.Timestamp = *timestamp
+	}
+	if last, ok := ts.last(); ok {
+		if sample.Timestamp < last.Timestamp {
+			return 0, errors.New("-ERR TSDB: timestamp must be equal to or higher than the maximum existing timestamp")
+		}
+		sample.Value += last.Value
+	}
+	if err := s.tsAddLocked(ts, sample, TSDuplicateLast); err != nil {
+		return 0, err
+	}
+	return sample.Timestamp, nil
+}
+
+// TSRangeQuery selects the samples from From to To inclusive, aggregated if
+// Aggregation has a type, newest first when Reverse is set, and limited to
+// Count samples when it is positive.
+type TSRangeQuery struct {
+	From        int64
+	To          int64
+	Count       int
+	Aggregation TSAggregation
+	Reverse     bool
+}
+
+func (ts *TimeSeries) query(query TSRangeQuery) []TSSample {
+	samples := slices.Clone(ts.Range(query.From, query.To))
+	if query.Aggregation.Type != "" {
+		samples = query.Aggregation.apply(samples)
+	}
+	if query.Reverse {
+		slices.Reverse(samples)
+	}
+	if query.Count > 0 && len(samples) > query.Count {
+		samples = samples[:query.Count]
+	}
+	return samples
+}
+
+func (s *InMemoryStore) TSRange(key string, query TSRangeQuery) ([]TSSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, err := s.timeSeriesLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if ts == nil {
+		return nil, errTSNoKey
+	}
+	return ts.query(query), nil
+}
+
+// TSLabelMatcher is a TS.MRANGE filter. It matches series whose label has
+// one of Values, or none of them when Negate is set. An empty value stands
+// for the label being absent.
+type TSLabelMatcher struct {
+	Label  string
+	Values []string
+	Negate bool
+}
+
+func (matcher TSLabelMatcher) matches(ts *TimeSeries) bool {
+	value, _ := ts.label(matcher.Label)
+	return slices.Contains(matcher.Values, value) != matcher.Negate
+}
+
+// TSRangeResult is the range of a series matched by TS.MRANGE.
+type TSRangeResult struct {
+	Key     string
+	Labels  []TSLabel
+	Samples []TSSample
+}
+
+// TSMRange runs query on every series matching all the filters, in key
+// order.
+func (s *InMemoryStore) TSMRange(filters []TSLabelMatcher, query TSRangeQuery) []TSRangeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []TSRangeResult
+	for key, ts := range s.TimeSeriesKV {
+		matched := true
+		for _, filter := range filters {
+			if !filter.matches(ts) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			results = append(results, TSRangeResult{Key: key, Labels: ts.labels, Samples: ts.query(query)})
+		}
+	}
+	slices.SortFunc(results, func(a TSRangeResult, b TSRangeResult) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return results
+}
+
+// TSCreateRule compacts the samples added to source from now on into
+// destination. A series can only be compacted into one that is neither a
+// source nor the destination of another rule.
+func (s *InMemoryStore) TSCreateRule(source string, destination string, aggregation TSAggregation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if source == destination {
+		return errors.New("-ERR TSDB: the source key and destination key should be different")
+	}
+	src, err := s.timeSeriesLocked(source)
+	if err != nil {
+		return err
+	}
+	dest, err := s.timeSeriesLocked(destination)
+	if err != nil {
+		return err
+	}
+	if src == nil || dest == nil {
+		return errTSNoKey
+	}
+	if src.source != "" {
+		return errors.New("-ERR TSDB: the source key already has a source rule")
+	}
+	if dest.source != "" || len(dest.rules) > 0 {
+		return errors.New("-ERR TSDB: the destination key already has a src rule")
+	}
+
+	src.rules = append(src.rules, &tsRule{destination: destination, aggregation: aggregation})
+	dest.source = source
+	return nil
+}
+
+// unlinkTimeSeriesLocked drops the compaction rules into and out of the
+// series at key before it is deleted, so a series created at either end
+// later doesn't inherit them.
+func (s *InMemoryStore) unlinkTimeSeriesLocked(key string) {
+	ts := s.TimeSeriesKV[key]
+	if ts.source != "" {
+		if src := s.TimeSeriesKV[ts.source]; src != nil {
+			src.rules = slices.DeleteFunc(src.rules, func(rule *tsRule) bool { return rule.destination == key })
+		}
+	}
+	for _, rule := range ts.rules {
+		if dest := s.TimeSeriesKV[rule.destination]; dest != nil {
+			dest.source = ""
+		}
+	}
+}
+
+func (s *InMemoryStore) TSDeleteRule(source string, destination string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := s.timeSeriesLocked(source)
+	if err != nil {
+		return err
+	}
+	if src == nil {
+		return errTSNoKey
+	}
+	i := slices.IndexFunc(src.rules, func(rule *tsRule) bool { return rule.destination == destination })
+	if i < 0 {
+		return errors.New("-ERR TSDB: compaction rule does not exist")
+	}
+	src.rules = slices.Delete(src.rules, i, i+1)
+	if dest, err := s.timeSeriesLocked(destination); err == nil && dest != nil {
+		dest.source = ""
+	}
+	return nil
+}
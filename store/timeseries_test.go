@@ -0,0 +1,354 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"slices"
+	"testing"
+)
+
+func tsAdd(t *testing.T, s *InMemoryStore, key string, samples ...TSSample) {
+	t.Helper()
+	for _, sample := range samples {
+		if _, err := s.TSAdd(key, &sample.Timestamp, sample.Value, TSOptions{}, ""); err != nil {
+			t.Fatalf("TSAdd(%s, %+v) = %v", key, sample, err)
+		}
+	}
+}
+
+func tsRange(t *testing.T, s *InMemoryStore, key string, query TSRangeQuery) []TSSample {
+	t.Helper()
+	samples, err := s.TSRange(key, query)
+	if err != nil {
+		t.Fatalf("TSRange(%s) = %v", key, err)
+	}
+	return samples
+}
+
+var tsEverything = TSRangeQuery{From: 0, To: 1 << 62}
+
+func TestTSDuplicatePolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		fails  bool
+		want   float64
+	}{
+		{TSDuplicateBlock, true, 5},
+		{TSDuplicateFirst, false, 5},
+		{TSDuplicateLast, false, 3},
+		{TSDuplicateMin, false, 3},
+		{TSDuplicateMax, false, 5},
+		{TSDuplicateSum, false, 8},
+	}
+	for _, test := range tests {
+		s := NewInMemoryStore()
+		s.TSCreate("ts", TSOptions{DuplicatePolicy: test.policy})
+		tsAdd(t, s, "ts", TSSample{10, 5})
+		timestamp := int64(10)
+		if _, err := s.TSAdd("ts", &timestamp, 3, TSOptions{}, ""); (err != nil) != test.fails {
+			t.Errorf("%s: duplicate TSAdd = %v", test.policy, err)
+		}
+		if got := tsRange(t, s, "ts", tsEverything); !slices.Equal(got, []TSSample{{10, test.want}}) {
+			t.Errorf("%s: samples = %v, want value %v", test.policy, got, test.want)
+		}
+	}
+
+	// ON_DUPLICATE overrides the series' policy.
+	s := NewInMemoryStore()
+	timestamp := int64(10)
+	s.TSAdd("ts", &timestamp, 5, TSOptions{}, "")
+	if _, err := s.TSAdd("ts", &timestamp, 3, TSOptions{}, TSDuplicateSum); err != nil {
+		t.Errorf("TSAdd ON_DUPLICATE SUM = %v", err)
+	}
+	if got := tsRange(t, s, "ts", tsEverything); got[0].Value != 8 {
+		t.Errorf("ON_DUPLICATE SUM left %v", got)
+	}
+}
+
+func TestTSRetention(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TSCreate("ts", TSOptions{Retention: 100})
+	tsAdd(t, s, "ts", TSSample{1000, 1}, TSSample{1050, 2}, TSSample{950, 0}, TSSample{1120, 3})
+
+	if got := tsRange(t, s, "ts", tsEverything); !slices.Equal(got, []TSSample{{1050, 2}, {1120, 3}}) {
+		t.Errorf("samples = %v, want those within 100ms of the latest", got)
+	}
+	timestamp := int64(1000)
+	if _, err := s.TSAdd("ts", &timestamp, 9, TSOptions{}, ""); err == nil {
+		t.Error("TSAdd older than the retention window did not fail")
+	}
+}
+
+func TestTSRange(t *testing.T) {
+	s := NewInMemoryStore()
+	tsAdd(t, s, "ts", TSSample{1, 10}, TSSample{2, 20}, TSSample{5, 50}, TSSample{9, 90})
+
+	tests := []struct {
+		name  string
+		query TSRangeQuery
+		want  []TSSample
+	}{
+		{"everything", tsEverything, []TSSample{{1, 10}, {2, 20}, {5, 50}, {9, 90}}},
+		{"inclusive bounds", TSRangeQuery{From: 2, To: 5}, []TSSample{{2, 20}, {5, 50}}},
+		{"between samples", TSRangeQuery{From: 3, To: 4}, nil},
+		{"count", TSRangeQuery{From: 0, To: 100, Count: 2}, []TSSample{{1, 10}, {2, 20}}},
+		{"reverse with count", TSRangeQuery{From: 0, To: 100, Count: 3, Reverse: true}, []TSSample{{9, 90}, {5, 50}, {2, 20}}},
+	}
+	for _, test := range tests {
+		if got := tsRange(t, s, "ts", test.query); !slices.Equal(got, test.want) {
+			t.Errorf("%s: TSRange = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := s.TSRange("missing", tsEverything); err == nil {
+		t.Error("TSRange on a missing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.TSRange("list", tsEverything); err == nil {
+		t.Error("TSRange on a list did not fail")
+	}
+}
+
+func TestTSAggregation(t *testing.T) {
+	s := NewInMemoryStore()
+	tsAdd(t, s, "ts", TSSample{0, 4}, TSSample{3, 1}, TSSample{9, 7}, TSSample{10, 2}, TSSample{25, 6}, TSSample{27, 3})
+
+	tests := []struct {
+		aggregation TSAggregation
+		want        []TSSample
+	}{
+		{TSAggregation{"avg", 10, 0}, []TSSample{{0, 4}, {10, 2}, {20, 4.5}}},
+		{TSAggregation{"sum", 10, 0}, []TSSample{{0, 12}, {10, 2}, {20, 9}}},
+		{TSAggregation{"min", 10, 0}, []TSSample{{0, 1}, {10, 2}, {20, 3}}},
+		{TSAggregation{"max", 10, 0}, []TSSample{{0, 7}, {10, 2}, {20, 6}}},
+		{TSAggregation{"count", 10, 0}, []TSSample{{0, 3}, {10, 1}, {20, 2}}},
+		{TSAggregation{"last", 10, 0}, []TSSample{{0, 7}, {10, 2}, {20, 3}}},
+		// Buckets aligned to 5 start at 5, 15 and 25, and the one holding
+		// the first samples starts before the epoch.
+		{TSAggregation{"sum", 10, 5}, []TSSample{{0, 5}, {5, 9}, {25, 9}}},
+		{TSAggregation{"count", 1000, 0}, []TSSample{{0, 6}}},
+	}
+	for _, test := range tests {
+		got := tsRange(t, s, "ts", TSRangeQuery{From: 0, To: 100, Aggregation: test.aggregation})
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%+v: TSRange = %v, want %v", test.aggregation, got, test.want)
+		}
+	}
+
+	// Aggregation applies before the count and the reversal.
+	got := tsRange(t, s, "ts", TSRangeQuery{From: 0, To: 100, Count: 2, Reverse: true, Aggregation: TSAggregation{"max", 10, 0}})
+	if !slices.Equal(got, []TSSample{{20, 6}, {10, 2}}) {
+		t.Errorf("reversed aggregation with count = %v", got)
+	}
+}
+
+func TestTSIncrByAndMAdd(t *testing.T) {
+	s := NewInMemoryStore()
+	for _, step := range []struct {
+		timestamp int64
+		by        float64
+		want      []TSSample
+	}{
+		{10, 5, []TSSample{{10, 5}}},
+		{10, 2, []TSSample{{10, 7}}},
+		{20, -1, []TSSample{{10, 7}, {20, 6}}},
+	} {
+		if _, err := s.TSIncrBy("counter", step.by, &step.timestamp, TSOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if got := tsRange(t, s, "counter", tsEverything); !slices.Equal(got, step.want) {
+			t.Errorf("after TSIncrBy(%v at %d), samples = %v, want %v", step.by, step.timestamp, got, step.want)
+		}
+	}
+	timestamp := int64(15)
+	if _, err := s.TSIncrBy("counter", 1, &timestamp, TSOptions{}); err == nil {
+		t.Error("TSIncrBy before the latest sample did not fail")
+	}
+
+	s.TSCreate("other", TSOptions{})
+	errs := s.TSMAdd([]string{"counter", "missing", "other", "counter"}, []TSSample{{30, 1}, {30, 1}, {5, 2}, {20, 9}})
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil || errs[3] == nil {
+		t.Errorf("TSMAdd errors = %v", errs)
+	}
+	if got := tsRange(t, s, "counter", tsEverything); !slices.Equal(got, []TSSample{{10, 7}, {20, 6}, {30, 1}}) {
+		t.Errorf("after TSMAdd, samples = %v", got)
+	}
+}
+
+func TestTSMRange(t *testing.T) {
+	s := NewInMemoryStore()
+	series := []struct {
+		key    string
+		labels []TSLabel
+	}{
+		{"cpu:a", []TSLabel{{"metric", "cpu"}, {"host", "a"}}},
+		{"cpu:b", []TSLabel{{"metric", "cpu"}, {"host", "b"}}},
+		{"mem:a", []TSLabel{{"metric", "mem"}, {"host", "a"}}},
+		{"mem:c", []TSLabel{{"metric", "mem"}}},
+	}
+	for i, ts := range series {
+		s.TSCreate(ts.key, TSOptions{Labels: ts.labels})
+		tsAdd(t, s, ts.key, TSSample{int64(i), float64(i)})
+	}
+
+	tests := []struct {
+		filters []TSLabelMatcher
+		want    []string
+	}{
+		{[]TSLabelMatcher{{Label: "metric", Values: []string{"cpu"}}}, []string{"cpu:a", "cpu:b"}},
+		{[]TSLabelMatcher{{Label: "host", Values: []string{"a", "c"}}}, []string{"cpu:a", "mem:a"}},
+		{[]TSLabelMatcher{{Label: "metric", Values: []string{"mem"}}, {Label: "host", Values: []string{"a"}, Negate: true}}, []string{"mem:c"}},
+		{[]TSLabelMatcher{{Label: "metric", Values: []string{"mem"}}, {Label: "host", Values: []string{""}}}, []string{"mem:c"}},
+		{[]TSLabelMatcher{{Label: "metric", Values: []string{"cpu", "mem"}}, {Label: "host", Values: []string{""}, Negate: true}}, []string{"cpu:a", "cpu:b", "mem:a"}},
+		{[]TSLabelMatcher{{Label: "metric", Values: []string{"disk"}}}, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, result := range s.TSMRange(test.filters, tsEverything) {
+			got = append(got, result.Key)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("TSMRange(%+v) = %v, want %v", test.filters, got, test.want)
+		}
+	}
+
+	results := s.TSMRange([]TSLabelMatcher{{Label: "host", Values: []string{"b"}}}, tsEverything)
+	if len(results) != 1 || !slices.Equal(results[0].Samples, []TSSample{{1, 1}}) || !slices.Equal(results[0].Labels, series[1].labels) {
+		t.Errorf("TSMRange result = %+v", results)
+	}
+}
+
+func TestTSCompaction(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TSCreate("raw", TSOptions{DuplicatePolicy: TSDuplicateLast})
+	s.TSCreate("avg", TSOptions{})
+	s.TSCreate("max", TSOptions{})
+	if err := s.TSCreateRule("raw", "avg", TSAggregation{"avg", 10, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TSCreateRule("raw", "max", TSAggregation{"max", 10, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bucket is written once a sample lands in a later one.
+	tsAdd(t, s, "raw", TSSample{1, 2}, TSSample{5, 4})
+	if got := tsRange(t, s, "avg", tsEverything); got != nil {
+		t.Errorf("open bucket was written: %v", got)
+	}
+	tsAdd(t, s, "raw", TSSample{12, 8}, TSSample{35, 1})
+	if got := tsRange(t, s, "avg", tsEverything); !slices.Equal(got, []TSSample{{0, 3}, {10, 8}}) {
+		t.Errorf("avg = %v", got)
+	}
+	if got := tsRange(t, s, "max", tsEverything); !slices.Equal(got, []TSSample{{0, 4}, {10, 8}}) {
+		t.Errorf("max = %v", got)
+	}
+
+	// A late sample rewrites the bucket it falls in.
+	tsAdd(t, s, "raw", TSSample{7, 9})
+	if got := tsRange(t, s, "avg", tsEverything); !slices.Equal(got, []TSSample{{0, 5}, {10, 8}}) {
+		t.Errorf("avg after a late sample = %v", got)
+	}
+
+	if err := s.TSDeleteRule("raw", "max"); err != nil {
+		t.Fatal(err)
+	}
+	tsAdd(t, s, "raw", TSSample{45, 0})
+	if got := tsRange(t, s, "max", tsEverything); len(got) != 2 {
+		t.Errorf("deleted rule still compacts: %v", got)
+	}
+	if got := tsRange(t, s, "avg", tsEverything); !slices.Equal(got, []TSSample{{0, 5}, {10, 8}, {30, 1}}) {
+		t.Errorf("avg = %v", got)
+	}
+	if err := s.TSDeleteRule("raw", "max"); err == nil {
+		t.Error("deleting a missing rule did not fail")
+	}
+}
+
+func TestTSCreateRuleErrors(t *testing.T) {
+	s := NewInMemoryStore()
+	for _, key := range []string{"a", "b", "c"} {
+		s.TSCreate(key, TSOptions{})
+	}
+	aggregation := TSAggregation{"sum", 10, 0}
+	s.TSCreateRule("a", "b", aggregation)
+
+	tests := []struct {
+		name                string
+		source, destination string
+	}{
+		{"to itself", "a", "a"},
+		{"from a missing key", "missing", "c"},
+		{"to a missing key", "c", "missing"},
+		{"from a destination", "b", "c"},
+		{"to a destination", "c", "b"},
+		{"to a source", "c", "a"},
+	}
+	for _, test := range tests {
+		if err := s.TSCreateRule(test.source, test.destination, aggregation); err == nil {
+			t.Errorf("TSCreateRule %s did not fail", test.name)
+		}
+	}
+}
+
+// TestTSDeleteUnlinksRules deletes both ends of a rule and checks series
+// created at the same keys afterwards start without rules.
+func TestTSDeleteUnlinksRules(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TSCreate("src", TSOptions{})
+	s.TSCreate("dest", TSOptions{})
+	s.TSCreateRule("src", "dest", TSAggregation{"sum", 10, 0})
+
+	s.NumKeyExists([]string{"dest"}, true)
+	if rules := s.TimeSeriesKV["src"].rules; len(rules) != 0 {
+		t.Errorf("source keeps %d rules into a deleted series", len(rules))
+	}
+	s.TSCreate("dest", TSOptions{})
+	tsAdd(t, s, "src", TSSample{1, 1}, TSSample{20, 1})
+	if got := tsRange(t, s, "dest", tsEverything); got != nil {
+		t.Errorf("new series at a deleted destination was compacted into: %v", got)
+	}
+
+	s.TSCreateRule("src", "dest", TSAggregation{"sum", 10, 0})
+	s.NumKeyExists([]string{"src"}, true)
+	if err := s.TSCreateRule("other", "dest", TSAggregation{}); err != errTSNoKey {
+		t.Errorf("TSCreateRule = %v", err)
+	}
+	s.TSCreate("other", TSOptions{})
+	if err := s.TSCreateRule("other", "dest", TSAggregation{"sum", 10, 0}); err != nil {
+		t.Errorf("destination of a deleted source can't take a new rule: %v", err)
+	}
+}
+
+func TestTimeSeriesSnapshot(t *testing.T) {
+	s := NewInMemoryStore()
+	s.TSCreate("raw", TSOptions{Retention: 1000, DuplicatePolicy: TSDuplicateSum, Labels: []TSLabel{{"host", "a"}}})
+	s.TSCreate("agg", TSOptions{})
+	s.TSCreateRule("raw", "agg", TSAggregation{"count", 10, 3})
+	tsAdd(t, s, "raw", TSSample{5, 1}, TSSample{8, 2}, TSSample{14, 3})
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s.TimeSeriesKV); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewInMemoryStore()
+	if err := gob.NewDecoder(&buf).Decode(&loaded.TimeSeriesKV); err != nil {
+		t.Fatal(err)
+	}
+	for key := range loaded.TimeSeriesKV {
+		loaded.KeyType[key] = TimeSeriesType
+	}
+
+	raw := loaded.TimeSeriesKV["raw"]
+	if raw.retention != 1000 || raw.duplicatePolicy != TSDuplicateSum || !slices.Equal(raw.labels, []TSLabel{{"host", "a"}}) {
+		t.Errorf("decoded series has retention %d, policy %s, labels %v", raw.retention, raw.duplicatePolicy, raw.labels)
+	}
+	if loaded.TimeSeriesKV["agg"].source != "raw" {
+		t.Error("decoded destination lost its source")
+	}
+
+	// The open bucket carries over, so the next bucket closes it.
+	tsAdd(t, loaded, "raw", TSSample{25, 4})
+	if got := tsRange(t, loaded, "agg", tsEverything); !slices.Equal(got, []TSSample{{3, 2}, {13, 1}}) {
+		t.Errorf("agg after reload = %v", got)
+	}
+}
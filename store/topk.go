@@ -0,0 +1,279 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// TopK tracks the heaviest hitters of a stream with the HeavyKeeper
+// algorithm, as RedisBloom does. Each of depth rows of width buckets holds a
+// fingerprint and a count. An item increments its bucket in every row where
+// it owns the bucket, and otherwise decays the owner's count with
+// probability decay^count, taking the bucket over once the count reaches
+// zero. The largest count it reaches in any row is its estimate, and the k
+// items with the largest estimates are kept in a min-heap.
+const (
+	TopKDefaultWidth = 8
+	TopKDefaultDepth = 7
+	TopKDefaultDecay = 0.9
+)
+
+var errTopKNoKey = errors.New("-ERR TopK: key does not exist")
+
+type topKBucket struct {
+	fingerprint uint32
+	count       uint32
+}
+
+// TopKItem is an item tracked by a TopK with its estimated count.
+type TopKItem struct {
+	Item  string
+	Count uint32
+}
+
+type TopK struct {
+	k       int
+	width   uint64
+	depth   uint64
+	decay   float64
+	buckets []topKBucket
+	heap    []TopKItem
+}
+
+func NewTopK(k int, width uint64, depth uint64, decay float64) *TopK {
+	return &TopK{
+		k:       k,
+		width:   width,
+		depth:   depth,
+		decay:   decay,
+		buckets: make([]topKBucket, width*depth),
+	}
+}
+
+func (topk *TopK) Encoding() string {
+	return "raw"
+}
+
+func (topk *TopK) heapIndex(item string) int {
+	return slices.IndexFunc(topk.heap, func(entry TopKItem) bool { return entry.Item == item })
+}
+
+// less orders the heap by count, so the root is the lightest item.
+func (topk *TopK) less(i int, j int) bool {
+	return topk.heap[i].Count < topk.heap[j].Count
+}
+
+func (topk *TopK) up(i int) {
+	for i > 0 {
+		parent := (i - 1) / 2
+		if !topk.less(i, parent) {
+			break
+		}
+		topk.heap[i], topk.heap[parent] = topk.heap[parent], topk.heap[i]
+		i = parent
+	}
+}
+
+func (topk *TopK) down(i int) {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(topk.heap) && topk.less(child, smallest) {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return
+		}
+		topk.heap[i], topk.heap[smallest] = topk.heap[smallest], topk.heap[i]
+		i = smallest
+	}
+}
+
+// IncrBy counts item increment times and returns the item it pushed out of
+// the top k, if any.
+func (topk *TopK) IncrBy(item string, increment uint32) (string, bool) {
+	fingerprint := uint32(murmurHash64A([]byte(item), math.MaxUint32))
+	estimate := uint32(0)
+	for row := range topk.depth {
+		column := murmurHash64A([]byte(item), row) % topk.width
+		bucket := &topk.buckets[row*topk.width+column]
+
+		switch {
+		case bucket.count == 0:
+			bucket.fingerprint, bucket.count = fingerprint, increment
+		case bucket.fingerprint == fingerprint:
+			bucket.count += increment
+		default:
+			for remaining := increment; remaining > 0; remaining-- {
+				if rand.Float64() < math.Pow(topk.decay, float64(bucket.count)) {
+					bucket.count--
+					if bucket.count == 0 {
+						bucket.fingerprint, bucket.count = fingerprint, remaining
+						break
+					}
+				}
+			}
+		}
+		if bucket.fingerprint == fingerprint {
+			estimate = max(estimate, bucket.count)
+		}
+	}
+
+	if i := topk.heapIndex(item); i >= 0 {
+		topk.heap[i].Count = max(topk.heap[i].Count, estimate)
+		topk.down(i)
+		topk.up(i)
+		return "", false
+	}
+	if estimate == 0 {
+		return "", false
+	}
+	if len(topk.heap) < topk.k {
+		topk.heap = append(topk.heap, TopKItem{Item: item, Count: estimate})
+		topk.up(len(topk.heap) - 1)
+		return "", false
+	}
+	if estimate <= topk.heap[0].Count {
+		return "", false
+	}
+	expelled := topk.heap[0].Item
+	topk.heap[0] = TopKItem{Item: item, Count: estimate}
+	topk.down(0)
+	return expelled, true
+}
+
+func (topk *TopK) Contains(item string) bool {
+	return topk.heapIndex(item) >= 0
+}
+
+// List returns the tracked items from heaviest to lightest.
+func (topk *TopK) List() []TopKItem {
+	items := slices.Clone(topk.heap)
+	slices.SortStableFunc(items, func(a TopKItem, b TopKItem) int {
+		if a.Count != b.Count {
+			return int(b.Count) - int(a.Count)
+		}
+		return strings.Compare(a.Item, b.Item)
+	})
+	return items
+}
+
+type topKSnapshot struct {
+	K            int
+	Width        uint64
+	Depth        uint64
+	Decay        float64
+	Fingerprints []uint32
+	Counts       []uint32
+	Heap         []TopKItem
+}
+
+func (topk *TopK) GobEncode() ([]byte, error) {
+	snapshot := topKSnapshot{K: topk.k, Width: topk.width, Depth: topk.depth, Decay: topk.decay, Heap: topk.heap}
+	for _, bucket := range topk.buckets {
+		snapshot.Fingerprints = append(snapshot.Fingerprints, bucket.fingerprint)
+		snapshot.Counts = append(snapshot.Counts, bucket.count)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (topk *TopK) GobDecode(data []byte) error {
+	var snapshot topKSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	topk.k, topk.width, topk.depth, topk.decay = snapshot.K, snapshot.Width, snapshot.Depth, snapshot.Decay
+	topk.heap = snapshot.Heap
+	topk.buckets = make([]topKBucket, len(snapshot.Counts))
+	for i := range topk.buckets {
+		topk.buckets[i] = topKBucket{fingerprint: snapshot.Fingerprints[i], count: snapshot.Counts[i]}
+	}
+	return nil
+}
+
+func (s *InMemoryStore) topKLocked(key string) (*TopK, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != TopKType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.TopKKV[key], nil
+}
+
+func (s *InMemoryStore) TopKReserve(key string, k int, width uint64, depth uint64, decay float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.KeyType[key]; ok {
+		return errors.New("-ERR TopK: key already exists")
+	}
+	s.KeyType[key] = TopKType
+	s.TopKKV[key] = NewTopK(k, width, depth, decay)
+	return nil
+}
+
+// TopKIncrBy counts each item by its increment and returns the items they
+// pushed out of the top k.
+func (s *InMemoryStore) TopKIncrBy(key string, items []string, increments []uint32) ([]string, []bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topk, err := s.topKLocked(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if topk == nil {
+		return nil, nil, errTopKNoKey
+	}
+
+	expelled := make([]string, len(items))
+	exists := make([]bool, len(items))
+	for i, item := range items {
+		expelled[i], exists[i] = topk.IncrBy(item, increments[i])
+	}
+	return expelled, exists, nil
+}
+
+func (s *InMemoryStore) TopKQuery(key string, items []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topk, err := s.topKLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if topk == nil {
+		return nil, errTopKNoKey
+	}
+
+	contained := make([]bool, len(items))
+	for i, item := range items {
+		contained[i] = topk.Contains(item)
+	}
+	return contained, nil
+}
+
+func (s *InMemoryStore) TopKList(key string) ([]TopKItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	topk, err := s.topKLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if topk == nil {
+		return nil, errTopKNoKey
+	}
+	return topk.List(), nil
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func checkTopKHeap(t *testing.T, topk *TopK) {
+	t.Helper()
+	if len(topk.heap) > topk.k {
+		t.Fatalf("heap holds %d items, more than k = %d", len(topk.heap), topk.k)
+	}
+	for i := 1; i < len(topk.heap); i++ {
+		if topk.less(i, (i-1)/2) {
+			t.Fatalf("heap entry %d (%+v) is lighter than its parent", i, topk.heap[i])
+		}
+	}
+}
+
+// TestTopKHeavyHitters mixes ten heavy items into a long tail of light ones
+// and checks that the heavy ones are the ones listed, in order.
+func TestTopKHeavyHitters(t *testing.T) {
+	topk := NewTopK(10, 1000, 5, TopKDefaultDecay)
+	rng := rand.New(rand.NewSource(1))
+
+	var stream []string
+	for i := range 10 {
+		for range 2000 - 150*i {
+			stream = append(stream, fmt.Sprintf("heavy%d", i))
+		}
+	}
+	for i := range 30000 {
+		stream = append(stream, fmt.Sprintf("light%d", i%15000))
+	}
+	rng.Shuffle(len(stream), func(i, j int) { stream[i], stream[j] = stream[j], stream[i] })
+
+	for _, item := range stream {
+		topk.IncrBy(item, 1)
+	}
+	checkTopKHeap(t, topk)
+
+	var got []string
+	for _, item := range topk.List() {
+		got = append(got, item.Item)
+	}
+	var want []string
+	for i := range 10 {
+		want = append(want, fmt.Sprintf("heavy%d", i))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+	for i, item := range topk.List() {
+		if count := uint32(2000 - 150*i); item.Count > count || item.Count < count*9/10 {
+			t.Errorf("estimate of %s is %d, want close to %d", item.Item, item.Count, count)
+		}
+	}
+}
+
+func TestTopKExpels(t *testing.T) {
+	topk := NewTopK(2, 100, 7, TopKDefaultDecay)
+	for _, add := range []struct {
+		item      string
+		increment uint32
+		expelled  string
+	}{
+		{"a", 5, ""},
+		{"b", 3, ""},
+		{"c", 2, ""},
+		{"c", 2, "b"},
+		{"b", 1, ""},
+		{"a", 1, ""},
+	} {
+		expelled, ok := topk.IncrBy(add.item, add.increment)
+		if expelled != add.expelled || ok != (add.expelled != "") {
+			t.Errorf("IncrBy(%s, %d) expelled %q, %v, want %q", add.item, add.increment, expelled, ok, add.expelled)
+		}
+		checkTopKHeap(t, topk)
+	}
+	if got := topk.List(); !slices.Equal(got, []TopKItem{{"a", 6}, {"c", 4}}) {
+		t.Errorf("List = %v", got)
+	}
+	if !topk.Contains("c") || topk.Contains("b") {
+		t.Error("Contains disagrees with the list")
+	}
+}
+
+func TestTopKCommands(t *testing.T) {
+	s := NewInMemoryStore()
+	if err := s.TopKReserve("top", 2, 50, 4, 0.9); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TopKReserve("top", 2, 50, 4, 0.9); err == nil {
+		t.Error("TopKReserve of an existing key did not fail")
+	}
+
+	expelled, exists, err := s.TopKIncrBy("top", []string{"a", "b", "c"}, []uint32{3, 2, 5})
+	if err != nil || !slices.Equal(expelled, []string{"", "", "b"}) || !slices.Equal(exists, []bool{false, false, true}) {
+		t.Errorf("TopKIncrBy = %q, %v, %v", expelled, exists, err)
+	}
+	if contained, _ := s.TopKQuery("top", []string{"a", "b", "c"}); !slices.Equal(contained, []bool{true, false, true}) {
+		t.Errorf("TopKQuery = %v", contained)
+	}
+	if items, _ := s.TopKList("top"); !slices.Equal(items, []TopKItem{{"c", 5}, {"a", 3}}) {
+		t.Errorf("TopKList = %v", items)
+	}
+
+	if _, _, err := s.TopKIncrBy("missing", []string{"a"}, []uint32{1}); err == nil {
+		t.Error("TopKIncrBy on a missing key did not fail")
+	}
+	if _, err := s.TopKList("missing"); err == nil {
+		t.Error("TopKList on a missing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.TopKQuery("list", []string{"a"}); err == nil {
+		t.Error("TopKQuery on a list did not fail")
+	}
+}
+
+func TestTopKSnapshot(t *testing.T) {
+	topk := NewTopK(5, 20, 3, 0.8)
+	for i := range 500 {
+		topk.IncrBy(fmt.Sprint(i%37), uint32(i%3+1))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(topk); err != nil {
+		t.Fatal(err)
+	}
+	var decoded TopK
+	if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.k != 5 || decoded.width != 20 || decoded.depth != 3 || decoded.decay != 0.8 {
+		t.Errorf("decoded k %d, %dx%d, decay %v", decoded.k, decoded.width, decoded.depth, decoded.decay)
+	}
+	if !slices.Equal(decoded.buckets, topk.buckets) || !slices.Equal(decoded.List(), topk.List()) {
+		t.Error("decoded buckets or list differ")
+	}
+}
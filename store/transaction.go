@@ -0,0 +1,33 @@
+package store
+
+// Every command runs between BeginCommand and EndCommand, while EXEC runs its
+// queued commands between BeginTransaction and EndTransaction, so no other
+// client's command can observe or interleave with a transaction half done.
+// A blocking command gives up its share of commandMu while it waits (see
+// blockOn), and clients that become ready during a transaction are only
+// served once it ends.
+
+func (s *InMemoryStore) BeginCommand() {
+	s.commandMu.RLock()
+}
+
+func (s *InMemoryStore) EndCommand() {
+	s.commandMu.RUnlock()
+}
+
+func (s *InMemoryStore) BeginTransaction() {
+	s.commandMu.Lock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inTransaction = true
+}
+
+func (s *InMemoryStore) EndTransaction() {
+	s.mu.Lock()
+	s.inTransaction = false
+	s.serveBlockedClients()
+	s.mu.Unlock()
+
+	s.commandMu.Unlock()
+}
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestTransactionIsolation checks that a transaction's writes are never seen
+// half done by commands running alongside it.
+func TestTransactionIsolation(t *testing.T) {
+	s := NewInMemoryStore()
+	s.StringSet("a", "50", 0, false, false, false, false)
+	s.StringSet("b", "50", 0, false, false, false, false)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 500 {
+			s.BeginTransaction()
+			amount := int64(i%7 - 3)
+			s.Increment("a", -amount)
+			s.Increment("b", amount)
+			s.EndTransaction()
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		s.BeginCommand()
+		a, _, _ := s.StringGet("a")
+		b, _, _ := s.StringGet("b")
+		s.EndCommand()
+		if a.Int+b.Int != 100 {
+			t.Fatalf("saw a = %d and b = %d mid-transaction", a.Int, b.Int)
+		}
+	}
+}
+
+// TestTransactionWaitsForCommands checks that EXEC only starts once the
+// commands already running have finished, and that commands arriving during
+// a transaction wait for it to end.
+func TestTransactionWaitsForCommands(t *testing.T) {
+	s := NewInMemoryStore()
+
+	s.BeginCommand()
+	started := make(chan struct{})
+	go func() {
+		s.BeginTransaction()
+		close(started)
+		s.RPush("list", []string{"exec"})
+		time.Sleep(10 * time.Millisecond)
+		s.EndTransaction()
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-started:
+		t.Fatal("transaction started while a command was running")
+	default:
+	}
+	s.RPush("list", []string{"command"})
+	s.EndCommand()
+	<-started
+
+	s.BeginCommand()
+	s.RPush("list", []string{"after"})
+	s.EndCommand()
+
+	if got := listContents(t, s, "list"); len(got) != 3 || got[0] != "command" || got[1] != "exec" || got[2] != "after" {
+		t.Errorf("list = %v, want [command exec after]", got)
+	}
+}
+
+// TestBlockedClientServedAfterTransaction checks that a client blocked on a
+// key the transaction pushes to and pops from again sees neither value, and
+// that one left behind by the transaction is handed over when it ends.
+func TestBlockedClientServedAfterTransaction(t *testing.T) {
+	s := NewInMemoryStore()
+	result := startBLMPop(s, context.Background(), []string{"queue"}, true, 1, 0)
+	waitBlocked(t, s, "queue", 1)
+
+	s.BeginTransaction()
+	s.RPush("queue", []string{"taken"})
+	s.LPop("queue")
+	s.RPush("queue", []string{"left"})
+	waitBlocked(t, s, "queue", 1)
+	s.EndTransaction()
+
+	r := receive(t, result)
+	if !r.ok || r.key != "queue" || len(r.values) != 1 || r.values[0] != "left" {
+		t.Errorf("blocked client got %+v, want left from queue", r)
+	}
+}
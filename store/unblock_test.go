@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCancelledBlockedClient(t *testing.T) {
+	tests := []struct {
+		cause error
+		err   error
+	}{
+		{ErrUnblockedError, ErrUnblockedError},
+		{ErrUnblockedTimeout, nil},
+		{context.Canceled, context.Canceled},
+	}
+	for _, test := range tests {
+		s := NewInMemoryStore()
+		ctx, cancel := context.WithCancelCause(context.Background())
+		result := startBLMPop(s, ctx, []string{"list"}, true, 1, 0)
+		waitBlocked(t, s, "list", 1)
+
+		cancel(test.cause)
+		if r := receive(t, result); r.ok || !errors.Is(r.err, test.err) {
+			t.Errorf("BLMPop cancelled with %v = %+v, want error %v", test.cause, r, test.err)
+		}
+		waitBlocked(t, s, "list", 0)
+
+		s.RPush("list", []string{"a"})
+		if got := listContents(t, s, "list"); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("push after cancelling left %v", got)
+		}
+	}
+}
+
+// serveThenCancel cancels the client blocked on key and, before it can take
+// s.mu to clean up, serves it with values the way a write that won the race
+// would have. That is the case restore exists for.
+func serveThenCancel(t *testing.T, s *InMemoryStore, key string, cancel context.CancelCauseFunc, values []string) {
+	t.Helper()
+	s.mu.Lock()
+	cancel(ErrUnblockedError)
+	// Give the client time to pick the cancellation and wait for s.mu.
+	time.Sleep(20 * time.Millisecond)
+	for _, value := range values {
+		s.listPushLocked(key, value, false)
+	}
+	client := s.blockedClients[key][0]
+	if !client.serve(key) {
+		t.Fatal("client could not be served")
+	}
+	s.unblockClient(client)
+	s.mu.Unlock()
+}
+
+func TestCancelAfterServeRestoresElements(t *testing.T) {
+	s := NewInMemoryStore()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	result := startBLMPop(s, ctx, []string{"list"}, true, 2, 0)
+	waitBlocked(t, s, "list", 1)
+
+	serveThenCancel(t, s, "list", cancel, []string{"a", "b", "c"})
+	if r := receive(t, result); r.ok || r.err != ErrUnblockedError {
+		t.Errorf("BLMPop = %+v, want the cancellation error", r)
+	}
+	if got := listContents(t, s, "list"); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("list = %v, want the popped elements back in place", got)
+	}
+}
+
+func TestCancelAfterServeKeepsBLMove(t *testing.T) {
+	s := NewInMemoryStore()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	moved := make(chan error, 1)
+	go func() {
+		s.BeginCommand()
+		defer s.EndCommand()
+		_, _, err := s.BLMove(ctx, "source", "destination", true, true, 0)
+		moved <- err
+	}()
+	waitBlocked(t, s, "source", 1)
+
+	serveThenCancel(t, s, "source", cancel, []string{"a"})
+	select {
+	case err := <-moved:
+		if err != ErrUnblockedError {
+			t.Errorf("BLMove = %v, want the cancellation error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("BLMove never returned")
+	}
+	if got := listContents(t, s, "destination"); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("destination = %v, want the moved element", got)
+	}
+	if got := listContents(t, s, "source"); len(got) != 0 {
+		t.Errorf("source = %v, want it empty", got)
+	}
+}
@@ -0,0 +1,537 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A vector filter is an expression over the JSON attributes of an element,
+// like `.year >= 1980 and .genre in ["drama", "comedy"]`. Selectors such as
+// .year read top level fields of the attributes, and an element whose
+// attributes are missing a selected field, or aren't valid JSON, never
+// matches. Expressions support numbers, strings, booleans, null and arrays,
+// arithmetic, comparisons, `in` for array membership and substrings, and the
+// logical operators and, or and not (also written &&, || and !).
+
+var errVectorFilterSyntax = errors.New("-ERR syntax error in FILTER expression")
+
+type filterTokenKind int
+
+const (
+	filterOperator filterTokenKind = iota
+	filterLiteral
+	filterSelector
+)
+
+type filterToken struct {
+	kind  filterTokenKind
+	text  string
+	value *JSONValue
+}
+
+// filterOperators lists the symbolic operators, longest first so that a
+// prefix never shadows a longer operator.
+var filterOperators = []string{"**", "==", "!=", "<=", ">=", "&&", "||", "(", ")", "[", "]", ",", "+", "-", "*", "/", "%", "<", ">", "!"}
+
+func isFilterNameByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func tokenizeFilter(text string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '.' && i+1 < len(text) && isFilterNameByte(text[i+1]) && !(text[i+1] >= '0' && text[i+1] <= '9'):
+			j := i + 1
+			for j < len(text) && isFilterNameByte(text[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: filterSelector, text: text[i+1 : j]})
+			i = j
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(text) && (text[j] >= '0' && text[j] <= '9' || text[j] == '.' || text[j] == 'e' || text[j] == 'E' ||
+				(text[j] == '+' || text[j] == '-') && (text[j-1] == 'e' || text[j-1] == 'E')) {
+				j++
+			}
+			number, err := strconv.ParseFloat(text[i:j], 64)
+			if err != nil {
+				return nil, errVectorFilterSyntax
+			}
+			tokens = append(tokens, filterToken{kind: filterLiteral, value: &JSONValue{kind: jsonNumber, number: number}})
+			i = j
+		case c == '"' || c == '\'':
+			var str strings.Builder
+			j := i + 1
+			for ; j < len(text) && text[j] != c; j++ {
+				if text[j] == '\\' && j+1 < len(text) {
+					j++
+					switch text[j] {
+					case 'n':
+						str.WriteByte('\n')
+					case 't':
+						str.WriteByte('\t')
+					default:
+						str.WriteByte(text[j])
+					}
+					continue
+				}
+				str.WriteByte(text[j])
+			}
+			if j == len(text) {
+				return nil, errVectorFilterSyntax
+			}
+			tokens = append(tokens, filterToken{kind: filterLiteral, value: &JSONValue{kind: jsonString, str: str.String()}})
+			i = j + 1
+		case isFilterNameByte(c):
+			j := i
+			for j < len(text) && isFilterNameByte(text[j]) {
+				j++
+			}
+			switch word := text[i:j]; word {
+			case "and", "or", "not", "in":
+				tokens = append(tokens, filterToken{kind: filterOperator, text: word})
+			case "true", "false":
+				tokens = append(tokens, filterToken{kind: filterLiteral, value: &JSONValue{kind: jsonBool, boolean: word == "true"}})
+			case "null":
+				tokens = append(tokens, filterToken{kind: filterLiteral, value: &JSONValue{kind: jsonNull}})
+			default:
+				return nil, errVectorFilterSyntax
+			}
+			i = j
+		default:
+			matched := false
+			for _, operator := range filterOperators {
+				if strings.HasPrefix(text[i:], operator) {
+					tokens = append(tokens, filterToken{kind: filterOperator, text: operator})
+					i += len(operator)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errVectorFilterSyntax
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// filterExpr is a node of a parsed filter. eval reports false when the
+// expression is undefined for the attributes, e.g. a selected field is
+// missing or a number is divided by zero.
+type filterExpr interface {
+	eval(attributes *JSONValue) (*JSONValue, bool)
+}
+
+type filterConstant struct {
+	value *JSONValue
+}
+
+type filterField struct {
+	name string
+}
+
+type filterList struct {
+	elements []filterExpr
+}
+
+type filterUnary struct {
+	operator string
+	operand  filterExpr
+}
+
+type filterBinary struct {
+	operator string
+	left     filterExpr
+	right    filterExpr
+}
+
+func (expr filterConstant) eval(attributes *JSONValue) (*JSONValue, bool) {
+	return expr.value, true
+}
+
+func (expr filterField) eval(attributes *JSONValue) (*JSONValue, bool) {
+	if attributes == nil || attributes.kind != jsonObject {
+		return nil, false
+	}
+	value, ok := attributes.fields[expr.name]
+	return value, ok
+}
+
+func (expr filterList) eval(attributes *JSONValue) (*JSONValue, bool) {
+	list := &JSONValue{kind: jsonArray}
+	for _, element := range expr.elements {
+		value, ok := element.eval(attributes)
+		if !ok {
+			return nil, false
+		}
+		list.elements = append(list.elements, value)
+	}
+	return list, true
+}
+
+func filterBool(b bool) *JSONValue {
+	return &JSONValue{kind: jsonBool, boolean: b}
+}
+
+// filterNumber converts numbers and booleans to a number.
+func filterNumber(value *JSONValue) (float64, bool) {
+	switch value.kind {
+	case jsonInteger, jsonNumber:
+		return value.float(), true
+	case jsonBool:
+		if value.boolean {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func filterTruthy(value *JSONValue) bool {
+	switch value.kind {
+	case jsonBool:
+		return value.boolean
+	case jsonInteger, jsonNumber:
+		return value.float() != 0
+	case jsonString:
+		return value.str != ""
+	case jsonArray:
+		return len(value.elements) > 0
+	case jsonObject:
+		return true
+	}
+	return false
+}
+
+func filterEqual(a *JSONValue, b *JSONValue) bool {
+	if x, ok := filterNumber(a); ok {
+		y, ok := filterNumber(b)
+		return ok && x == y
+	}
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case jsonString:
+		return a.str == b.str
+	case jsonArray:
+		if len(a.elements) != len(b.elements) {
+			return false
+		}
+		for i := range a.elements {
+			if !filterEqual(a.elements[i], b.elements[i]) {
+				return false
+			}
+		}
+		return true
+	case jsonObject:
+		return a.String() == b.String()
+	}
+	return true
+}
+
+func (expr filterUnary) eval(attributes *JSONValue) (*JSONValue, bool) {
+	value, ok := expr.operand.eval(attributes)
+	if !ok {
+		return nil, false
+	}
+	if expr.operator == "-" {
+		number, ok := filterNumber(value)
+		if !ok {
+			return nil, false
+		}
+		return &JSONValue{kind: jsonNumber, number: -number}, true
+	}
+	return filterBool(!filterTruthy(value)), true
+}
+
+func (expr filterBinary) eval(attributes *JSONValue) (*JSONValue, bool) {
+	left, ok := expr.left.eval(attributes)
+	if !ok {
+		return nil, false
+	}
+	switch expr.operator {
+	case "and", "&&":
+		if !filterTruthy(left) {
+			return filterBool(false), true
+		}
+	case "or", "||":
+		if filterTruthy(left) {
+			return filterBool(true), true
+		}
+	}
+	right, ok := expr.right.eval(attributes)
+	if !ok {
+		return nil, false
+	}
+
+	switch expr.operator {
+	case "and", "&&", "or", "||":
+		return filterBool(filterTruthy(right)), true
+	case "==":
+		return filterBool(filterEqual(left, right)), true
+	case "!=":
+		return filterBool(!filterEqual(left, right)), true
+	case "in":
+		switch right.kind {
+		case jsonArray:
+			for _, element := range right.elements {
+				if filterEqual(left, element) {
+					return filterBool(true), true
+				}
+			}
+			return filterBool(false), true
+		case jsonString:
+			if left.kind != jsonString {
+				return nil, false
+			}
+			return filterBool(strings.Contains(right.str, left.str)), true
+		}
+		return nil, false
+	case "<", "<=", ">", ">=":
+		var cmp int
+		if x, ok := filterNumber(left); ok {
+			y, ok := filterNumber(right)
+			if !ok {
+				return nil, false
+			}
+			cmp = compareFloats(x, y)
+		} else if left.kind == jsonString && right.kind == jsonString {
+			cmp = strings.Compare(left.str, right.str)
+		} else {
+			return nil, false
+		}
+		switch expr.operator {
+		case "<":
+			return filterBool(cmp < 0), true
+		case "<=":
+			return filterBool(cmp <= 0), true
+		case ">":
+			return filterBool(cmp > 0), true
+		}
+		return filterBool(cmp >= 0), true
+	}
+
+	x, ok := filterNumber(left)
+	if !ok {
+		return nil, false
+	}
+	y, ok := filterNumber(right)
+	if !ok {
+		return nil, false
+	}
+	var result float64
+	switch expr.operator {
+	case "+":
+		result = x + y
+	case "-":
+		result = x - y
+	case "*":
+		result = x * y
+	case "/":
+		if y == 0 {
+			return nil, false
+		}
+		result = x / y
+	case "%":
+		if y == 0 {
+			return nil, false
+		}
+		result = math.Mod(x, y)
+	case "**":
+		result = math.Pow(x, y)
+	}
+	return &JSONValue{kind: jsonNumber, number: result}, true
+}
+
+func compareFloats(x float64, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// accept consumes the next token if it is one of the given operators.
+func (p *filterParser) accept(operators ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != filterOperator {
+		return "", false
+	}
+	for _, operator := range operators {
+		if p.tokens[p.pos].text == operator {
+			p.pos++
+			return operator, true
+		}
+	}
+	return "", false
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	return p.parseBinary([]string{"or", "||"}, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	return p.parseBinary([]string{"and", "&&"}, p.parseNot)
+}
+
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if operator, ok := p.accept("not", "!"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return filterUnary{operator: operator, operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	operator, ok := p.accept("==", "!=", "<", "<=", ">", ">=", "in")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	return filterBinary{operator: operator, left: left, right: right}, nil
+}
+
+func (p *filterParser) parseAdditive() (filterExpr, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseMultiplicative)
+}
+
+func (p *filterParser) parseMultiplicative() (filterExpr, error) {
+	return p.parseBinary([]string{"*", "/", "%"}, p.parsePower)
+}
+
+// parsePower parses the right associative exponent operator.
+func (p *filterParser) parsePower() (filterExpr, error) {
+	base, err := p.parseNegation()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("**"); !ok {
+		return base, nil
+	}
+	exponent, err := p.parsePower()
+	if err != nil {
+		return nil, err
+	}
+	return filterBinary{operator: "**", left: base, right: exponent}, nil
+}
+
+func (p *filterParser) parseNegation() (filterExpr, error) {
+	if _, ok := p.accept("-"); ok {
+		operand, err := p.parseNegation()
+		if err != nil {
+			return nil, err
+		}
+		return filterUnary{operator: "-", operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parseBinary parses a left associative chain of the given operators.
+func (p *filterParser) parseBinary(operators []string, operand func() (filterExpr, error)) (filterExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator, ok := p.accept(operators...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = filterBinary{operator: operator, left: left, right: right}
+	}
+}
+
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errVectorFilterSyntax
+	}
+	token := p.tokens[p.pos]
+	switch token.kind {
+	case filterLiteral:
+		p.pos++
+		return filterConstant{value: token.value}, nil
+	case filterSelector:
+		p.pos++
+		return filterField{name: token.text}, nil
+	}
+
+	if _, ok := p.accept("("); ok {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, errVectorFilterSyntax
+		}
+		return expr, nil
+	}
+	if _, ok := p.accept("["); ok {
+		var list filterList
+		if _, ok := p.accept("]"); ok {
+			return list, nil
+		}
+		for {
+			element, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			list.elements = append(list.elements, element)
+			if _, ok := p.accept("]"); ok {
+				return list, nil
+			}
+			if _, ok := p.accept(","); !ok {
+				return nil, errVectorFilterSyntax
+			}
+		}
+	}
+	return nil, errVectorFilterSyntax
+}
+
+func parseVectorFilter(text string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, errVectorFilterSyntax
+	}
+	return expr, nil
+}
+
+func filterMatches(expr filterExpr, attributes *JSONValue) bool {
+	value, ok := expr.eval(attributes)
+	return ok && filterTruthy(value)
+}
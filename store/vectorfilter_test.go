@@ -0,0 +1,73 @@
+package store
+
+import "testing"
+
+func TestVectorFilter(t *testing.T) {
+	attributes, err := ParseJSON(`{"year":1984,"genre":"drama","rating":7.5,"tags":["classic","uk"],"seen":true,"note":null,"title":"it's"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{".year == 1984", true},
+		{".year >= 1980 and .year < 1990", true},
+		{".year > 1990 or .genre == 'drama'", true},
+		{`.genre in ["comedy", "drama"]`, true},
+		{`.genre in ["comedy"]`, false},
+		{`"ram" in .genre`, true},
+		{`"classic" in .tags`, true},
+		{".rating * 2 == 15", true},
+		{".year % 100 == 84", true},
+		{"2 ** 3 ** 2 == 512", true},
+		{"-.rating < -7", true},
+		{"(.year - 1900) / 4 == 21", true},
+		{"1 + 2 * 3 == 7", true},
+		{".seen", true},
+		{"!.seen", false},
+		{"not .seen or .year", true},
+		{".seen == 1", true},
+		{".note == null", true},
+		{".genre > 'comedy'", true},
+		{".title == \"it's\"", true},
+		{".tags == ['classic', 'uk']", true},
+		{".year != 1984 && .seen", false},
+		{".year == 1984 || .missing", true},
+		{".missing == 1", false},
+		{"not .missing", false},
+		{".missing or .seen", false},
+		{".year / 0 == 1", false},
+		{".genre < 5", false},
+		{".year in 5", false},
+		{".5 < 1", true},
+		{"1e3 == 1000", true},
+	}
+	for _, test := range tests {
+		filter, err := parseVectorFilter(test.filter)
+		if err != nil {
+			t.Errorf("parseVectorFilter(%s) = %v", test.filter, err)
+			continue
+		}
+		if got := filterMatches(filter, attributes); got != test.want {
+			t.Errorf("%s matched %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	filter, _ := parseVectorFilter(".year > 0")
+	for _, text := range []string{"not json", `[1984]`, `{"year":"1984"}`} {
+		other, _ := ParseJSON(text)
+		if filterMatches(filter, other) {
+			t.Errorf(".year > 0 matched %s", text)
+		}
+	}
+}
+
+func TestVectorFilterSyntax(t *testing.T) {
+	for _, text := range []string{"", ".year ==", "(.year", ".year == 1)", "[1, 2", "[1 2]", "'open", "year", ".year = 1", "1 ^ 2", "1..2", ".a .b"} {
+		if _, err := parseVectorFilter(text); err == nil {
+			t.Errorf("parseVectorFilter(%q) did not fail", text)
+		}
+	}
+}
@@ -0,0 +1,646 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+)
+
+// A vector set finds the elements whose vectors are most similar to a query
+// with a hierarchical navigable small world graph, as Redis vector sets do.
+// Every element is linked to its nearest neighbours on level zero and, with
+// probability falling by a factor of m per level, on the levels above it. A
+// search descends greedily from the single entry point on the top level and
+// explores the neighbourhood of the closest node on level zero.
+//
+// Vectors are normalized, so similarity is cosine similarity, reported as a
+// score from 0 (opposite) to 1 (identical). They are stored as float32s with
+// NOQUANT, as int8s with a per-vector scale with Q8, the default, or as sign
+// bits with BIN.
+const (
+	VectorQuantNone = "NOQUANT"
+	VectorQuantQ8   = "Q8"
+	VectorQuantBin  = "BIN"
+
+	VectorDefaultM  = 16
+	VectorDefaultEF = 200
+	vectorMaxLevel  = 16
+)
+
+var errVectorNoElement = errors.New("-ERR element not found in set")
+
+// vectorData is a normalized vector in the quantization of its set.
+type vectorData struct {
+	floats []float32
+	q8     []int8
+	scale  float32
+	bits   []uint64
+}
+
+type vectorNode struct {
+	element    string
+	vector     vectorData
+	attributes string
+	parsed     *JSONValue
+	// links holds the neighbours of the node on each of its levels.
+	links [][]*vectorNode
+}
+
+// setAttributes stores the attributes along with their parsed form, which is
+// nil when they aren't valid JSON and so never match a filter.
+func (node *vectorNode) setAttributes(attributes string) {
+	node.attributes = attributes
+	node.parsed, _ = ParseJSON(attributes)
+}
+
+type vectorCandidate struct {
+	node     *vectorNode
+	distance float64
+}
+
+type VectorSet struct {
+	dim          int
+	quantization string
+	m            int
+	nodes        map[string]*vectorNode
+	entry        *vectorNode
+}
+
+func NewVectorSet(dim int, quantization string, m int) *VectorSet {
+	return &VectorSet{dim: dim, quantization: quantization, m: m, nodes: make(map[string]*vectorNode)}
+}
+
+func (vs *VectorSet) Encoding() string {
+	return "raw"
+}
+
+func (vs *VectorSet) Len() int {
+	return len(vs.nodes)
+}
+
+// encode normalizes a vector and quantizes it.
+func (vs *VectorSet) encode(vector []float32) vectorData {
+	norm := 0.0
+	for _, x := range vector {
+		norm += float64(x) * float64(x)
+	}
+	norm = math.Sqrt(norm)
+	normalized := make([]float32, len(vector))
+	if norm > 0 {
+		for i, x := range vector {
+			normalized[i] = float32(float64(x) / norm)
+		}
+	}
+
+	switch vs.quantization {
+	case VectorQuantQ8:
+		largest := float32(0)
+		for _, x := range normalized {
+			largest = max(largest, float32(math.Abs(float64(x))))
+		}
+		data := vectorData{q8: make([]int8, len(normalized)), scale: largest / 127}
+		if largest > 0 {
+			for i, x := range normalized {
+				data.q8[i] = int8(math.Round(float64(x / data.scale)))
+			}
+		}
+		return data
+	case VectorQuantBin:
+		data := vectorData{bits: make([]uint64, (len(normalized)+63)/64)}
+		for i, x := range normalized {
+			if x > 0 {
+				data.bits[i/64] |= 1 << (i % 64)
+			}
+		}
+		return data
+	}
+	return vectorData{floats: normalized}
+}
+
+// similarity returns the cosine similarity of two encoded vectors. Binary
+// vectors estimate it from the fraction of signs they share.
+func (vs *VectorSet) similarity(a *vectorData, b *vectorData) float64 {
+	switch vs.quantization {
+	case VectorQuantQ8:
+		dot := int64(0)
+		for i := range a.q8 {
+			dot += int64(a.q8[i]) * int64(b.q8[i])
+		}
+		return float64(dot) * float64(a.scale) * float64(b.scale)
+	case VectorQuantBin:
+		differing := 0
+		for i := range a.bits {
+			differing += bits.OnesCount64(a.bits[i] ^ b.bits[i])
+		}
+		return 1 - 2*float64(differing)/float64(vs.dim)
+	}
+	dot := 0.0
+	for i := range a.floats {
+		dot += float64(a.floats[i]) * float64(b.floats[i])
+	}
+	return dot
+}
+
+// distance is the cosine distance, from 0 to 2.
+func (vs *VectorSet) distance(a *vectorData, b *vectorData) float64 {
+	return 1 - vs.similarity(a, b)
+}
+
+func (vs *VectorSet) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * vs.m
+	}
+	return vs.m
+}
+
+func (vs *VectorSet) randomLevel() int {
+	level := 0
+	for level < vectorMaxLevel && rand.Float64() < 1/float64(vs.m) {
+		level++
+	}
+	return level
+}
+
+func insertCandidate(candidates []vectorCandidate, candidate vectorCandidate) []vectorCandidate {
+	i, _ := slices.BinarySearchFunc(candidates, candidate.distance, func(c vectorCandidate, distance float64) int {
+		return compareFloats(c.distance, distance)
+	})
+	return slices.Insert(candidates, i, candidate)
+}
+
+// searchLayer returns up to ef of the nodes on a level closest to the query,
+// nearest first, exploring outwards from the entry points. Only nodes
+// accepted by the filter are returned, though all of them are explored. A
+// positive budget caps how many nodes are expanded, which bounds the work of
+// a filter that few nodes pass.
+func (vs *VectorSet) searchLayer(query *vectorData, entries []vectorCandidate, ef int, level int, accept func(*vectorNode) bool, budget int) []vectorCandidate {
+	visited := make(map[*vectorNode]bool)
+	var candidates, results []vectorCandidate
+	for _, entry := range entries {
+		visited[entry.node] = true
+		candidates = insertCandidate(candidates, entry)
+		if accept == nil || accept(entry.node) {
+			results = insertCandidate(results, entry)
+		}
+	}
+	if len(results) > ef {
+		results = results[:ef]
+	}
+
+	for expanded := 0; len(candidates) > 0; expanded++ {
+		if budget > 0 && expanded >= budget {
+			break
+		}
+		closest := candidates[0]
+		candidates = candidates[1:]
+		if len(results) >= ef && closest.distance > results[len(results)-1].distance {
+			break
+		}
+
+		for _, neighbour := range closest.node.links[level] {
+			if visited[neighbour] {
+				continue
+			}
+			visited[neighbour] = true
+			candidate := vectorCandidate{neighbour, vs.distance(query, &neighbour.vector)}
+			if len(results) >= ef && candidate.distance >= results[len(results)-1].distance {
+				continue
+			}
+			candidates = insertCandidate(candidates, candidate)
+			if accept == nil || accept(neighbour) {
+				results = insertCandidate(results, candidate)
+				if len(results) > ef {
+					results = results[:ef]
+				}
+			}
+		}
+	}
+	return results
+}
+
+// selectNeighbours picks up to m of the candidates, sorted by distance to a
+// node, as its neighbours. A candidate closer to an already selected
+// neighbour than to the node is passed over at first, which spreads links
+// in different directions, and the passed over ones fill any places left.
+func (vs *VectorSet) selectNeighbours(candidates []vectorCandidate, m int) []*vectorNode {
+	var selected, skipped []*vectorNode
+	for _, candidate := range candidates {
+		if len(selected) >= m {
+			break
+		}
+		diverse := true
+		for _, neighbour := range selected {
+			if vs.distance(&candidate.node.vector, &neighbour.vector) < candidate.distance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.node)
+		} else {
+			skipped = append(skipped, candidate.node)
+		}
+	}
+	for _, node := range skipped {
+		if len(selected) >= m {
+			break
+		}
+		selected = append(selected, node)
+	}
+	return selected
+}
+
+// relink chooses the neighbours of node on level from the given nodes.
+func (vs *VectorSet) relink(node *vectorNode, level int, nodes []*vectorNode) {
+	var candidates []vectorCandidate
+	for _, other := range nodes {
+		if other != node && !slices.ContainsFunc(candidates, func(c vectorCandidate) bool { return c.node == other }) {
+			candidates = insertCandidate(candidates, vectorCandidate{other, vs.distance(&node.vector, &other.vector)})
+		}
+	}
+	node.links[level] = vs.selectNeighbours(candidates, vs.maxLinks(level))
+}
+
+// entryPoints descends from the top of the graph to level, keeping the
+// node closest to the query on each level.
+func (vs *VectorSet) entryPoints(query *vectorData, level int) []vectorCandidate {
+	entries := []vectorCandidate{{vs.entry, vs.distance(query, &vs.entry.vector)}}
+	for l := len(vs.entry.links) - 1; l > level; l-- {
+		entries = vs.searchLayer(query, entries, 1, l, nil, 0)
+	}
+	return entries
+}
+
+func (vs *VectorSet) insert(node *vectorNode, ef int) {
+	level := vs.randomLevel()
+	node.links = make([][]*vectorNode, level+1)
+	vs.nodes[node.element] = node
+	if vs.entry == nil {
+		vs.entry = node
+		return
+	}
+
+	top := len(vs.entry.links) - 1
+	entries := vs.entryPoints(&node.vector, level)
+	for l := min(level, top); l >= 0; l-- {
+		candidates := vs.searchLayer(&node.vector, entries, ef, l, nil, 0)
+		node.links[l] = vs.selectNeighbours(candidates, vs.m)
+		for _, neighbour := range node.links[l] {
+			if len(neighbour.links[l]) < vs.maxLinks(l) {
+				neighbour.links[l] = append(neighbour.links[l], node)
+			} else {
+				vs.relink(neighbour, l, append(slices.Clone(neighbour.links[l]), node))
+			}
+		}
+		entries = candidates
+	}
+	if level > top {
+		vs.entry = node
+	}
+}
+
+// Add adds an element or replaces its vector, reporting whether it is new.
+// Attributes are only changed when given.
+func (vs *VectorSet) Add(element string, vector []float32, attributes *string, ef int) bool {
+	node, exists := vs.nodes[element]
+	if exists {
+		vs.Remove(element)
+	} else {
+		node = &vectorNode{element: element}
+	}
+	node.vector = vs.encode(vector)
+	if attributes != nil {
+		node.setAttributes(*attributes)
+	}
+	vs.insert(node, ef)
+	return !exists
+}
+
+// Remove deletes an element. Nodes that linked to it are relinked among
+// their remaining neighbours and those of the removed node, so the graph
+// stays connected around the gap.
+func (vs *VectorSet) Remove(element string) bool {
+	node, ok := vs.nodes[element]
+	if !ok {
+		return false
+	}
+	delete(vs.nodes, element)
+
+	for _, other := range vs.nodes {
+		for l := range min(len(other.links), len(node.links)) {
+			i := slices.Index(other.links[l], node)
+			if i < 0 {
+				continue
+			}
+			remaining := slices.Delete(other.links[l], i, i+1)
+			vs.relink(other, l, append(slices.Clone(remaining), node.links[l]...))
+		}
+	}
+
+	if vs.entry == node {
+		vs.entry = nil
+		for _, other := range vs.nodes {
+			if vs.entry == nil || len(other.links) > len(vs.entry.links) {
+				vs.entry = other
+			}
+		}
+	}
+	return true
+}
+
+// Search returns up to count elements nearest to the query vector that pass
+// the filter, if there is one, nearest first.
+func (vs *VectorSet) Search(query *vectorData, count int, ef int, filter filterExpr, filterEF int) []vectorCandidate {
+	if vs.entry == nil {
+		return nil
+	}
+	var accept func(*vectorNode) bool
+	budget := 0
+	if filter != nil {
+		accept = func(node *vectorNode) bool { return filterMatches(filter, node.parsed) }
+		budget = filterEF
+	}
+	results := vs.searchLayer(query, vs.entryPoints(query, 0), max(ef, count), 0, accept, budget)
+	if len(results) > count {
+		results = results[:count]
+	}
+	return results
+}
+
+type vectorNodeSnapshot struct {
+	Element    string
+	Floats     []float32
+	Q8         []int8
+	Scale      float32
+	Bits       []uint64
+	Attributes string
+	Links      [][]int
+}
+
+type vectorSetSnapshot struct {
+	Dim          int
+	Quantization string
+	M            int
+	Nodes        []vectorNodeSnapshot
+	Entry        int
+}
+
+// GobEncode saves the graph itself, with links as indices into the saved
+// nodes, so loading it doesn't have to rebuild the graph.
+func (vs *VectorSet) GobEncode() ([]byte, error) {
+	snapshot := vectorSetSnapshot{Dim: vs.dim, Quantization: vs.quantization, M: vs.m, Entry: -1}
+	indices := make(map[*vectorNode]int, len(vs.nodes))
+	nodes := make([]*vectorNode, 0, len(vs.nodes))
+	for _, node := range vs.nodes {
+		indices[node] = len(nodes)
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		saved := vectorNodeSnapshot{
+			Element:    node.element,
+			Floats:     node.vector.floats,
+			Q8:         node.vector.q8,
+			Scale:      node.vector.scale,
+			Bits:       node.vector.bits,
+			Attributes: node.attributes,
+			Links:      make([][]int, len(node.links)),
+		}
+		for l, links := range node.links {
+			saved.Links[l] = make([]int, len(links))
+			for i, neighbour := range links {
+				saved.Links[l][i] = indices[neighbour]
+			}
+		}
+		snapshot.Nodes = append(snapshot.Nodes, saved)
+	}
+	if vs.entry != nil {
+		snapshot.Entry = indices[vs.entry]
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(snapshot); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (vs *VectorSet) GobDecode(data []byte) error {
+	var snapshot vectorSetSnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	vs.dim, vs.quantization, vs.m = snapshot.Dim, snapshot.Quantization, snapshot.M
+	vs.nodes = make(map[string]*vectorNode, len(snapshot.Nodes))
+	nodes := make([]*vectorNode, len(snapshot.Nodes))
+	for i, saved := range snapshot.Nodes {
+		nodes[i] = &vectorNode{
+			element: saved.Element,
+			vector:  vectorData{floats: saved.Floats, q8: saved.Q8, scale: saved.Scale, bits: saved.Bits},
+		}
+		nodes[i].setAttributes(saved.Attributes)
+		vs.nodes[saved.Element] = nodes[i]
+	}
+	for i, saved := range snapshot.Nodes {
+		nodes[i].links = make([][]*vectorNode, len(saved.Links))
+		for l, links := range saved.Links {
+			nodes[i].links[l] = make([]*vectorNode, len(links))
+			for j, index := range links {
+				nodes[i].links[l][j] = nodes[index]
+			}
+		}
+	}
+	vs.entry = nil
+	if snapshot.Entry >= 0 {
+		vs.entry = nodes[snapshot.Entry]
+	}
+	return nil
+}
+
+// VAddOptions are the options of VADD. Quantization and M only apply when
+// the set is created, and nil Attributes leave those of an existing element
+// unchanged.
+type VAddOptions struct {
+	Quantization string
+	EF           int
+	M            int
+	Attributes   *string
+}
+
+// VSimQuery searches for the elements most similar to Vector, or to the
+// vector of Element when Vector is nil.
+type VSimQuery struct {
+	Element  string
+	Vector   []float32
+	Count    int
+	EF       int
+	Filter   string
+	FilterEF int
+}
+
+type VectorMatch struct {
+	Element    string
+	Score      float64
+	Attributes string
+}
+
+func (s *InMemoryStore) vectorSetLocked(key string) (*VectorSet, error) {
+	keyType, ok := s.KeyType[key]
+	if ok && keyType != VectorSetType {
+		return nil, errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	return s.VectorSetKV[key], nil
+}
+
+func errVectorDimMismatch(got int, expected int) error {
+	return fmt.Errorf("-ERR Vector dimension mismatch - got %d but set has %d", got, expected)
+}
+
+// VAdd adds an element to the vector set at key, creating the set if
+// needed, and reports whether the element is new.
+func (s *InMemoryStore) VAdd(key string, element string, vector []float32, options VAddOptions) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil {
+		return false, err
+	}
+	if vs == nil {
+		quantization := options.Quantization
+		if quantization == "" {
+			quantization = VectorQuantQ8
+		}
+		vs = NewVectorSet(len(vector), quantization, options.M)
+		s.KeyType[key] = VectorSetType
+		s.VectorSetKV[key] = vs
+	}
+	if len(vector) != vs.dim {
+		return false, errVectorDimMismatch(len(vector), vs.dim)
+	}
+	if options.Quantization != "" && options.Quantization != vs.quantization {
+		return false, errors.New("-ERR asked quantization mismatch with existing vector set")
+	}
+	return vs.Add(element, vector, options.Attributes, options.EF), nil
+}
+
+func (s *InMemoryStore) VRem(key string, element string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil || vs == nil {
+		return false, err
+	}
+	removed := vs.Remove(element)
+	if vs.Len() == 0 {
+		s.deleteKeyLocked(key)
+	}
+	return removed, nil
+}
+
+// VSim returns the elements most similar to the query, most similar first.
+func (s *InMemoryStore) VSim(key string, query VSimQuery) ([]VectorMatch, error) {
+	var filter filterExpr
+	if query.Filter != "" {
+		var err error
+		if filter, err = parseVectorFilter(query.Filter); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil || vs == nil {
+		return nil, err
+	}
+
+	var target vectorData
+	if query.Vector != nil {
+		if len(query.Vector) != vs.dim {
+			return nil, errVectorDimMismatch(len(query.Vector), vs.dim)
+		}
+		target = vs.encode(query.Vector)
+	} else {
+		node, ok := vs.nodes[query.Element]
+		if !ok {
+			return nil, errVectorNoElement
+		}
+		target = node.vector
+	}
+
+	results := vs.Search(&target, query.Count, query.EF, filter, query.FilterEF)
+	matches := make([]VectorMatch, len(results))
+	for i, result := range results {
+		score := min(max(1-result.distance/2, 0), 1)
+		matches[i] = VectorMatch{Element: result.node.element, Score: score, Attributes: result.node.attributes}
+	}
+	return matches, nil
+}
+
+func (s *InMemoryStore) VCard(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil || vs == nil {
+		return 0, err
+	}
+	return vs.Len(), nil
+}
+
+func (s *InMemoryStore) VDim(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil {
+		return 0, err
+	}
+	if vs == nil {
+		return 0, errors.New("-ERR key does not exist")
+	}
+	return vs.dim, nil
+}
+
+// VGetAttr returns the attributes of an element, reporting false when the
+// element doesn't exist or has none.
+func (s *InMemoryStore) VGetAttr(key string, element string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil || vs == nil {
+		return "", false, err
+	}
+	node, ok := vs.nodes[element]
+	if !ok || node.attributes == "" {
+		return "", false, nil
+	}
+	return node.attributes, true, nil
+}
+
+// VSetAttr replaces the attributes of an element, removing them when empty,
+// and reports whether the element exists.
+func (s *InMemoryStore) VSetAttr(key string, element string, attributes string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	vs, err := s.vectorSetLocked(key)
+	if err != nil || vs == nil {
+		return false, err
+	}
+	node, ok := vs.nodes[element]
+	if !ok {
+		return false, nil
+	}
+	node.setAttributes(attributes)
+	return true, nil
+}
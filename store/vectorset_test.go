@@ -0,0 +1,319 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func randomVector(rng *rand.Rand, dim int) []float32 {
+	vector := make([]float32, dim)
+	for i := range vector {
+		vector[i] = float32(rng.NormFloat64())
+	}
+	return vector
+}
+
+func newRandomVectorSet(rng *rand.Rand, quantization string, n int, dim int) *VectorSet {
+	vs := NewVectorSet(dim, quantization, VectorDefaultM)
+	for i := range n {
+		attributes := fmt.Sprintf(`{"n":%d,"even":%t}`, i, i%2 == 0)
+		vs.Add(fmt.Sprint(i), randomVector(rng, dim), &attributes, VectorDefaultEF)
+	}
+	return vs
+}
+
+// checkVectorGraph checks that every link points to a node of the set on a
+// level it has, no node has more links than allowed, and every node can be
+// reached from the entry point on level zero.
+func checkVectorGraph(t *testing.T, vs *VectorSet) {
+	t.Helper()
+	for element, node := range vs.nodes {
+		for l, links := range node.links {
+			if len(links) > vs.maxLinks(l) {
+				t.Fatalf("%s has %d links on level %d, more than %d", element, len(links), l, vs.maxLinks(l))
+			}
+			for _, neighbour := range links {
+				if vs.nodes[neighbour.element] != neighbour || len(neighbour.links) <= l || neighbour == node {
+					t.Fatalf("%s links to %s on level %d, which isn't a node there", element, neighbour.element, l)
+				}
+			}
+		}
+	}
+	if vs.entry == nil {
+		if len(vs.nodes) > 0 {
+			t.Fatal("set with nodes has no entry point")
+		}
+		return
+	}
+	for _, node := range vs.nodes {
+		if len(node.links) > len(vs.entry.links) {
+			t.Fatalf("%s is on %d levels, above the entry point's %d", node.element, len(node.links), len(vs.entry.links))
+		}
+	}
+
+	reached := map[*vectorNode]bool{vs.entry: true}
+	queue := []*vectorNode{vs.entry}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, neighbour := range node.links[0] {
+			if !reached[neighbour] {
+				reached[neighbour] = true
+				queue = append(queue, neighbour)
+			}
+		}
+	}
+	if len(reached) != len(vs.nodes) {
+		t.Errorf("only %d of %d nodes can be reached from the entry point", len(reached), len(vs.nodes))
+	}
+}
+
+// vectorRecall searches for the nearest count nodes to each query and
+// returns the fraction of results that are as near as the count nearest
+// nodes found by scanning every node that passes the filter.
+func vectorRecall(vs *VectorSet, queries [][]float32, count int, filter filterExpr) float64 {
+	found := 0
+	for _, vector := range queries {
+		query := vs.encode(vector)
+		var distances []float64
+		for _, node := range vs.nodes {
+			if filter == nil || filterMatches(filter, node.parsed) {
+				distances = append(distances, vs.distance(&query, &node.vector))
+			}
+		}
+		slices.Sort(distances)
+		worst := distances[min(count, len(distances))-1]
+
+		for _, result := range vs.Search(&query, count, VectorDefaultEF, filter, 0) {
+			if result.distance <= worst+1e-9 {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(count*len(queries))
+}
+
+func TestVectorSetRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	tests := []struct {
+		quantization string
+		recall       float64
+	}{
+		{VectorQuantNone, 0.95},
+		{VectorQuantQ8, 0.95},
+		{VectorQuantBin, 0.8},
+	}
+	for _, test := range tests {
+		vs := newRandomVectorSet(rng, test.quantization, 1000, 32)
+		checkVectorGraph(t, vs)
+
+		var queries [][]float32
+		for range 50 {
+			queries = append(queries, randomVector(rng, 32))
+		}
+		if recall := vectorRecall(vs, queries, 10, nil); recall < test.recall {
+			t.Errorf("%s: recall %v, want at least %v", test.quantization, recall, test.recall)
+		}
+	}
+}
+
+func TestVectorSetFilter(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vs := newRandomVectorSet(rng, VectorQuantNone, 1500, 16)
+	filter, err := parseVectorFilter(".even and .n % 3 == 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var queries [][]float32
+	for range 30 {
+		query := randomVector(rng, 16)
+		queries = append(queries, query)
+		encoded := vs.encode(query)
+		for _, result := range vs.Search(&encoded, 10, VectorDefaultEF, filter, 0) {
+			if n := result.node.parsed.fields["n"].integer; n%6 != 0 {
+				t.Fatalf("filtered search returned %d", n)
+			}
+		}
+	}
+	if recall := vectorRecall(vs, queries, 10, filter); recall < 0.9 {
+		t.Errorf("filtered recall %v, want at least 0.9", recall)
+	}
+
+	// Without a budget, the search explores until it finds the one node a
+	// rare filter passes.
+	rare, _ := parseVectorFilter(".n == 1234")
+	query := vs.encode(queries[0])
+	if results := vs.Search(&query, 10, VectorDefaultEF, rare, 0); len(results) != 1 || results[0].node.element != "1234" {
+		t.Errorf("unbudgeted search for a single node = %v", results)
+	}
+}
+
+func TestVectorSetRemove(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	vs := newRandomVectorSet(rng, VectorQuantNone, 600, 16)
+	for i := 0; i < 600; i += 2 {
+		if !vs.Remove(fmt.Sprint(i)) {
+			t.Fatalf("Remove(%d) found nothing", i)
+		}
+		if i%100 == 0 {
+			checkVectorGraph(t, vs)
+		}
+	}
+	checkVectorGraph(t, vs)
+	if vs.Len() != 300 || vs.Remove("0") {
+		t.Errorf("after removing half, %d nodes remain", vs.Len())
+	}
+
+	var queries [][]float32
+	for range 30 {
+		queries = append(queries, randomVector(rng, 16))
+	}
+	for _, vector := range queries {
+		query := vs.encode(vector)
+		for _, result := range vs.Search(&query, 10, VectorDefaultEF, nil, 0) {
+			if vs.nodes[result.node.element] == nil {
+				t.Fatalf("search returned removed node %s", result.node.element)
+			}
+		}
+	}
+	if recall := vectorRecall(vs, queries, 10, nil); recall < 0.95 {
+		t.Errorf("recall after removals %v, want at least 0.95", recall)
+	}
+
+	for element := range vs.nodes {
+		vs.Remove(element)
+	}
+	checkVectorGraph(t, vs)
+}
+
+func TestVectorSetReplace(t *testing.T) {
+	vs := NewVectorSet(2, VectorQuantNone, VectorDefaultM)
+	attributes := `{"a":1}`
+	if !vs.Add("x", []float32{1, 0}, &attributes, VectorDefaultEF) {
+		t.Error("Add of a new element reported it existing")
+	}
+	vs.Add("y", []float32{0, 1}, nil, VectorDefaultEF)
+	if vs.Add("x", []float32{-1, 0}, nil, VectorDefaultEF) {
+		t.Error("Add of an existing element reported it new")
+	}
+	checkVectorGraph(t, vs)
+	if node := vs.nodes["x"]; node.vector.floats[0] != -1 || node.attributes != attributes {
+		t.Errorf("replaced element has vector %v and attributes %q", node.vector.floats, node.attributes)
+	}
+	query := vs.encode([]float32{-3, 0.1})
+	if results := vs.Search(&query, 1, VectorDefaultEF, nil, 0); results[0].node.element != "x" {
+		t.Errorf("nearest to the replaced vector is %s", results[0].node.element)
+	}
+}
+
+func TestVectorCommands(t *testing.T) {
+	s := NewInMemoryStore()
+	options := VAddOptions{Quantization: VectorQuantNone, EF: VectorDefaultEF, M: VectorDefaultM}
+	for element, vector := range map[string][]float32{"east": {1, 0, 0}, "north": {0, 1, 0}, "northeast": {1, 1, 0}, "up": {0, 0, 1}} {
+		if added, err := s.VAdd("vs", element, vector, options); !added || err != nil {
+			t.Fatalf("VAdd(%s) = %v, %v", element, added, err)
+		}
+	}
+	if n, _ := s.VCard("vs"); n != 4 {
+		t.Errorf("VCard = %d", n)
+	}
+	if dim, _ := s.VDim("vs"); dim != 3 {
+		t.Errorf("VDim = %d", dim)
+	}
+
+	matches, err := s.VSim("vs", VSimQuery{Element: "east", Count: 3, EF: VectorDefaultEF})
+	if err != nil || len(matches) != 3 || matches[0].Element != "east" || matches[0].Score != 1 || matches[1].Element != "northeast" || !near(matches[1].Score, 0.8535533, 1e-6) || !near(matches[2].Score, 0.5, 1e-6) {
+		t.Errorf("VSim by element = %+v, %v", matches, err)
+	}
+	matches, _ = s.VSim("vs", VSimQuery{Vector: []float32{-1, 0, 0}, Count: 1, EF: VectorDefaultEF})
+	if len(matches) != 1 || !near(matches[0].Score, 0.5, 1e-6) {
+		t.Errorf("VSim by vector = %+v", matches)
+	}
+
+	if found, _ := s.VSetAttr("vs", "up", `{"tag":"sky"}`); !found {
+		t.Error("VSetAttr found no element")
+	}
+	if attributes, ok, _ := s.VGetAttr("vs", "up"); !ok || attributes != `{"tag":"sky"}` {
+		t.Errorf("VGetAttr = %q, %v", attributes, ok)
+	}
+	matches, _ = s.VSim("vs", VSimQuery{Element: "east", Count: 4, EF: VectorDefaultEF, Filter: `.tag == "sky"`})
+	if len(matches) != 1 || matches[0].Element != "up" || matches[0].Attributes != `{"tag":"sky"}` {
+		t.Errorf("filtered VSim = %+v", matches)
+	}
+	s.VSetAttr("vs", "up", "")
+	if _, ok, _ := s.VGetAttr("vs", "up"); ok {
+		t.Error("empty VSetAttr left attributes behind")
+	}
+	if found, _ := s.VSetAttr("vs", "missing", "{}"); found {
+		t.Error("VSetAttr of a missing element found it")
+	}
+
+	if _, err := s.VAdd("vs", "bad", []float32{1, 0}, options); err == nil {
+		t.Error("VAdd of the wrong dimension did not fail")
+	}
+	if _, err := s.VAdd("vs", "bad", []float32{1, 0, 0}, VAddOptions{Quantization: VectorQuantBin}); err == nil {
+		t.Error("VAdd with another quantization did not fail")
+	}
+	if _, err := s.VSim("vs", VSimQuery{Element: "missing", Count: 1}); err == nil {
+		t.Error("VSim by a missing element did not fail")
+	}
+	if _, err := s.VSim("vs", VSimQuery{Vector: []float32{1}, Count: 1}); err == nil {
+		t.Error("VSim by a vector of the wrong dimension did not fail")
+	}
+	if _, err := s.VSim("vs", VSimQuery{Element: "east", Count: 1, Filter: ".a =="}); err == nil {
+		t.Error("VSim with an invalid filter did not fail")
+	}
+
+	for _, element := range []string{"east", "north", "northeast", "up"} {
+		if removed, _ := s.VRem("vs", element); !removed {
+			t.Errorf("VRem(%s) found nothing", element)
+		}
+	}
+	if _, ok := s.KeyType["vs"]; ok {
+		t.Error("removing every element left the key behind")
+	}
+	if _, err := s.VDim("vs"); err == nil {
+		t.Error("VDim of a missing key did not fail")
+	}
+	s.RPush("list", []string{"a"})
+	if _, err := s.VAdd("list", "a", []float32{1}, options); err == nil {
+		t.Error("VAdd on a list did not fail")
+	}
+}
+
+func TestVectorSetSnapshot(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, quantization := range []string{VectorQuantNone, VectorQuantQ8, VectorQuantBin} {
+		vs := newRandomVectorSet(rng, quantization, 300, 8)
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(vs); err != nil {
+			t.Fatal(err)
+		}
+		var decoded VectorSet
+		if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		checkVectorGraph(t, &decoded)
+		if decoded.Len() != vs.Len() || decoded.entry.element != vs.entry.element || decoded.dim != 8 || decoded.quantization != quantization {
+			t.Errorf("%s: decoded %d nodes with entry %s", quantization, decoded.Len(), decoded.entry.element)
+		}
+
+		filter, _ := parseVectorFilter(".even")
+		for range 10 {
+			query := vs.encode(randomVector(rng, 8))
+			want := vs.Search(&query, 5, VectorDefaultEF, filter, 0)
+			got := decoded.Search(&query, 5, VectorDefaultEF, filter, 0)
+			if !slices.EqualFunc(got, want, func(a, b vectorCandidate) bool {
+				return a.node.element == b.node.element && a.distance == b.distance
+			}) {
+				t.Fatalf("%s: decoded set found different neighbours", quantization)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+type zpopResult struct {
+	key     string
+	members []ScoredMember
+	ok      bool
+	err     error
+}
+
+func startBZMPop(s *InMemoryStore, ctx context.Context, keys []string, max bool, count int, timeout time.Duration) <-chan zpopResult {
+	result := make(chan zpopResult, 1)
+	go func() {
+		s.BeginCommand()
+		defer s.EndCommand()
+		key, members, ok, err := s.BZMPop(ctx, keys, max, count, timeout)
+		result <- zpopResult{key, members, ok, err}
+	}()
+	return result
+}
+
+func receiveZPop(t *testing.T, result <-chan zpopResult) zpopResult {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked client was never served")
+	}
+	return zpopResult{}
+}
+
+func TestZPop(t *testing.T) {
+	s := newLeaderboard()
+	if got, _ := s.ZPop("zset", false, 2); !slices.Equal(got, []ScoredMember{{"a", 1}, {"b", 2}}) {
+		t.Errorf("ZPOPMIN 2 = %v", got)
+	}
+	if got, _ := s.ZPop("zset", true, 2); !slices.Equal(got, []ScoredMember{{"e", 5}, {"d", 3}}) {
+		t.Errorf("ZPOPMAX 2 = %v", got)
+	}
+	if got, _ := s.ZPop("zset", true, 10); !slices.Equal(got, []ScoredMember{{"c", 2}}) {
+		t.Errorf("ZPOPMAX past the end = %v", got)
+	}
+	if _, ok := s.KeyType["zset"]; ok {
+		t.Error("ZPop left an empty sorted set behind")
+	}
+	if got, err := s.ZPop("zset", false, 1); got != nil || err != nil {
+		t.Errorf("ZPop on a missing key = %v, %v", got, err)
+	}
+}
+
+func TestZMPop(t *testing.T) {
+	s := newLeaderboard()
+	key, got, err := s.ZMPop([]string{"missing", "zset"}, true, 1)
+	if err != nil || key != "zset" || !slices.Equal(got, []ScoredMember{{"e", 5}}) {
+		t.Errorf("ZMPop = %s, %v, %v", key, got, err)
+	}
+	if key, got, _ := s.ZMPop([]string{"missing"}, false, 1); key != "" || got != nil {
+		t.Errorf("ZMPop of missing keys = %s, %v", key, got)
+	}
+
+	s.RPush("list", []string{"a"})
+	if _, _, err := s.ZMPop([]string{"zset", "list"}, false, 1); err == nil {
+		t.Error("ZMPop with a list among the keys did not fail")
+	}
+	if n, _ := s.ZCard("zset"); n != 4 {
+		t.Errorf("failed ZMPop popped from an earlier key, %d members left", n)
+	}
+}
+
+func TestBZMPopWithoutBlocking(t *testing.T) {
+	s := newLeaderboard()
+	r := receiveZPop(t, startBZMPop(s, context.Background(), []string{"missing", "zset"}, false, 2, 0))
+	if !r.ok || r.key != "zset" || !slices.Equal(r.members, []ScoredMember{{"a", 1}, {"b", 2}}) {
+		t.Errorf("BZMPop = %+v", r)
+	}
+
+	s.RPush("list", []string{"a"})
+	if r := receiveZPop(t, startBZMPop(s, context.Background(), []string{"list"}, false, 1, 0)); r.err == nil {
+		t.Errorf("BZMPop on a list = %+v, want an error", r)
+	}
+}
+
+func TestBZMPopServedInOrder(t *testing.T) {
+	s := NewInMemoryStore()
+	var results []<-chan zpopResult
+	for i := range 3 {
+		results = append(results, startBZMPop(s, context.Background(), []string{"queue"}, false, 1, 0))
+		waitBlocked(t, s, "queue", i+1)
+	}
+
+	s.ZAdd("queue", []ScoredMember{{"low", 1}, {"high", 9}}, ZAddOptions{})
+	for i, want := range []string{"low", "high"} {
+		if r := receiveZPop(t, results[i]); !r.ok || r.key != "queue" || !slices.Equal(members(r.members), []string{want}) {
+			t.Errorf("client %d got %+v, want %s", i, r, want)
+		}
+	}
+	waitBlocked(t, s, "queue", 1)
+	if _, ok := s.KeyType["queue"]; ok {
+		t.Error("serving every member left the key behind")
+	}
+
+	s.ZAdd("queue", []ScoredMember{{"late", 5}}, ZAddOptions{})
+	if r := receiveZPop(t, results[2]); !slices.Equal(members(r.members), []string{"late"}) {
+		t.Errorf("third client got %+v", r)
+	}
+}
+
+func TestBZMPopWokenByStore(t *testing.T) {
+	s := newRollupStore()
+	result := startBZMPop(s, context.Background(), []string{"other", "dest"}, true, 10, 0)
+	waitBlocked(t, s, "dest", 1)
+
+	// A list at a watched key does not serve the client.
+	s.RPush("other", []string{"a"})
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case r := <-result:
+		t.Fatalf("client served by a list push: %+v", r)
+	default:
+	}
+
+	s.ZUnionStore("dest", []string{"week1"}, nil, ZAggregateSum)
+	r := receiveZPop(t, result)
+	if !r.ok || r.key != "dest" || !slices.Equal(members(r.members), []string{"c", "b", "a"}) {
+		t.Errorf("BZMPop woken by ZUNIONSTORE = %+v", r)
+	}
+}
+
+func TestBZMPopTimeout(t *testing.T) {
+	s := NewInMemoryStore()
+	start := time.Now()
+	r := receiveZPop(t, startBZMPop(s, context.Background(), []string{"queue"}, false, 1, 50*time.Millisecond))
+	if r.ok || r.err != nil {
+		t.Errorf("BZMPop timeout = %+v", r)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("BZMPop returned after %v, before its timeout", elapsed)
+	}
+	waitBlocked(t, s, "queue", 0)
+}
+
+func TestCancelAfterServeRestoresMembers(t *testing.T) {
+	s := NewInMemoryStore()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	result := startBZMPop(s, ctx, []string{"queue"}, false, 2, 0)
+	waitBlocked(t, s, "queue", 1)
+
+	// As in serveThenCancel, serve the client after it was cancelled but
+	// before it could take s.mu to clean up.
+	s.mu.Lock()
+	cancel(ErrUnblockedError)
+	time.Sleep(20 * time.Millisecond)
+	zset := NewSortedSet()
+	zset.Set("a", 1)
+	zset.Set("b", 2)
+	zset.Set("c", 3)
+	s.storeSortedSetLocked("queue", zset)
+	client := s.blockedClients["queue"][0]
+	if !client.serve("queue") {
+		t.Fatal("client could not be served")
+	}
+	s.unblockClient(client)
+	s.mu.Unlock()
+
+	if r := receiveZPop(t, result); r.ok || r.err != ErrUnblockedError {
+		t.Errorf("BZMPop = %+v, want the cancellation error", r)
+	}
+	got, _ := s.ZRange("queue", ZRangeSpec{Stop: -1})
+	if !slices.Equal(got, []ScoredMember{{"a", 1}, {"b", 2}, {"c", 3}}) {
+		t.Errorf("queue = %v, want the popped members back with their scores", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/theaniketnegi/goredis/store"
+)
+
+// commandArities holds the arity of every command following the Redis
+// convention: the number of arguments including the command name, or its
+// negation when that number is only a minimum. It lets commands queued by
+// MULTI be rejected before EXEC, which is all Redis checks at that point.
+var commandArities = map[string]int{
+	"PING": -1, "ECHO": 2, "GET": 2, "SET": -3, "DEL": -2, "EXISTS": -2, "TTL": 2,
+	"CONFIG": -2, "CLIENT": -2, "OBJECT": -2, "KEYS": 2,
+	"SAVE": 1, "BGSAVE": 1, "LASTSAVE": 1,
+	"MULTI": 1, "EXEC": 1, "DISCARD": 1,
+
+	"INCR": 2, "INCRBY": 3, "DECR": 2, "DECRBY": 3, "APPEND": 3, "MSET": -3, "MGET": -2,
+
+	"LPUSH": -3, "LPUSHX": -3, "RPUSH": -3, "RPUSHX": -3, "LPOP": -2, "RPOP": -2,
+	"BLPOP": -3, "BRPOP": -3, "LLEN": 2, "LRANGE": 4, "LTRIM": 4, "LMOVE": 5, "BLMOVE": 6,
+	"LINDEX": 3, "LSET": 4, "LINSERT": 5, "LREM": 4, "LPOS": -3, "LMPOP": -4, "BLMPOP": -5,
+	"RPOPLPUSH": 3, "BRPOPLPUSH": 4,
+
+	"SADD": -3, "SREM": -3, "SISMEMBER": 3, "SMISMEMBER": -3, "SCARD": 2, "SMEMBERS": 2,
+	"SINTER": -2, "SUNION": -2, "SDIFF": -2, "SINTERSTORE": -3, "SUNIONSTORE": -3,
+	"SDIFFSTORE": -3, "SINTERCARD": -3, "SMOVE": 4, "SPOP": -2, "SRANDMEMBER": -2,
+
+	"HSET": -4, "HSETNX": 4, "HGET": 3, "HMGET": -3, "HDEL": -3, "HGETALL": 2, "HKEYS": 2,
+	"HVALS": 2, "HLEN": 2, "HEXISTS": 3, "HSTRLEN": 3, "HRANDFIELD": -2,
+	"HEXPIRE": -6, "HPEXPIRE": -6, "HEXPIREAT": -6, "HPEXPIREAT": -6, "HTTL": -5, "HPTTL": -5,
+	"HPERSIST": -5, "HGETEX": -5, "HGETDEL": -5, "HSETEX": -6,
+
+	"ZADD": -4, "ZINCRBY": 4, "ZSCORE": 3, "ZMSCORE": -3, "ZREM": -3, "ZCARD": 2,
+	"ZCOUNT": 4, "ZLEXCOUNT": 4, "ZRANK": -3, "ZREVRANK": -3, "ZRANGE": -4,
+	"ZUNION": -3, "ZINTER": -3, "ZDIFF": -3, "ZUNIONSTORE": -4, "ZINTERSTORE": -4,
+	"ZDIFFSTORE": -4, "ZINTERCARD": -3, "ZRANGESTORE": -5, "ZREMRANGEBYRANK": 4,
+	"ZREMRANGEBYSCORE": 4, "ZREMRANGEBYLEX": 4, "ZPOPMIN": -2, "ZPOPMAX": -2, "ZMPOP": -4,
+	"BZPOPMIN": -3, "BZPOPMAX": -3, "BZMPOP": -5,
+
+	"GEOADD": -5, "GEOPOS": -2, "GEODIST": -4, "GEOHASH": -2, "GEOSEARCH": -6,
+	"GEOSEARCHSTORE": -7,
+
+	"XADD": -5, "XLEN": 2, "XRANGE": -4, "XREVRANGE": -4, "XDEL": -3, "XTRIM": -4,
+	"XSETID": -3, "XINFO": -2, "XREAD": -4, "XGROUP": -2, "XREADGROUP": -7, "XACK": -4,
+	"XPENDING": -3, "XCLAIM": -6, "XAUTOCLAIM": -6,
+
+	"JSON.SET": -4, "JSON.GET": -2, "JSON.MGET": -3, "JSON.DEL": -2, "JSON.FORGET": -2,
+	"JSON.TYPE": -2, "JSON.NUMINCRBY": 4, "JSON.STRAPPEND": -3, "JSON.ARRAPPEND": -4,
+	"JSON.ARRINSERT": -5, "JSON.ARRPOP": -2, "JSON.OBJKEYS": -2,
+
+	"BF.RESERVE": -4, "BF.ADD": 3, "BF.MADD": -3, "BF.EXISTS": 3, "BF.MEXISTS": -3, "BF.INFO": -2,
+	"CF.RESERVE": -3, "CF.ADD": 3, "CF.ADDNX": 3, "CF.DEL": 3, "CF.EXISTS": 3, "CF.COUNT": 3,
+	"TOPK.RESERVE": -3, "TOPK.ADD": -3, "TOPK.INCRBY": -4, "TOPK.QUERY": -3, "TOPK.LIST": -2,
+	"CMS.INITBYDIM": 4, "CMS.INITBYPROB": 4, "CMS.INCRBY": -4, "CMS.QUERY": -3, "CMS.MERGE": -4,
+
+	"TDIGEST.CREATE": -2, "TDIGEST.ADD": -3, "TDIGEST.MERGE": -4, "TDIGEST.QUANTILE": -3,
+	"TDIGEST.CDF": -3, "TDIGEST.RANK": -3, "TDIGEST.MIN": 2, "TDIGEST.MAX": 2,
+	"TDIGEST.TRIMMED_MEAN": 4,
+
+	"TS.CREATE": -2, "TS.ADD": -4, "TS.MADD": -4, "TS.INCRBY": -3, "TS.RANGE": -4,
+	"TS.REVRANGE": -4, "TS.MRANGE": -5, "TS.CREATERULE": -6, "TS.DELETERULE": 3,
+
+	"VADD": -5, "VREM": 3, "VSIM": -4, "VCARD": 2, "VDIM": 2, "VGETATTR": 3, "VSETATTR": 4,
+
+	"FT.CREATE": -5, "FT.DROPINDEX": -2, "FT._LIST": 1, "FT.SEARCH": -3, "FT.AGGREGATE": -3,
+
+	"PFADD": -2, "PFCOUNT": -2, "PFMERGE": -2, "PFDEBUG": 3,
+}
+
+// next returns the next request to execute: the commands queued by a
+// transaction while it is being executed, otherwise the next one read from
+// the connection. The store's command lock is held for whichever it returns
+// until next is called again.
+func (c *client) next(kv *store.InMemoryStore, requests <-chan request) (request, bool) {
+	if c.executing {
+		if len(c.queued) > 0 {
+			request := c.queued[0]
+			c.queued = c.queued[1:]
+			return request, true
+		}
+		c.queued, c.executing = nil, false
+		kv.EndTransaction()
+	}
+	if c.inCommand {
+		kv.EndCommand()
+		c.inCommand = false
+	}
+
+	request, ok := <-requests
+	if !ok {
+		return request, false
+	}
+	kv.BeginCommand()
+	c.inCommand = true
+	return request, true
+}
+
+// queue handles a command received between MULTI and EXEC, reporting false
+// for the ones that must run right away. A command that could never run
+// aborts the transaction, making EXEC fail.
+func (c *client) queue(conn net.Conn, command string, args []string) bool {
+	if !c.inMulti {
+		return false
+	}
+
+	arity, ok := commandArities[command]
+	if !ok {
+		c.aborted = true
+		conn.Write([]byte("-ERR unknown command '" + strings.ToLower(command) + "', with args beginning with: " + strings.Join(args, " ") + "\r\n"))
+		return true
+	}
+	if arity >= 0 && len(args)+1 != arity || arity < 0 && len(args)+1 < -arity {
+		c.aborted = true
+		conn.Write([]byte("-ERR wrong number of arguments for '" + strings.ToLower(command) + "' command\r\n"))
+		return true
+	}
+
+	switch command {
+	case "MULTI", "EXEC", "DISCARD":
+		return false
+	}
+	c.queued = append(c.queued, request{command: command, args: args})
+	conn.Write([]byte("+QUEUED\r\n"))
+	return true
+}
+
+func (c *client) discard() {
+	c.inMulti, c.aborted, c.queued = false, false, nil
+}
+
+// exec takes the store's transaction lock in place of the command lock and
+// starts executing the queued commands, returning how many there are.
+func (c *client) exec(kv *store.InMemoryStore) int {
+	c.inMulti = false
+	kv.EndCommand()
+	kv.BeginTransaction()
+	c.inCommand, c.executing = false, true
+	return len(c.queued)
+}
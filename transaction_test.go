@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/theaniketnegi/goredis/store"
+)
+
+// replyConn records what is written to the connection.
+type replyConn struct {
+	net.Conn
+	replies bytes.Buffer
+}
+
+func (c *replyConn) Write(b []byte) (int, error) {
+	return c.replies.Write(b)
+}
+
+func TestQueue(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		queued  bool
+		reply   string
+	}{
+		{"SET", []string{"k", "v"}, true, "+QUEUED\r\n"},
+		{"LPUSH", []string{"k", "a", "b"}, true, "+QUEUED\r\n"},
+		{"PING", nil, true, "+QUEUED\r\n"},
+		{"EXEC", nil, false, ""},
+		{"DISCARD", nil, false, ""},
+		{"MULTI", nil, false, ""},
+		{"GET", nil, true, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"EXEC", []string{"now"}, true, "-ERR wrong number of arguments for 'exec' command\r\n"},
+		{"NOPE", []string{"a"}, true, "-ERR unknown command 'nope', with args beginning with: a\r\n"},
+	}
+	for _, test := range tests {
+		conn := &replyConn{}
+		c := &client{inMulti: true}
+		if queued := c.queue(conn, test.command, test.args); queued != test.queued {
+			t.Errorf("%s %v handled = %v, want %v", test.command, test.args, queued, test.queued)
+		}
+		if got := conn.replies.String(); got != test.reply {
+			t.Errorf("%s %v replied %q, want %q", test.command, test.args, got, test.reply)
+		}
+		if wantAborted := test.reply != "" && test.reply[0] == '-'; c.aborted != wantAborted {
+			t.Errorf("%s %v aborted = %v, want %v", test.command, test.args, c.aborted, wantAborted)
+		}
+	}
+
+	conn := &replyConn{}
+	if (&client{}).queue(conn, "SET", []string{"k", "v"}) || conn.replies.Len() != 0 {
+		t.Error("a command outside MULTI was queued")
+	}
+}
+
+func TestExecAndDiscard(t *testing.T) {
+	kv := store.NewInMemoryStore()
+	conn := &replyConn{}
+	requests := make(chan request, 1)
+	c := &client{inMulti: true}
+	c.queue(conn, "SET", []string{"k", "v"})
+	c.queue(conn, "INCR", []string{"n"})
+
+	kv.BeginCommand()
+	c.inCommand = true
+	if n := c.exec(kv); n != 2 || c.inMulti {
+		t.Fatalf("exec = %d with inMulti %v, want 2 queued commands out of MULTI", n, c.inMulti)
+	}
+	for _, want := range []string{"SET", "INCR"} {
+		r, ok := c.next(kv, requests)
+		if !ok || r.command != want {
+			t.Fatalf("next = %v, %v, want %s", r, ok, want)
+		}
+	}
+
+	// Once the queue is drained, next ends the transaction and reads from
+	// the connection again, under the command lock.
+	requests <- request{command: "GET", args: []string{"k"}}
+	if r, ok := c.next(kv, requests); !ok || r.command != "GET" || c.executing || !c.inCommand {
+		t.Fatalf("next after EXEC = %v, %v with executing %v", r, ok, c.executing)
+	}
+	kv.EndCommand()
+
+	c = &client{inMulti: true, aborted: true}
+	c.queue(conn, "SET", []string{"k", "v"})
+	c.discard()
+	if c.inMulti || c.aborted || c.queued != nil {
+		t.Errorf("after discard, inMulti %v, aborted %v, queued %v", c.inMulti, c.aborted, c.queued)
+	}
+}
@@ -1,8 +1,17 @@
 package main
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/theaniketnegi/goredis/store"
 )
 
 func parsePattern(pattern string) string {
@@ -14,3 +23,2334 @@ func parsePattern(pattern string) string {
 	pattern = strings.ReplaceAll(pattern, "\\]", "]")
 	return "^" + pattern + "$"
 }
+
+func parseTimeout(timeoutStr string) (time.Duration, error) {
+	timeout, err := strconv.ParseFloat(timeoutStr, 64)
+	if err != nil || timeout < 0 {
+		return 0, errors.New("-ERR timeout is not a float or out of range")
+	}
+	return time.Duration(timeout * float64(time.Second)), nil
+}
+
+// parseMPopArgs parses the "numkeys key [key ...] first|second [COUNT count]"
+// tail shared by LMPOP, ZMPOP and their blocking variants, reporting whether
+// the first direction was given.
+func parseMPopArgs(args []string, first string, second string) ([]string, bool, int, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, errors.New("-ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-2 {
+		return nil, false, 0, errors.New("-ERR syntax error")
+	}
+
+	keys := args[1 : numKeys+1]
+	direction := strings.ToUpper(args[numKeys+1])
+	if direction != first && direction != second {
+		return nil, false, 0, errors.New("-ERR syntax error")
+	}
+
+	count := 1
+	rest := args[numKeys+2:]
+	if len(rest) != 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0]) != "COUNT" {
+			return nil, false, 0, errors.New("-ERR syntax error")
+		}
+		count, err = strconv.Atoi(rest[1])
+		if err != nil || count <= 0 {
+			return nil, false, 0, errors.New("-ERR count should be greater than 0")
+		}
+	}
+	return keys, direction == first, count, nil
+}
+
+// parseFieldsArg parses the "FIELDS numfields ..." block that ends the hash
+// field expiration commands, where every field takes width arguments.
+func parseFieldsArg(args []string, width int) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("-ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	numFields, err := strconv.Atoi(args[1])
+	if err != nil || numFields <= 0 {
+		return nil, errors.New("-ERR Parameter `numFields` should be greater than 0")
+	}
+	if len(args)-2 != numFields*width {
+		return nil, errors.New("-ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// maxExpireTime bounds expiry arguments so that converting them to unix
+// milliseconds cannot overflow.
+const maxExpireTime = 1<<48 - 1
+
+// parseExpireAt converts an expiry given with unit EX, PX, EXAT or PXAT to
+// unix milliseconds. Values below minValue are rejected.
+func parseExpireAt(unit string, value string, minValue int64, command string) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < minValue || n > maxExpireTime {
+		return 0, fmt.Errorf("-ERR invalid expire time in '%s' command", command)
+	}
+
+	switch unit {
+	case "EX":
+		return time.Now().UnixMilli() + n*1000, nil
+	case "PX":
+		return time.Now().UnixMilli() + n, nil
+	case "EXAT":
+		return n * 1000, nil
+	}
+	return n, nil
+}
+
+// parseScore parses a sorted set score, accepting "inf" and "-inf" but not
+// NaN.
+func parseScore(value string) (float64, error) {
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errors.New("-ERR value is not a valid float")
+	}
+	return score, nil
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case math.IsNaN(score):
+		return "nan"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// parseScoreRange parses ZCOUNT style bounds such as "(1.5" or "+inf".
+func parseScoreRange(min string, max string) (store.ScoreRange, error) {
+	var r store.ScoreRange
+	var err1, err2 error
+	r.Min, r.MinExclusive, err1 = parseScoreBound(min)
+	r.Max, r.MaxExclusive, err2 = parseScoreBound(max)
+	if err1 != nil || err2 != nil {
+		return r, errors.New("-ERR min or max is not a float")
+	}
+	return r, nil
+}
+
+func parseScoreBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	if exclusive {
+		bound = bound[1:]
+	}
+	score, err := parseScore(bound)
+	return score, exclusive, err
+}
+
+// parseLexRange parses ZLEXCOUNT style bounds: "-", "+", "[member" or
+// "(member".
+func parseLexRange(min string, max string) (store.LexRange, error) {
+	var r store.LexRange
+	var ok1, ok2 bool
+	r.Min, ok1 = parseLexBound(min)
+	r.Max, ok2 = parseLexBound(max)
+	if !ok1 || !ok2 {
+		return r, errors.New("-ERR min or max not valid string range item")
+	}
+	return r, nil
+}
+
+func parseLexBound(bound string) (store.LexBound, bool) {
+	switch {
+	case bound == "-":
+		return store.LexBound{Infinite: -1}, true
+	case bound == "+":
+		return store.LexBound{Infinite: 1}, true
+	case strings.HasPrefix(bound, "["):
+		return store.LexBound{Value: bound[1:]}, true
+	case strings.HasPrefix(bound, "("):
+		return store.LexBound{Value: bound[1:], Exclusive: true}, true
+	}
+	return store.LexBound{}, false
+}
+
+// writeScoredMembers writes members as an array, with every member paired
+// with its score in a nested array if withScores is set.
+func writeScoredMembers(resp *strings.Builder, members []store.ScoredMember, withScores bool) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(members)))
+	for _, member := range members {
+		if withScores {
+			score := formatScore(member.Score)
+			resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n,%s\r\n", len(member.Member), member.Member, score))
+			continue
+		}
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(member.Member), member.Member))
+	}
+}
+
+// parseZRangeArgs parses "start stop [BYSCORE|BYLEX] [REV] [LIMIT offset
+// count] [WITHSCORES]", the arguments ZRANGE takes after its key.
+func parseZRangeArgs(args []string) (store.ZRangeSpec, bool, error) {
+	spec := store.ZRangeSpec{Count: -1}
+	withScores := false
+	limit := false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "BYSCORE":
+			spec.By = store.ZRangeByScore
+		case "BYLEX":
+			spec.By = store.ZRangeByLex
+		case "REV":
+			spec.Reverse = true
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return spec, false, errors.New("-ERR syntax error")
+			}
+			offset, err1 := strconv.Atoi(args[i+1])
+			count, err2 := strconv.Atoi(args[i+2])
+			if err1 != nil || err2 != nil {
+				return spec, false, errors.New("-ERR value is not an integer or out of range")
+			}
+			spec.Offset, spec.Count = offset, count
+			limit = true
+			i += 2
+		default:
+			return spec, false, errors.New("-ERR syntax error")
+		}
+	}
+
+	if limit && spec.By == store.ZRangeByRank {
+		return spec, false, errors.New("-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if withScores && spec.By == store.ZRangeByLex {
+		return spec, false, errors.New("-ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	if spec.Offset < 0 {
+		spec.Count = 0
+	}
+
+	// With REV the bounds of score and lex ranges are given highest first.
+	min, max := args[0], args[1]
+	if spec.Reverse {
+		min, max = max, min
+	}
+
+	var err error
+	switch spec.By {
+	case store.ZRangeByScore:
+		spec.Score, err = parseScoreRange(min, max)
+	case store.ZRangeByLex:
+		spec.Lex, err = parseLexRange(min, max)
+	default:
+		var err1, err2 error
+		spec.Start, err1 = strconv.Atoi(args[0])
+		spec.Stop, err2 = strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil {
+			err = errors.New("-ERR value is not an integer or out of range")
+		}
+	}
+	return spec, withScores, err
+}
+
+// parseZAddArgs parses "[NX|XX] [GT|LT] [CH] [INCR] score member [score
+// member ...]", the arguments ZADD takes after its key.
+func parseZAddArgs(args []string) (store.ZAddOptions, []store.ScoredMember, error) {
+	var options store.ZAddOptions
+	i := 0
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			options.NX = true
+		case "XX":
+			options.XX = true
+		case "GT":
+			options.GT = true
+		case "LT":
+			options.LT = true
+		case "CH":
+			options.CH = true
+		case "INCR":
+			options.Incr = true
+		default:
+			break flags
+		}
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return options, nil, errors.New("-ERR syntax error")
+	}
+	if options.NX && options.XX {
+		return options, nil, errors.New("-ERR XX and NX options at the same time are not compatible")
+	}
+	if (options.GT && options.LT) || (options.NX && (options.GT || options.LT)) {
+		return options, nil, errors.New("-ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+	if options.Incr && len(pairs) > 2 {
+		return options, nil, errors.New("-ERR INCR option supports a single increment-element pair")
+	}
+
+	members := make([]store.ScoredMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			return options, nil, err
+		}
+		members = append(members, store.ScoredMember{Member: pairs[j+1], Score: score})
+	}
+	return options, members, nil
+}
+
+// parseZSetOperationArgs parses "numkeys key [key ...]" followed by the
+// options of the sorted set operations: WEIGHTS and AGGREGATE if weighted is
+// set, and WITHSCORES if withScores is set.
+func parseZSetOperationArgs(args []string, command string, weighted bool, withScores bool) ([]string, []float64, store.ZAggregate, bool, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, nil, 0, false, errors.New("-ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, nil, 0, false, fmt.Errorf("-ERR at least 1 input key is needed for '%s' command", command)
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, 0, false, errors.New("-ERR syntax error")
+	}
+
+	keys := args[1 : numKeys+1]
+	var weights []float64
+	aggregate := store.ZAggregateSum
+	scores := false
+
+	rest := args[numKeys+1:]
+	for i := 0; i < len(rest); i++ {
+		switch option := strings.ToUpper(rest[i]); {
+		case weighted && option == "WEIGHTS":
+			if i+numKeys >= len(rest) {
+				return nil, nil, 0, false, errors.New("-ERR syntax error")
+			}
+			weights = make([]float64, numKeys)
+			for j := range weights {
+				weights[j], err = strconv.ParseFloat(rest[i+1+j], 64)
+				if err != nil || math.IsNaN(weights[j]) {
+					return nil, nil, 0, false, errors.New("-ERR weight value is not a float")
+				}
+			}
+			i += numKeys
+		case weighted && option == "AGGREGATE":
+			if i+1 >= len(rest) {
+				return nil, nil, 0, false, errors.New("-ERR syntax error")
+			}
+			switch strings.ToUpper(rest[i+1]) {
+			case "SUM":
+				aggregate = store.ZAggregateSum
+			case "MIN":
+				aggregate = store.ZAggregateMin
+			case "MAX":
+				aggregate = store.ZAggregateMax
+			default:
+				return nil, nil, 0, false, errors.New("-ERR syntax error")
+			}
+			i++
+		case withScores && option == "WITHSCORES":
+			scores = true
+		default:
+			return nil, nil, 0, false, errors.New("-ERR syntax error")
+		}
+	}
+	return keys, weights, aggregate, scores, nil
+}
+
+// zsetOperation runs ZUNION, ZINTER or ZDIFF.
+func zsetOperation(kv *store.InMemoryStore, command string, keys []string, weights []float64, aggregate store.ZAggregate) ([]store.ScoredMember, error) {
+	switch command {
+	case "ZUNION":
+		return kv.ZUnion(keys, weights, aggregate)
+	case "ZINTER":
+		return kv.ZInter(keys, weights, aggregate)
+	}
+	return kv.ZDiff(keys)
+}
+
+// parseGeoAddArgs parses "[NX|XX] [CH] longitude latitude member ...", the
+// arguments GEOADD takes after its key, into the ZADD they amount to.
+func parseGeoAddArgs(args []string) (store.ZAddOptions, []store.ScoredMember, error) {
+	var options store.ZAddOptions
+	i := 0
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			options.NX = true
+		case "XX":
+			options.XX = true
+		case "CH":
+			options.CH = true
+		default:
+			break options
+		}
+	}
+	if options.NX && options.XX {
+		return options, nil, errors.New("-ERR XX and NX options at the same time are not compatible")
+	}
+
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return options, nil, errors.New("-ERR syntax error")
+	}
+
+	members := make([]store.ScoredMember, 0, len(rest)/3)
+	for j := 0; j < len(rest); j += 3 {
+		coord, err := parseGeoCoord(rest[j], rest[j+1])
+		if err != nil {
+			return options, nil, err
+		}
+		score, err := store.GeoScore(coord)
+		if err != nil {
+			return options, nil, err
+		}
+		members = append(members, store.ScoredMember{Member: rest[j+2], Score: score})
+	}
+	return options, members, nil
+}
+
+func parseGeoCoord(longitude string, latitude string) (store.GeoCoord, error) {
+	lon, err := strconv.ParseFloat(longitude, 64)
+	if err != nil || math.IsNaN(lon) {
+		return store.GeoCoord{}, errors.New("-ERR value is not a valid float")
+	}
+	lat, err := strconv.ParseFloat(latitude, 64)
+	if err != nil || math.IsNaN(lat) {
+		return store.GeoCoord{}, errors.New("-ERR value is not a valid float")
+	}
+	return store.GeoCoord{Longitude: lon, Latitude: lat}, nil
+}
+
+// parseGeoUnit returns the number of meters in a distance unit.
+func parseGeoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errors.New("-ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+func parseGeoDistance(value string, name string) (float64, error) {
+	distance, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(distance) {
+		return 0, fmt.Errorf("-ERR need numeric %s", name)
+	}
+	return distance, nil
+}
+
+// geoReplyOptions are the WITH* flags of GEOSEARCH.
+type geoReplyOptions struct {
+	withCoord bool
+	withDist  bool
+	withHash  bool
+}
+
+// parseGeoSearchArgs parses the arguments GEOSEARCH and GEOSEARCHSTORE take
+// after their source key. STOREDIST is only accepted when storing.
+func parseGeoSearchArgs(args []string, command string, storing bool) (store.GeoQuery, geoReplyOptions, bool, error) {
+	query := store.GeoQuery{Unit: 1}
+	var reply geoReplyOptions
+	storeDist := false
+	fromLonLat, byRadius := false, false
+	name := strings.ToLower(command)
+
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if remaining < 1 {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			if query.UseMember || fromLonLat {
+				return query, reply, false, fmt.Errorf("-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name)
+			}
+			query.UseMember = true
+			query.FromMember = args[i+1]
+			i++
+		case "FROMLONLAT":
+			if remaining < 2 {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			if query.UseMember || fromLonLat {
+				return query, reply, false, fmt.Errorf("-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name)
+			}
+			coord, err := parseGeoCoord(args[i+1], args[i+2])
+			if err != nil {
+				return query, reply, false, err
+			}
+			if _, err := store.GeoScore(coord); err != nil {
+				return query, reply, false, err
+			}
+			fromLonLat = true
+			query.Center = coord
+			i += 2
+		case "BYRADIUS":
+			if remaining < 2 {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			if byRadius || query.ByBox {
+				return query, reply, false, fmt.Errorf("-ERR exactly one of BYRADIUS and BYBOX can be specified for %s", name)
+			}
+			radius, err := parseGeoDistance(args[i+1], "radius")
+			if err != nil {
+				return query, reply, false, err
+			}
+			if radius < 0 {
+				return query, reply, false, errors.New("-ERR radius cannot be negative")
+			}
+			unit, err := parseGeoUnit(args[i+2])
+			if err != nil {
+				return query, reply, false, err
+			}
+			byRadius = true
+			query.Radius, query.Unit = radius*unit, unit
+			i += 2
+		case "BYBOX":
+			if remaining < 3 {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			if byRadius || query.ByBox {
+				return query, reply, false, fmt.Errorf("-ERR exactly one of BYRADIUS and BYBOX can be specified for %s", name)
+			}
+			width, err := parseGeoDistance(args[i+1], "width")
+			if err != nil {
+				return query, reply, false, err
+			}
+			height, err := parseGeoDistance(args[i+2], "height")
+			if err != nil {
+				return query, reply, false, err
+			}
+			if width < 0 || height < 0 {
+				return query, reply, false, errors.New("-ERR height or width cannot be negative")
+			}
+			unit, err := parseGeoUnit(args[i+3])
+			if err != nil {
+				return query, reply, false, err
+			}
+			query.ByBox = true
+			query.Width, query.Height, query.Unit = width*unit, height*unit, unit
+			i += 3
+		case "ASC":
+			query.Sort = store.GeoAsc
+		case "DESC":
+			query.Sort = store.GeoDesc
+		case "COUNT":
+			if remaining < 1 {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return query, reply, false, errors.New("-ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return query, reply, false, errors.New("-ERR COUNT must be > 0")
+			}
+			query.Count = count
+			i++
+		case "ANY":
+			query.Any = true
+		case "WITHCOORD":
+			reply.withCoord = true
+		case "WITHDIST":
+			reply.withDist = true
+		case "WITHHASH":
+			reply.withHash = true
+		case "STOREDIST":
+			if !storing {
+				return query, reply, false, errors.New("-ERR syntax error")
+			}
+			storeDist = true
+		default:
+			return query, reply, false, errors.New("-ERR syntax error")
+		}
+	}
+
+	switch {
+	case !query.UseMember && !fromLonLat:
+		return query, reply, false, fmt.Errorf("-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", name)
+	case !byRadius && !query.ByBox:
+		return query, reply, false, fmt.Errorf("-ERR exactly one of BYRADIUS and BYBOX can be specified for %s", name)
+	case query.Any && query.Count == 0:
+		return query, reply, false, errors.New("-ERR the ANY argument requires COUNT argument")
+	case storing && (reply.withCoord || reply.withDist || reply.withHash):
+		return query, reply, false, fmt.Errorf("-ERR %s is not compatible with WITHDIST, WITHHASH and WITHCOORD options", command)
+	}
+	return query, reply, storeDist, nil
+}
+
+func formatGeoDistance(distance float64) string {
+	return strconv.FormatFloat(distance, 'f', 4, 64)
+}
+
+func writeGeoCoord(resp *strings.Builder, coord store.GeoCoord) {
+	resp.WriteString(fmt.Sprintf("*2\r\n,%s\r\n,%s\r\n", formatScore(coord.Longitude), formatScore(coord.Latitude)))
+}
+
+// writeGeoResults writes GEOSEARCH matches as bare members, or as arrays of
+// the member followed by its distance, hash and coordinates as requested.
+func writeGeoResults(resp *strings.Builder, results []store.GeoResult, options geoReplyOptions) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(results)))
+	fields := 1
+	for _, with := range []bool{options.withDist, options.withHash, options.withCoord} {
+		if with {
+			fields++
+		}
+	}
+
+	for _, result := range results {
+		if fields == 1 {
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(result.Member), result.Member))
+			continue
+		}
+
+		resp.WriteString(fmt.Sprintf("*%d\r\n$%d\r\n%s\r\n", fields, len(result.Member), result.Member))
+		if options.withDist {
+			distance := formatGeoDistance(result.Distance)
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(distance), distance))
+		}
+		if options.withHash {
+			resp.WriteString(fmt.Sprintf(":%d\r\n", int64(result.Score)))
+		}
+		if options.withCoord {
+			writeGeoCoord(resp, result.Coord)
+		}
+	}
+}
+
+var errInvalidStreamID = errors.New("-ERR Invalid stream ID specified as stream command argument")
+
+// parseStreamID parses "ms-seq", or a bare "ms" which gets missingSeq as its
+// sequence number.
+func parseStreamID(value string, missingSeq uint64) (store.StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(value, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return store.StreamID{}, errInvalidStreamID
+	}
+	if !hasSeq {
+		return store.StreamID{Ms: ms, Seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return store.StreamID{}, errInvalidStreamID
+	}
+	return store.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// parseStreamRangeBound parses an XRANGE bound: "-", "+", an ID, or an ID
+// prefixed with "(" to exclude it. A bare millisecond time covers the whole
+// millisecond.
+func parseStreamRangeBound(value string, start bool) (store.StreamID, error) {
+	switch value {
+	case "-":
+		return store.StreamID{}, nil
+	case "+":
+		return store.MaxStreamID, nil
+	}
+
+	missingSeq := uint64(math.MaxUint64)
+	if start {
+		missingSeq = 0
+	}
+
+	exclusive, ok := strings.CutPrefix(value, "(")
+	if !ok {
+		return parseStreamID(value, missingSeq)
+	}
+
+	id, err := parseStreamID(exclusive, missingSeq)
+	if err != nil {
+		return id, err
+	}
+	if start {
+		if id, ok = id.Next(); !ok {
+			return id, errors.New("-ERR invalid start ID for the interval")
+		}
+		return id, nil
+	}
+	if id, ok = id.Prev(); !ok {
+		return id, errors.New("-ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+// parseStreamTrimArg parses the "MAXLEN|MINID [=|~] threshold [LIMIT count]"
+// option starting at args[i], returning the index of its last argument.
+func parseStreamTrimArg(args []string, i int, trim *store.StreamTrim) (int, error) {
+	if trim.Strategy != store.StreamTrimNone {
+		return i, errors.New("-ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+	}
+	strategy := strings.ToUpper(args[i])
+
+	i++
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		trim.Approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return i, errors.New("-ERR syntax error")
+	}
+
+	if strategy == "MAXLEN" {
+		maxLen, err := strconv.Atoi(args[i])
+		if err != nil {
+			return i, errors.New("-ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return i, errors.New("-ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.Strategy = store.StreamTrimMaxLen
+		trim.MaxLen = maxLen
+	} else {
+		minID, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return i, err
+		}
+		trim.Strategy = store.StreamTrimMinID
+		trim.MinID = minID
+	}
+
+	trim.Limit = -1
+	if i+1 < len(args) && strings.ToUpper(args[i+1]) == "LIMIT" {
+		if i+2 >= len(args) {
+			return i, errors.New("-ERR syntax error")
+		}
+		limit, err := strconv.Atoi(args[i+2])
+		if err != nil {
+			return i, errors.New("-ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return i, errors.New("-ERR The LIMIT argument must be >= 0.")
+		}
+		if !trim.Approx {
+			return i, errors.New("-ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		trim.Limit = limit
+		i += 2
+	}
+	return i, nil
+}
+
+// parseXAddArgs parses the arguments XADD takes after its key into its
+// options and the flattened field-value pairs.
+func parseXAddArgs(args []string) (store.XAddOptions, []string, error) {
+	var options store.XAddOptions
+	i := 0
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOMKSTREAM":
+			options.NoMkStream = true
+		case "MAXLEN", "MINID":
+			last, err := parseStreamTrimArg(args, i, &options.Trim)
+			if err != nil {
+				return options, nil, err
+			}
+			i = last
+		default:
+			break options
+		}
+	}
+
+	if i >= len(args) {
+		return options, nil, errors.New("-ERR syntax error")
+	}
+	fields := args[i+1:]
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return options, nil, errors.New("-ERR wrong number of arguments for 'xadd' command")
+	}
+
+	switch id := args[i]; {
+	case id == "*":
+		options.AutoID = true
+	case strings.HasSuffix(id, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(id, "-*"), 10, 64)
+		if err != nil {
+			return options, nil, errInvalidStreamID
+		}
+		options.ID.Ms = ms
+		options.AutoSeq = true
+	default:
+		parsed, err := parseStreamID(id, 0)
+		if err != nil {
+			return options, nil, err
+		}
+		options.ID = parsed
+	}
+	return options, fields, nil
+}
+
+// streamReadOptions are the options of XREAD and XREADGROUP. A count of -1
+// means no limit, and block reports whether BLOCK was given at all since a
+// zero timeout waits forever.
+type streamReadOptions struct {
+	count   int
+	block   bool
+	timeout time.Duration
+	noAck   bool
+}
+
+// parseXReadArgs parses "[COUNT count] [BLOCK milliseconds] [NOACK] STREAMS
+// key ... id ...", where NOACK and the ">" ID are only accepted for
+// XREADGROUP and "$" only for XREAD.
+func parseXReadArgs(args []string, command string) ([]store.StreamRead, streamReadOptions, error) {
+	options := streamReadOptions{count: -1}
+	group := command == "XREADGROUP"
+
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "STREAMS" {
+			break
+		}
+		if option == "NOACK" && group {
+			options.noAck = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, options, errors.New("-ERR syntax error")
+		}
+
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			return nil, options, errors.New("-ERR value is not an integer or out of range")
+		}
+		switch option {
+		case "COUNT":
+			if n > 0 {
+				options.count = n
+			}
+		case "BLOCK":
+			if n < 0 {
+				return nil, options, errors.New("-ERR timeout is negative")
+			}
+			options.block = true
+			options.timeout = time.Duration(n) * time.Millisecond
+		default:
+			return nil, options, errors.New("-ERR syntax error")
+		}
+		i++
+	}
+
+	rest := args[min(i+1, len(args)):]
+	if i >= len(args) || len(rest) == 0 || len(rest)%2 != 0 {
+		return nil, options, fmt.Errorf("-ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(command))
+	}
+
+	keys, ids := rest[:len(rest)/2], rest[len(rest)/2:]
+	reads := make([]store.StreamRead, len(keys))
+	for j, key := range keys {
+		reads[j].Key = key
+		switch {
+		case ids[j] == "$" && !group, ids[j] == ">" && group:
+			reads[j].Last = true
+			continue
+		case ids[j] == "$":
+			return nil, options, errors.New("-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		case ids[j] == ">":
+			return nil, options, errors.New("-ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		}
+		id, err := parseStreamID(ids[j], 0)
+		if err != nil {
+			return nil, options, err
+		}
+		reads[j].ID = id
+	}
+	return reads, options, nil
+}
+
+// writeStreamEntry writes a null in place of the fields of an entry that was
+// deleted while pending in a consumer group.
+func writeStreamEntry(resp *strings.Builder, entry store.StreamEntry) {
+	id := entry.ID.String()
+	if entry.Fields == nil {
+		resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n_\r\n", len(id), id))
+		return
+	}
+	resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(id), id, len(entry.Fields)))
+	for _, field := range entry.Fields {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(field), field))
+	}
+}
+
+func writeStreamEntries(resp *strings.Builder, entries []store.StreamEntry) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(entries)))
+	for _, entry := range entries {
+		writeStreamEntry(resp, entry)
+	}
+}
+
+// writeStreamReadResults writes the map from stream key to entries that
+// XREAD replies with, or a null when nothing was read.
+func writeStreamReadResults(resp *strings.Builder, results []store.StreamReadResult) {
+	if len(results) == 0 {
+		resp.WriteString("_\r\n")
+		return
+	}
+	resp.WriteString(fmt.Sprintf("%%%d\r\n", len(results)))
+	for _, result := range results {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(result.Key), result.Key))
+		writeStreamEntries(resp, result.Entries)
+	}
+}
+
+func writeStreamInfo(resp *strings.Builder, info store.StreamInfo) {
+	writeID := func(name string, id store.StreamID) {
+		value := id.String()
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(name), name, len(value), value))
+	}
+	writeInt := func(name string, value uint64) {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n:%d\r\n", len(name), name, value))
+	}
+	writeEntry := func(name string, entry *store.StreamEntry) {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(name), name))
+		if entry == nil {
+			resp.WriteString("_\r\n")
+			return
+		}
+		writeStreamEntry(resp, *entry)
+	}
+
+	resp.WriteString("%10\r\n")
+	writeInt("length", uint64(info.Length))
+	writeInt("radix-tree-keys", uint64(info.Chunks))
+	writeInt("radix-tree-nodes", uint64(info.Chunks+1))
+	writeID("last-generated-id", info.LastID)
+	writeID("max-deleted-entry-id", info.MaxDeletedID)
+	writeInt("entries-added", info.EntriesAdded)
+	writeID("recorded-first-entry-id", info.FirstID)
+	writeInt("groups", uint64(info.Groups))
+	writeEntry("first-entry", info.FirstEntry)
+	writeEntry("last-entry", info.LastEntry)
+}
+
+func parseStreamIDs(args []string) ([]store.StreamID, error) {
+	ids := make([]store.StreamID, len(args))
+	for i, arg := range args {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// parseXTrimArgs parses the "MAXLEN|MINID [=|~] threshold [LIMIT count]"
+// arguments XTRIM takes after its key.
+func parseXTrimArgs(args []string) (store.StreamTrim, error) {
+	var trim store.StreamTrim
+	if strategy := strings.ToUpper(args[0]); strategy != "MAXLEN" && strategy != "MINID" {
+		return trim, errors.New("-ERR syntax error")
+	}
+	last, err := parseStreamTrimArg(args, 0, &trim)
+	if err != nil {
+		return trim, err
+	}
+	if last != len(args)-1 {
+		return trim, errors.New("-ERR syntax error")
+	}
+	return trim, nil
+}
+
+// parseXSetIDArgs parses "last-id [ENTRIESADDED entries-added]
+// [MAXDELETEDID max-deleted-id]", returning nil for the options not given.
+func parseXSetIDArgs(args []string) (store.StreamID, *uint64, *store.StreamID, error) {
+	lastID, err := parseStreamID(args[0], 0)
+	if err != nil {
+		return lastID, nil, nil, err
+	}
+
+	var entriesAdded *uint64
+	var maxDeletedID *store.StreamID
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return lastID, nil, nil, errors.New("-ERR syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return lastID, nil, nil, errors.New("-ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return lastID, nil, nil, errors.New("-ERR entries_added must be positive")
+			}
+			added := uint64(n)
+			entriesAdded = &added
+		case "MAXDELETEDID":
+			id, err := parseStreamID(args[i+1], 0)
+			if err != nil {
+				return lastID, nil, nil, err
+			}
+			maxDeletedID = &id
+		default:
+			return lastID, nil, nil, errors.New("-ERR syntax error")
+		}
+	}
+	return lastID, entriesAdded, maxDeletedID, nil
+}
+
+// parseXGroupIDArgs parses "id|$ [MKSTREAM] [ENTRIESREAD entries-read]", the
+// tail of XGROUP CREATE and SETID. MKSTREAM is only accepted for CREATE, and
+// entriesRead is -1 when not given.
+func parseXGroupIDArgs(args []string, create bool) (store.StreamID, bool, bool, int64, error) {
+	var id store.StreamID
+	last := args[0] == "$"
+	if !last {
+		parsed, err := parseStreamID(args[0], 0)
+		if err != nil {
+			return id, false, false, 0, err
+		}
+		id = parsed
+	}
+
+	mkStream := false
+	entriesRead := int64(-1)
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "MKSTREAM":
+			if !create {
+				return id, false, false, 0, errors.New("-ERR syntax error")
+			}
+			mkStream = true
+		case "ENTRIESREAD":
+			if i+1 >= len(args) {
+				return id, false, false, 0, errors.New("-ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return id, false, false, 0, errors.New("-ERR value is not an integer or out of range")
+			}
+			if n < -1 {
+				return id, false, false, 0, errors.New("-ERR value for ENTRIESREAD must be positive or -1")
+			}
+			entriesRead = n
+			i++
+		default:
+			return id, false, false, 0, errors.New("-ERR syntax error")
+		}
+	}
+	return id, last, mkStream, entriesRead, nil
+}
+
+// parseXPendingArgs parses "[IDLE min-idle-time] start end count [consumer]",
+// the extended form of XPENDING after the key and group.
+func parseXPendingArgs(args []string) (store.XPendingQuery, error) {
+	var query store.XPendingQuery
+	if strings.ToUpper(args[0]) == "IDLE" {
+		if len(args) < 2 {
+			return query, errors.New("-ERR syntax error")
+		}
+		minIdle, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return query, errors.New("-ERR value is not an integer or out of range")
+		}
+		query.MinIdle = minIdle
+		args = args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		return query, errors.New("-ERR syntax error")
+	}
+
+	start, err := parseStreamRangeBound(args[0], true)
+	if err != nil {
+		return query, err
+	}
+	end, err := parseStreamRangeBound(args[1], false)
+	if err != nil {
+		return query, err
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil {
+		return query, errors.New("-ERR value is not an integer or out of range")
+	}
+	query.Start, query.End, query.Count = start, end, max(count, 0)
+	if len(args) == 4 {
+		query.Consumer = args[3]
+	}
+	return query, nil
+}
+
+func parseMinIdleTime(value string, command string) (int64, error) {
+	minIdle, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("-ERR Invalid min-idle-time argument for %s", command)
+	}
+	return max(minIdle, 0), nil
+}
+
+// parseXClaimArgs parses "min-idle-time id ... [IDLE ms] [TIME unix-time-ms]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]", the arguments XCLAIM
+// takes after the key, group and consumer.
+func parseXClaimArgs(args []string) (int64, []store.StreamID, store.XClaimOptions, error) {
+	options := store.XClaimOptions{RetryCount: -1}
+	minIdle, err := parseMinIdleTime(args[0], "XCLAIM")
+	if err != nil {
+		return 0, nil, options, err
+	}
+
+	var ids []store.StreamID
+	i := 1
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return 0, nil, options, errInvalidStreamID
+	}
+
+	now := time.Now().UnixMilli()
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "FORCE":
+			options.Force = true
+			continue
+		case "JUSTID":
+			options.JustID = true
+			continue
+		case "IDLE", "TIME", "RETRYCOUNT", "LASTID":
+			if i+1 >= len(args) {
+				return 0, nil, options, errors.New("-ERR syntax error")
+			}
+		default:
+			return 0, nil, options, fmt.Errorf("-ERR Unrecognized XCLAIM option '%s'", args[i])
+		}
+
+		i++
+		if option == "LASTID" {
+			lastID, err := parseStreamID(args[i], 0)
+			if err != nil {
+				return 0, nil, options, err
+			}
+			options.LastID = &lastID
+			continue
+		}
+
+		n, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return 0, nil, options, fmt.Errorf("-ERR Invalid %s option argument for XCLAIM", option)
+		}
+		switch option {
+		case "IDLE":
+			options.DeliveryTime = now - max(n, 0)
+		case "TIME":
+			options.DeliveryTime = min(n, now)
+		case "RETRYCOUNT":
+			options.RetryCount = max(n, 0)
+		}
+	}
+	return minIdle, ids, options, nil
+}
+
+// parseXAutoClaimArgs parses "min-idle-time start [COUNT count] [JUSTID]",
+// the arguments XAUTOCLAIM takes after the key, group and consumer.
+func parseXAutoClaimArgs(args []string) (int64, store.StreamID, int, bool, error) {
+	minIdle, err := parseMinIdleTime(args[0], "XAUTOCLAIM")
+	if err != nil {
+		return 0, store.StreamID{}, 0, false, err
+	}
+	start, err := parseStreamRangeBound(args[1], true)
+	if err != nil {
+		return 0, start, 0, false, err
+	}
+
+	count, justID := 100, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "JUSTID":
+			justID = true
+		case "COUNT":
+			if i+1 >= len(args) {
+				return 0, start, 0, false, errors.New("-ERR syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return 0, start, 0, false, errors.New("-ERR value is not an integer or out of range")
+			}
+			if n < 1 || n > math.MaxInt32 {
+				return 0, start, 0, false, errors.New("-ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		default:
+			return 0, start, 0, false, errors.New("-ERR syntax error")
+		}
+	}
+	return minIdle, start, count, justID, nil
+}
+
+func writeStreamIDs(resp *strings.Builder, ids []store.StreamID) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(ids)))
+	for _, id := range ids {
+		formatted := id.String()
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(formatted), formatted))
+	}
+}
+
+// writeClaimedEntries writes the entries XCLAIM and XAUTOCLAIM claimed, or
+// only their IDs with JUSTID.
+func writeClaimedEntries(resp *strings.Builder, entries []store.StreamEntry, justID bool) {
+	if !justID {
+		writeStreamEntries(resp, entries)
+		return
+	}
+	ids := make([]store.StreamID, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	writeStreamIDs(resp, ids)
+}
+
+func writePendingSummary(resp *strings.Builder, summary store.PendingSummary) {
+	if summary.Count == 0 {
+		resp.WriteString("*4\r\n:0\r\n_\r\n_\r\n_\r\n")
+		return
+	}
+
+	first, last := summary.First.String(), summary.Last.String()
+	resp.WriteString(fmt.Sprintf("*4\r\n:%d\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", summary.Count, len(first), first, len(last), last))
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(summary.Consumers)))
+	for _, consumer := range summary.Consumers {
+		count := strconv.Itoa(consumer.Count)
+		resp.WriteString(fmt.Sprintf("*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(consumer.Consumer), consumer.Consumer, len(count), count))
+	}
+}
+
+func writePendingEntries(resp *strings.Builder, entries []store.PendingEntry) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(entries)))
+	for _, entry := range entries {
+		id := entry.ID.String()
+		resp.WriteString(fmt.Sprintf("*4\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n:%d\r\n", len(id), id, len(entry.Consumer), entry.Consumer, entry.Idle, entry.DeliveryCount))
+	}
+}
+
+func writeStreamGroupInfos(resp *strings.Builder, groups []store.StreamGroupInfo) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(groups)))
+	for _, group := range groups {
+		lastID := group.LastDeliveredID.String()
+		resp.WriteString("%6\r\n")
+		resp.WriteString(fmt.Sprintf("$4\r\nname\r\n$%d\r\n%s\r\n", len(group.Name), group.Name))
+		resp.WriteString(fmt.Sprintf("$9\r\nconsumers\r\n:%d\r\n", group.Consumers))
+		resp.WriteString(fmt.Sprintf("$7\r\npending\r\n:%d\r\n", group.Pending))
+		resp.WriteString(fmt.Sprintf("$17\r\nlast-delivered-id\r\n$%d\r\n%s\r\n", len(lastID), lastID))
+		if group.EntriesRead < 0 {
+			resp.WriteString("$12\r\nentries-read\r\n_\r\n")
+		} else {
+			resp.WriteString(fmt.Sprintf("$12\r\nentries-read\r\n:%d\r\n", group.EntriesRead))
+		}
+		if !group.LagKnown {
+			resp.WriteString("$3\r\nlag\r\n_\r\n")
+		} else {
+			resp.WriteString(fmt.Sprintf("$3\r\nlag\r\n:%d\r\n", group.Lag))
+		}
+	}
+}
+
+func writeStreamConsumerInfos(resp *strings.Builder, consumers []store.StreamConsumerInfo) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(consumers)))
+	for _, consumer := range consumers {
+		resp.WriteString("%4\r\n")
+		resp.WriteString(fmt.Sprintf("$4\r\nname\r\n$%d\r\n%s\r\n", len(consumer.Name), consumer.Name))
+		resp.WriteString(fmt.Sprintf("$7\r\npending\r\n:%d\r\n", consumer.Pending))
+		resp.WriteString(fmt.Sprintf("$4\r\nidle\r\n:%d\r\n", consumer.Idle))
+		resp.WriteString(fmt.Sprintf("$8\r\ninactive\r\n:%d\r\n", consumer.Inactive))
+	}
+}
+
+// isJSONPath reports whether path is a JSONPath, whose replies list every
+// match, rather than a legacy path, whose replies are a single value.
+func isJSONPath(path string) bool {
+	return strings.HasPrefix(path, "$")
+}
+
+func parseJSONSetArgs(args []string) (bool, bool, error) {
+	nx, xx := false, false
+	for _, arg := range args {
+		switch strings.ToUpper(arg) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return false, false, errors.New("-ERR syntax error")
+		}
+	}
+	if nx && xx {
+		return false, false, errors.New("-ERR syntax error")
+	}
+	return nx, xx, nil
+}
+
+// parseJSONGetArgs splits the arguments of JSON.GET after the key into the
+// formatting options and the paths that follow them.
+func parseJSONGetArgs(args []string) (store.JSONFormat, []string) {
+	var format store.JSONFormat
+	i := 0
+	for ; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(args[i]) {
+		case "INDENT":
+			format.Indent = args[i+1]
+		case "NEWLINE":
+			format.Newline = args[i+1]
+		case "SPACE":
+			format.Space = args[i+1]
+		default:
+			return format, args[i:]
+		}
+	}
+	return format, args[i:]
+}
+
+// writeJSONIntegers writes the integer results of a JSON command, a single
+// one for a legacy path and an array with nulls for non-matching values
+// otherwise.
+func writeJSONIntegers(resp *strings.Builder, path string, values []int, exists []bool) {
+	if !isJSONPath(path) {
+		resp.WriteString(fmt.Sprintf(":%d\r\n", values[0]))
+		return
+	}
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+	for i, value := range values {
+		if !exists[i] {
+			resp.WriteString("_\r\n")
+			continue
+		}
+		resp.WriteString(fmt.Sprintf(":%d\r\n", value))
+	}
+}
+
+func writeBulkStrings(resp *strings.Builder, values []string, exists []bool) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+	for i, value := range values {
+		if exists != nil && !exists[i] {
+			resp.WriteString("_\r\n")
+			continue
+		}
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(value), value))
+	}
+}
+
+// parseBFReserveArgs parses the error rate, capacity and options of
+// BF.RESERVE.
+func parseBFReserveArgs(args []string) (float64, uint64, uint64, bool, error) {
+	errorRate, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, 0, 0, false, errors.New("-ERR bad error rate")
+	}
+	if errorRate <= 0 || errorRate >= 1 {
+		return 0, 0, 0, false, errors.New("-ERR (0 < error rate range < 1)")
+	}
+	capacity, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false, errors.New("-ERR bad capacity")
+	}
+	if capacity == 0 {
+		return 0, 0, 0, false, errors.New("-ERR (capacity should be larger than 0)")
+	}
+
+	expansion, nonScaling, expansionSet := uint64(store.BloomDefaultExpansion), false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NONSCALING":
+			nonScaling = true
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				return 0, 0, 0, false, errors.New("-ERR syntax error")
+			}
+			i++
+			expansion, err = strconv.ParseUint(args[i], 10, 64)
+			if err != nil || expansion == 0 {
+				return 0, 0, 0, false, errors.New("-ERR (expansion should be greater or equal to 1)")
+			}
+			expansionSet = true
+		default:
+			return 0, 0, 0, false, errors.New("-ERR syntax error")
+		}
+	}
+	if nonScaling && expansionSet {
+		return 0, 0, 0, false, errors.New("-ERR Nonscaling filters cannot expand")
+	}
+	return errorRate, capacity, expansion, nonScaling, nil
+}
+
+func writeBloomInfo(resp *strings.Builder, info store.BloomInfo) {
+	resp.WriteString("%5\r\n")
+	resp.WriteString(fmt.Sprintf("$8\r\nCapacity\r\n:%d\r\n", info.Capacity))
+	resp.WriteString(fmt.Sprintf("$4\r\nSize\r\n:%d\r\n", info.Size))
+	resp.WriteString(fmt.Sprintf("$17\r\nNumber of filters\r\n:%d\r\n", info.Filters))
+	resp.WriteString(fmt.Sprintf("$24\r\nNumber of items inserted\r\n:%d\r\n", info.Items))
+	resp.WriteString(fmt.Sprintf("$14\r\nExpansion rate\r\n:%d\r\n", info.Expansion))
+}
+
+// parseCFReserveArgs parses the capacity and options of CF.RESERVE.
+func parseCFReserveArgs(args []string) (uint64, uint64, int, uint64, error) {
+	capacity, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil || capacity < 2 {
+		return 0, 0, 0, 0, errors.New("-ERR Bad capacity")
+	}
+
+	bucketSize, maxIterations, expansion := uint64(store.CuckooDefaultBucketSize), store.CuckooDefaultMaxIterations, uint64(store.CuckooDefaultExpansion)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, 0, 0, 0, errors.New("-ERR syntax error")
+		}
+		n, err := strconv.ParseUint(args[i+1], 10, 64)
+		switch strings.ToUpper(args[i]) {
+		case "BUCKETSIZE":
+			if err != nil || n < 1 || n > 255 {
+				return 0, 0, 0, 0, errors.New("-ERR Bad bucket size")
+			}
+			bucketSize = n
+		case "MAXITERATIONS":
+			if err != nil || n < 1 || n > 65535 {
+				return 0, 0, 0, 0, errors.New("-ERR Bad maxIterations")
+			}
+			maxIterations = int(n)
+		case "EXPANSION":
+			if err != nil || n > 32768 {
+				return 0, 0, 0, 0, errors.New("-ERR Bad expansion")
+			}
+			expansion = n
+		default:
+			return 0, 0, 0, 0, errors.New("-ERR syntax error")
+		}
+	}
+	return capacity, bucketSize, maxIterations, expansion, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseTopKReserveArgs parses the k and the optional width, depth and decay
+// of TOPK.RESERVE.
+func parseTopKReserveArgs(args []string) (int, uint64, uint64, float64, error) {
+	k, err := strconv.Atoi(args[0])
+	if err != nil || k < 1 {
+		return 0, 0, 0, 0, errors.New("-ERR TopK: invalid k")
+	}
+	width, depth, decay := uint64(store.TopKDefaultWidth), uint64(store.TopKDefaultDepth), store.TopKDefaultDecay
+	if len(args) == 1 {
+		return k, width, depth, decay, nil
+	}
+
+	width, err = strconv.ParseUint(args[1], 10, 64)
+	if err != nil || width < 1 {
+		return 0, 0, 0, 0, errors.New("-ERR TopK: invalid width")
+	}
+	depth, err = strconv.ParseUint(args[2], 10, 64)
+	if err != nil || depth < 1 {
+		return 0, 0, 0, 0, errors.New("-ERR TopK: invalid depth")
+	}
+	decay, err = strconv.ParseFloat(args[3], 64)
+	if err != nil || decay <= 0 || decay > 1 {
+		return 0, 0, 0, 0, errors.New("-ERR TopK: invalid decay value. must be '<= 1' & '> 0'")
+	}
+	return k, width, depth, decay, nil
+}
+
+// parseTopKIncrByArgs parses the item and increment pairs of TOPK.INCRBY.
+func parseTopKIncrByArgs(args []string) ([]string, []uint32, error) {
+	items := make([]string, 0, len(args)/2)
+	increments := make([]uint32, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		increment, err := strconv.ParseUint(args[i+1], 10, 32)
+		if err != nil || increment < 1 || increment > 100000 {
+			return nil, nil, errors.New("-ERR TopK: increment must be an integer greater or equal to 1 and less than or equal to 100000")
+		}
+		items = append(items, args[i])
+		increments = append(increments, uint32(increment))
+	}
+	return items, increments, nil
+}
+
+// parseCMSIncrByArgs parses the item and increment pairs of CMS.INCRBY.
+func parseCMSIncrByArgs(args []string) ([]string, []int64, error) {
+	items := make([]string, 0, len(args)/2)
+	increments := make([]int64, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		increment, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || increment < 0 {
+			return nil, nil, errors.New("-ERR CMS: Cannot parse number")
+		}
+		items = append(items, args[i])
+		increments = append(increments, increment)
+	}
+	return items, increments, nil
+}
+
+// parseCMSMergeArgs parses the sources and weights of CMS.MERGE, which
+// start with the number of sources.
+func parseCMSMergeArgs(args []string) ([]string, []int64, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 1 {
+		return nil, nil, errors.New("-ERR CMS: invalid numkeys")
+	}
+	if numKeys > len(args)-1 {
+		return nil, nil, errors.New("-ERR wrong number of arguments for 'cms.merge' command")
+	}
+	sources := args[1 : 1+numKeys]
+	rest := args[1+numKeys:]
+
+	weights := make([]int64, numKeys)
+	for i := range weights {
+		weights[i] = 1
+	}
+	if len(rest) == 0 {
+		return sources, weights, nil
+	}
+	if strings.ToUpper(rest[0]) != "WEIGHTS" || len(rest)-1 != numKeys {
+		return nil, nil, errors.New("-ERR syntax error")
+	}
+	for i := range weights {
+		weights[i], err = strconv.ParseInt(rest[1+i], 10, 64)
+		if err != nil {
+			return nil, nil, errors.New("-ERR CMS: invalid weight value")
+		}
+	}
+	return sources, weights, nil
+}
+
+// parseTDigestValues parses the values of a t-digest command, none of which
+// may be NaN.
+func parseTDigestValues(args []string) ([]float64, error) {
+	values := make([]float64, len(args))
+	for i, arg := range args {
+		value, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(value) {
+			return nil, errors.New("-ERR T-Digest: error parsing val parameter")
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// parseTDigestMergeArgs parses the sources and options of TDIGEST.MERGE,
+// which start with the number of sources.
+func parseTDigestMergeArgs(args []string) ([]string, float64, bool, error) {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 1 {
+		return nil, 0, false, errors.New("-ERR T-Digest: error parsing numkeys")
+	}
+	if numKeys > len(args)-1 {
+		return nil, 0, false, errors.New("-ERR wrong number of arguments for 'tdigest.merge' command")
+	}
+
+	compression, override := 0.0, false
+	for i := 1 + numKeys; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COMPRESSION":
+			if i+1 >= len(args) {
+				return nil, 0, false, errors.New("-ERR syntax error")
+			}
+			i++
+			compression, err = strconv.ParseFloat(args[i], 64)
+			if err != nil || compression <= 0 {
+				return nil, 0, false, errors.New("-ERR T-Digest: error parsing compression parameter")
+			}
+		case "OVERRIDE":
+			override = true
+		default:
+			return nil, 0, false, errors.New("-ERR syntax error")
+		}
+	}
+	return args[1 : 1+numKeys], compression, override, nil
+}
+
+func writeDoubles(resp *strings.Builder, values []float64) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(values)))
+	for _, value := range values {
+		resp.WriteString(fmt.Sprintf(",%s\r\n", formatScore(value)))
+	}
+}
+
+func parseTDigestCreateArgs(args []string) (float64, error) {
+	if len(args) == 0 {
+		return store.TDigestDefaultCompression, nil
+	}
+	if strings.ToUpper(args[0]) != "COMPRESSION" {
+		return 0, errors.New("-ERR syntax error")
+	}
+	compression, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || compression <= 0 {
+		return 0, errors.New("-ERR T-Digest: error parsing compression parameter")
+	}
+	return compression, nil
+}
+
+func validQuantiles(quantiles []float64) bool {
+	for _, q := range quantiles {
+		if q < 0 || q > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// tsCommandOptions are the options of TS.CREATE, TS.ADD and TS.INCRBY. A nil
+// timestamp stands for the current time.
+type tsCommandOptions struct {
+	series      store.TSOptions
+	onDuplicate string
+	timestamp   *int64
+}
+
+func parseTSTimestamp(value string) (*int64, error) {
+	if value == "*" {
+		return nil, nil
+	}
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || timestamp < 0 {
+		return nil, errors.New("-ERR TSDB: invalid timestamp, must be a nonnegative integer")
+	}
+	return &timestamp, nil
+}
+
+func parseTSValue(value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("-ERR TSDB: invalid value")
+	}
+	return f, nil
+}
+
+func parseTSDuplicatePolicy(value string) (string, error) {
+	policy := strings.ToUpper(value)
+	switch policy {
+	case store.TSDuplicateBlock, store.TSDuplicateFirst, store.TSDuplicateLast,
+		store.TSDuplicateMin, store.TSDuplicateMax, store.TSDuplicateSum:
+		return policy, nil
+	}
+	return "", errors.New("-ERR TSDB: Unknown DUPLICATE_POLICY")
+}
+
+// parseTSOptions parses the options of a time series command. Only TS.CREATE
+// takes DUPLICATE_POLICY, only TS.ADD takes ON_DUPLICATE and only TS.INCRBY
+// takes TIMESTAMP. LABELS takes the remaining arguments as pairs.
+func parseTSOptions(args []string, command string) (tsCommandOptions, error) {
+	var options tsCommandOptions
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		if option == "LABELS" {
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return options, errors.New("-ERR TSDB: wrong number of labels")
+			}
+			for j := 0; j < len(rest); j += 2 {
+				options.series.Labels = append(options.series.Labels, store.TSLabel{Name: rest[j], Value: rest[j+1]})
+			}
+			break
+		}
+
+		if i+1 >= len(args) {
+			return options, errors.New("-ERR syntax error")
+		}
+		i++
+		var err error
+		switch {
+		case option == "RETENTION":
+			options.series.Retention, err = strconv.ParseInt(args[i], 10, 64)
+			if err != nil || options.series.Retention < 0 {
+				return options, errors.New("-ERR TSDB: invalid RETENTION value")
+			}
+		case option == "DUPLICATE_POLICY" && command == "TS.CREATE":
+			options.series.DuplicatePolicy, err = parseTSDuplicatePolicy(args[i])
+		case option == "ON_DUPLICATE" && command == "TS.ADD":
+			options.onDuplicate, err = parseTSDuplicatePolicy(args[i])
+		case option == "TIMESTAMP" && command == "TS.INCRBY":
+			options.timestamp, err = parseTSTimestamp(args[i])
+		default:
+			return options, errors.New("-ERR syntax error")
+		}
+		if err != nil {
+			return options, err
+		}
+	}
+	return options, nil
+}
+
+// parseTSAggregation parses the aggregator and bucket duration following
+// AGGREGATION.
+func parseTSAggregation(aggregator string, duration string) (store.TSAggregation, error) {
+	aggregation := store.TSAggregation{Type: strings.ToLower(aggregator)}
+	switch aggregation.Type {
+	case "avg", "sum", "min", "max", "count", "last":
+	default:
+		return aggregation, errors.New("-ERR TSDB: Unknown aggregation type")
+	}
+	bucketDuration, err := strconv.ParseInt(duration, 10, 64)
+	if err != nil || bucketDuration <= 0 {
+		return aggregation, errors.New("-ERR TSDB: bucketDuration must be greater than zero")
+	}
+	aggregation.BucketDuration = bucketDuration
+	return aggregation, nil
+}
+
+// parseTSFilter parses a TS.MRANGE filter: label=value, label!=value, or a
+// parenthesized list of values on the right.
+func parseTSFilter(expression string) (store.TSLabelMatcher, error) {
+	var matcher store.TSLabelMatcher
+	i := strings.Index(expression, "=")
+	if i <= 0 {
+		return matcher, errors.New("-ERR TSDB: failed parsing labels")
+	}
+	matcher.Label, matcher.Negate = expression[:i], expression[i-1] == '!'
+	if matcher.Negate {
+		matcher.Label = expression[:i-1]
+	}
+	if matcher.Label == "" {
+		return matcher, errors.New("-ERR TSDB: failed parsing labels")
+	}
+
+	value := expression[i+1:]
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		matcher.Values = strings.Split(value[1:len(value)-1], ",")
+	} else {
+		matcher.Values = []string{value}
+	}
+	return matcher, nil
+}
+
+// parseTSRangeArgs parses the arguments of TS.RANGE and TS.REVRANGE after the
+// key, or of TS.MRANGE, which also takes WITHLABELS and FILTER.
+func parseTSRangeArgs(args []string, multi bool) (store.TSRangeQuery, []store.TSLabelMatcher, bool, error) {
+	var query store.TSRangeQuery
+	var filters []store.TSLabelMatcher
+	withLabels := false
+
+	from, to := args[0], args[1]
+	query.From, query.To = 0, math.MaxInt64
+	if from != "-" {
+		timestamp, err := parseTSTimestamp(from)
+		if err != nil || timestamp == nil {
+			return query, nil, false, errors.New("-ERR TSDB: invalid fromTimestamp")
+		}
+		query.From = *timestamp
+	}
+	if to != "+" {
+		timestamp, err := parseTSTimestamp(to)
+		if err != nil || timestamp == nil {
+			return query, nil, false, errors.New("-ERR TSDB: invalid toTimestamp")
+		}
+		query.To = *timestamp
+	}
+
+	align := ""
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); {
+		case option == "COUNT" && i+1 < len(args):
+			i++
+			count, err := strconv.Atoi(args[i])
+			if err != nil || count <= 0 {
+				return query, nil, false, errors.New("-ERR TSDB: Invalid COUNT value")
+			}
+			query.Count = count
+		case option == "ALIGN" && i+1 < len(args):
+			i++
+			align = args[i]
+		case option == "AGGREGATION" && i+2 < len(args):
+			aggregation, err := parseTSAggregation(args[i+1], args[i+2])
+			if err != nil {
+				return query, nil, false, err
+			}
+			query.Aggregation = aggregation
+			i += 2
+		case option == "WITHLABELS" && multi:
+			withLabels = true
+		case option == "FILTER" && multi:
+			for _, expression := range args[i+1:] {
+				filter, err := parseTSFilter(expression)
+				if err != nil {
+					return query, nil, false, err
+				}
+				filters = append(filters, filter)
+			}
+			i = len(args)
+		default:
+			return query, nil, false, errors.New("-ERR syntax error")
+		}
+	}
+
+	if align != "" {
+		if query.Aggregation.Type == "" {
+			return query, nil, false, errors.New("-ERR TSDB: ALIGN parameter can only be used with AGGREGATION")
+		}
+		switch align {
+		case "-", "start":
+			query.Aggregation.Align = query.From
+		case "+", "end":
+			query.Aggregation.Align = query.To
+		default:
+			timestamp, err := strconv.ParseInt(align, 10, 64)
+			if err != nil {
+				return query, nil, false, errors.New("-ERR TSDB: unknown ALIGN parameter")
+			}
+			query.Aggregation.Align = timestamp
+		}
+	}
+
+	if multi && !slices.ContainsFunc(filters, func(filter store.TSLabelMatcher) bool {
+		return !filter.Negate && !slices.Contains(filter.Values, "")
+	}) {
+		return query, nil, false, errors.New("-ERR TSDB: please provide at least one matcher")
+	}
+	return query, filters, withLabels, nil
+}
+
+func writeTSSamples(resp *strings.Builder, samples []store.TSSample) {
+	resp.WriteString(fmt.Sprintf("*%d\r\n", len(samples)))
+	for _, sample := range samples {
+		resp.WriteString(fmt.Sprintf("*2\r\n:%d\r\n,%s\r\n", sample.Timestamp, formatScore(sample.Value)))
+	}
+}
+
+// writeTSRangeResults writes the TS.MRANGE reply, a map from each matched
+// key to its labels, if requested, and samples.
+func writeTSRangeResults(resp *strings.Builder, results []store.TSRangeResult, withLabels bool) {
+	resp.WriteString(fmt.Sprintf("%%%d\r\n", len(results)))
+	for _, result := range results {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n*2\r\n", len(result.Key), result.Key))
+		if !withLabels {
+			resp.WriteString("%0\r\n")
+		} else {
+			resp.WriteString(fmt.Sprintf("%%%d\r\n", len(result.Labels)))
+			for _, label := range result.Labels {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(label.Name), label.Name, len(label.Value), label.Value))
+			}
+		}
+		writeTSSamples(resp, result.Samples)
+	}
+}
+
+// parseTSMAddArgs parses the key, timestamp and value triplets of TS.MADD.
+func parseTSMAddArgs(args []string) ([]string, []store.TSSample, error) {
+	var keys []string
+	var samples []store.TSSample
+	for i := 0; i < len(args); i += 3 {
+		timestamp, err := parseTSTimestamp(args[i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := parseTSValue(args[i+2])
+		if err != nil {
+			return nil, nil, err
+		}
+		sample := store.TSSample{Timestamp: time.Now().UnixMilli(), Value: value}
+		if timestamp != nil {
+			sample.Timestamp = *timestamp
+		}
+		keys = append(keys, args[i])
+		samples = append(samples, sample)
+	}
+	return keys, samples, nil
+}
+
+// parseVector parses a vector at args[i], given either as FP32 and a blob
+// of little endian float32s or as VALUES, the number of components and the
+// components. It returns the index of the argument after the vector.
+func parseVector(args []string, i int) ([]float32, int, error) {
+	errInvalid := errors.New("-ERR invalid vector specification")
+	if i >= len(args) {
+		return nil, i, errInvalid
+	}
+	switch strings.ToUpper(args[i]) {
+	case "FP32":
+		if i+1 >= len(args) || len(args[i+1]) == 0 || len(args[i+1])%4 != 0 {
+			return nil, i, errInvalid
+		}
+		blob := []byte(args[i+1])
+		vector := make([]float32, len(blob)/4)
+		for j := range vector {
+			vector[j] = math.Float32frombits(binary.LittleEndian.Uint32(blob[4*j:]))
+		}
+		return vector, i + 2, nil
+	case "VALUES":
+		if i+1 >= len(args) {
+			return nil, i, errInvalid
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil || n <= 0 || n > len(args)-i-2 {
+			return nil, i, errInvalid
+		}
+		vector := make([]float32, n)
+		for j := range vector {
+			value, err := strconv.ParseFloat(args[i+2+j], 32)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, i, errors.New("-ERR invalid vector value")
+			}
+			vector[j] = float32(value)
+		}
+		return vector, i + 2 + n, nil
+	}
+	return nil, i, errInvalid
+}
+
+func parseVectorOptionInt(value string, name string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("-ERR invalid %s value", name)
+	}
+	return n, nil
+}
+
+// parseVAddArgs parses the arguments of VADD after the key. CAS is accepted
+// and ignored, since insertions always run inline.
+func parseVAddArgs(args []string) ([]float32, string, store.VAddOptions, error) {
+	options := store.VAddOptions{EF: store.VectorDefaultEF, M: store.VectorDefaultM}
+	vector, i, err := parseVector(args, 0)
+	if err != nil {
+		return nil, "", options, err
+	}
+	if i >= len(args) {
+		return nil, "", options, errors.New("-ERR syntax error")
+	}
+	element := args[i]
+
+	for i++; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "CAS":
+		case store.VectorQuantNone, store.VectorQuantQ8, store.VectorQuantBin:
+			if options.Quantization != "" && options.Quantization != option {
+				return nil, "", options, errors.New("-ERR only one quantization type can be given")
+			}
+			options.Quantization = option
+		case "EF", "M", "SETATTR":
+			if i+1 >= len(args) {
+				return nil, "", options, errors.New("-ERR syntax error")
+			}
+			i++
+			switch option {
+			case "EF":
+				options.EF, err = parseVectorOptionInt(args[i], "EF")
+			case "M":
+				options.M, err = parseVectorOptionInt(args[i], "M")
+			default:
+				options.Attributes = &args[i]
+			}
+			if err != nil {
+				return nil, "", options, err
+			}
+		default:
+			return nil, "", options, errors.New("-ERR syntax error")
+		}
+	}
+	return vector, element, options, nil
+}
+
+// vsimReplyOptions are the options of VSIM that shape its reply.
+type vsimReplyOptions struct {
+	withScores  bool
+	withAttribs bool
+}
+
+// parseVSimArgs parses the arguments of VSIM after the key. TRUTH and
+// NOTHREAD are accepted and ignored.
+func parseVSimArgs(args []string) (store.VSimQuery, vsimReplyOptions, error) {
+	query := store.VSimQuery{Count: 10, EF: store.VectorDefaultEF}
+	var options vsimReplyOptions
+	var i int
+	if len(args) > 0 && strings.ToUpper(args[0]) == "ELE" {
+		if len(args) < 2 {
+			return query, options, errors.New("-ERR syntax error")
+		}
+		query.Element = args[1]
+		i = 2
+	} else {
+		var err error
+		if query.Vector, i, err = parseVector(args, 0); err != nil {
+			return query, options, err
+		}
+	}
+
+	filterEF := 0
+	for ; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "WITHSCORES":
+			options.withScores = true
+		case "WITHATTRIBS":
+			options.withAttribs = true
+		case "TRUTH", "NOTHREAD":
+		case "COUNT", "EF", "FILTER", "FILTER-EF":
+			if i+1 >= len(args) {
+				return query, options, errors.New("-ERR syntax error")
+			}
+			i++
+			var err error
+			switch option {
+			case "COUNT":
+				query.Count, err = parseVectorOptionInt(args[i], "COUNT")
+			case "EF":
+				query.EF, err = parseVectorOptionInt(args[i], "EF")
+			case "FILTER":
+				query.Filter = args[i]
+			default:
+				filterEF, err = parseVectorOptionInt(args[i], "FILTER-EF")
+			}
+			if err != nil {
+				return query, options, err
+			}
+		default:
+			return query, options, errors.New("-ERR syntax error")
+		}
+	}
+
+	query.FilterEF = filterEF
+	if filterEF == 0 {
+		query.FilterEF = query.Count * 100
+	}
+	return query, options, nil
+}
+
+// writeVectorMatches writes the elements found by VSIM, as a map to their
+// scores or attributes, or to both as a pair, when either is asked for.
+func writeVectorMatches(resp *strings.Builder, matches []store.VectorMatch, options vsimReplyOptions) {
+	if !options.withScores && !options.withAttribs {
+		resp.WriteString(fmt.Sprintf("*%d\r\n", len(matches)))
+		for _, match := range matches {
+			resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(match.Element), match.Element))
+		}
+		return
+	}
+
+	resp.WriteString(fmt.Sprintf("%%%d\r\n", len(matches)))
+	for _, match := range matches {
+		resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(match.Element), match.Element))
+		if options.withScores && options.withAttribs {
+			resp.WriteString("*2\r\n")
+		}
+		if options.withScores {
+			resp.WriteString(fmt.Sprintf(",%s\r\n", formatScore(match.Score)))
+		}
+		if options.withAttribs {
+			if match.Attributes == "" {
+				resp.WriteString("_\r\n")
+			} else {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(match.Attributes), match.Attributes))
+			}
+		}
+	}
+}
+
+// parseFTCreateArgs parses the arguments of FT.CREATE after the index name.
+// Only hashes can be indexed, and options between PREFIX and SCHEMA other
+// than ON HASH are rejected.
+func parseFTCreateArgs(args []string) (store.SearchSchema, error) {
+	var schema store.SearchSchema
+	i := 0
+	for ; i < len(args) && strings.ToUpper(args[i]) != "SCHEMA"; i++ {
+		switch strings.ToUpper(args[i]) {
+		case "ON":
+			if i+1 >= len(args) || strings.ToUpper(args[i+1]) != "HASH" {
+				return schema, errors.New("-ERR Only HASH indexes are supported")
+			}
+			i++
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return schema, errors.New("-ERR syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 || n > len(args)-i-2 {
+				return schema, errors.New("-ERR Bad arguments for PREFIX: invalid number of prefixes")
+			}
+			schema.Prefixes = append(schema.Prefixes, args[i+2:i+2+n]...)
+			i += 1 + n
+		default:
+			return schema, fmt.Errorf("-ERR Unknown argument `%s`", args[i])
+		}
+	}
+	if i+1 >= len(args) {
+		return schema, errors.New("-ERR Fields arguments are missing")
+	}
+
+	for i++; i < len(args); i++ {
+		field := store.SearchField{Name: args[i], Alias: args[i], Separator: store.SearchDefaultSeparator}
+		if i+2 < len(args) && strings.ToUpper(args[i+1]) == "AS" {
+			field.Alias = args[i+2]
+			i += 2
+		}
+		if i+1 >= len(args) {
+			return schema, fmt.Errorf("-ERR Field type is missing for field `%s`", field.Name)
+		}
+		i++
+		field.Type = strings.ToUpper(args[i])
+		switch field.Type {
+		case store.SearchText, store.SearchTag, store.SearchNumeric:
+		default:
+			return schema, fmt.Errorf("-ERR Invalid field type for field `%s`", field.Name)
+		}
+		if slices.ContainsFunc(schema.Fields, func(other store.SearchField) bool { return other.Alias == field.Alias }) {
+			return schema, fmt.Errorf("-ERR Duplicate field in schema - %s", field.Alias)
+		}
+
+	options:
+		for i+1 < len(args) {
+			switch option := strings.ToUpper(args[i+1]); {
+			case option == "SORTABLE":
+				field.Sortable = true
+			case option == "NOSTEM" && field.Type == store.SearchText:
+			case option == "WEIGHT" && field.Type == store.SearchText && i+2 < len(args):
+				i++
+			case option == "SEPARATOR" && field.Type == store.SearchTag && i+2 < len(args):
+				if len(args[i+2]) != 1 {
+					return schema, errors.New("-ERR Tag separator must be a single character")
+				}
+				field.Separator = args[i+2]
+				i++
+			case option == "CASESENSITIVE" && field.Type == store.SearchTag:
+				field.CaseSensitive = true
+			default:
+				break options
+			}
+			i++
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	return schema, nil
+}
+
+// parseSearchLimit parses the offset and count following LIMIT.
+func parseSearchLimit(offset string, count string) (int, int, error) {
+	o, err := strconv.Atoi(offset)
+	if err != nil || o < 0 {
+		return 0, 0, errors.New("-ERR Bad arguments for LIMIT: offset must be a non-negative integer")
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, 0, errors.New("-ERR Bad arguments for LIMIT: count must be a non-negative integer")
+	}
+	return o, n, nil
+}
+
+// parseFTSearchArgs parses the arguments of FT.SEARCH after the index name
+// and query. DIALECT is accepted and ignored.
+func parseFTSearchArgs(args []string) (store.SearchQuery, error) {
+	query := store.SearchQuery{Limit: 10}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NOCONTENT":
+			query.NoContent = true
+		case "RETURN":
+			if i+1 >= len(args) {
+				return query, errors.New("-ERR syntax error")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 || n > len(args)-i-2 {
+				return query, errors.New("-ERR Bad arguments for RETURN: invalid number of fields")
+			}
+			query.Return = args[i+2 : i+2+n]
+			i += 1 + n
+		case "SORTBY":
+			if i+1 >= len(args) {
+				return query, errors.New("-ERR syntax error")
+			}
+			query.SortBy = strings.TrimPrefix(args[i+1], "@")
+			i++
+			if i+1 < len(args) {
+				switch strings.ToUpper(args[i+1]) {
+				case "ASC":
+					i++
+				case "DESC":
+					query.Descending = true
+					i++
+				}
+			}
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return query, errors.New("-ERR syntax error")
+			}
+			var err error
+			if query.Offset, query.Limit, err = parseSearchLimit(args[i+1], args[i+2]); err != nil {
+				return query, err
+			}
+			i += 2
+		case "DIALECT":
+			i++
+		default:
+			return query, fmt.Errorf("-ERR Unknown argument `%s`", args[i])
+		}
+	}
+	return query, nil
+}
+
+// parseSearchProperty strips the @ every property of FT.AGGREGATE starts
+// with.
+func parseSearchProperty(property string) (string, error) {
+	if !strings.HasPrefix(property, "@") {
+		return "", fmt.Errorf("-ERR Missing prefix: name requires '@' prefix, got: %s", property)
+	}
+	return property[1:], nil
+}
+
+// parseSearchCount parses the argument count at args[i] and returns the
+// arguments it covers.
+func parseSearchCount(args []string, i int, name string) ([]string, error) {
+	if i >= len(args) {
+		return nil, errors.New("-ERR syntax error")
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n < 0 || n > len(args)-i-1 {
+		return nil, fmt.Errorf("-ERR Bad arguments for %s: invalid number of arguments", name)
+	}
+	return args[i+1 : i+1+n], nil
+}
+
+// parseFTAggregateArgs parses the pipeline of FT.AGGREGATE following the
+// index name and query. DIALECT is accepted and ignored.
+func parseFTAggregateArgs(args []string) ([]store.AggregateStep, error) {
+	var steps []store.AggregateStep
+	for i := 0; i < len(args); i++ {
+		step := store.AggregateStep{Kind: strings.ToUpper(args[i])}
+		switch step.Kind {
+		case "LOAD":
+			if i+1 < len(args) && args[i+1] == "*" {
+				step.Properties = []string{"*"}
+				i++
+				break
+			}
+			properties, err := parseSearchCount(args, i+1, "LOAD")
+			if err != nil {
+				return nil, err
+			}
+			for _, property := range properties {
+				name, err := parseSearchProperty(property)
+				if err != nil {
+					return nil, err
+				}
+				step.Properties = append(step.Properties, name)
+			}
+			i += 1 + len(properties)
+		case "GROUPBY":
+			properties, err := parseSearchCount(args, i+1, "GROUPBY")
+			if err != nil {
+				return nil, err
+			}
+			for _, property := range properties {
+				name, err := parseSearchProperty(property)
+				if err != nil {
+					return nil, err
+				}
+				step.Properties = append(step.Properties, name)
+			}
+			i += 1 + len(properties)
+
+			for i+1 < len(args) && strings.ToUpper(args[i+1]) == "REDUCE" {
+				if i+2 >= len(args) {
+					return nil, errors.New("-ERR syntax error")
+				}
+				reducer := store.AggregateReducer{Function: strings.ToUpper(args[i+2])}
+				reducerArgs, err := parseSearchCount(args, i+3, "REDUCE")
+				if err != nil {
+					return nil, err
+				}
+				switch reducer.Function {
+				case "COUNT":
+					if len(reducerArgs) != 0 {
+						return nil, errors.New("-ERR Bad arguments for COUNT: Count accepts 0 values only")
+					}
+				case "COUNT_DISTINCT", "SUM", "AVG", "MIN", "MAX":
+					if len(reducerArgs) != 1 {
+						return nil, fmt.Errorf("-ERR Bad arguments for %s: expected 1 argument", reducer.Function)
+					}
+					if reducer.Property, err = parseSearchProperty(reducerArgs[0]); err != nil {
+						return nil, err
+					}
+				default:
+					return nil, fmt.Errorf("-ERR No such reducer `%s`", args[i+2])
+				}
+				i += 3 + len(reducerArgs)
+
+				reducer.Alias = "__generated_alias" + strings.ToLower(reducer.Function) + reducer.Property
+				if i+2 < len(args) && strings.ToUpper(args[i+1]) == "AS" {
+					reducer.Alias = args[i+2]
+					i += 2
+				}
+				step.Reducers = append(step.Reducers, reducer)
+			}
+		case "SORTBY":
+			sortArgs, err := parseSearchCount(args, i+1, "SORTBY")
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(sortArgs); j++ {
+				name, err := parseSearchProperty(sortArgs[j])
+				if err != nil {
+					return nil, err
+				}
+				descending := false
+				if j+1 < len(sortArgs) && slices.Contains([]string{"ASC", "DESC"}, strings.ToUpper(sortArgs[j+1])) {
+					descending = strings.ToUpper(sortArgs[j+1]) == "DESC"
+					j++
+				}
+				step.Properties = append(step.Properties, name)
+				step.Descending = append(step.Descending, descending)
+			}
+			i += 1 + len(sortArgs)
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return nil, errors.New("-ERR syntax error")
+			}
+			var err error
+			if step.Offset, step.Limit, err = parseSearchLimit(args[i+1], args[i+2]); err != nil {
+				return nil, err
+			}
+			i += 2
+		case "DIALECT":
+			i++
+			continue
+		default:
+			return nil, fmt.Errorf("-ERR Unknown argument `%s`", args[i])
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// writeSearchReply writes the reply of FT.SEARCH and FT.AGGREGATE, whose
+// results carry an id only when withIDs is set and their fields unless
+// noContent is set.
+func writeSearchReply(resp *strings.Builder, total int, results []store.SearchResult, withIDs bool, noContent bool) {
+	resp.WriteString("%5\r\n$10\r\nattributes\r\n*0\r\n$6\r\nformat\r\n$6\r\nSTRING\r\n")
+	resp.WriteString(fmt.Sprintf("$7\r\nresults\r\n*%d\r\n", len(results)))
+	for _, result := range results {
+		entries := 1
+		if withIDs {
+			entries++
+		}
+		if !noContent {
+			entries++
+		}
+		resp.WriteString(fmt.Sprintf("%%%d\r\n", entries))
+		if withIDs {
+			resp.WriteString(fmt.Sprintf("$2\r\nid\r\n$%d\r\n%s\r\n", len(result.Key), result.Key))
+		}
+		if !noContent {
+			resp.WriteString(fmt.Sprintf("$16\r\nextra_attributes\r\n%%%d\r\n", len(result.Fields)))
+			for i, field := range result.Fields {
+				resp.WriteString(fmt.Sprintf("$%d\r\n%s\r\n$%d\r\n%s\r\n", len(field), field, len(result.Values[i]), result.Values[i]))
+			}
+		}
+		resp.WriteString("$6\r\nvalues\r\n*0\r\n")
+	}
+	resp.WriteString(fmt.Sprintf("$13\r\ntotal_results\r\n:%d\r\n$7\r\nwarning\r\n*0\r\n", total))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// hugeCount is a count argument big enough to overflow when the parser adds
+// to it.
+const hugeCount = "9223372036854775806"
+
+func TestParseMPopArgs(t *testing.T) {
+	tests := []struct {
+		command string
+		args    []string
+		keys    []string
+		first   bool
+		count   int
+		err     string
+	}{
+		{"LMPOP", []string{"2", "a", "b", "RIGHT"}, []string{"a", "b"}, false, 1, ""},
+		{"LMPOP", []string{"1", "a", "left", "COUNT", "3"}, []string{"a"}, true, 3, ""},
+		{"LMPOP", []string{"0", "a", "LEFT"}, nil, false, 0, "-ERR numkeys should be greater than 0"},
+		{"LMPOP", []string{"2", "a", "LEFT"}, nil, false, 0, "-ERR syntax error"},
+		{"LMPOP", []string{"1", "a", "UP"}, nil, false, 0, "-ERR syntax error"},
+		{"LMPOP", []string{"1", "a", "LEFT", "COUNT"}, nil, false, 0, "-ERR syntax error"},
+		{"LMPOP", []string{"1", "a", "LEFT", "COUNT", "0"}, nil, false, 0, "-ERR count should be greater than 0"},
+		{"LMPOP", []string{hugeCount, "a", "LEFT"}, nil, false, 0, "-ERR syntax error"},
+		{"BLMPOP", []string{hugeCount, "a", "LEFT"}, nil, false, 0, "-ERR syntax error"},
+		{"ZMPOP", []string{"2", "a", "b", "min", "COUNT", "2"}, []string{"a", "b"}, true, 2, ""},
+		{"ZMPOP", []string{"1", "a", "LEFT"}, nil, false, 0, "-ERR syntax error"},
+		{"ZMPOP", []string{hugeCount, "a", "MIN"}, nil, false, 0, "-ERR syntax error"},
+		{"BZMPOP", []string{hugeCount, "a", "MIN"}, nil, false, 0, "-ERR syntax error"},
+	}
+	for _, test := range tests {
+		directions := [2]string{"LEFT", "RIGHT"}
+		if test.command == "ZMPOP" || test.command == "BZMPOP" {
+			directions = [2]string{"MIN", "MAX"}
+		}
+		keys, first, count, err := parseMPopArgs(test.args, directions[0], directions[1])
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s %v: err = %v, want %s", test.command, test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(keys, test.keys) || first != test.first || count != test.count {
+			t.Errorf("%s %v = %v, %v, %d, %v", test.command, test.args, keys, first, count, err)
+		}
+	}
+}
+
+func TestParseCMSMergeArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		sources []string
+		weights []int64
+		err     string
+	}{
+		{[]string{"2", "a", "b"}, []string{"a", "b"}, []int64{1, 1}, ""},
+		{[]string{"2", "a", "b", "WEIGHTS", "3", "-1"}, []string{"a", "b"}, []int64{3, -1}, ""},
+		{[]string{"0", "a"}, nil, nil, "-ERR CMS: invalid numkeys"},
+		{[]string{"3", "a", "b"}, nil, nil, "-ERR wrong number of arguments for 'cms.merge' command"},
+		{[]string{"2", "a", "b", "WEIGHTS", "3"}, nil, nil, "-ERR syntax error"},
+		{[]string{"1", "a", "WEIGHTS", "3", "4"}, nil, nil, "-ERR syntax error"},
+		{[]string{"1", "a", "WEIGHTS", "x"}, nil, nil, "-ERR CMS: invalid weight value"},
+		{[]string{"9223372036854775807", "a"}, nil, nil, "-ERR wrong number of arguments for 'cms.merge' command"},
+	}
+	for _, test := range tests {
+		sources, weights, err := parseCMSMergeArgs(test.args)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("CMS.MERGE d %v: err = %v, want %s", test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(sources, test.sources) || !slices.Equal(weights, test.weights) {
+			t.Errorf("CMS.MERGE d %v = %v, %v, %v", test.args, sources, weights, err)
+		}
+	}
+}
+
+func TestParseTDigestMergeArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		sources     []string
+		compression float64
+		override    bool
+		err         string
+	}{
+		{[]string{"2", "a", "b"}, []string{"a", "b"}, 0, false, ""},
+		{[]string{"1", "a", "OVERRIDE", "compression", "50"}, []string{"a"}, 50, true, ""},
+		{[]string{"0", "a"}, nil, 0, false, "-ERR T-Digest: error parsing numkeys"},
+		{[]string{"3", "a", "b"}, nil, 0, false, "-ERR wrong number of arguments for 'tdigest.merge' command"},
+		{[]string{"1", "a", "COMPRESSION"}, nil, 0, false, "-ERR syntax error"},
+		{[]string{"1", "a", "COMPRESSION", "0"}, nil, 0, false, "-ERR T-Digest: error parsing compression parameter"},
+		{[]string{"1", "a", "b"}, nil, 0, false, "-ERR syntax error"},
+		{[]string{"9223372036854775807", "a"}, nil, 0, false, "-ERR wrong number of arguments for 'tdigest.merge' command"},
+	}
+	for _, test := range tests {
+		sources, compression, override, err := parseTDigestMergeArgs(test.args)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("TDIGEST.MERGE d %v: err = %v, want %s", test.args, err, test.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(sources, test.sources) || compression != test.compression || override != test.override {
+			t.Errorf("TDIGEST.MERGE d %v = %v, %v, %v, %v", test.args, sources, compression, override, err)
+		}
+	}
+}
+
+func TestParseVector(t *testing.T) {
+	tests := []struct {
+		args   []string
+		vector []float32
+		next   int
+		err    bool
+	}{
+		{[]string{"VALUES", "2", "0.5", "1"}, []float32{0.5, 1}, 4, false},
+		{[]string{"values", "1", "3", "element"}, []float32{3}, 3, false},
+		{[]string{"FP32", "\x00\x00\x80\x3f"}, []float32{1}, 2, false},
+		{[]string{"VALUES", "3", "1", "2"}, nil, 0, true},
+		{[]string{"VALUES", "0"}, nil, 0, true},
+		{[]string{"VALUES", "1", "NaN"}, nil, 0, true},
+		{[]string{"VALUES", "9223372036854775806", "1"}, nil, 0, true},
+		{[]string{"FP32", "\x00\x00\x80"}, nil, 0, true},
+	}
+	for _, test := range tests {
+		vector, next, err := parseVector(test.args, 0)
+		if test.err {
+			if err == nil {
+				t.Errorf("parseVector(%q) did not fail", test.args)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(vector, test.vector) || next != test.next {
+			t.Errorf("parseVector(%q) = %v, %d, %v", test.args, vector, next, err)
+		}
+	}
+}
+
+// TestSearchArgumentCounts checks the FT commands' argument counts, which
+// must cover no more arguments than follow them.
+func TestSearchArgumentCounts(t *testing.T) {
+	schema, err := parseFTCreateArgs([]string{"ON", "HASH", "PREFIX", "2", "a:", "b:", "SCHEMA", "title", "TEXT"})
+	if err != nil || !slices.Equal(schema.Prefixes, []string{"a:", "b:"}) {
+		t.Errorf("FT.CREATE PREFIX 2 = %v, %v", schema.Prefixes, err)
+	}
+	query, err := parseFTSearchArgs([]string{"RETURN", "2", "title", "price", "LIMIT", "0", "5"})
+	if err != nil || !slices.Equal(query.Return, []string{"title", "price"}) || query.Limit != 5 {
+		t.Errorf("FT.SEARCH RETURN 2 = %v, %v", query, err)
+	}
+	steps, err := parseFTAggregateArgs([]string{"LOAD", "1", "@title"})
+	if err != nil || len(steps) != 1 || !slices.Equal(steps[0].Properties, []string{"title"}) {
+		t.Errorf("FT.AGGREGATE LOAD 1 = %v, %v", steps, err)
+	}
+
+	for _, count := range []string{"2", hugeCount, "9223372036854775807"} {
+		if _, err := parseFTCreateArgs([]string{"PREFIX", count, "a:"}); err == nil {
+			t.Errorf("FT.CREATE PREFIX %s with 1 argument after it did not fail", count)
+		}
+		if _, err := parseFTSearchArgs([]string{"RETURN", count, "title"}); err == nil {
+			t.Errorf("FT.SEARCH RETURN %s with 1 argument after it did not fail", count)
+		}
+		if _, err := parseFTAggregateArgs([]string{"LOAD", count, "@title"}); err == nil {
+			t.Errorf("FT.AGGREGATE LOAD %s with 1 argument after it did not fail", count)
+		}
+	}
+}